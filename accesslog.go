@@ -0,0 +1,137 @@
+package log4go
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// AccessLogFormat is an Apache access log format.
+type AccessLogFormat int
+
+const (
+	// AccessLogCommon is the Common Log Format:
+	//	%h %l %u %t "%r" %>s %b
+	AccessLogCommon AccessLogFormat = iota
+	// AccessLogCombined is the Combined Log Format, the common one with the referer and user
+	// agent:
+	//	%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"
+	AccessLogCombined
+)
+
+// AccessLogFormatter formats the records of a RequestLogger as Apache access log lines, for
+// the log analyzers (e.g. GoAccess). The line's time is the request's start, the record's
+// time less its latency. The records without request fields (e.g. those of the request-scoped
+// loggers) are skipped.
+type AccessLogFormatter struct {
+	format AccessLogFormat
+}
+
+// NewAccessLogFormatter returns a new AccessLogFormatter of the format.
+func NewAccessLogFormatter(format AccessLogFormat) *AccessLogFormatter {
+	return &AccessLogFormatter{format: format}
+}
+
+var _ AppendFormatter = &AccessLogFormatter{}
+
+// accessLogTime is the time format of the access logs.
+const accessLogTime = "[02/Jan/2006:15:04:05 -0700]"
+
+// Format returns the record as an access log line.
+func (f *AccessLogFormatter) Format(r *Record) ([]byte, error) {
+	return f.AppendFormat(nil, r)
+}
+
+// AppendFormat appends the record as an access log line to dst.
+func (f *AccessLogFormatter) AppendFormat(dst []byte, r *Record) ([]byte, error) {
+	status, ok := r.Fields["status"]
+	if r.Level == NOTSET || !ok {
+		return dst, ErrorNotSet
+	}
+
+	dst = appendAccessValue(dst, r.Fields["remote_ip"])
+	dst = append(dst, " - "...)
+	dst = appendAccessValue(dst, r.Fields["user"])
+	dst = append(dst, ' ')
+	start := r.Time
+	if latency, ok := r.Fields["latency"].(time.Duration); ok {
+		start = start.Add(-latency)
+	}
+	dst = start.AppendFormat(dst, accessLogTime)
+
+	request := fmt.Sprint(r.Fields["method"], " ", r.Fields["path"])
+	if query, ok := r.Fields["query"].(string); ok && len(query) > 0 {
+		request += "?" + query
+	}
+	if proto, ok := r.Fields["proto"].(string); ok && len(proto) > 0 {
+		request += " " + proto
+	}
+	dst = append(dst, ' ')
+	dst = appendAccessQuoted(dst, request)
+
+	dst = append(dst, ' ')
+	dst = appendAccessValue(dst, status)
+	dst = append(dst, ' ')
+	if size, ok := r.Fields["size"].(int64); ok && size == 0 {
+		dst = append(dst, '-')
+	} else {
+		dst = appendAccessValue(dst, r.Fields["size"])
+	}
+
+	if f.format == AccessLogCombined {
+		referer, _ := r.Fields["referer"].(string)
+		userAgent, _ := r.Fields["user_agent"].(string)
+		dst = append(dst, ' ')
+		dst = appendAccessQuoted(dst, referer)
+		dst = append(dst, ' ')
+		dst = appendAccessQuoted(dst, userAgent)
+	}
+	return dst, nil
+}
+
+// appendAccessValue appends a field's value, "-" if missing or empty.
+func appendAccessValue(dst []byte, value interface{}) []byte {
+	s := ""
+	if value != nil {
+		s = fmt.Sprint(value)
+	}
+	if len(s) == 0 {
+		return append(dst, '-')
+	}
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < ' ' || c >= 0x7f || c == '"' || c == '\\' {
+			return appendAccessEscaped(dst, s)
+		}
+	}
+	return append(dst, s...)
+}
+
+// appendAccessQuoted appends a quoted value, "-" if empty.
+func appendAccessQuoted(dst []byte, s string) []byte {
+	if len(s) == 0 {
+		s = "-"
+	}
+	dst = append(dst, '"')
+	dst = appendAccessEscaped(dst, s)
+	return append(dst, '"')
+}
+
+// appendAccessEscaped appends s with quotes, backslashes and non-printable bytes escaped, as
+// Apache does.
+func appendAccessEscaped(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			dst = append(dst, '\\', c)
+		case c < ' ' || c >= 0x7f:
+			dst = append(dst, `\x`...)
+			if c < 0x10 {
+				dst = append(dst, '0')
+			}
+			dst = strconv.AppendUint(dst, uint64(c), 16)
+		default:
+			dst = append(dst, c)
+		}
+	}
+	return dst
+}
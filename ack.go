@@ -0,0 +1,30 @@
+package log4go
+
+import "errors"
+
+// ErrDropped is the error a ReliableHandler acknowledges the records it dropped with, e.g.
+// when its queue was full or it was shut down.
+var ErrDropped = errors.New("log4go: record dropped")
+
+// ReliableHandler is implemented by the handlers acknowledging the records once they're
+// actually written or sent, not merely queued, so that the components keeping the records
+// until then (e.g. WALHandler) know when they're safe.
+type ReliableHandler interface {
+	Handler
+	// HandleAck handles the record like Handle, and calls ack once the record is written (or
+	// sent), with nil, or with the error if it won't be; ack may be called from another
+	// goroutine, before HandleAck returns, and mustn't block.
+	HandleAck(rec *Record, ack func(error)) error
+}
+
+var (
+	_ ReliableHandler = &StreamHandler{}
+	_ ReliableHandler = &batchHandler{}
+)
+
+// acknowledge calls the acks with err.
+func acknowledge(acks []func(error), err error) {
+	for _, ack := range acks {
+		ack(err)
+	}
+}
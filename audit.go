@@ -0,0 +1,269 @@
+package log4go
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// auditMACSize is the size of the audit HMACs, hex encoded.
+const auditMACSize = 2 * sha256.Size
+
+// AuditHandler writes a tamper-evident audit trail to a file: each record is written on one
+// line (newlines escaped), preceded by its HMAC-SHA256 and the one of the previous record:
+//
+//	<HMAC> <previous HMAC> <record>
+//
+// The HMAC is computed over the previous HMAC and the record, so that modifying, removing,
+// inserting or reordering records breaks the chain, which VerifyAudit checks. The chain
+// continues across restarts (from the file's last record) and re-opened files (see Reopen).
+type AuditHandler struct {
+	*StreamHandler
+
+	audit *auditFormatter
+}
+
+// NewAuditHandler returns a new AuditHandler appending to the file, the records being signed
+// with key. The file can't be encrypted, and gets no start header.
+func NewAuditHandler(filename string, key []byte, options FileOptions) (*AuditHandler, error) {
+	if len(key) == 0 {
+		return nil, errors.New("log4go.AuditHandler: no key")
+	}
+	if len(options.EncryptionKey) > 0 {
+		return nil, errors.New("log4go.AuditHandler: audit files can't be encrypted")
+	}
+	options.Append = true
+	options.WriteStartHeader = false
+
+	prev, partial, err := lastAuditMAC(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	formatter, err := NewTemplateFormatter("{time} {name} {level} {message}")
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := NewFileHandlerWithOptions(filename, options)
+	if err != nil {
+		return nil, err
+	}
+	if partial {
+		_, _ = s.Writer.Write([]byte{'\n'}) // terminate the incomplete (invalid) record
+	}
+	h := &AuditHandler{
+		StreamHandler: s,
+		audit:         &auditFormatter{key: key, prev: prev, inner: formatter},
+	}
+	s.StreamFormatter = h.audit
+	s.self = h
+	return h, nil
+}
+
+var _ Handler = &AuditHandler{}
+
+// SetFormatter sets the formatter of the records, before they are signed.
+func (h *AuditHandler) SetFormatter(formatter Formatter) {
+	if formatter == nil {
+		h.report(errors.New("setting nil formatter"))
+	}
+	h.audit.mu.Lock()
+	h.audit.inner = formatter
+	h.audit.mu.Unlock()
+}
+
+// Formatter returns the formatter of the records.
+func (h *AuditHandler) Formatter() Formatter {
+	h.audit.mu.Lock()
+	defer h.audit.mu.Unlock()
+	return h.audit.inner
+}
+
+// auditFormatter signs the records formatted by inner, chaining them.
+type auditFormatter struct {
+	key []byte
+
+	mu    sync.Mutex
+	inner Formatter
+	prev  []byte // HMAC of the previous record, nil at the start of the chain
+}
+
+func (f *auditFormatter) Format(rec *Record) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.inner == nil {
+		return nil, errNoFormatter
+	}
+	msg, err := f.inner.Format(rec)
+	if err != nil {
+		return nil, err
+	}
+	msg = bytes.Replace(msg, []byte{'\n'}, []byte(`\n`), -1)
+
+	prev := f.prev
+	if prev == nil {
+		prev = make([]byte, sha256.Size)
+	}
+	mac := auditMAC(f.key, prev, msg)
+
+	line := make([]byte, 0, 2*auditMACSize+len(msg)+3)
+	line = appendHex(line, mac)
+	line = append(line, ' ')
+	line = appendHex(line, prev)
+	line = append(line, ' ')
+	line = append(line, msg...)
+	line = append(line, '\n')
+
+	f.prev = mac
+	return line, nil
+}
+
+// RawOutput returns true: the lines must be written as signed.
+func (f *auditFormatter) RawOutput() bool {
+	return true
+}
+
+func auditMAC(key, prev, msg []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(prev)
+	mac.Write(msg)
+	return mac.Sum(nil)
+}
+
+func appendHex(dst, src []byte) []byte {
+	n := len(dst)
+	dst = append(dst, make([]byte, hex.EncodedLen(len(src)))...)
+	hex.Encode(dst[n:], src)
+	return dst
+}
+
+// lastAuditMAC returns the HMAC of the last record of an audit file (nil if it has none), and
+// whether the file ends with an incomplete line.
+func lastAuditMAC(filename string) ([]byte, bool, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, false, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+
+	// read the end of the file, more of it until the last complete line is found
+	size := info.Size()
+	for window := int64(4096); ; window *= 2 {
+		if window > size {
+			window = size
+		}
+		tail := make([]byte, window)
+		if _, err = f.ReadAt(tail, size-window); err != nil {
+			return nil, false, err
+		}
+		partial := len(tail) > 0 && tail[len(tail)-1] != '\n'
+		end := bytes.LastIndexByte(tail, '\n')
+		if end < 0 && window == size {
+			return nil, partial, nil // no complete line
+		}
+		if end >= 0 {
+			start := bytes.LastIndexByte(tail[:end], '\n') + 1
+			if start > 0 || window == size {
+				line := tail[start:end]
+				if len(line) < auditMACSize {
+					return nil, partial, errors.New("log4go.AuditHandler: not an audit file")
+				}
+				mac, err := hex.DecodeString(string(line[:auditMACSize]))
+				if err != nil {
+					return nil, partial, errors.New("log4go.AuditHandler: not an audit file")
+				}
+				return mac, partial, nil
+			}
+		}
+	}
+}
+
+// AuditError is the error returned by VerifyAudit for a record breaking the audit trail.
+type AuditError struct {
+	Line   int // in the verified log
+	Reason string
+}
+
+func (e *AuditError) Error() string {
+	return fmt.Sprintf("audit line %d: %s", e.Line, e.Reason)
+}
+
+// AuditVerifier verifies audit trails written by AuditHandlers.
+type AuditVerifier struct {
+	key     []byte
+	prev    []byte
+	records int
+}
+
+// NewAuditVerifier returns a new AuditVerifier for the records signed with key.
+func NewAuditVerifier(key []byte) *AuditVerifier {
+	return &AuditVerifier{key: key}
+}
+
+// Verify reads an audit log, checking that each record is correctly signed, and follows the
+// previous record: in r, or in the logs verified before, e.g. rotated files verified oldest
+// first. The first record verified may follow any record, as older records may have been
+// deleted legitimately. It returns an *AuditError for the first invalid record.
+func (v *AuditVerifier) Verify(r io.Reader) error {
+	br := bufio.NewReader(r)
+	for line := 1; ; line++ {
+		text, err := br.ReadBytes('\n')
+		if err == io.EOF {
+			if len(text) > 0 {
+				return &AuditError{Line: line, Reason: "incomplete record"}
+			}
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := v.verify(text[:len(text)-1]); err != nil {
+			return &AuditError{Line: line, Reason: err.Error()}
+		}
+	}
+}
+
+func (v *AuditVerifier) verify(text []byte) error {
+	if len(text) < 2*auditMACSize+2 || text[auditMACSize] != ' ' || text[2*auditMACSize+1] != ' ' {
+		return errors.New("invalid record")
+	}
+	mac, err := hex.DecodeString(string(text[:auditMACSize]))
+	if err != nil {
+		return errors.New("invalid HMAC")
+	}
+	prev, err := hex.DecodeString(string(text[auditMACSize+1 : 2*auditMACSize+1]))
+	if err != nil {
+		return errors.New("invalid previous HMAC")
+	}
+
+	if v.prev != nil && !hmac.Equal(prev, v.prev) {
+		return errors.New("doesn't follow the previous record")
+	}
+	if !hmac.Equal(mac, auditMAC(v.key, prev, text[2*auditMACSize+2:])) {
+		return errors.New("invalid HMAC")
+	}
+	v.prev = mac
+	v.records++
+	return nil
+}
+
+// Records returns the number of valid records verified.
+func (v *AuditVerifier) Records() int {
+	return v.records
+}
+
+// VerifyAudit verifies an audit log written with key, see AuditVerifier.Verify.
+func VerifyAudit(r io.Reader, key []byte) error {
+	return NewAuditVerifier(key).Verify(r)
+}
@@ -0,0 +1,326 @@
+package log4go
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// batchHandler is the base of handlers sending records in batches from their own goroutine,
+// it implements the Handler interface except for what's specific to the destination, which
+// is left to a batchSink.
+type batchHandler struct {
+	counters handlerCounters // first, for 64-bit alignment
+
+	level     int32 // Level, accessed atomically
+	formatter atomic.Value
+	breaker   atomic.Value // breakerValue, see SetCircuitBreaker
+	spool     atomic.Value // spoolValue, see SetSpool
+
+	queue    chan *Record
+	done     chan struct{}
+	mu       sync.RWMutex // guards shutdown vs. sending to queue
+	shutdown bool
+	stopOnce sync.Once
+	stopping chan struct{}
+
+	self Handler // the handler reporting errors, set by run
+}
+
+// batchSink collects records into batches and sends them, it's only used from the
+// batchHandler's goroutine.
+type batchSink interface {
+	// add adds the record to the batch, returning true if the batch should be flushed.
+	add(rec *Record) bool
+	// flush sends the pending batch(es).
+	flush()
+	// close releases the sink's resources, after the final flush.
+	close()
+}
+
+// formatterValue wraps formatters for atomic.Value (which needs a consistent type).
+type formatterValue struct {
+	Formatter
+}
+
+// breakerValue wraps circuit breakers for atomic.Value.
+type breakerValue struct {
+	*CircuitBreaker
+}
+
+// spoolValue wraps spools for atomic.Value.
+type spoolValue struct {
+	*Spool
+}
+
+func (h *batchHandler) init(queueSize int, formatter Formatter) {
+	h.queue = make(chan *Record, queueSize)
+	h.done = make(chan struct{})
+	h.stopping = make(chan struct{})
+	h.formatter.Store(formatterValue{formatter})
+	h.breaker.Store(breakerValue{})
+	h.spool.Store(spoolValue{})
+}
+
+// run starts the goroutine feeding records to the sink, flushing at least every interval.
+func (h *batchHandler) run(sink batchSink, interval time.Duration) {
+	h.self, _ = sink.(Handler)
+	go func() {
+		defer close(h.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		pending := false
+		for {
+			select {
+			case rec, ok := <-h.queue:
+				if !ok {
+					if pending {
+						h.flushSink(sink)
+					}
+					sink.close()
+					if spool := h.spool.Load().(spoolValue).Spool; spool != nil {
+						_ = spool.Close() // what's left is replayed by the next process
+					}
+					return
+				}
+				if h.spoolRecord(rec) {
+					rec.release()
+					continue
+				}
+				pending = true
+				flush := sink.add(rec)
+				if rec.ack != nil { // not taken by the sink, see takeAck
+					rec.ack(ErrDropped)
+				}
+				rec.release()
+				if flush {
+					h.flushSink(sink)
+					pending = false
+				}
+
+			case <-ticker.C:
+				if h.replay(sink) {
+					pending = true
+				}
+				if pending {
+					h.flushSink(sink)
+					pending = false
+				}
+			}
+		}
+	}()
+}
+
+// flushSink flushes the sink, timing it.
+func (h *batchHandler) flushSink(sink batchSink) {
+	start := time.Now()
+	sink.flush()
+	h.counters.countWrite(time.Since(start))
+}
+
+// attempt calls send, the sink's sending of its pending batch(es), through the circuit
+// breaker if one is set.
+func (h *batchHandler) attempt(send func() error) error {
+	if b := h.breaker.Load().(breakerValue).CircuitBreaker; b != nil {
+		return b.Do(send)
+	}
+	return send()
+}
+
+// SetCircuitBreaker makes the handler send its batches through a circuit breaker (see
+// CircuitBreaker): while the circuit is open, the sends fail with ErrCircuitOpen rather than
+// waiting for an unavailable destination, and the batches are retried or dropped as on any
+// failure.
+func (h *batchHandler) SetCircuitBreaker(options CircuitBreakerOptions) {
+	h.breaker.Store(breakerValue{NewCircuitBreaker(options)})
+}
+
+// SetSpool makes the handler spool the records it can't send while the circuit is open,
+// replaying them in order once it closes (see Spool), nil for none; the batches are sent
+// through a circuit breaker with the default options unless SetCircuitBreaker was called. The
+// spool is closed when the handler is shut down.
+func (h *batchHandler) SetSpool(spool *Spool) {
+	if h.breaker.Load().(breakerValue).CircuitBreaker == nil {
+		h.SetCircuitBreaker(CircuitBreakerOptions{})
+	}
+	h.spool.Store(spoolValue{spool})
+}
+
+// spoolRecord spools the record if the circuit is open, or if spooled records are waiting
+// (they go first); it returns false if the record is to be added to the sink.
+func (h *batchHandler) spoolRecord(rec *Record) bool {
+	spool := h.spool.Load().(spoolValue).Spool
+	if spool == nil || spool.Len() == 0 && !h.breaker.Load().(breakerValue).refusing() {
+		return false
+	}
+	payload, err := encodeBinaryRecord(rec)
+	if err == nil {
+		err = spool.Append(payload)
+	}
+	if err != nil {
+		h.counters.countDropped(1)
+		h.report(&DroppedError{Count: 1, Err: err})
+	}
+	if rec.ack != nil {
+		rec.ack(err)
+	}
+	return true
+}
+
+// replay adds the spooled records to the sink (flushing the full batches) until the circuit
+// opens, it returns whether records were added and not flushed yet.
+func (h *batchHandler) replay(sink batchSink) bool {
+	spool := h.spool.Load().(spoolValue).Spool
+	if spool == nil || spool.Len() == 0 {
+		return false
+	}
+	breaker := h.breaker.Load().(breakerValue).CircuitBreaker
+	pending := false
+	err := spool.Replay(func(p []byte) error {
+		if breaker.refusing() {
+			return ErrCircuitOpen // kept for the next replay
+		}
+		rec, err := DecodeBinaryRecord(p)
+		if err != nil {
+			h.counters.countDropped(1)
+			h.report(&DroppedError{Count: 1, Err: err})
+			return nil
+		}
+		pending = true
+		if sink.add(rec) {
+			h.flushSink(sink)
+			pending = false
+		}
+		return nil
+	})
+	if err != nil && err != ErrCircuitOpen {
+		h.report(err)
+	}
+	return pending
+}
+
+// report reports an error to the ErrorHandler.
+func (h *batchHandler) report(err error) {
+	reportError(h.self, err)
+}
+
+// Handle queues the record for sending.
+func (h *batchHandler) Handle(rec *Record) error {
+	return h.handle(rec, nil)
+}
+
+// HandleAck queues the record like Handle, and calls ack once it's sent (or spooled, see
+// SetSpool) with nil, else with the error, e.g. once the retries are exhausted.
+func (h *batchHandler) HandleAck(rec *Record, ack func(error)) error {
+	return h.handle(rec, ack)
+}
+
+// handle queues the record, with its ack if not nil.
+func (h *batchHandler) handle(rec *Record, ack func(error)) error {
+	if rec.Level < h.Level() {
+		if ack != nil {
+			ack(nil) // nothing to send
+		}
+		return nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if !h.shutdown {
+		var r *Record
+		if ack == nil {
+			r = rec.retain()
+		} else {
+			c := *rec // not shared with the other handlers, unlike the pooled records
+			c.pool = nil
+			c.ack = ack
+			r = &c
+		}
+		select {
+		case h.queue <- r:
+		case <-h.stopping:
+			r.release()
+			h.dropped(ack)
+		}
+	} else {
+		h.dropped(ack)
+	}
+	return nil
+}
+
+// dropped counts a record dropped, calling its ack if not nil.
+func (h *batchHandler) dropped(ack func(error)) {
+	h.counters.countDropped(1)
+	if ack != nil {
+		ack(ErrDropped)
+	}
+}
+
+// takeAck returns the ack of the record a sink adds to its batch, nil if none: the sink calls
+// it once the record is sent, or dropped. The records whose ack isn't taken are dropped.
+func takeAck(rec *Record) func(error) {
+	ack := rec.ack
+	rec.ack = nil
+	return ack
+}
+
+// acknowledgeDropped calls the acks of records dropped because of err, with ErrDropped if nil.
+func acknowledgeDropped(acks []func(error), err error) {
+	if err == nil {
+		err = ErrDropped
+	}
+	acknowledge(acks, err)
+}
+
+func (h *batchHandler) handlerStats() HandlerStats {
+	return h.counters.read(len(h.queue), cap(h.queue))
+}
+
+// SetFormatter sets the handler's Formatter.
+func (h *batchHandler) SetFormatter(formatter Formatter) {
+	h.formatter.Store(formatterValue{formatter})
+}
+
+// Formatter returns the handler's Formatter.
+func (h *batchHandler) Formatter() Formatter {
+	return h.formatter.Load().(formatterValue).Formatter
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *batchHandler) SetLevel(level Level) {
+	atomic.StoreInt32(&h.level, int32(level))
+}
+
+// Level returns the level previously set (or NOTSET if not set).
+func (h *batchHandler) Level() Level {
+	return Level(atomic.LoadInt32(&h.level))
+}
+
+// Shutdown sends the queued records, then releases the handler's resources.
+func (h *batchHandler) Shutdown() {
+	_ = h.ShutdownContext(context.Background())
+}
+
+// ShutdownContext sends the queued records, then releases the handler's resources, or
+// returns ctx.Err() if ctx is done first.
+func (h *batchHandler) ShutdownContext(ctx context.Context) error {
+	h.stopOnce.Do(func() { close(h.stopping) })
+
+	h.mu.Lock()
+	if !h.shutdown {
+		h.shutdown = true
+		close(h.queue)
+	}
+	h.mu.Unlock()
+
+	select {
+	case <-h.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,169 @@
+package log4go
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/kaizer666/log4go/internal/msgpack"
+)
+
+// RawFormatter is implemented by formatters whose output handlers must write exactly as
+// returned: no newline is appended, ANSI sequences are not stripped and nothing is truncated.
+type RawFormatter interface {
+	Formatter
+	// RawOutput reports whether the output must be written as is.
+	RawOutput() bool
+}
+
+// isRawFormatter reports whether f's output must be written as is.
+func isRawFormatter(f Formatter) bool {
+	raw, ok := f.(RawFormatter)
+	return ok && raw.RawOutput()
+}
+
+// BinaryFormatVersion is the version of the record layout written by BinaryFormatter.
+const BinaryFormatVersion = 2
+
+// BinaryFormatter formats records in a compact binary format, readable with the reader
+// package: each record is a uvarint length followed by a MessagePack array of
+// [version, time (Unix nanoseconds), name, level, message, seq, goroutine ID, fields, extras,
+// tags, MDC, file, line]. Version 1 records, ending with the fields merged with the extras,
+// are still decoded.
+type BinaryFormatter struct{}
+
+// NewBinaryFormatter returns a new BinaryFormatter.
+func NewBinaryFormatter() *BinaryFormatter {
+	return &BinaryFormatter{}
+}
+
+var _ RawFormatter = &BinaryFormatter{}
+
+// RawOutput is always true, binary records must not be altered.
+func (f *BinaryFormatter) RawOutput() bool {
+	return true
+}
+
+// Format returns the length prefixed binary record.
+func (f *BinaryFormatter) Format(r *Record) ([]byte, error) {
+	if r.Level == NOTSET {
+		return []byte{}, ErrorNotSet
+	}
+
+	payload := make([]byte, 0, 64+len(r.Message))
+	payload = msgpack.AppendArrayHeader(payload, 13)
+	payload = msgpack.AppendUint(payload, BinaryFormatVersion)
+	payload = msgpack.AppendInt(payload, r.Time.UnixNano())
+	payload = msgpack.AppendString(payload, r.Name)
+	payload = msgpack.AppendInt(payload, int64(r.Level))
+	payload = msgpack.AppendString(payload, r.Message)
+	payload = msgpack.AppendUint(payload, r.Seq)
+	payload = msgpack.AppendUint(payload, r.GoroutineID)
+	payload = appendBinaryMap(payload, r.Fields)
+	payload = appendBinaryMap(payload, r.Extras)
+	if r.Tags == nil {
+		payload = msgpack.AppendNil(payload)
+	} else {
+		payload = msgpack.AppendArrayHeader(payload, len(r.Tags))
+		for _, tag := range r.Tags {
+			payload = msgpack.AppendString(payload, tag)
+		}
+	}
+	payload = appendBinaryMap(payload, r.MDC)
+	payload = msgpack.AppendString(payload, r.File)
+	payload = msgpack.AppendInt(payload, int64(r.Line))
+
+	frame := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(payload))
+	frame = append(frame[:binary.PutUvarint(frame, uint64(len(payload)))], payload...)
+	return frame, nil
+}
+
+// appendBinaryMap appends m, nil if m is.
+func appendBinaryMap(b []byte, m map[string]interface{}) []byte {
+	if m == nil {
+		return msgpack.AppendNil(b)
+	}
+	return msgpack.Append(b, m)
+}
+
+// encodeBinaryRecord returns the binary record's payload (i.e. without the length prefix).
+func encodeBinaryRecord(rec *Record) ([]byte, error) {
+	frame, err := (&BinaryFormatter{}).Format(rec)
+	if err != nil {
+		return nil, err
+	}
+	_, n := binary.Uvarint(frame)
+	return frame[n:], nil
+}
+
+// DecodeBinaryRecord decodes a binary record's payload (i.e. without the length prefix), see
+// the reader package for reading binary records. The values of the fields, extras and MDC are
+// decoded as MessagePack's: the integers as int64 or uint64, the slices as []interface{}, the
+// maps as map[string]interface{}, the times and the other types as strings.
+func DecodeBinaryRecord(payload []byte) (*Record, error) {
+	v, _, err := msgpack.Decode(payload)
+	if err != nil {
+		return nil, err
+	}
+	items, ok := v.([]interface{})
+	if !ok || len(items) < 8 {
+		return nil, errors.New("invalid binary record")
+	}
+	version, _ := items[0].(int64)
+	if version != 1 && version != BinaryFormatVersion {
+		return nil, fmt.Errorf("unsupported binary record version %v", items[0])
+	}
+	if version == BinaryFormatVersion && len(items) < 13 {
+		return nil, errors.New("invalid binary record")
+	}
+	nanos, ok1 := items[1].(int64)
+	name, ok2 := items[2].(string)
+	level, ok3 := items[3].(int64)
+	message, ok4 := items[4].(string)
+	if !ok1 || !ok2 || !ok3 || !ok4 {
+		return nil, errors.New("invalid binary record")
+	}
+
+	rec := &Record{
+		Time:        time.Unix(0, nanos),
+		Name:        name,
+		Level:       Level(level),
+		Message:     message,
+		Seq:         binaryUint(items[5]),
+		GoroutineID: binaryUint(items[6]),
+	}
+	if fields, ok := items[7].(map[string]interface{}); ok {
+		rec.Fields = Fields(fields)
+	}
+	if version == 1 {
+		return rec, nil
+	}
+
+	rec.Extras, _ = items[8].(map[string]interface{})
+	if tags, ok := items[9].([]interface{}); ok {
+		rec.Tags = make(Tags, 0, len(tags))
+		for _, tag := range tags {
+			if tag, ok := tag.(string); ok {
+				rec.Tags = append(rec.Tags, tag)
+			}
+		}
+	}
+	if mdc, ok := items[10].(map[string]interface{}); ok {
+		rec.MDC = Fields(mdc)
+	}
+	rec.File, _ = items[11].(string)
+	rec.Line = int(binaryUint(items[12]))
+	return rec, nil
+}
+
+// binaryUint returns the decoded unsigned integer v, 0 if not one.
+func binaryUint(v interface{}) uint64 {
+	switch v := v.(type) {
+	case int64:
+		return uint64(v)
+	case uint64:
+		return v
+	}
+	return 0
+}
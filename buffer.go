@@ -0,0 +1,162 @@
+package log4go
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SyncPolicy is when a buffered handler syncs its file to disk (see SetBuffering).
+type SyncPolicy int
+
+const (
+	// SyncNever leaves syncing to the operating system.
+	SyncNever SyncPolicy = iota
+	// SyncInterval syncs the file each time the buffer is flushed by the FlushInterval.
+	SyncInterval
+	// SyncEveryRecord flushes the buffer and syncs the file after each write, the records
+	// being on disk once written (batched records, see SetBatching, are synced together).
+	SyncEveryRecord
+)
+
+// BufferOptions configures the buffering of a handler's writes.
+type BufferOptions struct {
+	// Size is the buffer size in bytes (default 64KB).
+	Size int
+	// FlushInterval is the maximum time written records wait in the buffer (default 1s).
+	FlushInterval time.Duration
+	// Sync is when the file is synced to disk (default SyncNever).
+	Sync SyncPolicy
+}
+
+// fileBuffer is a handler's write buffer, owned by the committer.
+type fileBuffer struct {
+	options  *BufferOptions
+	w        io.Writer // the buffered writer
+	bw       *bufio.Writer
+	unsynced bool          // written since the last sync
+	acks     []func(error) // of the buffered (or unsynced) records
+}
+
+// SetBuffering buffers the handler's writes (to its Writer, usually a file), trading
+// durability for throughput: the buffer is written when full, every FlushInterval, on Flush
+// and on shutdown, and the file synced according to the Sync policy. SetBuffering(BufferOptions{})
+// disables the buffering.
+func (h *StreamHandler) SetBuffering(options BufferOptions) {
+	if options.Size == 0 && options.FlushInterval == 0 && options.Sync == SyncNever {
+		h.buffering.Store((*BufferOptions)(nil))
+		return
+	}
+	if options.Size <= 0 {
+		options.Size = 64 * 1024
+	}
+	if options.FlushInterval <= 0 {
+		options.FlushInterval = time.Second
+	}
+	h.buffering.Store(&options)
+}
+
+// bufferedWriter returns the writer the committer writes w's records to: a buffer over it when
+// buffering is set and w is the handler's Writer, w itself otherwise.
+func (h *StreamHandler) bufferedWriter(w io.Writer) io.Writer {
+	options, _ := h.buffering.Load().(*BufferOptions)
+	if b := h.buffer; b != nil && (b.options != options || b.w != h.Writer) {
+		h.flushBuffer(true)
+		h.buffer = nil
+	}
+	if options == nil || w != h.Writer {
+		return w
+	}
+	if h.buffer == nil {
+		h.buffer = &fileBuffer{options: options, w: w, bw: bufio.NewWriterSize(w, options.Size)}
+	}
+	return h.buffer.bw
+}
+
+// written is called by the committer after writing to w, err being the write error.
+func (h *StreamHandler) written(w io.Writer, err error) {
+	if h.buffer == nil || w != io.Writer(h.buffer.bw) {
+		return
+	}
+	if err != nil {
+		h.buffer.bw.Reset(h.buffer.w) // the error sticks otherwise
+		acknowledge(h.buffer.acks, err)
+		h.buffer.acks = nil
+		return
+	}
+	h.buffer.unsynced = true
+	if h.buffer.options.Sync == SyncEveryRecord {
+		h.flushBuffer(true)
+	}
+}
+
+// flushBuffer writes the buffered records, and syncs the file if sync is set (and the policy
+// isn't SyncNever).
+func (h *StreamHandler) flushBuffer(sync bool) error {
+	b := h.buffer
+	if b == nil {
+		return nil
+	}
+	err := b.bw.Flush()
+	if err != nil {
+		h.report(fmt.Errorf("write error: %w", err))
+		h.counters.countError(err)
+		b.bw.Reset(b.w) // drop what couldn't be written, the buffer is unusable otherwise
+	}
+	if sync && b.unsynced && b.options.Sync != SyncNever {
+		if s, ok := b.w.(interface{ Sync() error }); ok {
+			if serr := s.Sync(); serr != nil {
+				h.report(fmt.Errorf("sync error: %w", serr))
+				h.counters.countError(serr)
+				if err == nil {
+					err = serr
+				}
+			}
+		}
+		b.unsynced = false
+	}
+	if err != nil || !b.unsynced || b.options.Sync == SyncNever {
+		acknowledge(b.acks, err)
+		b.acks = nil
+	}
+	return err
+}
+
+// bufferPending returns true if the buffer has records to write, or the file to sync.
+func (h *StreamHandler) bufferPending() bool {
+	b := h.buffer
+	return b != nil && (b.bw.Buffered() > 0 || b.unsynced && b.options.Sync != SyncNever)
+}
+
+// committerTimer is a timer the committer selects on, C being nil while stopped.
+type committerTimer struct {
+	timer *time.Timer
+	C     <-chan time.Time
+}
+
+// start starts the timer, unless it's already running.
+func (t *committerTimer) start(d time.Duration) {
+	if t.C != nil {
+		return
+	}
+	if t.timer == nil {
+		t.timer = time.NewTimer(d)
+	} else {
+		t.timer.Reset(d)
+	}
+	t.C = t.timer.C
+}
+
+// stop stops the timer, which mustn't have fired unless fired was called.
+func (t *committerTimer) stop() {
+	if t.C != nil && !t.timer.Stop() {
+		<-t.timer.C
+	}
+	t.C = nil
+}
+
+// fired marks the timer as stopped, after receiving from C.
+func (t *committerTimer) fired() {
+	t.C = nil
+}
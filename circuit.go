@@ -0,0 +1,311 @@
+package log4go
+
+import (
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrCircuitOpen is returned by a CircuitBreaker refusing calls while its circuit is open.
+var ErrCircuitOpen = errors.New("log4go: circuit open")
+
+// CircuitState is the state of a CircuitBreaker.
+type CircuitState int
+
+// Circuit states.
+const (
+	// CircuitClosed lets the calls through.
+	CircuitClosed CircuitState = iota
+	// CircuitOpen refuses the calls, after too many consecutive failures.
+	CircuitOpen
+	// CircuitHalfOpen lets a single probing call through, once the circuit was open long enough.
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// CircuitBreakerOptions configures a CircuitBreaker.
+type CircuitBreakerOptions struct {
+	// FailureThreshold is the number of consecutive failures opening the circuit (default 5).
+	FailureThreshold int
+	// MinBackoff is how long the circuit first stays open (default 1s); it doubles each time
+	// the probe fails, up to MaxBackoff (default 1m), with a ±20% jitter.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// CircuitBreaker protects the network handlers (and their callers) from an unavailable
+// remote: after FailureThreshold consecutive failures the calls are refused for a jittered,
+// exponentially growing backoff, after which a single probing call decides whether the
+// circuit closes again. It's safe for concurrent use.
+type CircuitBreaker struct {
+	options CircuitBreakerOptions
+
+	mu        sync.Mutex
+	state     CircuitState
+	failures  int
+	backoff   time.Duration // of the next opening
+	openUntil time.Time
+}
+
+// NewCircuitBreaker returns a new, closed, CircuitBreaker.
+func NewCircuitBreaker(options CircuitBreakerOptions) *CircuitBreaker {
+	if options.FailureThreshold <= 0 {
+		options.FailureThreshold = 5
+	}
+	if options.MinBackoff <= 0 {
+		options.MinBackoff = time.Second
+	}
+	if options.MaxBackoff < options.MinBackoff {
+		options.MaxBackoff = time.Minute
+		if options.MaxBackoff < options.MinBackoff {
+			options.MaxBackoff = options.MinBackoff
+		}
+	}
+	return &CircuitBreaker{options: options, backoff: options.MinBackoff}
+}
+
+// Do calls f unless the circuit is open (returning ErrCircuitOpen), and records its outcome.
+func (b *CircuitBreaker) Do(f func() error) error {
+	if !b.allow() {
+		return ErrCircuitOpen
+	}
+	err := f()
+	b.record(err == nil)
+	return err
+}
+
+// State returns the circuit's state.
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// allow reports whether a call may go through, switching an open circuit to half-open when
+// its backoff has elapsed.
+func (b *CircuitBreaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case CircuitOpen:
+		if now().Before(b.openUntil) {
+			return false
+		}
+		b.state = CircuitHalfOpen // this call is the probe
+		return true
+	case CircuitHalfOpen:
+		return false // the probe is in flight
+	}
+	return true
+}
+
+// refusing reports whether the circuit is open, and its backoff not elapsed yet.
+func (b *CircuitBreaker) refusing() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state == CircuitOpen && now().Before(b.openUntil)
+}
+
+// record records the outcome of a call.
+func (b *CircuitBreaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.state = CircuitClosed
+		b.failures = 0
+		b.backoff = b.options.MinBackoff
+		return
+	}
+	b.failures++
+	if b.state == CircuitHalfOpen || b.failures >= b.options.FailureThreshold {
+		b.state = CircuitOpen
+		b.openUntil = now().Add(jitter(b.backoff))
+		if b.backoff *= 2; b.backoff > b.options.MaxBackoff {
+			b.backoff = b.options.MaxBackoff
+		}
+	}
+}
+
+// jitter returns d ±20%.
+func jitter(d time.Duration) time.Duration {
+	return d + time.Duration((rand.Float64()-0.5)*0.4*float64(d))
+}
+
+// CircuitWriter writes to a writer through a CircuitBreaker: while the circuit is open, or
+// when writing fails, the data is spooled if there's a spool (see SetSpool), to be replayed
+// in order before the next data once the writer works again, else spilled to the overflow
+// file if there's one (see SetSpillFile), else the write fails.
+type CircuitWriter struct {
+	w       io.Writer
+	breaker *CircuitBreaker
+
+	mu        sync.Mutex
+	spool     *Spool
+	spillName string
+	spill     *os.File
+	spilled   uint64 // bytes, accessed atomically
+}
+
+// NewCircuitWriter returns a new CircuitWriter writing to w through breaker.
+func NewCircuitWriter(w io.Writer, breaker *CircuitBreaker) *CircuitWriter {
+	return &CircuitWriter{w: w, breaker: breaker}
+}
+
+// SetSpillFile sets the overflow file the data is appended to when it can't be written, ""
+// for none. The file isn't replayed: it's kept for recovery.
+func (w *CircuitWriter) SetSpillFile(filename string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.spill != nil {
+		_ = w.spill.Close()
+		w.spill = nil
+	}
+	w.spillName = filename
+}
+
+// SetSpool sets the spool the data is appended to when it can't be written, nil for none;
+// it's closed with the writer.
+func (w *CircuitWriter) SetSpool(spool *Spool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.spool = spool
+}
+
+// Breaker returns the writer's circuit breaker.
+func (w *CircuitWriter) Breaker() *CircuitBreaker {
+	return w.breaker
+}
+
+// Spilled returns the number of bytes spooled or spilled to the overflow file.
+func (w *CircuitWriter) Spilled() uint64 {
+	return atomic.LoadUint64(&w.spilled)
+}
+
+// Write writes p through the circuit breaker, after replaying the spool, spooling or spilling
+// it if that fails.
+func (w *CircuitWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	spool := w.spool
+	w.mu.Unlock()
+
+	if spool != nil && spool.Len() > 0 {
+		// the spooled data goes first, or it would be out of order
+		if err := w.breaker.Do(func() error { return spool.Replay(w.send) }); err != nil {
+			return w.overflow(p, 0, err)
+		}
+	}
+
+	var n int
+	err := w.breaker.Do(func() error {
+		var err error
+		n, err = w.w.Write(p)
+		return err
+	})
+	if err == nil {
+		return n, nil
+	}
+	return w.overflow(p, n, err)
+}
+
+// send writes p to the writer, for replaying the spool.
+func (w *CircuitWriter) send(p []byte) error {
+	_, err := w.w.Write(p)
+	return err
+}
+
+// overflow spools or spills the data of p not written, p[n:], returning the result of the
+// write: the error if it couldn't be kept either.
+func (w *CircuitWriter) overflow(p []byte, n int, err error) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.spool != nil {
+		if spoolErr := w.spool.Append(p[n:]); spoolErr != nil {
+			return n, err
+		}
+		atomic.AddUint64(&w.spilled, uint64(len(p)-n))
+		return len(p), nil
+	}
+
+	if len(w.spillName) == 0 {
+		return n, err
+	}
+	if w.spill == nil {
+		fp, openErr := openFile(w.spillName, FileOptions{Append: true, CreateDirs: true})
+		if openErr != nil {
+			return n, err
+		}
+		w.spill = fp
+	}
+	if _, spillErr := w.spill.Write(p[n:]); spillErr != nil {
+		return n, err
+	}
+	atomic.AddUint64(&w.spilled, uint64(len(p)-n))
+	return len(p), nil
+}
+
+// Close closes the spool and the overflow file, and the writer if it's an io.Closer.
+func (w *CircuitWriter) Close() error {
+	w.mu.Lock()
+	if w.spool != nil {
+		_ = w.spool.Close()
+	}
+	if w.spill != nil {
+		_ = w.spill.Close()
+		w.spill = nil
+	}
+	w.mu.Unlock()
+	if c, ok := w.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// SetCircuitBreaker makes the handler write through a circuit breaker (see CircuitWriter),
+// spilling the records it can't send to spillFile if not empty.
+func (h *SocketHandler) SetCircuitBreaker(options CircuitBreakerOptions, spillFile string) {
+	_ = h.call(func(c *commit) error {
+		_ = h.flush(c) // errors reported already
+		w := NewCircuitWriter(h.writer, NewCircuitBreaker(options))
+		w.SetSpillFile(spillFile)
+		if old, ok := h.Writer.(*CircuitWriter); ok {
+			old.SetSpillFile("")
+			w.SetSpool(old.spool)
+		}
+		h.Writer = w
+		return nil
+	})
+}
+
+// SetSpool makes the handler spool the records it can't send, replaying them in order once
+// the socket works again (see CircuitWriter), nil for none; it writes through a circuit
+// breaker with the default options unless SetCircuitBreaker was called.
+func (h *SocketHandler) SetSpool(spool *Spool) {
+	_ = h.call(func(c *commit) error {
+		_ = h.flush(c) // errors reported already
+		w, ok := h.Writer.(*CircuitWriter)
+		if !ok {
+			w = NewCircuitWriter(h.writer, NewCircuitBreaker(CircuitBreakerOptions{}))
+			h.Writer = w
+		}
+		w.SetSpool(spool)
+		return nil
+	})
+}
@@ -0,0 +1,48 @@
+package log4go
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock provides the time of the records (see SetClock), e.g. a fake clock for
+// deterministic tests (see the log4gotest package).
+type Clock interface {
+	Now() time.Time
+}
+
+// clockValue wraps clocks for atomic.Value (which needs a consistent type).
+type clockValue struct {
+	Clock
+	start time.Time // the clock's time when set
+}
+
+var clock atomic.Value // clockValue
+
+// SetClock sets the clock giving the time of the records (and of the time based decisions
+// on them, like the SQLiteHandler's retention), the {uptime} then counts from its current
+// time; nil restores the system clock.
+func SetClock(c Clock) {
+	cv := clockValue{Clock: c}
+	if c != nil {
+		cv.start = c.Now()
+	}
+	clock.Store(cv)
+}
+
+// startTime returns the time the {uptime} counts from: the process start, or the clock's time
+// when set.
+func startTime() time.Time {
+	if c, _ := clock.Load().(clockValue); c.Clock != nil {
+		return c.start
+	}
+	return processStart
+}
+
+// now returns the clock's current time.
+func now() time.Time {
+	if c, _ := clock.Load().(clockValue); c.Clock != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
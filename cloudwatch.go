@@ -0,0 +1,367 @@
+package log4go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kaizer666/log4go/internal/sigv4"
+)
+
+// CloudWatch Logs PutLogEvents limits.
+const (
+	CloudWatchMaxBatchSize  = 10000
+	CloudWatchMaxBatchBytes = 1048576
+	CloudWatchMaxEventBytes = 262144
+	cloudWatchEventOverhead = 26
+	cloudWatchMaxBatchSpan  = 24 * time.Hour
+)
+
+// CloudWatchConfig configures a CloudWatchHandler.
+type CloudWatchConfig struct {
+	// Region is the AWS region (default: $AWS_REGION, then $AWS_DEFAULT_REGION).
+	Region string
+	// LogGroup is the log group name, LogStream the log stream name (default: the host name).
+	LogGroup  string
+	LogStream string
+	// CreateLogGroup makes the handler create the log group if it doesn't exist (the log
+	// stream is always created if needed).
+	CreateLogGroup bool
+	// The credentials (default: $AWS_ACCESS_KEY_ID, $AWS_SECRET_ACCESS_KEY and $AWS_SESSION_TOKEN).
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Endpoint overrides the service URL (default: https://logs.<region>.amazonaws.com).
+	Endpoint string
+	// BatchSize is the maximum number of events per PutLogEvents call (default and max 10000).
+	BatchSize int
+	// MaxBatchBytes is the maximum size of a PutLogEvents call, counted as CloudWatch does
+	// (default and max 1048576).
+	MaxBatchBytes int
+	// FlushInterval is the maximum time events wait for their batch (default 5s).
+	FlushInterval time.Duration
+	// Timeout limits each API call (default 10s).
+	Timeout time.Duration
+	// QueueSize is the number of records buffered before Handle blocks (default 1000).
+	QueueSize int
+	// MaxRetries is the number of times a batch is resent before it's dropped (default 3).
+	MaxRetries int
+	// HTTPClient is the client used for the API calls (default: http.DefaultClient).
+	HTTPClient *http.Client
+}
+
+// CloudWatchHandler sends records to AWS CloudWatch Logs, batching them into PutLogEvents
+// calls flushed when full (in events or bytes) or old enough. The records are formatted with
+// an ECSFormatter by default.
+type CloudWatchHandler struct {
+	batchHandler
+
+	config        CloudWatchConfig
+	creds         sigv4.Credentials
+	events        []cloudWatchEvent
+	size          int
+	retries       int
+	lastErr       error
+	acks          []func(error) // of the pending records, see takeAck
+	sequenceToken string
+	streamReady   bool
+}
+
+type cloudWatchEvent struct {
+	Timestamp int64  `json:"timestamp"`
+	Message   string `json:"message"`
+}
+
+// NewCloudWatchHandler returns a new CloudWatchHandler, the log group and stream are checked
+// (and created) on the first flush.
+func NewCloudWatchHandler(config CloudWatchConfig) (*CloudWatchHandler, error) {
+	if len(config.LogGroup) == 0 {
+		return nil, errors.New("log4go.CloudWatchHandler: no log group")
+	}
+	if len(config.Region) == 0 {
+		config.Region = os.Getenv("AWS_REGION")
+	}
+	if len(config.Region) == 0 {
+		config.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if len(config.Region) == 0 && len(config.Endpoint) == 0 {
+		return nil, errors.New("log4go.CloudWatchHandler: no region")
+	}
+	if len(config.Endpoint) == 0 {
+		config.Endpoint = "https://logs." + config.Region + ".amazonaws.com"
+	}
+	if len(config.LogStream) == 0 {
+		config.LogStream = hostname
+	}
+	if len(config.AccessKeyID) == 0 {
+		config.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		config.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		config.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if config.BatchSize <= 0 || config.BatchSize > CloudWatchMaxBatchSize {
+		config.BatchSize = CloudWatchMaxBatchSize
+	}
+	if config.MaxBatchBytes <= 0 || config.MaxBatchBytes > CloudWatchMaxBatchBytes {
+		config.MaxBatchBytes = CloudWatchMaxBatchBytes
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	h := &CloudWatchHandler{
+		config: config,
+		creds: sigv4.Credentials{
+			AccessKeyID:     config.AccessKeyID,
+			SecretAccessKey: config.SecretAccessKey,
+			SessionToken:    config.SessionToken,
+		},
+	}
+	h.init(config.QueueSize, NewECSFormatter())
+	h.run(h, config.FlushInterval)
+
+	return h, nil
+}
+
+var _ Handler = &CloudWatchHandler{}
+
+func (h *CloudWatchHandler) add(rec *Record) bool {
+	msg, err := h.Formatter().Format(rec)
+	if err != nil {
+		if err != ErrorNotSet {
+			h.report(fmt.Errorf("formatter error: %w", err))
+			h.counters.countError(err)
+		}
+		return false
+	}
+	if maxLength := CloudWatchMaxEventBytes - cloudWatchEventOverhead; len(msg) > maxLength {
+		msg = truncateRecord(msg, maxLength, []byte(DefaultTruncationMarker))
+	}
+
+	event := cloudWatchEvent{
+		Timestamp: rec.Time.UnixNano() / int64(time.Millisecond),
+		Message:   string(msg),
+	}
+	size := len(msg) + cloudWatchEventOverhead
+
+	// a batch can't exceed BatchSize events, MaxBatchBytes nor span more than 24 hours
+	if len(h.events) != 0 && (len(h.events) >= h.config.BatchSize ||
+		h.size+size > h.config.MaxBatchBytes || h.exceedsSpan(event.Timestamp)) {
+		h.flush()
+		if len(h.events) != 0 {
+			h.drop() // the flush failed, and there's no room left to keep retrying
+		}
+	}
+
+	h.events = append(h.events, event)
+	if ack := takeAck(rec); ack != nil {
+		h.acks = append(h.acks, ack)
+	}
+	h.size += size
+	return len(h.events) >= h.config.BatchSize
+}
+
+func (h *CloudWatchHandler) exceedsSpan(timestamp int64) bool {
+	span := int64(cloudWatchMaxBatchSpan / time.Millisecond)
+	for _, e := range h.events {
+		if timestamp-e.Timestamp >= span || e.Timestamp-timestamp >= span {
+			return true
+		}
+	}
+	return false
+}
+
+func (h *CloudWatchHandler) flush() {
+	if len(h.events) == 0 {
+		return
+	}
+
+	// the events of a batch must be in chronological order
+	sort.SliceStable(h.events, func(i, j int) bool {
+		return h.events[i].Timestamp < h.events[j].Timestamp
+	})
+
+	if err := h.attempt(h.put); err != nil {
+		h.lastErr = err
+		h.retries++
+		h.counters.countError(err)
+		if h.retries <= h.config.MaxRetries {
+			return // keep them for the next flush
+		}
+		h.drop()
+		return
+	}
+	h.counters.countHandled(len(h.events))
+	acknowledge(h.acks, nil)
+	h.reset()
+}
+
+func (h *CloudWatchHandler) drop() {
+	h.counters.countDropped(len(h.events))
+	h.report(&DroppedError{Count: len(h.events), Err: h.lastErr})
+	acknowledgeDropped(h.acks, h.lastErr)
+	h.reset()
+}
+
+func (h *CloudWatchHandler) reset() {
+	h.acks = h.acks[:0]
+	h.events = h.events[:0]
+	h.size = 0
+	h.retries = 0
+	h.lastErr = nil
+}
+
+func (h *CloudWatchHandler) close() {}
+
+// put sends the pending events, handling the log stream creation and sequence tokens.
+func (h *CloudWatchHandler) put() error {
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if !h.streamReady {
+			if err = h.createLogStream(); err != nil {
+				return err
+			}
+			h.streamReady = true
+		}
+
+		input := map[string]interface{}{
+			"logGroupName":  h.config.LogGroup,
+			"logStreamName": h.config.LogStream,
+			"logEvents":     h.events,
+		}
+		if len(h.sequenceToken) != 0 {
+			input["sequenceToken"] = h.sequenceToken
+		}
+		var output struct {
+			NextSequenceToken     string                 `json:"nextSequenceToken"`
+			RejectedLogEventsInfo map[string]interface{} `json:"rejectedLogEventsInfo"`
+		}
+
+		err = h.call("PutLogEvents", input, &output)
+		if err == nil {
+			h.sequenceToken = output.NextSequenceToken
+			if len(output.RejectedLogEventsInfo) != 0 {
+				h.report(fmt.Errorf("rejected events: %v", output.RejectedLogEventsInfo))
+			}
+			return nil
+		}
+
+		var awsErr *cloudWatchError
+		if !errors.As(err, &awsErr) {
+			return err
+		}
+		switch awsErr.Type {
+		case "InvalidSequenceTokenException":
+			h.sequenceToken = awsErr.ExpectedSequenceToken
+		case "DataAlreadyAcceptedException":
+			h.sequenceToken = awsErr.ExpectedSequenceToken
+			return nil
+		case "ResourceNotFoundException":
+			h.streamReady = false
+		default:
+			return err
+		}
+	}
+	return err
+}
+
+func (h *CloudWatchHandler) createLogStream() error {
+	if h.config.CreateLogGroup {
+		err := h.call("CreateLogGroup", map[string]interface{}{
+			"logGroupName": h.config.LogGroup,
+		}, nil)
+		if err != nil && !isCloudWatchError(err, "ResourceAlreadyExistsException") {
+			return err
+		}
+	}
+
+	err := h.call("CreateLogStream", map[string]interface{}{
+		"logGroupName":  h.config.LogGroup,
+		"logStreamName": h.config.LogStream,
+	}, nil)
+	if err != nil && !isCloudWatchError(err, "ResourceAlreadyExistsException") {
+		return err
+	}
+	return nil
+}
+
+// cloudWatchError is an error returned by the CloudWatch Logs API.
+type cloudWatchError struct {
+	Type                  string `json:"__type"`
+	Message               string `json:"message"`
+	ExpectedSequenceToken string `json:"expectedSequenceToken"`
+}
+
+func (e *cloudWatchError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+func isCloudWatchError(err error, errorType string) bool {
+	var awsErr *cloudWatchError
+	return errors.As(err, &awsErr) && awsErr.Type == errorType
+}
+
+// call calls the action of the CloudWatch Logs JSON API, decoding the response in output.
+func (h *CloudWatchHandler) call(action string, input, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, h.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "Logs_20140328."+action)
+	sigv4.Sign(req, body, h.creds, h.config.Region, "logs", time.Now())
+
+	resp, err := h.config.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		awsErr := &cloudWatchError{}
+		if json.Unmarshal(data, awsErr) != nil || len(awsErr.Type) == 0 {
+			return fmt.Errorf("%s: %s", action, resp.Status)
+		}
+		// the type may be prefixed with the namespace, e.g. "com.amazonaws...#ResourceNotFoundException"
+		if i := strings.LastIndexByte(awsErr.Type, '#'); i >= 0 {
+			awsErr.Type = awsErr.Type[i+1:]
+		}
+		return awsErr
+	}
+	if output != nil && len(data) != 0 {
+		return json.Unmarshal(data, output)
+	}
+	return nil
+}
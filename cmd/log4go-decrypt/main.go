@@ -0,0 +1,88 @@
+// Command log4go-decrypt decrypts log files written with an encryption key (see
+// log4go.FileOptions.EncryptionKey) to stdout:
+//
+//	log4go-decrypt -key-file app.key app.log app.log.1
+//
+// The key is given hex encoded, as the -key flag, in the -key-file file or in the
+// LOG4GO_KEY environment variable. Without file arguments stdin is decrypted.
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/kaizer666/log4go"
+)
+
+func main() {
+	keyFlag := flag.String("key", "", "hex encoded key")
+	keyFile := flag.String("key-file", "", "file containing the hex encoded key")
+	flag.Parse()
+
+	key, err := readKey(*keyFlag, *keyFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "log4go-decrypt:", err)
+		os.Exit(2)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	status := 0
+	if flag.NArg() == 0 {
+		if err = decrypt(out, os.Stdin, key); err != nil {
+			fmt.Fprintln(os.Stderr, "log4go-decrypt: stdin:", err)
+			status = 1
+		}
+	}
+	for _, name := range flag.Args() {
+		if err = decryptFile(out, name, key); err != nil {
+			fmt.Fprintf(os.Stderr, "log4go-decrypt: %s: %v\n", name, err)
+			status = 1
+		}
+	}
+	if err = out.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, "log4go-decrypt:", err)
+		status = 1
+	}
+	os.Exit(status)
+}
+
+func readKey(key, keyFile string) ([]byte, error) {
+	if len(keyFile) > 0 {
+		data, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = string(data)
+	}
+	if len(key) == 0 {
+		key = os.Getenv("LOG4GO_KEY")
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("no key, see -help")
+	}
+	return hex.DecodeString(strings.TrimSpace(key))
+}
+
+func decryptFile(w io.Writer, name string, key []byte) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return decrypt(w, f, key)
+}
+
+func decrypt(w io.Writer, r io.Reader, key []byte) error {
+	dr, err := log4go.NewDecryptingReader(bufio.NewReader(r), key)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, dr)
+	return err
+}
@@ -1,6 +1,7 @@
 package color
 
 import (
+	"strconv"
 	"strings"
 )
 
@@ -16,22 +17,112 @@ var Purple string
 var RedBg string
 
 func init() {
-	_esc := func(codes ...string) string {
-		return strings.Join([]string{
-			"\x1b",
-			"[",
-			strings.Join(codes, ";"),
-			"m",
-		}, "")
+	Bold = esc("1")
+	Normal = esc("0")
+	Faint = esc("38", "5", "240")
+	Red = esc("31", "1")
+	Fail = esc("41", "37", "1")
+	Green = esc("38", "5", "66")
+	Yellow = esc("38", "5", "220")
+	Blue = esc("38", "5", "24")
+	Purple = esc("38", "5", "96")
+	RedBg = esc("41", "1")
+}
+
+func esc(codes ...string) string {
+	return strings.Join([]string{
+		"\x1b",
+		"[",
+		strings.Join(codes, ";"),
+		"m",
+	}, "")
+}
+
+// Color256 returns the foreground color n (0-255) of the 256-color palette.
+func Color256(n uint8) string {
+	return esc("38", "5", strconv.Itoa(int(n)))
+}
+
+// BgColor256 returns the background color n (0-255) of the 256-color palette.
+func BgColor256(n uint8) string {
+	return esc("48", "5", strconv.Itoa(int(n)))
+}
+
+// RGB returns a 24-bit (truecolor) foreground color.
+func RGB(r, g, b uint8) string {
+	return esc("38", "2", strconv.Itoa(int(r)), strconv.Itoa(int(g)), strconv.Itoa(int(b)))
+}
+
+// BgRGB returns a 24-bit (truecolor) background color.
+func BgRGB(r, g, b uint8) string {
+	return esc("48", "2", strconv.Itoa(int(r)), strconv.Itoa(int(g)), strconv.Itoa(int(b)))
+}
+
+// Hex returns a 24-bit foreground color from a "#rrggbb" (or "rrggbb") string, "" if invalid.
+func Hex(hex string) string {
+	hex = strings.TrimPrefix(hex, "#")
+	if len(hex) != 6 {
+		return ""
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return ""
+	}
+	return RGB(uint8(v>>16), uint8(v>>8), uint8(v))
+}
+
+// ByName returns the color named name ("bold", "normal", "faint", "red", "fail", "green",
+// "yellow", "blue", "purple" or "redbg", case insensitive), of a "#rrggbb" string (see Hex), or
+// of a 256-color palette number (see Color256); "" if unknown.
+func ByName(name string) string {
+	switch strings.ToLower(name) {
+	case "bold":
+		return Bold
+	case "normal":
+		return Normal
+	case "faint":
+		return Faint
+	case "red":
+		return Red
+	case "fail":
+		return Fail
+	case "green":
+		return Green
+	case "yellow":
+		return Yellow
+	case "blue":
+		return Blue
+	case "purple":
+		return Purple
+	case "redbg":
+		return RedBg
+	}
+	if strings.HasPrefix(name, "#") {
+		return Hex(name)
+	}
+	if n, err := strconv.ParseUint(name, 10, 8); err == nil {
+		return Color256(uint8(n))
+	}
+	return ""
+}
+
+// Strip removes ANSI escape sequences (e.g. colors) from b, in place.
+func Strip(b []byte) []byte {
+	out := b[:0]
+	for idx := 0; idx < len(b); idx++ {
+		if b[idx] != '\x1b' {
+			out = append(out, b[idx])
+			continue
+		}
+		if idx+1 < len(b) && b[idx+1] == '[' {
+			// CSI: parameters and intermediates up to a final byte in 0x40-0x7e
+			idx += 2
+			for idx < len(b) && (b[idx] < 0x40 || b[idx] > 0x7e) {
+				idx++
+			}
+		} else {
+			idx++ // two-character sequence
+		}
 	}
-	Bold = _esc("1")
-	Normal = _esc("0")
-	Faint = _esc("38", "5", "240")
-	Red = _esc("31", "1")
-	Fail = _esc("41", "37", "1")
-	Green = _esc("38", "5", "66")
-	Yellow = _esc("38", "5", "220")
-	Blue = _esc("38", "5", "24")
-	Purple = _esc("38", "5", "96")
-	RedBg = _esc("41", "1")
+	return out
 }
@@ -0,0 +1,132 @@
+package log4go
+
+// Stage is a stage of a ComposedFormatter: a RecordProcessor or an OutputProcessor.
+type Stage interface {
+	stage()
+}
+
+// RecordProcessor transforms the records before they are formatted, e.g. to redact fields. It
+// returns the record to format: rec itself, or a modified Clone (rec is shared, see Record),
+// or nil to skip the record.
+type RecordProcessor func(rec *Record) *Record
+
+// OutputProcessor transforms the formatted records, e.g. to colorize them; it may modify out.
+type OutputProcessor func(rec *Record, out []byte) ([]byte, error)
+
+func (RecordProcessor) stage() {}
+func (OutputProcessor) stage() {}
+
+// ComposedFormatter formats the records with a chain of stages around a formatter: the record
+// processors, in order, then the formatter, then the output processors, in order. It lets
+// handlers apply cross-cutting transforms without custom formatters, e.g.
+//
+//	template, _ := log4go.NewTemplateFormatter("{time} {level} {message}")
+//	handler.SetFormatter(log4go.Compose(template,
+//		log4go.RedactFields("password", "token"),
+//		log4go.ColorizeByLevel(map[log4go.Level]string{log4go.ERROR: color.Red})))
+type ComposedFormatter struct {
+	formatter Formatter
+	records   []RecordProcessor
+	outputs   []OutputProcessor
+}
+
+// Compose returns a new ComposedFormatter of the formatter and stages.
+func Compose(formatter Formatter, stages ...Stage) *ComposedFormatter {
+	f := &ComposedFormatter{formatter: formatter}
+	for _, s := range stages {
+		switch s := s.(type) {
+		case RecordProcessor:
+			f.records = append(f.records, s)
+		case OutputProcessor:
+			f.outputs = append(f.outputs, s)
+		}
+	}
+	return f
+}
+
+var _ AppendFormatter = &ComposedFormatter{}
+
+// Formatter returns the composed formatter.
+func (f *ComposedFormatter) Formatter() Formatter {
+	return f.formatter
+}
+
+// Format returns the record formatted by the chain.
+func (f *ComposedFormatter) Format(r *Record) ([]byte, error) {
+	return f.AppendFormat(nil, r)
+}
+
+// AppendFormat appends the record formatted by the chain to dst.
+func (f *ComposedFormatter) AppendFormat(dst []byte, r *Record) ([]byte, error) {
+	for _, process := range f.records {
+		if r = process(r); r == nil {
+			return dst, ErrorNotSet
+		}
+	}
+	if len(f.outputs) == 0 {
+		return appendFormat(dst, f.formatter, r)
+	}
+
+	out, err := appendFormat(nil, f.formatter, r)
+	if err != nil {
+		return dst, err
+	}
+	for _, process := range f.outputs {
+		if out, err = process(r, out); err != nil {
+			return dst, err
+		}
+	}
+	return append(dst, out...), nil
+}
+
+// RawOutput reports whether the composed formatter's output must be written as is.
+func (f *ComposedFormatter) RawOutput() bool {
+	return isRawFormatter(f.formatter)
+}
+
+func (f *ComposedFormatter) usesPrevious() bool {
+	return usesPrevious(f.formatter)
+}
+
+// Redacted replaces the values of the fields redacted by RedactFields.
+const Redacted = "[REDACTED]"
+
+// RedactFields returns a RecordProcessor replacing the values of the fields (and extras) named
+// keys by Redacted.
+func RedactFields(keys ...string) RecordProcessor {
+	return func(rec *Record) *Record {
+		redacted := rec
+		for _, key := range keys {
+			_, isField := rec.Fields[key]
+			_, isExtra := rec.Extras[key]
+			if !isField && !isExtra {
+				continue
+			}
+			if redacted == rec {
+				redacted = rec.Clone()
+			}
+			if isField {
+				redacted.Fields[key] = Redacted
+			}
+			if isExtra {
+				redacted.Extras[key] = Redacted
+			}
+		}
+		return redacted
+	}
+}
+
+// ColorizeByLevel returns an OutputProcessor coloring the formatted records by level with the
+// colors (e.g. those of the color package), the records of the other levels being unchanged.
+func ColorizeByLevel(colors map[Level]string) OutputProcessor {
+	return func(rec *Record, out []byte) ([]byte, error) {
+		c, ok := colors[rec.Level]
+		if !ok || len(c) == 0 {
+			return out, nil
+		}
+		colored := make([]byte, 0, len(c)+len(out)+len(colorReset))
+		colored = append(colored, c...)
+		colored = append(colored, out...)
+		return append(colored, colorReset...), nil
+	}
+}
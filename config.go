@@ -0,0 +1,419 @@
+package log4go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/kaizer666/log4go/color"
+)
+
+// Config is a configuration of named handlers and of the loggers using them, see Configure.
+// In JSON (see ConfigureFromFile):
+//
+//	{
+//	    "handlers": {
+//	        "console": {"type": "stderr", "level": "WARNING"},
+//	        "app": {"type": "file", "filename": "/var/log/app.log", "formatter": "ecs"}
+//	    },
+//	    "loggers": {
+//	        "root": {"level": "INFO", "handlers": ["console", "app"]},
+//	        "app/db": {"level": "DEBUG"}
+//	    }
+//	}
+type Config struct {
+	Handlers map[string]HandlerConfig `json:"handlers"`
+	Loggers  map[string]LoggerConfig  `json:"loggers"` // by logger name, "root" for the root logger
+}
+
+// HandlerConfig configures a handler of a Config.
+type HandlerConfig struct {
+	// Type is "stderr" (default), "stdout", "console", "file", "watched_file" or "socket".
+	Type string `json:"type"`
+	// Filename is the file of the file handlers.
+	Filename string `json:"filename"`
+	// Append appends to the file rather than truncating it (default true). A file the previous
+	// configuration's handlers write isn't truncated on a reconfiguration.
+	Append *bool `json:"append"`
+	// CreateDirs creates the file's missing directories.
+	CreateDirs bool `json:"create_dirs"`
+	// DateDirs are the date-derived directories of the "file" handlers' file, e.g.
+	// "{year}/{month}/{day}" (see FileOptions.DateDirs).
+	DateDirs string `json:"date_dirs"`
+	// Network and Address are the socket handlers' unix socket (e.g. "unixgram" and "/dev/log").
+	Network string `json:"network"`
+	Address string `json:"address"`
+	// SpoolDir is the directory where the network handlers (those having a SetSpool method,
+	// e.g. "socket") spool the records they can't send, to replay them once the destination
+	// works again (see Spool), empty for none.
+	SpoolDir string `json:"spool_dir"`
+	// Level is the minimum level of the records handled (default all).
+	Level Level `json:"level"`
+	// DropWhenFull drops the records when the handler's queue is full, rather than blocking
+	// the logging calls (see StreamHandler.SetBlocking).
+	DropWhenFull bool `json:"drop_when_full"`
+	// Formatter is "template" (default), "ecs", "csv", "tsv", "rfc5424", "msgpack", "binary",
+	// "common" or "combined" for access logs (see AccessLogFormatter), or a named format (see
+	// NewFormatter).
+	Formatter string `json:"formatter"`
+	// Format is the template of the template formatter, which can extend named formats (see
+	// RegisterTemplateFormat), or a named format, e.g. "detailed" (default "default").
+	Format string `json:"format"`
+	// FieldColors are the colors of the fields of the template formatter's {fields} token:
+	// "key" and "value" those of the keys and values, other keys those of their values (see
+	// FieldColoring), e.g. {"key": "faint", "err": "red"}. The colors are names or codes, see
+	// color.ByName.
+	FieldColors map[string]string `json:"field_colors"`
+	// Highlight are the template formatter's highlight rules, coloring the lines or field values
+	// of the records matching their conditions (see HighlightRule).
+	Highlight []HighlightRule `json:"highlight"`
+}
+
+// LoggerConfig configures a logger of a Config.
+type LoggerConfig struct {
+	// Level is the logger's level (default the logger's default: WARNING for the root logger,
+	// DEBUG for the others).
+	Level Level `json:"level"`
+	// Handlers are the names of the logger's handlers, replacing the ones it has, if any.
+	Handlers []string `json:"handlers"`
+}
+
+// configLock serializes Configure calls.
+var configLock sync.Mutex
+
+// configured is the state of the current configuration, guarded by loggersLock.
+var configured struct {
+	loggers  map[*Logger]loggerState      // the configured loggers, and their state before
+	handlers map[string]configuredHandler // the configured handlers, by name
+}
+
+// configuredHandler is a configured handler, and the configuration it was created from, so
+// that reconfiguring keeps it if its configuration is unchanged.
+type configuredHandler struct {
+	config  HandlerConfig
+	handler Handler
+}
+
+// loggerState is a logger's level (as set, possibly NOTSET) and handlers.
+type loggerState struct {
+	level    Level
+	handlers []Handler
+}
+
+// ConfigureFromFile configures the loggers from a JSON file (see Config and Configure); call
+// it again to reload the configuration, e.g. after the file changed.
+func ConfigureFromFile(filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&config); err != nil {
+		return fmt.Errorf("log4go: %s: %w", filename, err)
+	}
+	if err := Configure(config); err != nil {
+		return fmt.Errorf("log4go: %s: %w", filename, err)
+	}
+	return nil
+}
+
+// Configure creates the configured handlers, and sets the level and handlers of the configured
+// loggers (creating them, so GetLogger(name) returns them configured). It can be called again
+// to reconfigure the loggers: the loggers no longer configured get their level and handlers
+// back, and the handlers of the previous configuration are shut down, once their queued
+// records are written, except those whose configuration is unchanged: they are kept (so a
+// file handler not appending doesn't truncate its file again). Nothing is changed if the
+// configuration is invalid.
+func Configure(config Config) error {
+	configLock.Lock()
+	defer configLock.Unlock()
+
+	loggersLock.Lock()
+	current := configured.handlers
+	loggersLock.Unlock()
+
+	// first create the handlers the loggers use, keeping the unchanged ones
+	handlers := make(map[string]configuredHandler)
+	var created []Handler
+	for name, lc := range config.Loggers {
+		for _, hname := range lc.Handlers {
+			if _, exists := handlers[hname]; exists {
+				continue
+			}
+			hc, exists := config.Handlers[hname]
+			if !exists {
+				shutdownHandlers(context.Background(), created)
+				return fmt.Errorf("logger %q: unknown handler %q", name, hname)
+			}
+			if ch, exists := current[hname]; exists && reflect.DeepEqual(ch.config, hc) {
+				handlers[hname] = ch
+				continue
+			}
+			opened := hc
+			if hc.Append != nil && !*hc.Append && writesFile(current, hc.Filename) {
+				opened.Append = nil // the replaced handler's file, appended to rather than truncated
+			}
+			h, err := newConfiguredHandler(opened)
+			if err != nil {
+				shutdownHandlers(context.Background(), created)
+				return fmt.Errorf("handler %q: %w", hname, err)
+			}
+			handlers[hname] = configuredHandler{config: hc, handler: h}
+			created = append(created, h)
+		}
+	}
+
+	// then (re)configure the loggers
+	configuredLoggers := make(map[string]*Logger, len(config.Loggers))
+	for name := range config.Loggers {
+		if len(name) == 0 {
+			configuredLoggers[name] = GetLogger()
+		} else {
+			configuredLoggers[name] = GetLogger(name)
+		}
+	}
+
+	loggersLock.Lock()
+	previous := configured.loggers
+	oldHandlers := make([]Handler, 0, len(configured.handlers))
+	for hname, ch := range configured.handlers {
+		if handlers[hname].handler != ch.handler {
+			oldHandlers = append(oldHandlers, ch.handler)
+		}
+	}
+	affected := make(map[*Logger]bool, len(configuredLoggers)+len(previous))
+	for _, logger := range configuredLoggers {
+		affected[logger] = true
+	}
+	for logger := range previous {
+		affected[logger] = true
+	}
+	changed := make([]*Logger, 0, len(affected))
+	for logger := range affected {
+		changed = append(changed, logger)
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].name < changed[j].name })
+	snapshot := snapshotLoggers(changed) // to log the changes, see SetMetaLogger
+	states := make(map[*Logger]loggerState, len(configuredLoggers))
+	for name, logger := range configuredLoggers {
+		state, exists := previous[logger]
+		if !exists {
+			state = loggerState{level: logger.loadLevel(), handlers: logger.loadHandlers()}
+		}
+		states[logger] = state
+
+		if lvl := config.Loggers[name].Level; lvl != NOTSET {
+			logger.setLevel(lvl)
+		} else {
+			logger.storeLevel(state.level)
+		}
+		if hnames := config.Loggers[name].Handlers; len(hnames) > 0 {
+			lhandlers := make([]Handler, 0, len(hnames))
+			for _, hname := range hnames {
+				lhandlers = append(lhandlers, handlers[hname].handler)
+			}
+			logger.storeHandlers(lhandlers)
+		} else {
+			logger.storeHandlers(state.handlers)
+		}
+		applyLevelOverride(logger)
+	}
+	for logger, state := range previous {
+		if _, exists := states[logger]; !exists {
+			logger.storeLevel(state.level)
+			logger.storeHandlers(state.handlers)
+			applyLevelOverride(logger)
+		}
+	}
+	configured.loggers = states
+	configured.handlers = handlers
+	loggersLock.Unlock()
+	snapshot.logChanges("config")
+
+	waitHandlers() // for the records being passed to the old handlers
+	shutdownHandlers(context.Background(), oldHandlers)
+	return nil
+}
+
+// writesFile returns whether one of the configured handlers writes the file.
+func writesFile(handlers map[string]configuredHandler, filename string) bool {
+	for _, ch := range handlers {
+		if (ch.config.Type == "file" || ch.config.Type == "watched_file") &&
+			filepath.Clean(ch.config.Filename) == filepath.Clean(filename) {
+			return true
+		}
+	}
+	return false
+}
+
+// newConfiguredHandler returns a new handler configured by hc.
+func newConfiguredHandler(hc HandlerConfig) (Handler, error) {
+	formatter, err := newConfiguredFormatter(hc)
+	if err != nil {
+		return nil, err
+	}
+	if len(hc.FieldColors) > 0 {
+		tf, ok := formatter.(*TemplateFormatter)
+		if !ok {
+			return nil, errors.New("field colors need a template formatter")
+		}
+		coloring, err := parseFieldColors(hc.FieldColors)
+		if err != nil {
+			return nil, err
+		}
+		tf.SetFieldColoring(coloring)
+	}
+	if len(hc.Highlight) > 0 {
+		tf, ok := formatter.(*TemplateFormatter)
+		if !ok {
+			return nil, errors.New("highlight rules need a template formatter")
+		}
+		if err := tf.SetHighlightRules(hc.Highlight); err != nil {
+			return nil, err
+		}
+	}
+
+	options := FileOptions{Append: hc.Append == nil || *hc.Append, CreateDirs: hc.CreateDirs, DateDirs: hc.DateDirs}
+	var h Handler
+	switch hc.Type {
+	case "", "stderr":
+		h, err = NewStreamHandler(os.Stderr)
+	case "stdout":
+		h, err = NewStreamHandler(os.Stdout)
+	case "console":
+		h, err = NewConsoleHandler()
+	case "file", "watched_file":
+		if len(hc.Filename) == 0 {
+			return nil, errors.New("no filename")
+		}
+		if len(hc.DateDirs) > 0 && hc.Type != "file" {
+			return nil, errors.New("date dirs need a file handler")
+		}
+		if hc.Type == "file" {
+			h, err = NewFileHandlerWithOptions(hc.Filename, options)
+		} else {
+			h, err = NewWatchedFileHandlerWithOptions(hc.Filename, options)
+		}
+	case "socket":
+		h, err = NewSocketHandler(hc.Network, hc.Address)
+	default:
+		return nil, fmt.Errorf("unknown handler type %q", hc.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(hc.SpoolDir) > 0 {
+		s, ok := h.(interface{ SetSpool(*Spool) })
+		if !ok {
+			h.Shutdown()
+			return nil, fmt.Errorf("spool dir not supported by %q handlers", hc.Type)
+		}
+		spool, err := OpenSpool(SpoolOptions{Dir: hc.SpoolDir})
+		if err != nil {
+			h.Shutdown()
+			return nil, err
+		}
+		s.SetSpool(spool)
+	}
+	h.SetFormatter(formatter)
+	if b, ok := h.(interface{ SetBlocking(bool) }); ok && hc.DropWhenFull {
+		b.SetBlocking(false)
+	}
+
+	h.SetLevel(hc.Level)
+	return h, nil
+}
+
+// newConfiguredFormatter returns a new formatter configured by hc.
+func newConfiguredFormatter(hc HandlerConfig) (Formatter, error) {
+	switch hc.Formatter {
+	case "", "template":
+		if !strings.Contains(hc.Format, "{") {
+			format := hc.Format
+			if len(format) == 0 {
+				format = "default"
+			}
+			return NewFormatter(format)
+		}
+		format, err := expandFormatPresets(hc.Format)
+		if err != nil {
+			return nil, err
+		}
+		return NewTemplateFormatter(format)
+	case "ecs":
+		return NewECSFormatter(), nil
+	case "csv":
+		return NewCSVFormatter(), nil
+	case "tsv":
+		return NewTSVFormatter(), nil
+	case "rfc5424":
+		return NewRFC5424Formatter(), nil
+	case "msgpack":
+		return NewMsgpackFormatter(), nil
+	case "binary":
+		return NewBinaryFormatter(), nil
+	case "common":
+		return NewAccessLogFormatter(AccessLogCommon), nil
+	case "combined":
+		return NewAccessLogFormatter(AccessLogCombined), nil
+	}
+	if formatter, err := NewFormatter(hc.Formatter); err == nil {
+		return formatter, nil
+	}
+	return nil, fmt.Errorf("unknown formatter %q", hc.Formatter)
+}
+
+// parseFieldColors returns the field coloring of HandlerConfig.FieldColors.
+func parseFieldColors(colors map[string]string) (*FieldColoring, error) {
+	coloring := &FieldColoring{Highlight: make(map[string]string)}
+	for key, name := range colors {
+		c := color.ByName(name)
+		if len(c) == 0 {
+			return nil, fmt.Errorf("field %q: unknown color %q", key, name)
+		}
+		switch key {
+		case "key":
+			coloring.Key = c
+		case "value":
+			coloring.Value = c
+		default:
+			coloring.Highlight[key] = c
+		}
+	}
+	return coloring, nil
+}
+
+// Loggers returns the root logger and all loggers created by GetLogger, sorted by name.
+func Loggers() []*Logger {
+	root := GetLogger()
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	return registeredLoggers(root)
+}
+
+// registeredLoggers returns root and the loggers sorted by name, loggersLock must be held.
+func registeredLoggers(root *Logger) []*Logger {
+	all := make([]*Logger, 0, len(loggers)+1)
+	all = append(all, root)
+	names := make([]string, 0, len(loggers))
+	for name := range loggers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		all = append(all, loggers[name])
+	}
+	return all
+}
@@ -0,0 +1,57 @@
+package log4go
+
+import (
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// ConsoleHandler writes the records below its split level (WARNING by default) to stdout and
+// the others to stderr, as container platforms expect.
+type ConsoleHandler struct {
+	*StreamHandler
+
+	stdout io.Writer
+	stderr io.Writer
+	split  int32 // Level, accessed atomically
+}
+
+// NewConsoleHandler returns a new ConsoleHandler writing to os.Stdout and os.Stderr.
+func NewConsoleHandler() (*ConsoleHandler, error) {
+	return newConsoleHandler(os.Stdout, os.Stderr)
+}
+
+func newConsoleHandler(stdout, stderr io.Writer) (*ConsoleHandler, error) {
+	s, err := NewStreamHandler(stdout)
+	if err != nil {
+		return nil, err
+	}
+	h := &ConsoleHandler{
+		StreamHandler: s,
+		stdout:        stdout,
+		stderr:        stderr,
+		split:         WARNING,
+	}
+	s.writerFor = h.writerFor
+	s.self = h
+	return h, nil
+}
+
+var _ Handler = &ConsoleHandler{}
+
+// SetSplitLevel sets the level from which records are written to stderr.
+func (h *ConsoleHandler) SetSplitLevel(level Level) {
+	atomic.StoreInt32(&h.split, int32(level))
+}
+
+// SplitLevel returns the level from which records are written to stderr.
+func (h *ConsoleHandler) SplitLevel() Level {
+	return Level(atomic.LoadInt32(&h.split))
+}
+
+func (h *ConsoleHandler) writerFor(rec *Record) io.Writer {
+	if rec.Level >= h.SplitLevel() {
+		return h.stderr
+	}
+	return h.stdout
+}
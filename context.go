@@ -0,0 +1,117 @@
+package log4go
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx carrying the logger, e.g. a request-scoped logger (see
+// RequestLogger), which FromContext returns.
+func NewContext(ctx context.Context, logger *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx (see NewContext), or the root logger.
+func FromContext(ctx context.Context) *Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*Logger); ok && logger != nil {
+		return logger
+	}
+	return GetLogger()
+}
+
+type fieldsContextKey struct{}
+
+// ContextWithFields returns a copy of ctx carrying the key/value pairs (or Fields) of kv, along
+// with the fields ctx already carries, e.g. a request ID set by a middleware: the Ctx logging
+// methods (e.g. Logger.InfoCtx) add them to their records, below the logger's bound fields and
+// the call's fields.
+func ContextWithFields(ctx context.Context, kv ...interface{}) context.Context {
+	return context.WithValue(ctx, fieldsContextKey{}, bindFields(FieldsFromContext(ctx), "", kv))
+}
+
+// FieldsFromContext returns the fields carried by ctx (see ContextWithFields), nil if none; they
+// must not be modified.
+func FieldsFromContext(ctx context.Context) Fields {
+	fields, _ := ctx.Value(fieldsContextKey{}).(Fields)
+	return fields
+}
+
+// contextFields are the fields of a context, passed as the last argument of log by the Ctx
+// logging methods.
+type contextFields Fields
+
+// withContextFields returns args with the fields carried by ctx, if any, appended.
+func withContextFields(ctx context.Context, args []interface{}) []interface{} {
+	fields := FieldsFromContext(ctx)
+	if len(fields) == 0 {
+		return args
+	}
+	return append(args[:len(args):len(args)], contextFields(fields))
+}
+
+// splitContextFields removes the context fields appended by withContextFields from args,
+// returning them separately.
+func splitContextFields(args []interface{}) ([]interface{}, Fields) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	if fields, ok := args[len(args)-1].(contextFields); ok {
+		return args[:len(args)-1], Fields(fields)
+	}
+	return args, nil
+}
+
+// mergeContextFields returns the record's fields with the context's fields added, unless
+// already set.
+func mergeContextFields(ctxFields, fields Fields) Fields {
+	if len(ctxFields) == 0 {
+		return fields
+	}
+	if len(fields) == 0 {
+		return ctxFields // not modified by handlers
+	}
+	merged := make(Fields, len(ctxFields)+len(fields))
+	for key, value := range ctxFields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return merged
+}
+
+// FatalCtx logs message with FATAL level and the fields carried by ctx, see Fatal and
+// ContextWithFields.
+func (l *Logger) FatalCtx(ctx context.Context, message string, args ...interface{}) {
+	l.Fatal(message, withContextFields(ctx, args)...)
+}
+
+// ErrorCtx logs message with ERROR level and the fields carried by ctx, see Error.
+func (l *Logger) ErrorCtx(ctx context.Context, message string, args ...interface{}) {
+	l.Error(message, withContextFields(ctx, args)...)
+}
+
+// WarningCtx logs message with WARNING level and the fields carried by ctx, see Warning.
+func (l *Logger) WarningCtx(ctx context.Context, message string, args ...interface{}) {
+	l.Warning(message, withContextFields(ctx, args)...)
+}
+
+// InfoCtx logs message with INFO level and the fields carried by ctx, see Info.
+func (l *Logger) InfoCtx(ctx context.Context, message string, args ...interface{}) {
+	l.Info(message, withContextFields(ctx, args)...)
+}
+
+// DebugCtx logs message with DEBUG level and the fields carried by ctx, see Debug; with
+// SampledDebug, only if ctx is verbose.
+func (l *Logger) DebugCtx(ctx context.Context, message string, args ...interface{}) {
+	if !l.logSampled(ctx, DEBUG, message, args) {
+		l.Debug(message, withContextFields(ctx, args)...)
+	}
+}
+
+// LogCtx logs message with given level and the fields carried by ctx, see Log; with
+// SampledDebug, only if ctx is verbose for DEBUG level and below.
+func (l *Logger) LogCtx(ctx context.Context, lvl Level, message string, args ...interface{}) {
+	if !l.logSampled(ctx, lvl, message, args) {
+		l.Log(lvl, message, withContextFields(ctx, args)...)
+	}
+}
@@ -0,0 +1,99 @@
+package log4go
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CSVFormatter formats records as CSV (or TSV) rows.
+//
+// Columns are "time", "name", "level", "message", "seq", "pid", "hostname" and "goid";
+// any other column name is looked up in the record's extras and fields ("fields." prefix
+// optional).
+type CSVFormatter struct {
+	columns []string
+	comma   rune
+}
+
+// NewCSVFormatter returns a new comma separated CSVFormatter with the given columns
+// (default: time, name, level, message).
+func NewCSVFormatter(columns ...string) *CSVFormatter {
+	if len(columns) == 0 {
+		columns = []string{"time", "name", "level", "message"}
+	}
+	return &CSVFormatter{columns: columns, comma: ','}
+}
+
+// NewTSVFormatter returns a new tab separated CSVFormatter with the given columns.
+func NewTSVFormatter(columns ...string) *CSVFormatter {
+	f := NewCSVFormatter(columns...)
+	f.comma = '\t'
+	return f
+}
+
+var _ Formatter = &CSVFormatter{}
+
+// Columns returns the formatter's columns.
+func (f *CSVFormatter) Columns() []string {
+	return f.columns
+}
+
+// Header returns the header row (the column names), e.g. to write at the start of a file.
+func (f *CSVFormatter) Header() []byte {
+	out, _ := f.row(f.columns)
+	return out
+}
+
+// Format returns the record as a CSV row.
+func (f *CSVFormatter) Format(r *Record) ([]byte, error) {
+	if r.Level == NOTSET {
+		return []byte{}, ErrorNotSet
+	}
+
+	values := make([]string, len(f.columns))
+	for idx, column := range f.columns {
+		switch column {
+		case "time":
+			values[idx] = r.Time.Format("2006-01-02 15:04:05.000")
+		case "name":
+			values[idx] = r.Name
+			if len(r.Name) == 0 {
+				values[idx] = "root"
+			}
+		case "level":
+			values[idx] = LevelName(r.Level)
+		case "message":
+			values[idx] = r.Message
+		case "seq":
+			values[idx] = strconv.FormatUint(r.Seq, 10)
+		case "pid":
+			values[idx] = processID
+		case "hostname":
+			values[idx] = hostname
+		case "goid":
+			values[idx] = strconv.FormatUint(r.GoroutineID, 10)
+		default:
+			if value, exists := r.Get(strings.TrimPrefix(column, "fields.")); exists {
+				values[idx] = fmt.Sprint(value)
+			}
+		}
+	}
+	return f.row(values)
+}
+
+func (f *CSVFormatter) row(values []string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Comma = f.comma
+	if err := w.Write(values); err != nil {
+		return nil, err
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
@@ -0,0 +1,309 @@
+package log4go
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Datadog log intake limits.
+const (
+	DatadogMaxBatchSize  = 1000
+	DatadogMaxBatchBytes = 5 * 1024 * 1024
+	DatadogMaxEntryBytes = 1024 * 1024
+)
+
+// DatadogConfig configures a DatadogHandler.
+type DatadogConfig struct {
+	// APIKey is the Datadog API key (default: $DD_API_KEY).
+	APIKey string
+	// Site is the Datadog site, e.g. "datadoghq.eu" (default: $DD_SITE, then "datadoghq.com").
+	Site string
+	// Endpoint overrides the intake URL (default: https://http-intake.logs.<site>/api/v2/logs).
+	Endpoint string
+	// Service, Source (default "go") and Hostname (default: the host name) are sent with each
+	// log, as are Tags ("key:value" items, default: $DD_TAGS, space or comma separated).
+	Service  string
+	Source   string
+	Hostname string
+	Tags     []string
+	// DisableCompression sends the payloads uncompressed (they're gzip'ed by default).
+	DisableCompression bool
+	// BatchSize is the maximum number of logs per request (default and max 1000).
+	BatchSize int
+	// FlushInterval is the maximum time logs wait for their batch (default 2s).
+	FlushInterval time.Duration
+	// Timeout limits each request (default 10s).
+	Timeout time.Duration
+	// QueueSize is the number of records buffered before Handle blocks (default 1000).
+	QueueSize int
+	// MaxRetries is the number of times a request failing with 429 or 5xx (or a network
+	// error) is retried, with exponential backoff, before the batch is dropped (default 3).
+	MaxRetries int
+	// HTTPClient is the client used for the requests (default: http.DefaultClient).
+	HTTPClient *http.Client
+}
+
+// DatadogHandler ships JSON logs to Datadog's HTTP log intake.
+//
+// The message is the formatted record (the plain message by default), the level is sent as
+// the status and the fields as attributes; error valued fields are sent as error.message and
+// error.kind.
+type DatadogHandler struct {
+	batchHandler
+
+	config DatadogConfig
+	tags   string
+	logs   []byte // JSON array being built
+	count  int
+	acks   []func(error) // of the logs, see takeAck
+}
+
+// NewDatadogHandler returns a new DatadogHandler.
+func NewDatadogHandler(config DatadogConfig) (*DatadogHandler, error) {
+	if len(config.APIKey) == 0 {
+		config.APIKey = os.Getenv("DD_API_KEY")
+	}
+	if len(config.APIKey) == 0 {
+		return nil, errors.New("log4go.DatadogHandler: no API key")
+	}
+	if len(config.Site) == 0 {
+		config.Site = os.Getenv("DD_SITE")
+	}
+	if len(config.Site) == 0 {
+		config.Site = "datadoghq.com"
+	}
+	if len(config.Endpoint) == 0 {
+		config.Endpoint = "https://http-intake.logs." + config.Site + "/api/v2/logs"
+	}
+	if len(config.Service) == 0 {
+		config.Service = os.Getenv("DD_SERVICE")
+	}
+	if len(config.Service) == 0 {
+		config.Service = filepath.Base(os.Args[0])
+	}
+	if len(config.Source) == 0 {
+		config.Source = "go"
+	}
+	if len(config.Hostname) == 0 {
+		config.Hostname = hostname
+	}
+	if config.Tags == nil {
+		config.Tags = strings.FieldsFunc(os.Getenv("DD_TAGS"), func(r rune) bool {
+			return r == ' ' || r == ','
+		})
+	}
+	if config.BatchSize <= 0 || config.BatchSize > DatadogMaxBatchSize {
+		config.BatchSize = DatadogMaxBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 2 * time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+
+	formatter, err := NewTemplateFormatter("{message}")
+	if err != nil {
+		return nil, err
+	}
+
+	h := &DatadogHandler{
+		config: config,
+		tags:   strings.Join(config.Tags, ","),
+	}
+	h.init(config.QueueSize, formatter)
+	h.run(h, config.FlushInterval)
+
+	return h, nil
+}
+
+var _ Handler = &DatadogHandler{}
+
+var levelToDatadogStatus = map[Level]string{
+	TRACE:   "trace",
+	DEBUG:   "debug",
+	INFO:    "info",
+	WARNING: "warning",
+	ERROR:   "error",
+	FATAL:   "critical",
+}
+
+func (h *DatadogHandler) add(rec *Record) bool {
+	msg, err := h.Formatter().Format(rec)
+	if err != nil {
+		if err != ErrorNotSet {
+			h.report(fmt.Errorf("formatter error: %w", err))
+			h.counters.countError(err)
+		}
+		return false
+	}
+
+	name := rec.Name
+	if len(name) == 0 {
+		name = "root"
+	}
+	status, ok := levelToDatadogStatus[rec.Level]
+	if !ok {
+		status = LevelName(rec.Level)
+	}
+
+	fields := rec.allFields()
+	entry := make(map[string]interface{}, 8+len(fields))
+	for key, value := range fields {
+		if e, ok := value.(error); ok {
+			entry["error"] = map[string]string{"message": e.Error(), "kind": fmt.Sprintf("%T", e)}
+			continue
+		}
+		entry[key] = value
+	}
+	entry["message"] = string(msg)
+	entry["status"] = status
+	entry["timestamp"] = rec.Time.UnixNano() / int64(time.Millisecond)
+	entry["logger"] = map[string]string{"name": name}
+	entry["service"] = h.config.Service
+	entry["ddsource"] = h.config.Source
+	entry["hostname"] = h.config.Hostname
+	if len(h.tags) != 0 {
+		entry["ddtags"] = h.tags
+	}
+
+	data, err := marshalJSON(entry)
+	if err != nil {
+		h.report(err)
+		h.counters.countError(err)
+		return false
+	}
+	data = bytes.TrimSuffix(data, []byte{'\n'})
+	if len(data) > DatadogMaxEntryBytes {
+		h.report(&DroppedError{Count: 1, Err: fmt.Errorf("%d bytes log exceeds the maximum", len(data))})
+		h.counters.countDropped(1)
+		return false
+	}
+
+	if h.count != 0 && len(h.logs)+len(data)+2 > DatadogMaxBatchBytes {
+		h.flush()
+	}
+	if h.count == 0 {
+		h.logs = append(h.logs[:0], '[')
+	} else {
+		h.logs = append(h.logs, ',')
+	}
+	h.logs = append(h.logs, data...)
+	h.count++
+	if ack := takeAck(rec); ack != nil {
+		h.acks = append(h.acks, ack)
+	}
+	return h.count >= h.config.BatchSize
+}
+
+func (h *DatadogHandler) flush() {
+	if h.count == 0 {
+		return
+	}
+	payload := append(h.logs, ']')
+
+	backoff := 500 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		var retryAfter time.Duration
+		err := h.attempt(func() (err error) {
+			retryAfter, err = h.send(payload)
+			return err
+		})
+		if err == ErrCircuitOpen {
+			retryAfter = -1 // not sent, the destination is unavailable
+		}
+		if err == nil {
+			h.counters.countHandled(h.count)
+			acknowledge(h.acks, nil)
+			break
+		}
+		h.counters.countError(err)
+		if retryAfter < 0 || attempt >= h.config.MaxRetries {
+			h.report(&DroppedError{Count: h.count, Err: err})
+			h.counters.countDropped(h.count)
+			acknowledgeDropped(h.acks, err)
+			break
+		}
+		if retryAfter == 0 {
+			retryAfter = backoff
+			backoff *= 2
+		}
+		time.Sleep(retryAfter)
+	}
+
+	h.logs = h.logs[:0]
+	h.count = 0
+	h.acks = h.acks[:0]
+}
+
+func (h *DatadogHandler) close() {}
+
+// send posts the payload; on error it returns how long to wait before retrying (0 for the
+// default backoff), or -1 if retrying is pointless.
+func (h *DatadogHandler) send(payload []byte) (time.Duration, error) {
+	var body io.Reader = bytes.NewReader(payload)
+	if !h.config.DisableCompression {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(payload)
+		if err := zw.Close(); err != nil {
+			return -1, err
+		}
+		body = &buf
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, h.config.Endpoint, body)
+	if err != nil {
+		return -1, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("DD-API-KEY", h.config.APIKey)
+	if !h.config.DisableCompression {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := h.config.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 300 {
+		return 0, nil
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(data)))
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode < 500 {
+		return -1, err
+	}
+	if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+		if seconds > 30 {
+			seconds = 30
+		}
+		return time.Duration(seconds) * time.Second, err
+	}
+	return 0, err
+}
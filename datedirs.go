@@ -0,0 +1,51 @@
+package log4go
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// datedFilename returns the file name of a file handler writing in date-derived directories
+// (see FileOptions.DateDirs) at time t: the directories are inserted before the file's base
+// name, e.g. "logs/2024/05/01/app.log" for "logs/app.log" and "{year}/{month}/{day}".
+func datedFilename(filename, dateDirs string, t time.Time) string {
+	isoYear, isoWeek := t.ISOWeek()
+	dirs := strings.NewReplacer(
+		"{year}", fmt.Sprintf("%04d", t.Year()),
+		"{month}", fmt.Sprintf("%02d", int(t.Month())),
+		"{day}", fmt.Sprintf("%02d", t.Day()),
+		"{hour}", fmt.Sprintf("%02d", t.Hour()),
+		"{isoyear}", fmt.Sprintf("%04d", isoYear),
+		"{week}", fmt.Sprintf("%02d", isoWeek),
+	).Replace(dateDirs)
+	return filepath.Join(filepath.Dir(filename), filepath.FromSlash(dirs), filepath.Base(filename))
+}
+
+// checkDateDirs returns an error if the FileOptions.DateDirs pattern has no date token, that
+// is if it's the same for two times differing by all tokens.
+func checkDateDirs(dateDirs string) error {
+	if datedFilename("f", dateDirs, time.Time{}) == datedFilename("f", dateDirs, time.Date(2001, 2, 3, 4, 0, 0, 0, time.UTC)) {
+		return errors.New("date dirs without date token")
+	}
+	return nil
+}
+
+// rollDateDirs is the preWrite function of the file handlers writing in date-derived
+// directories: it reopens the file in the current directories when they changed, creating
+// them; called by the committer.
+func (h *StreamHandler) rollDateDirs(filename *string, original, dateDirs string) {
+	dated := datedFilename(original, dateDirs, now())
+	if dated == *filename {
+		return
+	}
+	h.flushBuffer(true)
+	h.buffer = nil
+	*filename = dated
+	h.file.current.Store(dated)
+	if err := h.reopen(); err != nil {
+		h.report(fmt.Errorf("re-open error: %w", err))
+	}
+}
@@ -0,0 +1,306 @@
+package log4go
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DBColumn maps a table column to a record value.
+type DBColumn struct {
+	// Name is the column's name.
+	Name string
+	// Value is "time", "time_ns" (Unix time in nanoseconds), "name", "level" (the level name), "level_no" (the level number),
+	// "message", "formatted" (the record formatted by the handler's formatter), "seq", "pid",
+	// "hostname", "goid" or "fields" (the fields as a JSON object); any other value is looked
+	// up in the record's fields ("fields." prefix optional).
+	Value string
+	// Type is the column's SQL type for CREATE TABLE (default: depends on Value).
+	Type string
+}
+
+// DefaultDBColumns are the DBConfig.Columns used by default.
+var DefaultDBColumns = []DBColumn{
+	{Name: "time", Value: "time"},
+	{Name: "level", Value: "level"},
+	{Name: "logger", Value: "name"},
+	{Name: "message", Value: "message"},
+	{Name: "fields", Value: "fields"},
+}
+
+// DBConfig configures a DBHandler.
+type DBConfig struct {
+	// DB is the database the records are inserted into.
+	DB *sql.DB
+	// Table is the table name (default "logs").
+	Table string
+	// Columns maps the table's columns to the record values (default DefaultDBColumns).
+	Columns []DBColumn
+	// Placeholder is the driver's bind parameter style: "?" (default, e.g. MySQL and SQLite),
+	// "$" ($1, $2... e.g. PostgreSQL), "@p" (@p1... SQL Server) or ":" (:1... Oracle).
+	Placeholder string
+	// CreateTable makes NewDBHandler create the table if it doesn't exist.
+	CreateTable bool
+	// BatchSize is the maximum number of records inserted per transaction (default 100).
+	BatchSize int
+	// FlushInterval is the maximum time records wait for their batch (default 1s).
+	FlushInterval time.Duration
+	// Timeout limits each transaction (default 10s).
+	Timeout time.Duration
+	// QueueSize is the number of records buffered before Handle blocks (default 1000).
+	QueueSize int
+	// MaxRetries is the number of times a batch is retried before it's dropped (default 3).
+	MaxRetries int
+}
+
+// DBHandler inserts records into a database table through database/sql, a transaction per
+// batch using a prepared statement.
+type DBHandler struct {
+	batchHandler
+
+	config  DBConfig
+	insert  string
+	stmt    *sql.Stmt
+	pending [][]interface{}
+	retries int
+	lastErr error
+	acks    []func(error) // of the pending records, see takeAck
+}
+
+// NewDBHandler returns a new DBHandler, creating the table first if config.CreateTable is set.
+func NewDBHandler(config DBConfig) (*DBHandler, error) {
+	h, err := newDBHandler(config)
+	if err != nil {
+		return nil, err
+	}
+	h.run(h, h.config.FlushInterval)
+	return h, nil
+}
+
+// newDBHandler returns a new DBHandler, but doesn't start its goroutine.
+func newDBHandler(config DBConfig) (*DBHandler, error) {
+	if config.DB == nil {
+		return nil, errors.New("log4go.DBHandler: no database")
+	}
+	if len(config.Table) == 0 {
+		config.Table = "logs"
+	}
+	if len(config.Columns) == 0 {
+		config.Columns = DefaultDBColumns
+	}
+	if len(config.Placeholder) == 0 {
+		config.Placeholder = "?"
+	}
+	switch config.Placeholder {
+	case "?", "$", "@p", ":":
+	default:
+		return nil, fmt.Errorf("log4go.DBHandler: unsupported placeholder: '%s'", config.Placeholder)
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+
+	names := make([]string, len(config.Columns))
+	placeholders := make([]string, len(config.Columns))
+	for i, column := range config.Columns {
+		names[i] = column.Name
+		placeholders[i] = config.Placeholder
+		if config.Placeholder != "?" {
+			placeholders[i] += strconv.Itoa(i + 1)
+		}
+	}
+	h := &DBHandler{
+		config: config,
+		insert: "INSERT INTO " + config.Table + " (" + strings.Join(names, ", ") +
+			") VALUES (" + strings.Join(placeholders, ", ") + ")",
+	}
+
+	if config.CreateTable {
+		ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+		_, err := config.DB.ExecContext(ctx, h.createTable())
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	formatter, err := NewTemplateFormatter("{message}")
+	if err != nil {
+		return nil, err
+	}
+	h.init(config.QueueSize, formatter)
+
+	return h, nil
+}
+
+var _ Handler = &DBHandler{}
+
+// createTable returns the CREATE TABLE statement.
+func (h *DBHandler) createTable() string {
+	definitions := make([]string, len(h.config.Columns))
+	for i, column := range h.config.Columns {
+		sqlType := column.Type
+		if len(sqlType) == 0 {
+			switch column.Value {
+			case "time":
+				sqlType = "TIMESTAMP"
+			case "time_ns", "level_no", "seq", "goid":
+				sqlType = "BIGINT"
+			case "message", "formatted", "fields":
+				sqlType = "TEXT"
+			default:
+				sqlType = "VARCHAR(255)"
+			}
+		}
+		definitions[i] = column.Name + " " + sqlType
+	}
+	return "CREATE TABLE IF NOT EXISTS " + h.config.Table + " (" + strings.Join(definitions, ", ") + ")"
+}
+
+func (h *DBHandler) add(rec *Record) bool {
+	row := make([]interface{}, len(h.config.Columns))
+	for i, column := range h.config.Columns {
+		switch column.Value {
+		case "time":
+			row[i] = rec.Time
+		case "time_ns":
+			row[i] = rec.Time.UnixNano()
+		case "name":
+			row[i] = rec.Name
+			if len(rec.Name) == 0 {
+				row[i] = "root"
+			}
+		case "level":
+			row[i] = LevelName(rec.Level)
+		case "level_no":
+			row[i] = int64(rec.Level)
+		case "message":
+			row[i] = rec.Message
+		case "formatted":
+			msg, err := h.Formatter().Format(rec)
+			if err != nil {
+				if err != ErrorNotSet {
+					h.report(fmt.Errorf("formatter error: %w", err))
+					h.counters.countError(err)
+				}
+				return false
+			}
+			row[i] = string(msg)
+		case "seq":
+			row[i] = int64(rec.Seq)
+		case "pid":
+			row[i] = int64(pid)
+		case "hostname":
+			row[i] = hostname
+		case "goid":
+			row[i] = int64(rec.GoroutineID)
+		case "fields":
+			if fields := rec.allFields(); len(fields) != 0 {
+				data, err := marshalJSON(fields)
+				if err != nil {
+					data = []byte(fmt.Sprint(fields))
+				}
+				row[i] = string(bytes.TrimSuffix(data, []byte{'\n'}))
+			}
+		default:
+			if value, exists := rec.Get(strings.TrimPrefix(column.Value, "fields.")); exists {
+				row[i] = fmt.Sprint(value)
+			}
+		}
+	}
+
+	if len(h.pending) >= h.config.BatchSize {
+		h.flush()
+		if len(h.pending) != 0 {
+			h.drop() // the flush failed, and there's no room left to keep retrying
+		}
+	}
+	h.pending = append(h.pending, row)
+	if ack := takeAck(rec); ack != nil {
+		h.acks = append(h.acks, ack)
+	}
+	return len(h.pending) >= h.config.BatchSize
+}
+
+func (h *DBHandler) flush() {
+	if len(h.pending) == 0 {
+		return
+	}
+	if err := h.attempt(h.insertPending); err != nil {
+		h.lastErr = err
+		h.retries++
+		h.counters.countError(err)
+		if h.retries <= h.config.MaxRetries {
+			return // keep them for the next flush
+		}
+		h.drop()
+		return
+	}
+	h.counters.countHandled(len(h.pending))
+	acknowledge(h.acks, nil)
+	h.reset()
+}
+
+func (h *DBHandler) drop() {
+	h.counters.countDropped(len(h.pending))
+	h.report(&DroppedError{Count: len(h.pending), Err: h.lastErr})
+	acknowledgeDropped(h.acks, h.lastErr)
+	h.reset()
+}
+
+func (h *DBHandler) reset() {
+	h.acks = h.acks[:0]
+	h.pending = h.pending[:0]
+	h.retries = 0
+	h.lastErr = nil
+}
+
+func (h *DBHandler) close() {
+	if h.stmt != nil {
+		_ = h.stmt.Close()
+	}
+}
+
+// insertPending inserts the pending rows in a transaction.
+func (h *DBHandler) insertPending() error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+	defer cancel()
+
+	if h.stmt == nil {
+		stmt, err := h.config.DB.PrepareContext(ctx, h.insert)
+		if err != nil {
+			return err
+		}
+		h.stmt = stmt
+	}
+
+	tx, err := h.config.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	stmt := tx.StmtContext(ctx, h.stmt)
+	for _, row := range h.pending {
+		if _, err = stmt.ExecContext(ctx, row...); err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
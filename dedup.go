@@ -0,0 +1,197 @@
+package log4go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DedupHandler suppresses repeated records, like syslog: identical consecutive records (same
+// logger, level and message), or identical records within a time window, are passed once to
+// the wrapped handler, followed by a "last message repeated N times" record (or "message
+// repeated N times: <message>" within a window).
+type DedupHandler struct {
+	level   int32 // Level, accessed atomically
+	handler Handler
+	window  time.Duration
+
+	mu       sync.Mutex
+	last     *dedupEntry            // consecutive mode
+	entries  map[string]*dedupEntry // window mode, by key
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// dedupEntry is a record passed, and the number of its repeats suppressed since.
+type dedupEntry struct {
+	key     string
+	rec     Record
+	repeats int
+	expires time.Time
+}
+
+// NewDedupHandler returns a new DedupHandler passing the records to handler. With a zero
+// window identical consecutive records are suppressed, and summarized when a different record
+// is handled; otherwise the records identical to one passed within the last window are, and
+// summarized once the window ends.
+func NewDedupHandler(handler Handler, window time.Duration) (*DedupHandler, error) {
+	if handler == nil {
+		return nil, errors.New("log4go.DedupHandler: no handler")
+	}
+	h := &DedupHandler{handler: handler, window: window, stop: make(chan struct{})}
+	if window > 0 {
+		h.entries = make(map[string]*dedupEntry)
+		go h.expireLoop()
+	}
+	return h, nil
+}
+
+var _ Handler = &DedupHandler{}
+
+func dedupKey(rec *Record) string {
+	return fmt.Sprintf("%s\x00%d\x00%s", rec.Name, rec.Level, rec.Message)
+}
+
+// Handle passes the record to the wrapped handler, unless it's a repeat.
+func (h *DedupHandler) Handle(rec *Record) error {
+	if rec.Level < h.Level() {
+		return nil
+	}
+	key := dedupKey(rec)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.window == 0 {
+		if h.last != nil && h.last.key == key {
+			h.last.repeats++
+			return nil
+		}
+		h.summarize(h.last)
+		h.last = &dedupEntry{key: key, rec: *rec}
+		return h.handler.Handle(rec)
+	}
+
+	t := now()
+	if entry := h.entries[key]; entry != nil {
+		if t.Before(entry.expires) {
+			entry.repeats++
+			return nil
+		}
+		h.summarize(entry)
+	}
+	h.entries[key] = &dedupEntry{key: key, rec: *rec, expires: t.Add(h.window)}
+	return h.handler.Handle(rec)
+}
+
+// summarize passes the summary of the entry's repeats, if any; h.mu must be held.
+func (h *DedupHandler) summarize(entry *dedupEntry) {
+	if entry == nil || entry.repeats == 0 {
+		return
+	}
+	summary := entry.rec
+	summary.pool = nil
+	summary.Time = now()
+	times := "times"
+	if entry.repeats == 1 {
+		times = "time"
+	}
+	if h.window == 0 {
+		summary.Message = fmt.Sprintf("last message repeated %d %s", entry.repeats, times)
+	} else { // not necessarily the last message
+		summary.Message = fmt.Sprintf("message repeated %d %s: %s", entry.repeats, times, entry.rec.Message)
+	}
+	entry.repeats = 0
+	_ = h.handler.Handle(&summary)
+}
+
+// Flush passes the summaries of the repeats suppressed so far.
+func (h *DedupHandler) Flush() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.summarize(h.last)
+	for _, entry := range h.entries {
+		h.summarize(entry)
+	}
+}
+
+// expireLoop summarizes the ended windows, until the handler is shut down.
+func (h *DedupHandler) expireLoop() {
+	ticker := time.NewTicker(h.window)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			h.expire()
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+func (h *DedupHandler) expire() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t := now()
+	for key, entry := range h.entries {
+		if !t.Before(entry.expires) {
+			h.summarize(entry)
+			delete(h.entries, key)
+		}
+	}
+}
+
+// Handler returns the wrapped handler.
+func (h *DedupHandler) Handler() Handler {
+	return h.handler
+}
+
+// SetFormatter sets the wrapped handler's formatter.
+func (h *DedupHandler) SetFormatter(formatter Formatter) {
+	h.handler.SetFormatter(formatter)
+}
+
+// Formatter returns the wrapped handler's formatter.
+func (h *DedupHandler) Formatter() Formatter {
+	return h.handler.Formatter()
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *DedupHandler) SetLevel(level Level) {
+	atomic.StoreInt32(&h.level, int32(level))
+}
+
+// Level returns the level previously set (or NOTSET if not set).
+func (h *DedupHandler) Level() Level {
+	return Level(atomic.LoadInt32(&h.level))
+}
+
+// Shutdown passes the pending summaries, and shuts down the wrapped handler.
+func (h *DedupHandler) Shutdown() {
+	_ = h.ShutdownContext(context.Background())
+}
+
+// ShutdownContext passes the pending summaries, and shuts down the wrapped handler, waiting
+// until its queued records have been written, or returns ctx.Err() if ctx is done before that.
+func (h *DedupHandler) ShutdownContext(ctx context.Context) error {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+		h.Flush()
+	})
+	shutdownHandlers(ctx, []Handler{h.handler})
+	return ctx.Err()
+}
+
+// Reopen reopens the wrapped handler's file, if it's a file handler.
+func (h *DedupHandler) Reopen() error {
+	return reopenHandlers([]Handler{h.handler})
+}
+
+func (h *DedupHandler) canReopen() bool {
+	return canReopenHandlers([]Handler{h.handler})
+}
@@ -0,0 +1,94 @@
+package log4go
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// ErrorHandler is called with the errors of the handlers: formatting, write and send errors,
+// and DroppedError when records are given up on. It's called from the handlers' goroutines
+// and shouldn't block.
+type ErrorHandler func(h Handler, err error)
+
+// DroppedError is reported when a handler gives up on records.
+type DroppedError struct {
+	// Count is the number of records dropped.
+	Count int
+	// Err is the (last) error that caused the drop.
+	Err error
+}
+
+func (e *DroppedError) Error() string {
+	return fmt.Sprintf("dropping %d records: %v", e.Count, e.Err)
+}
+
+// Unwrap returns the error that caused the drop.
+func (e *DroppedError) Unwrap() error {
+	return e.Err
+}
+
+type errorHandlerValue struct {
+	f ErrorHandler
+}
+
+var (
+	errorHandler      atomic.Value // errorHandlerValue
+	diagnosticsLogger atomic.Value // *Logger
+)
+
+// SetErrorHandler sets the function called with the handlers' errors, replacing the default
+// which logs them to the diagnostics logger (see SetDiagnosticsLogger) or prints them to
+// stderr; nil restores the default.
+func SetErrorHandler(f ErrorHandler) {
+	errorHandler.Store(errorHandlerValue{f})
+}
+
+// SetDiagnosticsLogger makes the default ErrorHandler log the handlers' errors as ERROR
+// records (with a "handler" field) to logger instead of printing them to stderr; the errors
+// of logger's own handlers are still printed to stderr. nil restores stderr.
+func SetDiagnosticsLogger(logger *Logger) {
+	diagnosticsLogger.Store(logger)
+}
+
+// reportWarning reports a warning of log4go itself, e.g. of the disk guard: as a WARNING
+// record of the diagnostics logger (see SetDiagnosticsLogger), or to stderr.
+func reportWarning(format string, args ...interface{}) {
+	if logger, _ := diagnosticsLogger.Load().(*Logger); logger != nil {
+		logger.Warning(format, args...)
+		return
+	}
+	_, _ = fmt.Fprintln(os.Stderr, "log4go: "+fmt.Sprintf(format, args...))
+}
+
+// reportError reports the handler's error to the ErrorHandler.
+func reportError(h Handler, err error) {
+	if v, _ := errorHandler.Load().(errorHandlerValue); v.f != nil {
+		v.f(h, err)
+		return
+	}
+
+	if logger, _ := diagnosticsLogger.Load().(*Logger); logger != nil && !hasHandler(logger, h) {
+		logger.Error("%v", err, Fields{"handler": handlerName(h)})
+		return
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "log4go.%s: %v\n", handlerName(h), err)
+}
+
+// hasHandler reports whether h is one of the logger's handlers, whose errors mustn't be
+// logged to it.
+func hasHandler(logger *Logger, h Handler) bool {
+	for _, handler := range logger.Handlers() {
+		if handler == h {
+			return true
+		}
+	}
+	return false
+}
+
+// handlerName returns the handler's type name, without the package for log4go's own.
+func handlerName(h Handler) string {
+	name := strings.TrimPrefix(fmt.Sprintf("%T", h), "*")
+	return strings.TrimPrefix(name, "log4go.")
+}
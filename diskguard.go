@@ -0,0 +1,199 @@
+package log4go
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DiskGuardOptions configures the disk budget of the file handlers, see GuardDiskUsage.
+type DiskGuardOptions struct {
+	// MaxBytes is the budget: the total size of the files of all the file handlers, and of
+	// their rotated files (the files of their directory whose name starts with the file's).
+	MaxBytes int64
+	// Interval is the time between two checks (default 10s).
+	Interval time.Duration
+	// DeleteRotated deletes the oldest rotated files (and those of past dates, see
+	// FileOptions.DateDirs) to get back within the budget; otherwise, or if that's not
+	// enough, the file handlers drop their records (see Stats) until it is.
+	DeleteRotated bool
+}
+
+// DefaultDiskGuardInterval is the default DiskGuardOptions.Interval.
+const DefaultDiskGuardInterval = 10 * time.Second
+
+// guardedFile is the file of a file handler, as seen by the disk guard.
+type guardedFile struct {
+	pattern string       // glob matching the file and its rotated files
+	current atomic.Value // string, the file being written
+}
+
+var fileHandlersLock sync.Mutex
+var fileHandlers = make(map[*StreamHandler]bool)
+
+// guardFile registers a file handler writing filename (possibly in date-derived directories,
+// see FileOptions.DateDirs) for the disk guard.
+func guardFile(h *StreamHandler, filename, dateDirs, current string) {
+	dirs := filepath.FromSlash(dateDirs)
+	for _, token := range []string{"{year}", "{month}", "{day}", "{hour}", "{isoyear}", "{week}"} {
+		dirs = strings.Replace(dirs, token, "*", -1)
+	}
+	pattern := filepath.Join(filepath.Dir(filename), dirs, filepath.Base(filename)+"*")
+	h.file = &guardedFile{pattern: pattern}
+	h.file.current.Store(current)
+
+	fileHandlersLock.Lock()
+	defer fileHandlersLock.Unlock()
+	fileHandlers[h] = true
+}
+
+// unguardFile unregisters a file handler being shut down.
+func unguardFile(h *StreamHandler) {
+	if h.file == nil {
+		return
+	}
+	fileHandlersLock.Lock()
+	defer fileHandlersLock.Unlock()
+	delete(fileHandlers, h)
+}
+
+var diskGuardLock sync.Mutex
+var stopDiskGuard func()
+
+// GuardDiskUsage starts checking the disk usage of the file handlers' files against a budget,
+// so logs can't fill the disk: past it, the oldest rotated files are deleted if options say
+// so, else (or if not enough) the file handlers drop their records until the usage is back
+// within the budget, with a warning (see SetDiagnosticsLogger). There's one guard at a time:
+// it replaces the previous one, until stop is called.
+func GuardDiskUsage(options DiskGuardOptions) (stop func(), err error) {
+	if options.MaxBytes <= 0 {
+		return nil, errors.New("log4go: no disk budget")
+	}
+	if options.Interval <= 0 {
+		options.Interval = DefaultDiskGuardInterval
+	}
+
+	diskGuardLock.Lock()
+	defer diskGuardLock.Unlock()
+	if stopDiskGuard != nil {
+		stopDiskGuard()
+	}
+
+	guard := &diskGuard{options: options}
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(options.Interval)
+		defer ticker.Stop()
+		for {
+			guard.check()
+			select {
+			case <-ticker.C:
+			case <-done:
+				guard.setDropping(false)
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(done)
+			<-stopped
+		})
+	}
+	stopDiskGuard = stop
+	return stop, nil
+}
+
+// diskGuard is the state of the disk guard, owned by its goroutine.
+type diskGuard struct {
+	options  DiskGuardOptions
+	dropping bool
+}
+
+// guardedFileInfo is a file of the file handlers, with its size and modification time.
+type guardedFileInfo struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// check checks the disk usage, deleting the rotated files or making the handlers drop their
+// records as needed.
+func (g *diskGuard) check() {
+	fileHandlersLock.Lock()
+	current := make(map[string]bool, len(fileHandlers))
+	patterns := make(map[string]bool, len(fileHandlers))
+	for h := range fileHandlers {
+		current[h.file.current.Load().(string)] = true
+		patterns[h.file.pattern] = true
+	}
+	fileHandlersLock.Unlock()
+
+	var total int64
+	var rotated []guardedFileInfo
+	seen := make(map[string]bool)
+	for pattern := range patterns {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() || seen[path] {
+				continue
+			}
+			seen[path] = true
+			total += info.Size()
+			if !current[path] {
+				rotated = append(rotated, guardedFileInfo{path, info.Size(), info.ModTime()})
+			}
+		}
+	}
+
+	if total > g.options.MaxBytes && g.options.DeleteRotated && len(rotated) > 0 {
+		sort.Slice(rotated, func(i, j int) bool { return rotated[i].modTime.Before(rotated[j].modTime) })
+		deleted := 0
+		for _, file := range rotated {
+			if total <= g.options.MaxBytes {
+				break
+			}
+			if err := os.Remove(file.path); err != nil {
+				reportWarning("disk guard: %v", err)
+				continue
+			}
+			total -= file.size
+			deleted++
+		}
+		if deleted > 0 {
+			reportWarning("disk guard: deleted %d rotated log files, the disk budget of %d bytes was reached", deleted, g.options.MaxBytes)
+		}
+	}
+
+	switch dropping := total > g.options.MaxBytes; {
+	case dropping && !g.dropping:
+		reportWarning("disk guard: log files use %d bytes, over the disk budget of %d bytes: dropping records", total, g.options.MaxBytes)
+	case !dropping && g.dropping:
+		reportWarning("disk guard: log files use %d bytes, within the disk budget of %d bytes again: writing records", total, g.options.MaxBytes)
+	}
+	g.dropping = total > g.options.MaxBytes
+	g.setDropping(g.dropping)
+}
+
+// setDropping sets whether the file handlers drop their records.
+func (g *diskGuard) setDropping(dropping bool) {
+	var value int32
+	if dropping {
+		value = 1
+	}
+	fileHandlersLock.Lock()
+	defer fileHandlersLock.Unlock()
+	for h := range fileHandlers {
+		atomic.StoreInt32(&h.diskFull, value)
+	}
+}
@@ -0,0 +1,56 @@
+// Package echolog provides log4go request logging and recovery middlewares for echo, to use
+// instead of echo's middleware.Logger and middleware.Recover.
+package echolog
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/kaizer666/log4go"
+	"github.com/labstack/echo/v4"
+)
+
+// Logger returns a middleware logging the requests with rl (see log4go.RequestLogger); the
+// requests' contexts carry their request-scoped logger (see log4go.FromContext). The errors
+// returned by the handlers are passed to echo's HTTPErrorHandler first, so that their
+// response is logged.
+func Logger(rl *log4go.RequestLogger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			r := c.Request()
+			info := rl.NewRequestInfo(r)
+			c.Response().Header().Set(rl.RequestIDHeader(), info.RequestID)
+			c.SetRequest(r.WithContext(log4go.NewContext(r.Context(), rl.RequestScoped(info))))
+
+			if err := next(c); err != nil {
+				c.Error(err)
+			}
+
+			info.Status = c.Response().Status
+			info.Size = c.Response().Size
+			info.Latency = time.Now().Sub(start)
+			rl.Log(info)
+			return nil
+		}
+	}
+}
+
+// Recovery returns a middleware recovering the panics of the handlers, logging them with
+// their stack trace at ERROR level to the request-scoped logger (or the root logger), and
+// returning a 500 Internal Server Error.
+func Recovery() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) (err error) {
+			defer func() {
+				if v := recover(); v != nil {
+					log4go.FromContext(c.Request().Context()).Error("PANIC: %v\n%s", v, debug.Stack())
+					err = echo.NewHTTPError(http.StatusInternalServerError).SetInternal(fmt.Errorf("panic: %v", v))
+				}
+			}()
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package echolog
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/kaizer666/log4go"
+	"github.com/labstack/echo/v4"
+)
+
+func TestMiddlewares(t *testing.T) {
+	log4go.BasicConfig(log4go.BasicConfigOpts{Level: log4go.INFO, Writer: ioutil.Discard})
+	defer log4go.Shutdown()
+	logger := log4go.GetLogger("echo")
+	ring, _ := log4go.NewRingHandler(10)
+	formatter, _ := log4go.NewTemplateFormatter("{level} {message}")
+	ring.SetFormatter(formatter)
+	logger.ReplaceHandlers(ring)
+	rl, err := log4go.NewRequestLogger(log4go.RequestLogOptions{Logger: logger, Format: "{method} {path} {status} {size}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	e := echo.New()
+	e.Use(Logger(rl), Recovery())
+	e.GET("/hello", func(c echo.Context) error {
+		log4go.FromContext(c.Request().Context()).Info("handling")
+		return c.String(http.StatusOK, "hello")
+	})
+	e.GET("/panic", func(c echo.Context) error {
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+	if len(w.Header().Get("X-Request-Id")) == 0 {
+		t.Error("no request ID header")
+	}
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+
+	records := ring.Records()
+	if len(records) != 4 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if records[0].Message != "handling" || records[0].Fields["path"] != "/hello" {
+		t.Errorf("unexpected record: %+v", records[0])
+	}
+	if records[1].Message != "GET /hello 200 5" {
+		t.Errorf("unexpected record: %q", records[1].Message)
+	}
+	if records[2].Level != log4go.ERROR || records[3].Message != "GET /panic 500 36" {
+		t.Errorf("unexpected records: %v %q", records[2].Level, records[3].Message)
+	}
+}
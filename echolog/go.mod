@@ -0,0 +1,26 @@
+module github.com/kaizer666/log4go/echolog
+
+// To build against the log4go checkout, rather than the required version, use the workspace
+// created from the repository's root by:
+//
+//	go work init . ./echolog ./fiberlog ./ginlog ./logrsink ./prometheus
+
+go 1.22
+
+require (
+	github.com/kaizer666/log4go v0.0.0-20261017070848-da72bd423885
+	github.com/labstack/echo/v4 v4.9.1
+)
+
+require (
+	github.com/fsnotify/fsnotify v1.7.0 // indirect
+	github.com/labstack/gommon v0.4.0 // indirect
+	github.com/mattn/go-colorable v0.1.11 // indirect
+	github.com/mattn/go-isatty v0.0.19 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/valyala/fasttemplate v1.2.1 // indirect
+	golang.org/x/crypto v0.14.0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
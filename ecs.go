@@ -0,0 +1,95 @@
+package log4go
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// ECSVersion is the Elastic Common Schema version written by ECSFormatter.
+const ECSVersion = "1.6.0"
+
+// ECSFormatter formats records as Elastic Common Schema (ECS) JSON lines.
+//
+// Besides @timestamp, log.level, log.logger and message, an error valued field (or a field
+// named "error" or "err") is written as error.message/error.type, "trace_id" and "span_id"
+// fields as trace.id and span.id; all other fields are written using their own names, and the
+// record's tags as tags.
+type ECSFormatter struct {
+	// ServiceName, if set, is written as service.name.
+	ServiceName string
+}
+
+// NewECSFormatter returns a new ECSFormatter.
+func NewECSFormatter() *ECSFormatter {
+	return &ECSFormatter{}
+}
+
+// Format returns the record as an ECS JSON object.
+func (f *ECSFormatter) Format(r *Record) ([]byte, error) {
+	if r.Level == NOTSET {
+		return []byte{}, ErrorNotSet
+	}
+
+	name := r.Name
+	if len(name) == 0 {
+		name = "root"
+	}
+
+	fields := r.allFields()
+	doc := make(map[string]interface{}, 8+len(fields))
+	for key, value := range fields {
+		switch v := value.(type) {
+		case error:
+			doc["error.message"] = v.Error()
+			doc["error.type"] = fmt.Sprintf("%T", v)
+		default:
+			switch key {
+			case "error", "err":
+				doc["error.message"] = fmt.Sprint(v)
+			case "trace_id":
+				doc["trace.id"] = v
+			case "span_id":
+				doc["span.id"] = v
+			default:
+				doc[key] = v
+			}
+		}
+	}
+
+	doc["@timestamp"] = r.Time.UTC().Format(ecsTimeFormat)
+	doc["log.level"] = strings.ToLower(LevelName(r.Level))
+	doc["log.logger"] = name
+	doc["message"] = r.Message
+	doc["ecs.version"] = ECSVersion
+	if len(r.Tags) > 0 {
+		doc["tags"] = r.Tags
+	}
+	if len(r.File) > 0 {
+		doc["log.origin.file.name"] = r.File
+		doc["log.origin.file.line"] = r.Line
+	}
+	doc["process.pid"] = pid
+	doc["host.hostname"] = hostname
+	if len(f.ServiceName) > 0 {
+		doc["service.name"] = f.ServiceName
+	}
+
+	return marshalJSON(doc)
+}
+
+// marshalJSON returns v as JSON, without escaping HTML characters.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte{'\n'}), nil
+}
+
+const ecsTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+var _ Formatter = &ECSFormatter{}
@@ -0,0 +1,180 @@
+package log4go
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+)
+
+// Encrypted logs are a sequence of self-contained chunks, so they can be appended to (and
+// rotated) like plain logs; a chunk is:
+//
+//	"L4GE" | version (1) | nonce (12) | length (4, big endian) | AES-GCM ciphertext (length)
+//
+// the header (everything before the ciphertext) being authenticated as additional data. Each
+// Write makes a chunk: buffering the handler's writes (see SetBuffering) makes fewer, bigger
+// chunks.
+const (
+	encryptedMagic   = "L4GE"
+	encryptedVersion = 1
+	encryptedHeader  = len(encryptedMagic) + 1 + 12 + 4
+
+	// maxEncryptedChunk is the maximum plaintext size of a chunk, bigger writes are split.
+	maxEncryptedChunk = 1 << 20
+)
+
+// ErrEncryptedFormat is returned when decrypting data which isn't an encrypted log.
+var ErrEncryptedFormat = errors.New("log4go: invalid encrypted log chunk")
+
+// EncryptingWriter encrypts what's written to it with AES-GCM, see NewEncryptingWriter.
+type EncryptingWriter struct {
+	w    io.Writer
+	aead cipher.AEAD
+
+	mu     sync.Mutex
+	chunk  []byte
+	header [encryptedHeader]byte
+}
+
+// NewEncryptingWriter returns a writer encrypting to w with the AES key (16, 24 or 32 bytes
+// long, for AES-128, AES-192 or AES-256); NewDecryptingReader reads the encrypted data back.
+// Since nonces are random, a key shouldn't encrypt more than about 2^32 writes.
+func NewEncryptingWriter(w io.Writer, key []byte) (*EncryptingWriter, error) {
+	aead, err := newLogAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptingWriter{w: w, aead: aead}, nil
+}
+
+func newLogAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Write writes p as one encrypted chunk (or more, for big writes).
+func (e *EncryptingWriter) Write(p []byte) (int, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	n := 0
+	for len(p) > 0 {
+		plain := p
+		if len(plain) > maxEncryptedChunk {
+			plain = plain[:maxEncryptedChunk]
+		}
+
+		header := e.header[:]
+		copy(header, encryptedMagic)
+		header[len(encryptedMagic)] = encryptedVersion
+		nonce := header[len(encryptedMagic)+1 : encryptedHeader-4]
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return n, err
+		}
+		binary.BigEndian.PutUint32(header[encryptedHeader-4:], uint32(len(plain)+e.aead.Overhead()))
+		chunk := e.aead.Seal(append(e.chunk[:0], header...), nonce, plain, header)
+		if cap(chunk) <= 64*1024 {
+			e.chunk = chunk
+		}
+
+		if _, err := e.w.Write(chunk); err != nil {
+			return n, err
+		}
+		n += len(plain)
+		p = p[len(plain):]
+	}
+	return n, nil
+}
+
+// Sync syncs the underlying writer, if it has a Sync method.
+func (e *EncryptingWriter) Sync() error {
+	if s, ok := e.w.(interface{ Sync() error }); ok {
+		return s.Sync()
+	}
+	return nil
+}
+
+// Close closes the underlying writer, if it's an io.Closer.
+func (e *EncryptingWriter) Close() error {
+	if c, ok := e.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// decryptingReader decrypts the chunks written by an EncryptingWriter.
+type decryptingReader struct {
+	r      io.Reader
+	aead   cipher.AEAD
+	chunk  []byte
+	plain  []byte // decrypted, not read yet
+	header [encryptedHeader]byte
+}
+
+// NewDecryptingReader returns a reader decrypting r, written by an EncryptingWriter with the
+// same key. It fails with ErrEncryptedFormat on data which isn't an encrypted log, and with
+// io.ErrUnexpectedEOF when the last chunk is incomplete (e.g. after a crash).
+func NewDecryptingReader(r io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newLogAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &decryptingReader{r: r, aead: aead}, nil
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if err := d.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk.
+func (d *decryptingReader) next() error {
+	if n, err := io.ReadFull(d.r, d.header[:]); err != nil {
+		if n > len(encryptedMagic) {
+			n = len(encryptedMagic)
+		}
+		if string(d.header[:n]) != encryptedMagic[:n] {
+			return ErrEncryptedFormat
+		}
+		return err // io.EOF between chunks
+	}
+	if string(d.header[:len(encryptedMagic)]) != encryptedMagic || d.header[len(encryptedMagic)] != encryptedVersion {
+		return ErrEncryptedFormat
+	}
+	size := binary.BigEndian.Uint32(d.header[encryptedHeader-4:])
+	if size < uint32(d.aead.Overhead()) || size > maxEncryptedChunk+uint32(d.aead.Overhead()) {
+		return ErrEncryptedFormat
+	}
+
+	if cap(d.chunk) < int(size) {
+		d.chunk = make([]byte, size)
+	}
+	d.chunk = d.chunk[:size]
+	if _, err := io.ReadFull(d.r, d.chunk); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	nonce := d.header[len(encryptedMagic)+1 : encryptedHeader-4]
+	plain, err := d.aead.Open(d.chunk[:0], nonce, d.chunk, d.header[:])
+	if err != nil {
+		return err
+	}
+	d.plain = plain
+	return nil
+}
@@ -0,0 +1,72 @@
+package log4go
+
+import (
+	"expvar"
+	"time"
+)
+
+// PublishExpvar publishes the statistics (see ReadStats) as the expvar variable name (default
+// "log4go"), i.e. on /debug/vars for environments without Prometheus:
+//
+//	{"logged": {"INFO": 10, ...}, "rate_limited": 0, "dropped": 0, "errors": 0,
+//	 "loggers": {"root": {"INFO": 10, ...}, ...},
+//	 "handlers": {"StreamHandler": {"handled": 10, "dropped": 0, "errors": 0,
+//	   "last_error": "...", "last_error_time": "...", ...}, ...}}
+//
+// Publishing the same name again is a no-op.
+func PublishExpvar(name string) {
+	if len(name) == 0 {
+		name = "log4go"
+	}
+	if expvar.Get(name) != nil {
+		return
+	}
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return expvarStats(ReadStats())
+	}))
+}
+
+func expvarStats(stats Stats) map[string]interface{} {
+	logged := make(map[string]uint64)
+	var limited uint64
+	loggers := make(map[string]interface{}, len(stats.Loggers))
+	for _, logger := range stats.Loggers {
+		limited += logger.RateLimited
+		levels := make(map[string]uint64, len(logger.Logged))
+		for lvl, n := range logger.Logged {
+			levels[LevelName(lvl)] = n
+			logged[LevelName(lvl)] += n
+		}
+		loggers[logger.Name] = levels
+	}
+
+	var dropped, errors uint64
+	handlers := make(map[string]interface{}, len(stats.Handlers))
+	for _, h := range stats.Handlers {
+		dropped += h.Dropped
+		errors += h.Errors
+		handler := map[string]interface{}{
+			"handled":        h.Handled,
+			"dropped":        h.Dropped,
+			"errors":         h.Errors,
+			"queue_depth":    h.QueueDepth,
+			"queue_capacity": h.QueueCapacity,
+			"writes":         h.Writes,
+			"write_seconds":  h.WriteTime.Seconds(),
+		}
+		if !h.LastErrorTime.IsZero() {
+			handler["last_error"] = h.LastError
+			handler["last_error_time"] = h.LastErrorTime.Format(time.RFC3339Nano)
+		}
+		handlers[h.Name] = handler
+	}
+
+	return map[string]interface{}{
+		"logged":       logged,
+		"rate_limited": limited,
+		"dropped":      dropped,
+		"errors":       errors,
+		"loggers":      loggers,
+		"handlers":     handlers,
+	}
+}
@@ -0,0 +1,81 @@
+// Package fiberlog provides log4go request logging and recovery middlewares for fiber, to use
+// instead of fiber's logger and recover middlewares.
+//
+// It's a separate module, fiber requiring a more recent Go version than log4go.
+package fiberlog
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kaizer666/log4go"
+)
+
+// Logger returns a middleware logging the requests with rl (see log4go.RequestLogger); the
+// requests' user contexts carry their request-scoped logger (see log4go.FromContext). The
+// errors returned by the handlers are passed to the app's ErrorHandler first, so that their
+// response is logged.
+func Logger(rl *log4go.RequestLogger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		info := newRequestInfo(c, rl.RequestIDHeader())
+		c.Set(rl.RequestIDHeader(), info.RequestID)
+		c.SetUserContext(log4go.NewContext(c.UserContext(), rl.RequestScoped(info)))
+
+		if err := c.Next(); err != nil {
+			if err = c.App().ErrorHandler(c, err); err != nil {
+				_ = c.SendStatus(fiber.StatusInternalServerError)
+			}
+		}
+
+		info.Status = c.Response().StatusCode()
+		info.Size = int64(len(c.Response().Body()))
+		info.Latency = time.Now().Sub(start)
+		rl.Log(info)
+		return nil
+	}
+}
+
+// newRequestInfo returns the RequestInfo of a request (see log4go.RequestLogger.NewRequestInfo).
+func newRequestInfo(c *fiber.Ctx, requestIDHeader string) log4go.RequestInfo {
+	// fiber's strings are only valid during the request, the records may outlive it
+	info := log4go.RequestInfo{
+		Method:    strings.Clone(c.Method()),
+		Path:      strings.Clone(c.Path()),
+		Query:     string(c.Request().URI().QueryString()),
+		Proto:     string(c.Request().Header.Protocol()),
+		RemoteIP:  strings.Clone(c.IP()),
+		UserAgent: strings.Clone(c.Get(fiber.HeaderUserAgent)),
+		Referer:   strings.Clone(c.Get(fiber.HeaderReferer)),
+		RequestID: strings.Clone(c.Get(requestIDHeader)),
+	}
+	if auth := c.Get(fiber.HeaderAuthorization); len(auth) > 0 {
+		r := http.Request{Header: http.Header{"Authorization": {auth}}}
+		if user, _, ok := r.BasicAuth(); ok {
+			info.User = user
+		}
+	}
+	if len(info.RequestID) == 0 {
+		info.RequestID = log4go.NewRequestID()
+	}
+	return info
+}
+
+// Recovery returns a middleware recovering the panics of the handlers, logging them with
+// their stack trace at ERROR level to the request-scoped logger (or the root logger), and
+// returning a 500 Internal Server Error.
+func Recovery() fiber.Handler {
+	return func(c *fiber.Ctx) (err error) {
+		defer func() {
+			if v := recover(); v != nil {
+				log4go.FromContext(c.UserContext()).Error("PANIC: %v\n%s", v, debug.Stack())
+				err = fiber.NewError(fiber.StatusInternalServerError, fmt.Sprintf("panic: %v", v))
+			}
+		}()
+		return c.Next()
+	}
+}
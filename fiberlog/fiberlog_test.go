@@ -0,0 +1,64 @@
+package fiberlog
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/kaizer666/log4go"
+)
+
+func TestMiddlewares(t *testing.T) {
+	log4go.BasicConfig(log4go.BasicConfigOpts{Level: log4go.INFO, Writer: ioutil.Discard})
+	defer log4go.Shutdown()
+	logger := log4go.GetLogger("fiber")
+	ring, _ := log4go.NewRingHandler(10)
+	formatter, _ := log4go.NewTemplateFormatter("{level} {message}")
+	ring.SetFormatter(formatter)
+	logger.ReplaceHandlers(ring)
+	rl, err := log4go.NewRequestLogger(log4go.RequestLogOptions{Logger: logger, Format: "{method} {path} {query} {status} {size}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := fiber.New()
+	app.Use(Logger(rl), Recovery())
+	app.Get("/hello", func(c *fiber.Ctx) error {
+		log4go.FromContext(c.UserContext()).Info("handling")
+		return c.SendString("hello")
+	})
+	app.Get("/panic", func(c *fiber.Ctx) error {
+		panic("boom")
+	})
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/hello?x=1", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Header.Get("X-Request-Id")) == 0 {
+		t.Error("no request ID header")
+	}
+	resp, err = app.Test(httptest.NewRequest("GET", "/panic", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("unexpected status: %d", resp.StatusCode)
+	}
+
+	records := ring.Records()
+	if len(records) != 4 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if records[0].Message != "handling" || records[0].Fields["path"] != "/hello" {
+		t.Errorf("unexpected record: %+v", records[0])
+	}
+	if records[1].Message != "GET /hello x=1 200 5" {
+		t.Errorf("unexpected record: %q", records[1].Message)
+	}
+	if records[2].Level != log4go.ERROR || records[3].Message != "GET /panic - 500 11" {
+		t.Errorf("unexpected records: %v %q", records[2].Level, records[3].Message)
+	}
+}
@@ -0,0 +1,30 @@
+module github.com/kaizer666/log4go/fiberlog
+
+// To build against the log4go checkout, rather than the required version, use the workspace
+// created from the repository's root by:
+//
+//	go work init . ./echolog ./fiberlog ./ginlog ./logrsink ./prometheus
+
+go 1.22
+
+require (
+	github.com/gofiber/fiber/v2 v2.52.9
+	github.com/kaizer666/log4go v0.0.0-20261017070848-da72bd423885
+)
+
+require (
+	github.com/andybalholm/brotli v1.1.0 // indirect
+	github.com/fsnotify/fsnotify v1.7.0 // indirect
+	github.com/google/uuid v1.6.0 // indirect
+	github.com/klauspost/compress v1.18.0 // indirect
+	github.com/mattn/go-colorable v0.1.13 // indirect
+	github.com/mattn/go-isatty v0.0.20 // indirect
+	github.com/mattn/go-runewidth v0.0.16 // indirect
+	github.com/rivo/uniseg v0.2.0 // indirect
+	github.com/valyala/bytebufferpool v1.0.0 // indirect
+	github.com/valyala/fasthttp v1.51.0 // indirect
+	github.com/valyala/tcplisten v1.0.0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.28.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
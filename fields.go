@@ -0,0 +1,126 @@
+package log4go
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/kaizer666/log4go/color"
+)
+
+// Fields are structured key/value pairs attached to a Record; passed as the last argument of
+// a logging call they are attached instead of being formatted into the message:
+//
+//	log.Info("user %s logged in", name, log4go.Fields{"ip": ip})
+type Fields map[string]interface{}
+
+// splitFields removes trailing Fields from args, returning them separately.
+func splitFields(args []interface{}) ([]interface{}, Fields) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	if fields, ok := args[len(args)-1].(Fields); ok {
+		return args[:len(args)-1], fields
+	}
+	return args, nil
+}
+
+// FieldColoring are the colors of the fields written by the {fields} template token, see
+// TemplateFormatter.SetFieldColoring.
+type FieldColoring struct {
+	// Key and Value are the colors of the keys and values, empty for the line's color.
+	Key   string
+	Value string
+	// Highlight maps keys to the colors of their values, e.g. {"err": color.Red}.
+	Highlight map[string]string
+}
+
+var defaultFieldColoring = &FieldColoring{
+	Key:       color.Faint,
+	Highlight: map[string]string{"err": color.Red, "error": color.Red},
+}
+
+// EnableFieldColoring sets the default field colors (faint keys, and red "err" and "error"
+// values), false to disable.
+func (f *TemplateFormatter) EnableFieldColoring(enable bool) {
+	if enable {
+		f.fieldColoring = defaultFieldColoring
+	} else {
+		f.fieldColoring = nil
+	}
+}
+
+// SetFieldColoring sets the colors of the fields written by the {fields} token, nil to
+// disable.
+func (f *TemplateFormatter) SetFieldColoring(coloring *FieldColoring) {
+	f.fieldColoring = coloring
+}
+
+// appendFields appends the record's fields as space separated key=value pairs, sorted by key,
+// the values quoted if needed; baseColor is the line's color, restored after the colored parts.
+func (f *TemplateFormatter) appendFields(dst []byte, r *Record, baseColor string) []byte {
+	fields := r.allFields()
+	if len(fields) == 0 {
+		return dst
+	}
+	highlights := f.fieldHighlights(r)
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	if len(baseColor) == 0 {
+		baseColor = colorReset
+	}
+	coloring := f.fieldColoring
+	for idx, key := range keys {
+		if idx > 0 {
+			dst = append(dst, ' ')
+		}
+		value := fieldValue(fields[key])
+		if highlight, exists := highlights[key]; exists {
+			keyColor := ""
+			if coloring != nil {
+				keyColor = coloring.Key
+			}
+			dst = appendColored(dst, keyColor, key, baseColor)
+			dst = append(dst, '=')
+			dst = appendColored(dst, highlight, value, baseColor)
+			continue
+		}
+		if coloring == nil {
+			dst = append(dst, key...)
+			dst = append(dst, '=')
+			dst = append(dst, value...)
+			continue
+		}
+		valueColor, highlighted := coloring.Highlight[key]
+		if !highlighted {
+			valueColor = coloring.Value
+		}
+		dst = appendColored(dst, coloring.Key, key, baseColor)
+		dst = append(dst, '=')
+		dst = appendColored(dst, valueColor, value, baseColor)
+	}
+	return dst
+}
+
+// fieldValue returns a field's value as written by the {fields} token.
+func fieldValue(value interface{}) string {
+	s := fieldString(value)
+	if len(s) == 0 || strings.ContainsAny(s, " =\"\t\n") {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// appendColored appends s in color (if any), followed by baseColor.
+func appendColored(dst []byte, color, s, baseColor string) []byte {
+	if len(color) == 0 {
+		return append(dst, s...)
+	}
+	dst = append(dst, color...)
+	dst = append(dst, s...)
+	return append(dst, baseColor...)
+}
@@ -0,0 +1,67 @@
+package log4go
+
+import (
+	"flag"
+	"strings"
+)
+
+// Set sets the level from its name, see ParseLevel; with String, it makes *Level a flag.Value:
+//
+//	level := log4go.INFO
+//	flag.Var(&level, "level", "logging level")
+func (l *Level) Set(name string) error {
+	return l.UnmarshalText([]byte(name))
+}
+
+// Type returns "level", for github.com/spf13/pflag.
+func (l *Level) Type() string {
+	return "level"
+}
+
+// LogFlags are the logging command line flags, see RegisterFlags.
+type LogFlags struct {
+	// Level is the -log-level flag, the root logger's level (default WARNING).
+	Level Level
+	// Format is the -log-format flag: "text" (default), "json", a formatter name of
+	// HandlerConfig.Formatter (e.g. "csv"), or a template (e.g. "{level} {message}").
+	Format string
+	// File is the -log-file flag, the file the records are appended to (default stderr).
+	File string
+}
+
+// RegisterFlags registers the -log-level, -log-format and -log-file flags on fs (the
+// flag.CommandLine if nil), which the returned LogFlags are set from; once the flags are
+// parsed, its BasicConfig method configures the logging. With github.com/spf13/pflag, the
+// flags can be added with AddGoFlagSet.
+func RegisterFlags(fs *flag.FlagSet) *LogFlags {
+	if fs == nil {
+		fs = flag.CommandLine
+	}
+	f := &LogFlags{Level: WARNING, Format: "text"}
+	fs.Var(&f.Level, "log-level", "logging level: TRACE, DEBUG, INFO, WARNING, ERROR or FATAL")
+	fs.StringVar(&f.Format, "log-format", f.Format, `logging format: "text", "json", or a template like "{time} {level} {message}"`)
+	fs.StringVar(&f.File, "log-file", f.File, "log file (default stderr)")
+	return f
+}
+
+// BasicConfig configures the logging as described by the flags, see BasicConfig.
+func (f *LogFlags) BasicConfig() error {
+	hc := HandlerConfig{Type: "stderr", Formatter: f.Format}
+	switch {
+	case f.Format == "" || f.Format == "text":
+		hc.Formatter, hc.Format = "template", "{time} {name<20} {level<8} {message}"
+	case f.Format == "json":
+		hc.Formatter = "ecs"
+	case strings.Contains(f.Format, "{"):
+		hc.Formatter, hc.Format = "template", f.Format
+	}
+	if len(f.File) > 0 {
+		hc.Type, hc.Filename = "file", f.File
+	}
+
+	handler, err := newConfiguredHandler(hc)
+	if err != nil {
+		return err
+	}
+	return BasicConfig(BasicConfigOpts{Level: f.Level, Handlers: []Handler{handler}})
+}
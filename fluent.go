@@ -0,0 +1,330 @@
+package log4go
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/kaizer666/log4go/internal/msgpack"
+)
+
+// FluentConfig configures a FluentHandler.
+type FluentConfig struct {
+	// Address is the host:port of the Fluentd/Fluent Bit forward input.
+	Address string
+	// TagPrefix is prepended to the logger names to build the tags (see FluentTag).
+	TagPrefix string
+	// SharedKey enables the shared key handshake (with Username and Password, if set).
+	SharedKey string
+	Username  string
+	Password  string
+	// SelfHostname is sent in the handshake (default: the host name).
+	SelfHostname string
+	// RequireAck makes the handler wait for the server's ack of each batch.
+	RequireAck bool
+	// BatchSize is the maximum number of records per batch (default 100).
+	BatchSize int
+	// FlushInterval is the maximum time records wait for their batch (default 1s).
+	FlushInterval time.Duration
+	// Timeout limits connecting, writing and waiting for acks (default 5s).
+	Timeout time.Duration
+	// QueueSize is the number of records buffered before Handle blocks (default 1000).
+	QueueSize int
+	// MaxRetries is the number of times a batch is resent before it's dropped (default 3).
+	MaxRetries int
+}
+
+// FluentHandler sends records to Fluentd (or Fluent Bit) using the forward protocol,
+// batching them per tag in PackedForward mode.
+//
+// With the default MsgpackFormatter the records are sent as structured maps, with any other
+// formatter the formatted text is sent as the record's message.
+type FluentHandler struct {
+	batchHandler
+
+	config  FluentConfig
+	batches map[string]*fluentBatch
+	pending int
+	conn    net.Conn // owned by the sender goroutine
+}
+
+// NewFluentHandler returns a new FluentHandler, connecting lazily to config.Address.
+func NewFluentHandler(config FluentConfig) (*FluentHandler, error) {
+	if len(config.Address) == 0 {
+		return nil, errors.New("log4go.FluentHandler: no address")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+	if len(config.SelfHostname) == 0 {
+		config.SelfHostname = hostname
+	}
+
+	h := &FluentHandler{
+		config:  config,
+		batches: make(map[string]*fluentBatch),
+	}
+	h.init(config.QueueSize, NewMsgpackFormatter())
+	h.run(h, config.FlushInterval)
+
+	return h, nil
+}
+
+var _ Handler = &FluentHandler{}
+
+// fluentBatch is the pending entries of a tag.
+type fluentBatch struct {
+	entries []byte
+	count   int
+	retries int
+	acks    []func(error) // see takeAck
+}
+
+func (h *FluentHandler) add(rec *Record) bool {
+	entry, err := h.entry(rec)
+	if err != nil {
+		if err != ErrorNotSet {
+			h.report(fmt.Errorf("formatter error: %w", err))
+			h.counters.countError(err)
+		}
+		return false
+	}
+
+	tag := FluentTag(h.config.TagPrefix, rec.Name)
+	batch := h.batches[tag]
+	if batch == nil {
+		batch = &fluentBatch{}
+		h.batches[tag] = batch
+	}
+	batch.entries = append(batch.entries, entry...)
+	batch.count++
+	if ack := takeAck(rec); ack != nil {
+		batch.acks = append(batch.acks, ack)
+	}
+	h.pending++
+	return h.pending >= h.config.BatchSize
+}
+
+func (h *FluentHandler) flush() {
+	for tag, batch := range h.batches {
+		if err := h.attempt(func() error { return h.send(tag, batch) }); err != nil {
+			batch.retries++
+			h.counters.countError(err)
+			if batch.retries <= h.config.MaxRetries {
+				continue // keep it for the next flush
+			}
+			h.counters.countDropped(batch.count)
+			h.report(&DroppedError{Count: batch.count, Err: err})
+			acknowledgeDropped(batch.acks, err)
+		} else {
+			h.counters.countHandled(batch.count)
+			acknowledge(batch.acks, nil)
+		}
+		h.pending -= batch.count
+		delete(h.batches, tag)
+	}
+}
+
+func (h *FluentHandler) close() {
+	h.closeConn()
+}
+
+// entry returns the record as a forward protocol entry, [time, record].
+func (h *FluentHandler) entry(rec *Record) ([]byte, error) {
+	b := msgpack.AppendArrayHeader(nil, 2)
+	b = msgpack.AppendEventTime(b, rec.Time)
+
+	formatter := h.Formatter()
+	if _, ok := formatter.(*MsgpackFormatter); ok || formatter == nil {
+		if rec.Level == NOTSET {
+			return nil, ErrorNotSet
+		}
+		return appendMsgpackRecord(b, rec, false), nil
+	}
+
+	msg, err := formatter.Format(rec)
+	if err != nil {
+		return nil, err
+	}
+	name := rec.Name
+	if len(name) == 0 {
+		name = "root"
+	}
+	return msgpack.Append(b, map[string]interface{}{
+		"level":   LevelName(rec.Level),
+		"logger":  name,
+		"message": string(msg),
+	}), nil
+}
+
+// send sends a batch in PackedForward mode: [tag, entries, option].
+func (h *FluentHandler) send(tag string, batch *fluentBatch) error {
+	if h.conn == nil {
+		if err := h.connect(); err != nil {
+			return err
+		}
+	}
+
+	option := map[string]interface{}{"size": batch.count}
+	var chunk string
+	if h.config.RequireAck {
+		id := make([]byte, 16)
+		_, _ = rand.Read(id)
+		chunk = base64.StdEncoding.EncodeToString(id)
+		option["chunk"] = chunk
+	}
+
+	msg := msgpack.AppendArrayHeader(nil, 3)
+	msg = msgpack.AppendString(msg, tag)
+	msg = msgpack.AppendBytes(msg, batch.entries)
+	msg = msgpack.Append(msg, option)
+
+	_ = h.conn.SetDeadline(time.Now().Add(h.config.Timeout))
+	if _, err := h.conn.Write(msg); err != nil {
+		h.closeConn()
+		return err
+	}
+
+	if h.config.RequireAck {
+		response, err := h.read()
+		if err != nil {
+			h.closeConn()
+			return err
+		}
+		if m, ok := response.(map[string]interface{}); !ok || m["ack"] != chunk {
+			h.closeConn()
+			return fmt.Errorf("unexpected ack: %v", response)
+		}
+	}
+	return nil
+}
+
+func (h *FluentHandler) connect() error {
+	conn, err := net.DialTimeout("tcp", h.config.Address, h.config.Timeout)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+
+	if len(h.config.SharedKey) > 0 {
+		if err = h.handshake(); err != nil {
+			h.closeConn()
+			return err
+		}
+	}
+	return nil
+}
+
+// handshake performs the shared key authentication: HELO, PING, PONG.
+func (h *FluentHandler) handshake() error {
+	_ = h.conn.SetDeadline(time.Now().Add(h.config.Timeout))
+
+	v, err := h.read()
+	if err != nil {
+		return err
+	}
+	helo, ok := v.([]interface{})
+	if !ok || len(helo) < 2 || helo[0] != "HELO" {
+		return fmt.Errorf("unexpected HELO: %v", v)
+	}
+	options, _ := helo[1].(map[string]interface{})
+	nonce := bytesValue(options["nonce"])
+	auth := bytesValue(options["auth"])
+
+	salt := make([]byte, 16)
+	_, _ = rand.Read(salt)
+
+	ping := msgpack.AppendArrayHeader(nil, 6)
+	ping = msgpack.AppendString(ping, "PING")
+	ping = msgpack.AppendString(ping, h.config.SelfHostname)
+	ping = msgpack.AppendBytes(ping, salt)
+	ping = msgpack.AppendString(ping, sha512Hex(salt, []byte(h.config.SelfHostname), nonce, []byte(h.config.SharedKey)))
+	if len(auth) > 0 {
+		ping = msgpack.AppendString(ping, h.config.Username)
+		ping = msgpack.AppendString(ping, sha512Hex(auth, []byte(h.config.Username), []byte(h.config.Password)))
+	} else {
+		ping = msgpack.AppendString(ping, "")
+		ping = msgpack.AppendString(ping, "")
+	}
+	if _, err = h.conn.Write(ping); err != nil {
+		return err
+	}
+
+	if v, err = h.read(); err != nil {
+		return err
+	}
+	pong, ok := v.([]interface{})
+	if !ok || len(pong) < 5 || pong[0] != "PONG" {
+		return fmt.Errorf("unexpected PONG: %v", v)
+	}
+	if authenticated, _ := pong[1].(bool); !authenticated {
+		return fmt.Errorf("authentication failed: %v", pong[2])
+	}
+	serverHostname, _ := pong[3].(string)
+	if pong[4] != sha512Hex(salt, []byte(serverHostname), nonce, []byte(h.config.SharedKey)) {
+		return errors.New("server's shared key digest mismatch")
+	}
+	return nil
+}
+
+// read reads a single MessagePack value from the connection.
+func (h *FluentHandler) read() (interface{}, error) {
+	var buf []byte
+	chunk := make([]byte, 512)
+	for {
+		n, err := h.conn.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if n > 0 {
+			v, _, decodeErr := msgpack.Decode(buf)
+			if decodeErr == nil {
+				return v, nil
+			}
+			if decodeErr != msgpack.ErrShortBuffer {
+				return nil, decodeErr
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (h *FluentHandler) closeConn() {
+	if h.conn != nil {
+		_ = h.conn.Close()
+		h.conn = nil
+	}
+}
+
+func bytesValue(v interface{}) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+	return nil
+}
+
+func sha512Hex(parts ...[]byte) string {
+	digest := sha512.Sum512(bytes.Join(parts, nil))
+	return hex.EncodeToString(digest[:])
+}
@@ -6,6 +6,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/kaizer666/log4go/color"
 )
@@ -18,6 +19,35 @@ type Formatter interface {
 	Format(rec *Record) ([]byte, error)
 }
 
+// AppendFormatter is implemented by formatters that can append the formatted record to a
+// buffer, letting the handlers reuse their buffers instead of allocating one per record.
+type AppendFormatter interface {
+	Formatter
+	// AppendFormat appends the formatted record to dst and returns the extended buffer.
+	AppendFormat(dst []byte, rec *Record) ([]byte, error)
+}
+
+// appendFormat appends the record formatted by f to dst if f is an AppendFormatter, else it
+// returns f.Format(rec).
+func appendFormat(dst []byte, f Formatter, rec *Record) ([]byte, error) {
+	if af, ok := f.(AppendFormatter); ok {
+		return af.AppendFormat(dst, rec)
+	}
+	return f.Format(rec)
+}
+
+// previousFormatter is implemented by formatters using the time of the previous record
+// handled by the same handler, which handlers then need to set.
+type previousFormatter interface {
+	usesPrevious() bool
+}
+
+// usesPrevious reports whether f uses the previous record's time.
+func usesPrevious(f Formatter) bool {
+	pf, ok := f.(previousFormatter)
+	return ok && pf.usesPrevious()
+}
+
 // TemplateFormatter is formatting based on a string template.
 type TemplateFormatter struct {
 	formatString            string
@@ -26,8 +56,26 @@ type TemplateFormatter struct {
 	patternColoringPatterns []PatternColor
 	patternColoring         map[string]string
 	processMessage          func(m, c string) string
+	fieldColoring           *FieldColoring
+	highlightRules          []highlightRule
+	multiline               MultilineMode
+	multilineMarker         string
 }
 
+// MultilineMode specifies how continuation lines of multi-line messages are formatted.
+type MultilineMode int
+
+const (
+	// MultilineAsIs outputs continuation lines unchanged (the default).
+	MultilineAsIs MultilineMode = iota
+	// MultilineRepeat formats every line with the full template (time, level, etc.).
+	MultilineRepeat
+	// MultilineIndent indents continuation lines to the message's column, followed by the marker.
+	MultilineIndent
+	// MultilinePrefix prefixes continuation lines with the marker.
+	MultilinePrefix
+)
+
 // PatternColor pairs a color and a match pattern.
 type PatternColor struct {
 	color   string
@@ -58,6 +106,15 @@ const (
 	tfBaseName
 	tfLevel
 	tfMessage
+	tfPID
+	tfHostname
+	tfGoroutineID
+	tfSeq
+	tfUptime
+	tfDelta
+	tfCaller
+	tfFields
+	tfMDC
 
 	tfFieldWidth      = 0x100 // width: 0 (auto) - 254
 	tfFieldWidthMask  = 0xff00
@@ -65,6 +122,12 @@ const (
 
 	tfAlignRight = 0x10000
 	tfAlignLeft  = 0 // i.e. the default
+	tfPadZero    = 0x20000
+
+	tfTokenMask = 0xff // the token value, without the modifiers below
+	tfShort     = 0x40000
+	tfLower     = 0x80000
+	tfUpper     = 0x100000
 )
 
 // TODO: or string->func(Record) string
@@ -75,9 +138,17 @@ var tokenToValue = map[string]int{
 	"basename": tfBaseName,
 	"level":    tfLevel,
 	"message":  tfMessage,
+	"pid":      tfPID,
+	"hostname": tfHostname,
+	"goid":     tfGoroutineID,
+	"seq":      tfSeq,
+	"uptime":   tfUptime, // since the process start, or the clock's time when set (see SetClock)
+	"delta":    tfDelta,  // since the StreamHandler's previous record, always +0.000 with other handlers
+	"caller":   tfCaller,
+	"fields":   tfFields,
+	"mdc":      tfMDC,
 }
 
-var templatePtn *regexp.Regexp
 var templateSpecPtn *regexp.Regexp
 
 var defaultLevelColoring map[Level]string
@@ -133,80 +204,259 @@ func (f *TemplateFormatter) EnablePatternColoring(enable bool) {
 	}
 }
 
-// SetPatternColoring sets the color map and the patterns using them (any pattern matching '[' must be first).
+// SetPatternColoring sets the color map and the patterns using them.
+//
+// A pattern's whole match is colored using the color named as the pattern; named capture
+// groups, e.g. `(?P<key>\w+)=(?P<value>\S+)`, are colored with the color of their name (if
+// present in the map). All patterns are combined in a single regexp, matches don't overlap
+// and the first pattern matching at a position wins.
 func (f *TemplateFormatter) SetPatternColoring(colors map[string]string, patterns []PatternColor) {
 	f.patternColoringPatterns = patterns
 	f.patternColoring = colors
 	f.processMessage = makeProcessor(f.patternColoring, f.patternColoringPatterns)
 }
 
+// patternRule is a pattern's part of the combined regexp built by makeProcessor.
+type patternRule struct {
+	group  int          // the combined regexp's group wrapping the whole pattern
+	color  string       // color of the whole match, may be empty
+	groups []groupColor // colored named groups, in order
+}
+
+type groupColor struct {
+	group int
+	color string
+}
+
+// makeProcessor returns the message processor coloring the patterns' matches, in a single pass
+// over the message: the patterns are combined in one regexp, so the colored spans don't overlap
+// and text already colored (or the colors' codes) is never matched again.
 func makeProcessor(colors map[string]string, patterns []PatternColor) func(m, c string) string {
+	parts := make([]string, 0, len(patterns))
+	rules := make([]patternRule, 0, len(patterns))
+	group := 0
+	for _, colPtn := range patterns {
+		rule := patternRule{group: group + 1, color: colors[colPtn.color]}
+		for idx, name := range colPtn.pattern.SubexpNames() {
+			if myColor, exists := colors[name]; exists && idx > 0 && len(name) > 0 {
+				rule.groups = append(rule.groups, groupColor{rule.group + idx, myColor})
+			}
+		}
+		if len(rule.color) == 0 && len(rule.groups) == 0 {
+			continue // nothing to color
+		}
+
+		parts = append(parts, "("+colPtn.pattern.String()+")")
+		rules = append(rules, rule)
+		group += 1 + colPtn.pattern.NumSubexp()
+	}
+	if len(rules) == 0 {
+		return defaultProcessMessage
+	}
+	combined := regexp.MustCompile(strings.Join(parts, "|"))
+
 	return func(m string, baseColor string) string {
-		repl := "$1" + baseColor
-		for _, colPtn := range patterns {
-			if myColor, exists := colors[colPtn.color]; exists {
-				m = colPtn.pattern.ReplaceAllString(m, myColor+repl)
+		if len(baseColor) == 0 {
+			baseColor = colorReset
+		}
+		matches := combined.FindAllStringSubmatchIndex(m, -1)
+		if len(matches) == 0 {
+			return m
+		}
+
+		var b strings.Builder
+		b.Grow(len(m) + len(matches)*(len(baseColor)+16))
+		last := 0
+		for _, loc := range matches {
+			for _, rule := range rules {
+				start, end := loc[2*rule.group], loc[2*rule.group+1]
+				if start < 0 {
+					continue // another pattern matched
+				}
+				b.WriteString(m[last:start])
+
+				pos := start
+				for _, g := range rule.groups {
+					gStart, gEnd := loc[2*g.group], loc[2*g.group+1]
+					if gStart < pos {
+						continue // group did not participate, or is nested in a previous one
+					}
+					writeColored(&b, rule.color, m[pos:gStart], baseColor)
+					writeColored(&b, g.color, m[gStart:gEnd], baseColor)
+					pos = gEnd
+				}
+				writeColored(&b, rule.color, m[pos:end], baseColor)
+
+				last = end
+				break
 			}
 		}
-		return m
+		b.WriteString(m[last:])
+		return b.String()
 	}
 }
 
+func writeColored(b *strings.Builder, color, s, baseColor string) {
+	if len(s) == 0 {
+		return
+	}
+	if len(color) == 0 {
+		b.WriteString(s)
+		return
+	}
+	b.WriteString(color)
+	b.WriteString(s)
+	b.WriteString(baseColor)
+}
+
+// SetMultiline sets how continuation lines of multi-line messages are formatted, marker is
+// used by MultilineIndent and MultilinePrefix (e.g. "| ").
+func (f *TemplateFormatter) SetMultiline(mode MultilineMode, marker string) {
+	f.multiline = mode
+	f.multilineMarker = marker
+}
+
 // SetFormat setts the formatters template string format.
+//
+// Tokens are enclosed in braces, e.g. "{time} {name<20} {message}"; use "{{" and "}}" for
+// literal braces. Errors report the byte offset of the offending part of the template.
 func (f *TemplateFormatter) SetFormat(template string) error {
-	if templatePtn == nil {
-		templatePtn, _ = regexp.Compile(`\{[^}]+\}`)
+	tokens, err := compileTemplate(template)
+	if err != nil {
+		return err
 	}
-	if templateSpecPtn == nil {
-		templateSpecPtn, _ = regexp.Compile(`^\{([^}]+?)(([<>])(\d+))?\}$`) // e.g. "{name<20}" - left align, max width 20
+
+	f.formatTokens = tokens
+	f.formatString = template
+
+	return nil
+}
+
+// Validate checks the template string format without changing the formatter.
+func (f *TemplateFormatter) Validate(template string) error {
+	_, err := compileTemplate(template)
+	return err
+}
+
+// Tokens returns the names of the tokens used by the formatters template, in order.
+func (f *TemplateFormatter) Tokens() []string {
+	names := make([]string, 0, len(f.formatTokens))
+	for _, token := range f.formatTokens {
+		if value, ok := token.(int); ok && value&tfFieldWidthMask == 0 {
+			for name, v := range tokenToValue {
+				if v == value&tfTokenMask {
+					names = append(names, name)
+					break
+				}
+			}
+		}
 	}
+	return names
+}
 
-	m := templatePtn.FindAllStringIndex(template, -1)
-	if len(m) == 0 {
-		return fmt.Errorf("invalid format template string: '%s'", template)
+// compileTemplate compiles the template string into a token list.
+func compileTemplate(template string) ([]interface{}, error) {
+	if templateSpecPtn == nil {
+		templateSpecPtn, _ = regexp.Compile(`^\{([^}]+?)(([<>])(\d+))?(:([^}]*))?\}$`) // e.g. "{name<20}" - left align, max width 20
 	}
 
-	// compile the template into a token list
 	var tokens []interface{}
-	last := 0
-	for _, tag := range m {
-		start, end := tag[0], tag[1]
-		if start > last {
-			// part before the token
-			tokens = append(tokens, template[last:start])
-		}
-		last = end
-
-		item := template[start:end]
-
-		spec := templateSpecPtn.FindStringSubmatch(item)
-		token := spec[1]
-		alignment := spec[3]
-		width := spec[4]
-		if len(alignment) > 0 && len(width) > 0 {
-			w, _ := strconv.Atoi(width)
-			if w > 0 {
-				if w > 254 {
-					w = 254
-				}
-				tokens = append(tokens, tfFieldWidth+(w-1)<<tfFieldWidthShift)
-				if alignment == ">" {
-					tokens = append(tokens, tfAlignRight)
-				}
+	var literal strings.Builder
+	found := false
+	for idx := 0; idx < len(template); idx++ {
+		c := template[idx]
+		escaped := idx+1 < len(template) && template[idx+1] == c
+		switch {
+		case (c == '{' || c == '}') && escaped:
+			literal.WriteByte(c)
+			idx++
+		case c == '}':
+			return nil, fmt.Errorf("invalid format template string: unmatched '}' at offset %d", idx)
+		case c == '{':
+			end := strings.IndexByte(template[idx:], '}')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid format template string: unterminated token at offset %d", idx)
+			}
+			if literal.Len() > 0 {
+				// part before the token
+				tokens = append(tokens, literal.String())
+				literal.Reset()
 			}
+
+			tokenTokens, err := parseTemplateToken(template[idx:idx+end+1], idx)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, tokenTokens...)
+			found = true
+			idx += end
+		default:
+			literal.WriteByte(c)
 		}
+	}
+	if literal.Len() > 0 {
+		tokens = append(tokens, literal.String())
+	}
+	if !found {
+		return nil, fmt.Errorf("invalid format template string: '%s'", template)
+	}
 
-		value, ok := tokenToValue[token]
-		if !ok {
-			return fmt.Errorf("unknown format template token: '%s'", token)
+	return tokens, nil
+}
+
+// parseTemplateToken compiles a single "{token}" found at the given offset of the template.
+func parseTemplateToken(item string, offset int) ([]interface{}, error) {
+	spec := templateSpecPtn.FindStringSubmatch(item)
+	if spec == nil {
+		return nil, fmt.Errorf("invalid format template token: '%s' at offset %d", item, offset)
+	}
+	token := spec[1]
+	alignment := spec[3]
+	width := spec[4]
+	modifier := spec[6]
+
+	var tokens []interface{}
+	widthToken := 0
+	if len(alignment) > 0 && len(width) > 0 {
+		w, _ := strconv.Atoi(width)
+		if w > 0 {
+			if w > 254 {
+				w = 254
+			}
+			widthToken = tfFieldWidth + (w-1)<<tfFieldWidthShift
+			if alignment == ">" {
+				widthToken |= tfAlignRight
+			}
 		}
+	}
 
-		tokens = append(tokens, value)
+	value, ok := tokenToValue[token]
+	if !ok {
+		return nil, fmt.Errorf("unknown format template token: '%s' at offset %d", token, offset)
 	}
 
-	f.formatTokens = tokens
+	if len(spec[5]) > 0 {
+		for _, mod := range strings.Split(modifier, ",") {
+			switch {
+			case mod == "0" && widthToken != 0: // e.g. "{seq>8:0}"
+				widthToken |= tfPadZero
+			case mod == "short" && value == tfLevel: // e.g. "INF"
+				value |= tfShort
+			case mod == "lower":
+				value |= tfLower
+			case mod == "upper":
+				value |= tfUpper
+			default:
+				return nil, fmt.Errorf("invalid format template modifier: '%s' at offset %d", spec[5], offset)
+			}
+		}
+	}
 
-	return nil
+	if widthToken != 0 {
+		tokens = append(tokens, widthToken)
+	}
+
+	return append(tokens, value), nil
 }
 
 // GetFormat returns the formatters template string.
@@ -216,100 +466,327 @@ func (f *TemplateFormatter) GetFormat() string {
 
 const colorReset = "\x1b[0m"
 
+var _ AppendFormatter = &TemplateFormatter{}
+
 // Format returns the record as a string.
 func (f *TemplateFormatter) Format(r *Record) ([]byte, error) {
 	if r.Level == NOTSET {
 		return []byte{}, ErrorNotSet
 	}
-	parts := make([]string, 0, 10)
+	return f.AppendFormat(nil, r)
+}
 
-	alignFmt := ""
-	width := 0
+// AppendFormat appends the formatted record to dst.
+func (f *TemplateFormatter) AppendFormat(dst []byte, r *Record) ([]byte, error) {
+	if r.Level == NOTSET {
+		return dst, ErrorNotSet
+	}
+
+	if f.multiline == MultilineAsIs || !strings.Contains(r.Message, "\n") {
+		dst, _ = f.appendRecord(dst, r, r.Message)
+		return dst, nil
+	}
 
-	colorSet := false
-	var lineColor string
-	if f.levelColoring[r.Level] != "" {
-		var exists bool
-		if lineColor, exists = f.levelColoring[r.Level]; exists {
-			parts = append(parts, lineColor)
-			colorSet = true
+	lines := strings.Split(r.Message, "\n")
+	out := make([]string, 0, len(lines))
+	first, messageStart := f.render(r, lines[0])
+	out = append(out, first)
+
+	var prefix string
+	switch f.multiline {
+	case MultilineIndent:
+		if messageStart >= 0 {
+			prefix = strings.Repeat(" ", visibleLen(first[:messageStart]))
+		}
+		prefix += f.multilineMarker
+	case MultilinePrefix:
+		prefix = f.multilineMarker
+	}
+
+	lineColor := f.lineColor(r)
+	for _, line := range lines[1:] {
+		if f.multiline == MultilineRepeat {
+			line, _ = f.render(r, line)
 		} else {
-			lineColor = "\x1b[0m"
+			line = prefix + f.processMessage(line, lineColor)
+			if len(lineColor) > 0 {
+				line = lineColor + line + colorReset
+			}
+		}
+		out = append(out, line)
+	}
+	for idx, line := range out {
+		if idx > 0 {
+			dst = append(dst, '\n')
+		}
+		dst = append(dst, line...)
+	}
+	return dst, nil
+}
+
+// usesPrevious reports whether the template has a {delta} token.
+func (f *TemplateFormatter) usesPrevious() bool {
+	for _, token := range f.formatTokens {
+		if value, ok := token.(int); ok && value&tfFieldWidthMask == 0 && value&tfTokenMask == tfDelta {
+			return true
 		}
 	}
+	return false
+}
+
+// render formats the record using message as its message, it also returns the byte offset
+// of the message in the result (-1 if the template has no {message}).
+func (f *TemplateFormatter) render(r *Record, message string) (string, int) {
+	line, messageStart := f.appendRecord(nil, r, message)
+	return string(line), messageStart
+}
+
+// appendRecord appends the record formatted using message as its message to dst, it also
+// returns the byte offset of the message in the appended part (-1 if the template has no
+// {message}).
+func (f *TemplateFormatter) appendRecord(dst []byte, r *Record, message string) ([]byte, int) {
+	start := len(dst)
+	messageStart := -1
+
+	fieldWidth := 0 // width token applying to the next field, if any
+
+	lineColor := f.lineColor(r)
+	colorSet := len(lineColor) > 0
+	if colorSet {
+		dst = append(dst, lineColor...)
+	}
 
 	var processedMessage string
 
 	for _, token := range f.formatTokens {
 		switch token := token.(type) {
 		case string:
-			parts = append(parts, token)
+			dst = append(dst, token...)
 		case int:
+			if token&tfFieldWidthMask > 0 {
+				fieldWidth = token
+				continue
+			}
+
+			// tokens without width nor case modifiers are appended directly when possible
+			plain := fieldWidth == 0 && token&(tfLower|tfUpper) == 0
+
 			s := ""
-			switch {
-			case token == tfTimeMilliseconds:
-				s = f.formatTime(r.Time, 1000)
-			case token == tfTime:
-				s = f.formatTime(r.Time)
-			case token == tfName:
+			switch token & tfTokenMask {
+			case tfTimeMilliseconds:
+				if plain {
+					dst = appendTime(dst, r.Time, true)
+					continue
+				}
+				s = string(appendTime(nil, r.Time, true))
+			case tfTime:
+				if plain {
+					dst = appendTime(dst, r.Time, false)
+					continue
+				}
+				s = string(appendTime(nil, r.Time, false))
+			case tfName:
 				if len(r.Name) == 0 {
 					s = "root"
 				} else {
 					s = r.Name
 				}
-			case token == tfBaseName:
+			case tfBaseName:
 				if len(r.Name) == 0 {
 					s = "root"
 				} else {
 					parts := strings.Split(r.Name, "/")
 					s = parts[len(parts)-1]
 				}
-			case token == tfLevel:
-				s = LevelName(r.Level)
-			case token == tfMessage:
+			case tfLevel:
+				if token&tfShort != 0 {
+					s = levelShortName(r.Level)
+				} else {
+					s = LevelName(r.Level)
+				}
+			case tfMessage:
 				if len(processedMessage) > 0 {
 					s = processedMessage
-				} else if len(r.Message) > 0 {
-					processedMessage = f.processMessage(r.Message, lineColor)
+				} else if len(message) > 0 {
+					processedMessage = f.processMessage(message, lineColor)
 					s = processedMessage
 				}
-			case token&tfFieldWidthMask > 0:
-				width = (token & tfFieldWidthMask) >> tfFieldWidthShift
-				if (token & tfAlignRight) > 0 {
-					alignFmt = fmt.Sprintf("%%%ds", width)
+				if messageStart < 0 {
+					messageStart = len(dst) - start
+				}
+			case tfPID:
+				s = processID
+			case tfHostname:
+				s = hostname
+			case tfGoroutineID:
+				if r.GoroutineID != 0 {
+					s = strconv.FormatUint(r.GoroutineID, 10)
+				} else {
+					s = "-"
+				}
+			case tfSeq:
+				if plain {
+					dst = strconv.AppendUint(dst, r.Seq, 10)
+					continue
+				}
+				s = strconv.FormatUint(r.Seq, 10)
+			case tfUptime:
+				s = formatSeconds(r.Time.Sub(startTime()))
+			case tfDelta:
+				if r.previous.IsZero() {
+					s = "+0.000"
+				} else {
+					s = "+" + formatSeconds(r.Time.Sub(r.previous))
+				}
+			case tfCaller:
+				if len(r.File) > 0 {
+					s = r.File + ":" + strconv.Itoa(r.Line)
 				} else {
-					alignFmt = fmt.Sprintf("%%-%ds", width)
+					s = "-"
+				}
+			case tfFields:
+				if plain {
+					dst = f.appendFields(dst, r, lineColor)
+					continue
+				}
+				s = string(f.appendFields(nil, r, lineColor))
+			case tfMDC:
+				if plain {
+					dst = appendMDC(dst, r.MDC)
+					continue
 				}
+				s = string(appendMDC(nil, r.MDC))
 			}
 
-			if len(s) > 0 {
-				if len(alignFmt) > 0 {
-					s = fmt.Sprintf(alignFmt, s)
-					if len(s) > width {
-						s = s[:width]
-					}
-
-					alignFmt = "" // field width used, reset it for next token
-					width = 0
-				}
+			switch {
+			case token&tfLower != 0:
+				s = strings.ToLower(s)
+			case token&tfUpper != 0:
+				s = strings.ToUpper(s)
+			}
 
-				parts = append(parts, s)
+			if fieldWidth != 0 {
+				s = alignField(s, fieldWidth)
+				fieldWidth = 0 // field width used, reset it for next token
 			}
+			dst = append(dst, s...)
 		}
 	}
 
 	if colorSet {
-		parts = append(parts, colorReset)
+		dst = append(dst, colorReset...)
 	}
 
-	return []byte(strings.Join(parts, "")), nil
+	return dst, messageStart
+}
+
+// formatSeconds formats d as seconds with millisecond precision, e.g. "12.345".
+func formatSeconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// visibleLen returns the number of runes in s, not counting ANSI sequences.
+func visibleLen(s string) int {
+	n := 0
+	for idx := 0; idx < len(s); {
+		if seq := ansiSequenceLen(s[idx:]); seq > 0 {
+			idx += seq
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[idx:])
+		idx += size
+		n++
+	}
+	return n
 }
 
-func (f *TemplateFormatter) formatTime(t time.Time, resolution ...int) string {
-	ts := fmt.Sprintf("%4d-%02d-%02d %02d:%02d:%02d", t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second())
+// alignField pads or truncates s to the width (and alignment) of the width token, counting
+// runes and ignoring ANSI sequences (which are kept when truncating).
+func alignField(s string, widthToken int) string {
+	width := (widthToken & tfFieldWidthMask) >> tfFieldWidthShift
+
+	visible := 0
+	for idx := 0; idx < len(s); {
+		if n := ansiSequenceLen(s[idx:]); n > 0 {
+			idx += n
+			continue
+		}
+		if visible == width { // truncate, but keep any following ANSI sequences
+			var b strings.Builder
+			b.WriteString(s[:idx])
+			for ; idx < len(s); idx++ {
+				if n := ansiSequenceLen(s[idx:]); n > 0 {
+					b.WriteString(s[idx : idx+n])
+					idx += n - 1
+				}
+			}
+			return b.String()
+		}
+		_, size := utf8.DecodeRuneInString(s[idx:])
+		idx += size
+		visible++
+	}
+	if visible == width {
+		return s
+	}
+
+	pad := strings.Repeat(" ", width-visible)
+	switch {
+	case widthToken&tfPadZero != 0:
+		return strings.Repeat("0", width-visible) + s
+	case widthToken&tfAlignRight != 0:
+		return pad + s
+	default:
+		return s + pad
+	}
+}
+
+// ansiSequenceLen returns the length of the ANSI CSI sequence s starts with, 0 if none.
+func ansiSequenceLen(s string) int {
+	if len(s) < 2 || s[0] != '\x1b' || s[1] != '[' {
+		return 0
+	}
+	for idx := 2; idx < len(s); idx++ {
+		if s[idx] >= 0x40 && s[idx] <= 0x7e {
+			return idx + 1
+		}
+	}
+	return len(s)
+}
+
+// appendTime appends t as "2006-01-02 15:04:05" (with ".000" milliseconds if millis) to dst.
+func appendTime(dst []byte, t time.Time, millis bool) []byte {
+	year, month, day := t.Date()
+	hour, min, sec := t.Clock()
+	dst = appendDigits(dst, year, 4)
+	dst = append(dst, '-')
+	dst = appendDigits(dst, int(month), 2)
+	dst = append(dst, '-')
+	dst = appendDigits(dst, day, 2)
+	dst = append(dst, ' ')
+	dst = appendDigits(dst, hour, 2)
+	dst = append(dst, ':')
+	dst = appendDigits(dst, min, 2)
+	dst = append(dst, ':')
+	dst = appendDigits(dst, sec, 2)
+	if millis {
+		dst = append(dst, '.')
+		dst = appendDigits(dst, t.Nanosecond()/1e6, 3)
+	}
+	return dst
+}
 
-	if len(resolution) == 1 && resolution[0] == 1000 {
-		ts = fmt.Sprintf("%s.%03d", ts, t.Nanosecond()/1e6)
+// appendDigits appends the non-negative n to dst, zero padded to width digits.
+func appendDigits(dst []byte, n int, width int) []byte {
+	var buf [20]byte
+	idx := len(buf)
+	for n >= 10 || width > 1 {
+		idx--
+		buf[idx] = byte('0' + n%10)
+		n /= 10
+		width--
 	}
-	return ts
+	idx--
+	buf[idx] = byte('0' + n)
+	return append(dst, buf[idx:]...)
 }
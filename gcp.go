@@ -0,0 +1,334 @@
+package log4go
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaizer666/log4go/internal/gcpauth"
+)
+
+// GCPLoggingScope is the OAuth2 scope needed to write log entries.
+const GCPLoggingScope = "https://www.googleapis.com/auth/logging.write"
+
+// GCPConfig configures a GCPHandler.
+type GCPConfig struct {
+	// ProjectID is the Google Cloud project (default: $GOOGLE_CLOUD_PROJECT, the credentials'
+	// project, then the metadata server's).
+	ProjectID string
+	// LogID is the log name within the project (default: the program's name).
+	LogID string
+	// ResourceType is the monitored resource type (default "global") and ResourceLabels its
+	// labels, e.g. {"project_id": ..., "location": ...} for "generic_node".
+	ResourceType   string
+	ResourceLabels map[string]string
+	// Labels are added to all entries.
+	Labels map[string]string
+	// CredentialsFile is a service account key file (default: $GOOGLE_APPLICATION_CREDENTIALS,
+	// then the metadata server's default service account).
+	CredentialsFile string
+	// Endpoint overrides the entries.write URL.
+	Endpoint string
+	// BatchSize is the maximum number of entries per write (default 500).
+	BatchSize int
+	// FlushInterval is the maximum time entries wait for their batch (default 5s).
+	FlushInterval time.Duration
+	// Timeout limits each API call (default 10s).
+	Timeout time.Duration
+	// QueueSize is the number of records buffered before Handle blocks (default 1000).
+	QueueSize int
+	// MaxRetries is the number of times a batch is resent before it's dropped (default 3).
+	MaxRetries int
+	// HTTPClient is the client used for the API calls (default: http.DefaultClient).
+	HTTPClient *http.Client
+}
+
+// GCPHandler writes records to Google Cloud Logging as structured (jsonPayload) entries.
+//
+// The levels are mapped to Cloud Logging severities, the message is the formatted record (the
+// plain message by default) and the fields are added to the payload, except "trace_id",
+// "span_id" and "trace_sampled" which set the entry's trace correlation (see TraceFields).
+type GCPHandler struct {
+	batchHandler
+
+	config  GCPConfig
+	tokens  *gcpauth.TokenSource
+	logName string
+	entries []map[string]interface{}
+	retries int
+	lastErr error
+	acks    []func(error) // of the pending records, see takeAck
+}
+
+// GCPEntriesWriteURL is the default GCPConfig.Endpoint.
+const GCPEntriesWriteURL = "https://logging.googleapis.com/v2/entries:write"
+
+// NewGCPHandler returns a new GCPHandler.
+func NewGCPHandler(config GCPConfig) (*GCPHandler, error) {
+	if config.HTTPClient == nil {
+		config.HTTPClient = http.DefaultClient
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+
+	tokens, projectID, err := gcpauth.NewTokenSource(config.HTTPClient, GCPLoggingScope, config.CredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(config.ProjectID) == 0 {
+		config.ProjectID = os.Getenv("GOOGLE_CLOUD_PROJECT")
+	}
+	if len(config.ProjectID) == 0 {
+		config.ProjectID = projectID
+	}
+	if len(config.ProjectID) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), config.Timeout)
+		config.ProjectID, err = gcpauth.ProjectID(ctx, config.HTTPClient)
+		cancel()
+		if err != nil {
+			return nil, fmt.Errorf("log4go.GCPHandler: no project ID: %v", err)
+		}
+	}
+	if len(config.LogID) == 0 {
+		config.LogID = filepath.Base(os.Args[0])
+	}
+	if len(config.ResourceType) == 0 {
+		config.ResourceType = "global"
+	}
+	if len(config.Endpoint) == 0 {
+		config.Endpoint = GCPEntriesWriteURL
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 500
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+
+	formatter, err := NewTemplateFormatter("{message}")
+	if err != nil {
+		return nil, err
+	}
+
+	h := &GCPHandler{
+		config:  config,
+		tokens:  tokens,
+		logName: "projects/" + config.ProjectID + "/logs/" + url.PathEscape(config.LogID),
+	}
+	h.init(config.QueueSize, formatter)
+	h.run(h, config.FlushInterval)
+
+	return h, nil
+}
+
+var _ Handler = &GCPHandler{}
+
+var levelToGCPSeverity = map[Level]string{
+	TRACE:   "DEBUG",
+	DEBUG:   "DEBUG",
+	INFO:    "INFO",
+	WARNING: "WARNING",
+	ERROR:   "ERROR",
+	FATAL:   "CRITICAL",
+}
+
+// gcpSeverity returns the Cloud Logging severity of the level.
+func gcpSeverity(level Level) string {
+	if severity, ok := levelToGCPSeverity[level]; ok {
+		return severity
+	}
+	if level > FATAL {
+		return "ALERT"
+	}
+	return "DEFAULT"
+}
+
+func (h *GCPHandler) add(rec *Record) bool {
+	msg, err := h.Formatter().Format(rec)
+	if err != nil {
+		if err != ErrorNotSet {
+			h.report(fmt.Errorf("formatter error: %w", err))
+			h.counters.countError(err)
+		}
+		return false
+	}
+
+	name := rec.Name
+	if len(name) == 0 {
+		name = "root"
+	}
+	fields := rec.allFields()
+	payload := make(map[string]interface{}, 2+len(fields))
+	entry := map[string]interface{}{
+		"timestamp":   rec.Time.UTC().Format(time.RFC3339Nano),
+		"severity":    gcpSeverity(rec.Level),
+		"jsonPayload": payload,
+	}
+	for key, value := range fields {
+		switch key {
+		case "trace_id":
+			entry["trace"] = "projects/" + h.config.ProjectID + "/traces/" + fmt.Sprint(value)
+		case "span_id":
+			entry["spanId"] = fmt.Sprint(value)
+		case "trace_sampled":
+			sampled, _ := value.(bool)
+			entry["traceSampled"] = sampled
+		default:
+			if e, ok := value.(error); ok {
+				value = e.Error()
+			}
+			payload[key] = value
+		}
+	}
+	payload["message"] = string(msg)
+	payload["logger"] = name
+
+	if len(h.entries) >= h.config.BatchSize {
+		h.flush()
+		if len(h.entries) != 0 {
+			h.drop() // the flush failed, and there's no room left to keep retrying
+		}
+	}
+	h.entries = append(h.entries, entry)
+	if ack := takeAck(rec); ack != nil {
+		h.acks = append(h.acks, ack)
+	}
+	return len(h.entries) >= h.config.BatchSize
+}
+
+func (h *GCPHandler) flush() {
+	if len(h.entries) == 0 {
+		return
+	}
+	if err := h.attempt(h.write); err != nil {
+		h.lastErr = err
+		h.retries++
+		h.counters.countError(err)
+		if h.retries <= h.config.MaxRetries {
+			return // keep them for the next flush
+		}
+		h.drop()
+		return
+	}
+	h.counters.countHandled(len(h.entries))
+	acknowledge(h.acks, nil)
+	h.reset()
+}
+
+func (h *GCPHandler) drop() {
+	h.counters.countDropped(len(h.entries))
+	h.report(&DroppedError{Count: len(h.entries), Err: h.lastErr})
+	acknowledgeDropped(h.acks, h.lastErr)
+	h.reset()
+}
+
+func (h *GCPHandler) reset() {
+	h.acks = h.acks[:0]
+	h.entries = h.entries[:0]
+	h.retries = 0
+	h.lastErr = nil
+}
+
+func (h *GCPHandler) close() {}
+
+// write sends the pending entries with entries.write.
+func (h *GCPHandler) write() error {
+	resource := map[string]interface{}{"type": h.config.ResourceType}
+	if len(h.config.ResourceLabels) != 0 {
+		resource["labels"] = h.config.ResourceLabels
+	}
+	request := map[string]interface{}{
+		"logName":        h.logName,
+		"resource":       resource,
+		"entries":        h.entries,
+		"partialSuccess": true,
+	}
+	if len(h.config.Labels) != 0 {
+		request["labels"] = h.config.Labels
+	}
+	body, err := marshalJSON(request)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+	defer cancel()
+
+	token, err := h.tokens.Token(ctx)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, h.config.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := h.config.HTTPClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("entries.write: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+		if resp.StatusCode == http.StatusBadRequest {
+			// invalid entries won't get any better, partialSuccess wrote the others
+			h.report(err)
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// TraceFields returns the trace correlation fields ("trace_id", "span_id" and "trace_sampled")
+// of an incoming request, from its W3C traceparent or X-Cloud-Trace-Context header, or nil if
+// it has neither.
+func TraceFields(header http.Header) Fields {
+	// traceparent: 00-<32 hex trace id>-<16 hex span id>-<2 hex flags>
+	if parts := strings.Split(header.Get("traceparent"), "-"); len(parts) == 4 && len(parts[1]) == 32 && len(parts[2]) == 16 {
+		flags, _ := strconv.ParseUint(parts[3], 16, 8)
+		return Fields{"trace_id": parts[1], "span_id": parts[2], "trace_sampled": flags&1 != 0}
+	}
+
+	// X-Cloud-Trace-Context: <trace id>/<decimal span id>;o=<sampled>
+	value := header.Get("X-Cloud-Trace-Context")
+	if len(value) == 0 {
+		return nil
+	}
+	value, options := splitOnce(value, ";")
+	traceID, spanID := splitOnce(value, "/")
+	if len(traceID) == 0 {
+		return nil
+	}
+	fields := Fields{"trace_id": traceID, "trace_sampled": options == "o=1"}
+	if id, err := strconv.ParseUint(spanID, 10, 64); err == nil {
+		fields["span_id"] = fmt.Sprintf("%016x", id)
+	}
+	return fields
+}
+
+func splitOnce(s, sep string) (string, string) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):]
+	}
+	return s, ""
+}
@@ -0,0 +1,47 @@
+// Package ginlog provides log4go request logging and recovery middlewares for gin, to use
+// instead of gin.Logger and gin.Recovery.
+package ginlog
+
+import (
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaizer666/log4go"
+)
+
+// Logger returns a middleware logging the requests with rl (see log4go.RequestLogger); the
+// requests' contexts carry their request-scoped logger (see log4go.FromContext).
+func Logger(rl *log4go.RequestLogger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		info := rl.NewRequestInfo(c.Request)
+		c.Header(rl.RequestIDHeader(), info.RequestID)
+		c.Request = c.Request.WithContext(log4go.NewContext(c.Request.Context(), rl.RequestScoped(info)))
+
+		c.Next()
+
+		info.Status = c.Writer.Status()
+		if size := c.Writer.Size(); size > 0 {
+			info.Size = int64(size)
+		}
+		info.Latency = time.Now().Sub(start)
+		rl.Log(info)
+	}
+}
+
+// Recovery returns a middleware recovering the panics of the handlers, logging them with
+// their stack trace at ERROR level to the request-scoped logger (or the root logger), and
+// responding 500 Internal Server Error.
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			if v := recover(); v != nil {
+				log4go.FromContext(c.Request.Context()).Error("PANIC: %v\n%s", v, debug.Stack())
+				c.AbortWithStatus(http.StatusInternalServerError)
+			}
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,61 @@
+package ginlog
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kaizer666/log4go"
+)
+
+func TestMiddlewares(t *testing.T) {
+	log4go.BasicConfig(log4go.BasicConfigOpts{Level: log4go.INFO, Writer: ioutil.Discard})
+	defer log4go.Shutdown()
+	logger := log4go.GetLogger("gin")
+	ring, _ := log4go.NewRingHandler(10)
+	formatter, _ := log4go.NewTemplateFormatter("{level} {message}")
+	ring.SetFormatter(formatter)
+	logger.ReplaceHandlers(ring)
+	rl, err := log4go.NewRequestLogger(log4go.RequestLogOptions{Logger: logger, Format: "{method} {path} {status} {size}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	router := gin.New()
+	router.Use(Logger(rl), Recovery())
+	router.GET("/hello", func(c *gin.Context) {
+		log4go.FromContext(c.Request.Context()).Info("handling")
+		c.String(http.StatusOK, "hello")
+	})
+	router.GET("/panic", func(c *gin.Context) {
+		panic("boom")
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/hello", nil))
+	if len(w.Header().Get("X-Request-Id")) == 0 {
+		t.Error("no request ID header")
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status: %d", w.Code)
+	}
+
+	records := ring.Records()
+	if len(records) != 4 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if records[0].Message != "handling" || records[0].Fields["path"] != "/hello" {
+		t.Errorf("unexpected record: %+v", records[0])
+	}
+	if records[1].Message != "GET /hello 200 5" {
+		t.Errorf("unexpected record: %q", records[1].Message)
+	}
+	if records[2].Level != log4go.ERROR || records[3].Message != "GET /panic 500 0" {
+		t.Errorf("unexpected records: %v %q", records[2].Level, records[3].Message)
+	}
+}
@@ -1,3 +1,8 @@
 module github.com/kaizer666/log4go
 
 go 1.13
+
+require (
+	github.com/fsnotify/fsnotify v1.7.0
+	golang.org/x/net v0.17.0
+)
@@ -0,0 +1,323 @@
+package log4go
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/kaizer666/log4go/internal/protowire"
+	"golang.org/x/net/http2"
+)
+
+// GRPCExportMethod is the gRPC method records are streamed to (see proto/log4go.proto).
+const GRPCExportMethod = "/log4go.v1.LogCollector/Export"
+
+// GRPCConfig configures a GRPCHandler.
+type GRPCConfig struct {
+	// Address is the collector's host:port.
+	Address string
+	// TLSConfig, if set, secures the connection, which is plaintext (h2c) otherwise.
+	TLSConfig *tls.Config
+	// Metadata is sent as the stream's request headers, e.g. {"authorization": "Bearer ..."}.
+	Metadata map[string]string
+	// BatchSize is the maximum number of records written to the stream at once (default 100).
+	BatchSize int
+	// FlushInterval is the maximum time records wait for their batch (default 1s).
+	FlushInterval time.Duration
+	// Timeout limits connecting, and waiting for the collector's summary on shutdown (default 5s).
+	Timeout time.Duration
+	// QueueSize is the number of records buffered before Handle blocks (default 1000); when
+	// the collector applies backpressure (HTTP/2 flow control) the queue fills up.
+	QueueSize int
+	// MaxRetries is the number of times a batch is written again, on a new stream, before
+	// it's dropped (default 3).
+	MaxRetries int
+}
+
+// GRPCHandler streams records to a collector over a gRPC client stream (the LogCollector
+// service of proto/log4go.proto), reopening the stream when it breaks.
+type GRPCHandler struct {
+	batchHandler
+
+	config  GRPCConfig
+	client  *http.Client
+	url     string
+	pending []byte // framed messages
+	count   int
+	retries int
+	lastErr error
+	acks    []func(error) // of the pending records, see takeAck
+
+	stream *grpcStream // owned by the sender goroutine
+}
+
+// grpcStream is an open Export call.
+type grpcStream struct {
+	w    *io.PipeWriter
+	done chan error // receives the call's result
+}
+
+// NewGRPCHandler returns a new GRPCHandler, opening the stream lazily.
+func NewGRPCHandler(config GRPCConfig) (*GRPCHandler, error) {
+	if len(config.Address) == 0 {
+		return nil, errors.New("log4go.GRPCHandler: no address")
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+
+	client, scheme := newGRPCClient(config.TLSConfig, config.Timeout)
+
+	formatter, err := NewTemplateFormatter("{message}")
+	if err != nil {
+		return nil, err
+	}
+
+	h := &GRPCHandler{
+		config: config,
+		client: client,
+		url:    (&url.URL{Scheme: scheme, Host: config.Address, Path: GRPCExportMethod}).String(),
+	}
+	h.init(config.QueueSize, formatter)
+	h.run(h, config.FlushInterval)
+
+	return h, nil
+}
+
+var _ Handler = &GRPCHandler{}
+
+func (h *GRPCHandler) add(rec *Record) bool {
+	msg, err := h.Formatter().Format(rec)
+	if err != nil {
+		if err != ErrorNotSet {
+			h.report(fmt.Errorf("formatter error: %w", err))
+			h.counters.countError(err)
+		}
+		return false
+	}
+
+	if h.count >= h.config.BatchSize {
+		h.flush()
+		if h.count != 0 {
+			h.drop() // the flush failed, and there's no room left to keep retrying
+		}
+	}
+
+	message := appendGRPCRecord(nil, rec, msg)
+	h.pending = append(h.pending, 0) // not compressed
+	h.pending = append(h.pending, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(h.pending[len(h.pending)-4:], uint32(len(message)))
+	h.pending = append(h.pending, message...)
+	h.count++
+	if ack := takeAck(rec); ack != nil {
+		h.acks = append(h.acks, ack)
+	}
+	return h.count >= h.config.BatchSize
+}
+
+// appendGRPCRecord appends the record as a log4go.v1.Record message.
+func appendGRPCRecord(b []byte, rec *Record, msg []byte) []byte {
+	name := rec.Name
+	if len(name) == 0 {
+		name = "root"
+	}
+	b = protowire.AppendFixed64(b, 1, uint64(rec.Time.UnixNano()))
+	b = protowire.AppendString(b, 2, name)
+	b = protowire.AppendInt(b, 3, int64(rec.Level))
+	b = protowire.AppendString(b, 4, LevelName(rec.Level))
+	b = protowire.AppendString(b, 5, string(msg))
+
+	fields := rec.allFields()
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var entry []byte
+		entry = protowire.AppendString(entry, 1, key)
+		entry = protowire.AppendString(entry, 2, fmt.Sprint(fields[key]))
+		b = protowire.AppendBytes(b, 6, entry)
+	}
+
+	b = protowire.AppendUint(b, 7, rec.Seq)
+	b = protowire.AppendUint(b, 8, rec.GoroutineID)
+	b = protowire.AppendString(b, 9, hostname)
+	return protowire.AppendInt(b, 10, int64(pid))
+}
+
+func (h *GRPCHandler) flush() {
+	if h.count == 0 {
+		return
+	}
+	if err := h.attempt(h.write); err != nil {
+		h.closeStream()
+		h.lastErr = err
+		h.retries++
+		h.counters.countError(err)
+		if h.retries <= h.config.MaxRetries {
+			return // keep them for the next flush
+		}
+		h.drop()
+		return
+	}
+	h.counters.countHandled(h.count)
+	acknowledge(h.acks, nil)
+	h.reset()
+}
+
+func (h *GRPCHandler) drop() {
+	h.counters.countDropped(h.count)
+	h.report(&DroppedError{Count: h.count, Err: h.lastErr})
+	acknowledgeDropped(h.acks, h.lastErr)
+	h.reset()
+}
+
+func (h *GRPCHandler) reset() {
+	h.acks = h.acks[:0]
+	h.pending = h.pending[:0]
+	h.count = 0
+	h.retries = 0
+	h.lastErr = nil
+}
+
+// close ends the stream and waits for the collector's response.
+func (h *GRPCHandler) close() {
+	if h.stream == nil {
+		return
+	}
+	_ = h.stream.w.Close()
+	select {
+	case err := <-h.stream.done:
+		if err != nil {
+			h.report(err)
+		}
+	case <-time.After(h.config.Timeout):
+		h.report(errors.New("no response from the collector"))
+	}
+	h.stream = nil
+}
+
+// write writes the pending messages to the stream, opening it if needed; it blocks while
+// the collector applies backpressure.
+func (h *GRPCHandler) write() error {
+	if h.stream == nil {
+		h.open()
+	}
+	_, err := h.stream.w.Write(h.pending)
+	return err
+}
+
+// open starts an Export call, the request body being fed by the returned stream's writer.
+func (h *GRPCHandler) open() {
+	r, w := io.Pipe()
+	stream := &grpcStream{w: w, done: make(chan error, 1)}
+	h.stream = stream
+
+	req, _ := http.NewRequest(http.MethodPost, h.url, r)
+	req.Header.Set("Content-Type", "application/grpc+proto")
+	req.Header.Set("TE", "trailers")
+	for key, value := range h.config.Metadata {
+		req.Header.Set(key, value)
+	}
+
+	go func() {
+		err := h.call(req)
+		// fail the pending and next writes (no-op if the stream was closed by us)
+		_ = r.CloseWithError(err)
+		if err == errGRPCStreamEnded {
+			err = nil
+		}
+		stream.done <- err
+	}()
+}
+
+// newGRPCClient returns an HTTP/2 client for gRPC calls, and the URL scheme to use: with
+// TLS if tlsConfig is set, plaintext (h2c) otherwise.
+func newGRPCClient(tlsConfig *tls.Config, timeout time.Duration) (*http.Client, string) {
+	transport := &http2.Transport{TLSClientConfig: tlsConfig}
+	if tlsConfig != nil {
+		return &http.Client{Transport: transport}, "https"
+	}
+	transport.AllowHTTP = true
+	transport.DialTLS = func(network, address string, _ *tls.Config) (net.Conn, error) {
+		return net.DialTimeout(network, address, timeout)
+	}
+	return &http.Client{Transport: transport}, "http"
+}
+
+// grpcStatusError returns the error of a gRPC response's status (from the trailers, or the
+// headers for trailers-only responses), nil if OK. It must be called after reading the body.
+func grpcStatusError(resp *http.Response) error {
+	status := resp.Trailer.Get("Grpc-Status")
+	message := resp.Trailer.Get("Grpc-Message")
+	if len(status) == 0 {
+		status = resp.Header.Get("Grpc-Status")
+		message = resp.Header.Get("Grpc-Message")
+	}
+	if code, _ := strconv.Atoi(status); code != 0 || len(status) == 0 {
+		return &grpcError{code: code, message: message}
+	}
+	return nil
+}
+
+// grpcError is a gRPC status error.
+type grpcError struct {
+	code    int
+	message string
+}
+
+func (e *grpcError) Error() string {
+	return fmt.Sprintf("gRPC status %d: %s", e.code, e.message)
+}
+
+// errGRPCStreamEnded is returned by call when the stream ended normally.
+var errGRPCStreamEnded = errors.New("stream ended")
+
+// call performs the Export call, returning errGRPCStreamEnded if it succeeded.
+func (h *GRPCHandler) call(req *http.Request) error {
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// the summary, then the trailers
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	if err = grpcStatusError(resp); err != nil {
+		return err
+	}
+	return errGRPCStreamEnded
+}
+
+func (h *GRPCHandler) closeStream() {
+	if h.stream != nil {
+		_ = h.stream.w.CloseWithError(context.Canceled)
+		h.stream = nil
+	}
+}
@@ -1,10 +1,20 @@
 package log4go
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/kaizer666/log4go/color"
 )
 
 // Handler handles the formatted log events.
@@ -19,21 +29,56 @@ type Handler interface {
 
 // StreamHandler handles stream-based output.
 type StreamHandler struct {
+	counters        handlerCounters // first, for 64-bit alignment
+	batchDelay      int64           // time.Duration, see SetBatching, accessed atomically
+	shutdownTimeout int64           // time.Duration, see SetShutdownTimeout, accessed atomically
+	writeStart      int64           // UnixNano of the current write, 0 if none; accessed atomically
+
 	Writer          io.Writer
 	StreamFormatter Formatter
-	LogLevel        Level
-	CommitChannel   chan Record
+	CommitChannel   chan *Record
 	CommitterStop   chan struct{}
 	StreamShutdown  bool
+
+	level       int32   // Level, accessed atomically
+	stripANSI   int32   // accessed atomically
+	nonBlocking int32   // see SetBlocking, accessed atomically
+	preWrite    func()  // called by the committer before each write
+	self        Handler // the handler reporting errors, when embedded
+
+	writerFor func(rec *Record) io.Writer // if set, selects the writer of each record
+
+	truncation atomic.Value // *truncation, see SetMaxLength
+
+	batchBytes int32              // see SetBatching, accessed atomically
+	calls      chan committerCall // see call
+	reopen     func() error       // reopens the file, for file handlers; called by the committer
+	file       *guardedFile       // the file of the file handlers, see GuardDiskUsage
+	diskFull   int32              // drop the records, see GuardDiskUsage; accessed atomically
+
+	watchdog     atomic.Value // chan struct{} stopping the stall watchdog, see SetStallWatchdog
+	stallOptions atomic.Value // *StallOptions
+	stalled      int32        // the current write is stalled; accessed atomically
+
+	buffering atomic.Value // *BufferOptions, see SetBuffering
+	buffer    *fileBuffer  // owned by the committer
+
+	mu            sync.RWMutex // guards StreamShutdown vs. sending to CommitChannel
+	stopOnce      sync.Once
+	stopping      chan struct{} // closed when shutdown begins, releases blocked Handle calls
+	committerDone chan struct{} // closed when the committer has returned
 }
 
 // NewStreamHandler returns a new StreamHandler instance using the specified writer.
 func NewStreamHandler(w io.Writer) (*StreamHandler, error) {
 	handler := &StreamHandler{
 		Writer:         w,
-		CommitChannel:  make(chan Record, 100),
+		CommitChannel:  make(chan *Record, 100),
 		CommitterStop:  make(chan struct{}),
 		StreamShutdown: false,
+		stopping:       make(chan struct{}),
+		committerDone:  make(chan struct{}),
+		calls:          make(chan committerCall),
 	}
 
 	go handler.committer()
@@ -43,87 +88,578 @@ func NewStreamHandler(w io.Writer) (*StreamHandler, error) {
 
 // NewFileHandler returns a new StreamHandler instance writing to the specified file name.
 func NewFileHandler(filename string, append bool, writeStartHeader bool) (*StreamHandler, error) {
-	flags := os.O_WRONLY | os.O_CREATE
-	if append {
-		flags |= os.O_APPEND
-	} else {
+	return NewFileHandlerWithOptions(filename, FileOptions{Append: append, WriteStartHeader: writeStartHeader})
+}
+
+// FileOptions configures how file handlers open their file.
+type FileOptions struct {
+	// Append appends to an existing file, which is truncated otherwise.
+	Append bool
+	// WriteStartHeader writes a "START LOGS" line once the file is opened.
+	WriteStartHeader bool
+	// Mode is the permissions of a created file (default 0664, before the umask).
+	Mode os.FileMode
+	// CreateDirs creates the missing parent directories, with DirMode (default 0775).
+	CreateDirs bool
+	DirMode    os.FileMode
+	// Exclusive fails if the file already exists (O_EXCL); re-opening it doesn't.
+	Exclusive bool
+	// Chown changes the file's owner to UID and GID (-1 keeping either unchanged).
+	Chown    bool
+	UID, GID int
+	// EncryptionKey, if set, encrypts the file with AES-GCM (see NewEncryptingWriter).
+	EncryptionKey []byte
+	// DateDirs, if set, writes the file in date-derived directories inserted before its base
+	// name, created as needed (see CreateDirs), and rolls over to new ones as the date changes:
+	// "{year}/{month}/{day}" writes "logs/app.log" in e.g. "logs/2024/05/01/app.log". The
+	// tokens are {year}, {month}, {day}, {hour}, and {isoyear} and {week} for ISO weeks.
+	DateDirs string
+}
+
+// NewFileHandlerWithOptions returns a new StreamHandler instance writing to the specified
+// file name, opened according to options.
+func NewFileHandlerWithOptions(filename string, options FileOptions) (*StreamHandler, error) {
+	name := filename
+	if len(options.DateDirs) > 0 {
+		if err := checkDateDirs(options.DateDirs); err != nil {
+			return nil, err
+		}
+		options.CreateDirs = true
+		name = datedFilename(filename, options.DateDirs, now())
+	}
+	fp, err := openFile(name, options)
+	if err != nil {
+		return nil, err
+	}
+	writer, err := fileWriter(fp, options)
+	if err != nil {
+		_ = fp.Close()
+		return nil, err
+	}
+	if options.WriteStartHeader {
+		_, _ = writer.Write([]byte("START LOGS\n"))
+	}
+	h, err := NewStreamHandler(writer)
+	if err != nil {
+		return nil, err
+	}
+	options = reopenOptions(options)
+	h.reopen = func() error {
+		if s, ok := h.Writer.(interface{ Sync() error }); ok {
+			_ = s.Sync()
+		}
+		if c, ok := h.Writer.(io.Closer); ok {
+			_ = c.Close()
+		}
+		h.Writer = ioutil.Discard
+
+		fp, err := openFile(name, options)
+		if err != nil {
+			return err
+		}
+		h.Writer, _ = fileWriter(fp, options) // the key was checked already
+		return nil
+	}
+	if len(options.DateDirs) > 0 {
+		h.preWrite = func() { h.rollDateDirs(&name, filename, options.DateDirs) }
+	}
+	guardFile(h, filename, options.DateDirs, name)
+	return h, nil
+}
+
+// fileWriter returns the writer of a file handler's file, encrypting it if options say so.
+func fileWriter(fp *os.File, options FileOptions) (io.Writer, error) {
+	if len(options.EncryptionKey) == 0 {
+		return fp, nil
+	}
+	return NewEncryptingWriter(fp, options.EncryptionKey)
+}
+
+// openFile opens a file handler's file. It is written in append mode even when truncated, so
+// that a handler replacing another on the same file (see Configure) doesn't overwrite it.
+func openFile(filename string, options FileOptions) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if !options.Append {
 		flags |= os.O_TRUNC
 	}
+	if options.Exclusive {
+		flags |= os.O_EXCL
+	}
+	mode := options.Mode
+	if mode == 0 {
+		mode = 0664
+	}
+
+	if options.CreateDirs {
+		dirMode := options.DirMode
+		if dirMode == 0 {
+			dirMode = 0775
+		}
+		if err := os.MkdirAll(filepath.Dir(filename), dirMode); err != nil {
+			return nil, err
+		}
+	}
 
-	writer, err := os.OpenFile(filename, flags, 0664)
+	fp, err := os.OpenFile(filename, flags, mode)
 	if err != nil {
 		return nil, err
 	}
-	if writeStartHeader {
-		_, _ = writer.WriteString("START LOGS\n")
+	if options.Chown {
+		if err = fp.Chown(options.UID, options.GID); err != nil {
+			_ = fp.Close()
+			return nil, err
+		}
 	}
-	return NewStreamHandler(writer)
+	return fp, nil
+}
+
+// reopenOptions returns the options re-opening a file: it mustn't be truncated (nor fail
+// because it exists), and gets no new header.
+func reopenOptions(options FileOptions) FileOptions {
+	options.Append = true
+	options.Exclusive = false
+	options.WriteStartHeader = false
+	return options
 }
 
-// SetLevel sets the level the handler will (at least) handle.
+// SetLevel sets the level the handler will (at least) handle, safe for concurrent use.
 func (h *StreamHandler) SetLevel(level Level) {
-	h.LogLevel = level
+	atomic.StoreInt32(&h.level, int32(level))
 }
 
 // Level returns the level previously set (or NOTSET if not set).
 func (h *StreamHandler) Level() Level {
-	return h.LogLevel
+	return Level(atomic.LoadInt32(&h.level))
 }
 
-// Handle handles the formatted message.
+// SetStripANSI makes the handler remove ANSI sequences (colors) from the formatted records,
+// so a coloring formatter can be shared with handlers writing e.g. to files.
+func (h *StreamHandler) SetStripANSI(strip bool) {
+	var value int32
+	if strip {
+		value = 1
+	}
+	atomic.StoreInt32(&h.stripANSI, value)
+}
+
+// SetBlocking sets what Handle does when the CommitChannel is full, i.e. when the records are
+// logged faster than they are written: block until the committer makes room (the default, no
+// record is lost but logging waits for the writer), or drop the record, counting it (see
+// Stats), so that logging never waits.
+func (h *StreamHandler) SetBlocking(block bool) {
+	var value int32
+	if !block {
+		value = 1
+	}
+	atomic.StoreInt32(&h.nonBlocking, value)
+}
+
+// Handle queues the record for the committer, see SetBlocking.
 func (h *StreamHandler) Handle(rec *Record) error {
-	if !h.StreamShutdown { // TODO: should use mutex (to avoid writing to closed channel)
-		h.CommitChannel <- *rec
+	return h.handle(rec, nil)
+}
+
+// HandleAck queues the record like Handle, and calls ack once it's written: with nil once
+// the writer took it (once the buffer is flushed, and synced unless the policy is SyncNever,
+// when buffering, see SetBuffering), else with the error, e.g. ErrDropped.
+func (h *StreamHandler) HandleAck(rec *Record, ack func(error)) error {
+	return h.handle(rec, ack)
+}
+
+// handle queues the record, with its ack if not nil.
+func (h *StreamHandler) handle(rec *Record, ack func(error)) error {
+	if rec.Level < h.Level() {
+		if ack != nil {
+			ack(nil) // nothing to write
+		}
+		return nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if atomic.LoadInt32(&h.diskFull) != 0 {
+		h.dropped(ack)
+		return nil
+	}
+	if atomic.LoadInt32(&h.stalled) != 0 && h.handleStalled(rec, ack) {
+		return nil
+	}
+	if !h.StreamShutdown {
+		var r *Record
+		if ack == nil {
+			r = rec.retain()
+		} else {
+			c := *rec // not shared with the other handlers, unlike the pooled records
+			c.pool = nil
+			c.ack = ack
+			r = &c
+		}
+		if atomic.LoadInt32(&h.nonBlocking) != 0 {
+			select {
+			case h.CommitChannel <- r:
+			default:
+				r.release()
+				h.dropped(ack)
+			}
+			return nil
+		}
+		select {
+		case h.CommitChannel <- r:
+		case <-h.stopping:
+			r.release()
+			h.dropped(ack)
+		}
+	} else {
+		h.dropped(ack)
 	}
 	return nil
 }
 
-// Shutdown shuts down the handler.
+// dropped counts a record dropped, calling its ack if not nil.
+func (h *StreamHandler) dropped(ack func(error)) {
+	h.counters.countDropped(1)
+	if ack != nil {
+		ack(ErrDropped)
+	}
+}
+
+// Shutdown shuts down the handler once the queued records have been written, waiting for
+// them at most the shutdown timeout if set (see SetShutdownTimeout and ShutdownContext).
 func (h *StreamHandler) Shutdown() {
-	if !h.StreamShutdown {
-		h.StreamShutdown = true
-		h.CommitterStop <- struct{}{} // unbuffered; when this returns the committer has stopped
+	ctx, cancel := h.shutdownContext()
+	defer cancel()
+	_ = h.ShutdownContext(ctx)
+}
 
-		close(h.CommitterStop)
-		close(h.CommitChannel) // TODO: see Handle() or never close this channel?
+// shutdownContext returns the context of Shutdown, done after the shutdown timeout if set.
+func (h *StreamHandler) shutdownContext() (context.Context, context.CancelFunc) {
+	if timeout := time.Duration(atomic.LoadInt64(&h.shutdownTimeout)); timeout > 0 {
+		return context.WithTimeout(context.Background(), timeout)
 	}
+	return context.WithCancel(context.Background())
+}
+
+// SetShutdownTimeout sets how long Shutdown waits for the queued records to be written, e.g.
+// when the writer may hang; zero (the default) waits for all of them.
+func (h *StreamHandler) SetShutdownTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&h.shutdownTimeout, int64(timeout))
 }
 
-func (h *StreamHandler) onPreWrite() {
-	// default does nothing
+// ShutdownContext shuts down the handler once all queued records have been written (and the
+// writer synced, if it has a Sync method), or returns ctx.Err() if ctx is done before that.
+func (h *StreamHandler) ShutdownContext(ctx context.Context) error {
+	if h.beginShutdown() {
+		unguardFile(h)
+		close(h.CommitChannel) // the committer drains what's left, then returns
+	}
+
+	select {
+	case <-h.committerDone:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	if s, ok := h.Writer.(interface{ Sync() error }); ok {
+		_ = s.Sync()
+	}
+	return nil
+}
+
+// beginShutdown marks the handler as shut down, it returns false if it already was.
+// When it returns true no Handle call is (or will be) sending to CommitChannel.
+func (h *StreamHandler) beginShutdown() bool {
+	h.stopOnce.Do(func() { close(h.stopping) })
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.StreamShutdown {
+		return false
+	}
+	h.StreamShutdown = true
+	return true
 }
 
 func (h *StreamHandler) committer() {
+	defer close(h.committerDone)
+
+	var c commit
+	var batchTimer, bufferTimer committerTimer
+	stop := h.CommitterStop
 	for {
 		select {
-		case rec := <-h.CommitChannel:
-			msg, err := h.Formatter().Format(&rec)
-			if err != nil {
-				if err == ErrorNotSet {
-					continue
-				}
-				_, _ = fmt.Fprintf(os.Stderr, "log4go.StreamHandler: formatter error %v\n", err)
-				continue
+		case rec, ok := <-h.CommitChannel:
+			if !ok {
+				h.flushBatch(&c)
+				h.flushBuffer(true)
+				return
 			}
+			h.commit(&c, rec)
+
+		case <-batchTimer.C:
+			batchTimer.fired()
+			h.flushBatch(&c)
+
+		case <-bufferTimer.C:
+			bufferTimer.fired()
+			h.flushBuffer(true)
+
+		case call := <-h.calls:
+			call.done <- call.f(&c)
+
+		case _, ok := <-stop:
+			if !ok {
+				stop = nil // closed, don't spin on it
+			}
+			h.flushBatch(&c)
+			h.flushBuffer(true)
+		}
+
+		if len(c.batch) == 0 {
+			batchTimer.stop()
+		} else {
+			batchTimer.start(time.Duration(atomic.LoadInt64(&h.batchDelay)))
+		}
+		if h.bufferPending() {
+			bufferTimer.start(h.buffer.options.FlushInterval)
+		} else {
+			bufferTimer.stop()
+		}
+	}
+}
+
+// SetBatching makes the committer accumulate the formatted records and write them at once,
+// when they reach maxBytes or the oldest one has waited for maxDelay (default 100ms).
+// A zero maxBytes (the default) writes each record on its own.
+func (h *StreamHandler) SetBatching(maxBytes int, maxDelay time.Duration) {
+	if maxDelay <= 0 {
+		maxDelay = 100 * time.Millisecond
+	}
+	atomic.StoreInt64(&h.batchDelay, int64(maxDelay))
+	atomic.StoreInt32(&h.batchBytes, int32(maxBytes))
+}
+
+// Flush writes the queued, batched and buffered records now (see SetBatching and
+// SetBuffering), syncing the file unless its policy is SyncNever, and returns the write error
+// if any.
+func (h *StreamHandler) Flush() error {
+	return h.call(h.flush)
+}
+
+// flush writes the queued, batched and buffered records; called by the committer.
+func (h *StreamHandler) flush(c *commit) error {
+	for n := len(h.CommitChannel); n > 0; n-- { // the records handled before the call
+		if rec, ok := <-h.CommitChannel; ok {
+			h.commit(c, rec)
+		}
+	}
+	err := h.flushBatch(c)
+	if berr := h.flushBuffer(true); err == nil {
+		err = berr
+	}
+	return err
+}
 
-			msg = append(msg, '\n')
+// Reopen writes the queued records, then closes and reopens the file of a file handler (see
+// NewFileHandler and NewWatchedFileHandler), appending to it, e.g. once logrotate has moved it.
+func (h *StreamHandler) Reopen() error {
+	if h.reopen == nil {
+		return errors.New("not a file handler")
+	}
+	return h.call(func(c *commit) error {
+		_ = h.flush(c) // errors reported already
+		h.buffer = nil
+		return h.reopen()
+	})
+}
+
+// committerCall is a function run by the committer, see call.
+type committerCall struct {
+	f    func(c *commit) error
+	done chan error
+}
 
-			h.onPreWrite()
+// call runs f in the committer and returns its error, or nil if the handler is shut down.
+func (h *StreamHandler) call(f func(c *commit) error) error {
+	call := committerCall{f: f, done: make(chan error, 1)}
+	select {
+	case h.calls <- call:
+		return <-call.done
+	case <-h.committerDone:
+		return nil
+	}
+}
 
-			if _, err = h.Writer.Write(msg); err != nil {
-				_, _ = fmt.Fprintf(os.Stderr, "log4go.StreamHandler: write error: %v\n", err)
+// commit is the committer's state.
+type commit struct {
+	previous time.Time
+	buf      []byte // formatting buffer, reused with AppendFormatters
+	local    Record // copy of the records whose formatter needs the previous record's time
+
+	batch       []byte        // see SetBatching
+	batchCount  int           // number of records in batch
+	batchAcks   []func(error) // acks of the records in batch
+	batchWriter io.Writer     // the writer of the batch when selected by writerFor
+}
+
+// maxCommitBuffer is the capacity above which the committer's formatting buffer isn't kept.
+const maxCommitBuffer = 64 * 1024
+
+// commit formats and writes a queued record, then releases it.
+func (h *StreamHandler) commit(c *commit, rec *Record) {
+	ack := rec.ack
+	formatter := h.Formatter()
+	r := rec
+	if usesPrevious(formatter) {
+		// the record may be shared with other handlers, don't modify it
+		c.local = *rec
+		c.local.previous = c.previous
+		r = &c.local
+	}
+	c.previous = rec.Time
+
+	msg, err := appendFormat(c.buf[:0], formatter, r)
+	if err != nil {
+		rec.release()
+		if err != ErrorNotSet {
+			h.report(fmt.Errorf("formatter error: %w", err))
+			h.counters.countError(err)
+		}
+		if ack != nil {
+			ack(err)
+		}
+		return
+	}
+
+	if !isRawFormatter(formatter) {
+		if atomic.LoadInt32(&h.stripANSI) != 0 {
+			msg = color.Strip(msg)
+		}
+		msg = h.truncate(msg)
+		msg = append(msg, '\n')
+	}
+
+	if maxBytes := int(atomic.LoadInt32(&h.batchBytes)); maxBytes > 0 {
+		var w io.Writer
+		if h.writerFor != nil {
+			if w = h.writerFor(rec); w != c.batchWriter {
+				h.flushBatch(c)
 			}
+		}
+		rec.release()
+
+		c.batch = append(c.batch, msg...)
+		c.batchCount++
+		c.batchWriter = w
+		if ack != nil {
+			c.batchAcks = append(c.batchAcks, ack)
+		}
+		if len(c.batch) >= maxBytes {
+			h.flushBatch(c)
+		}
+	} else {
+		if h.preWrite != nil {
+			h.preWrite()
+		}
+		w := h.Writer
+		if h.writerFor != nil {
+			w = h.writerFor(rec)
+		}
+		rec.release()
 
-		case <-h.CommitterStop:
-			break
+		var acks []func(error)
+		if ack != nil {
+			acks = []func(error){ack}
 		}
+		h.write(w, msg, 1, acks)
+	}
+
+	if _, ok := formatter.(AppendFormatter); ok && cap(msg) <= maxCommitBuffer {
+		c.buf = msg[:0]
 	}
 }
 
+// flushBatch writes the batched records, if any.
+func (h *StreamHandler) flushBatch(c *commit) error {
+	if c.batchCount == 0 {
+		return nil
+	}
+	if h.preWrite != nil {
+		h.preWrite()
+	}
+	w := c.batchWriter
+	if w == nil {
+		w = h.Writer // selected now, preWrite may have reopened it
+	}
+	err := h.write(w, c.batch, c.batchCount, c.batchAcks)
+
+	if cap(c.batch) <= maxCommitBuffer {
+		c.batch = c.batch[:0]
+	} else {
+		c.batch = nil
+	}
+	c.batchCount = 0
+	c.batchWriter = nil
+	c.batchAcks = c.batchAcks[:0]
+	return err
+}
+
+// write writes the formatted records to w, and counts them; their acks are called once
+// written, when the buffer is flushed if w is buffered.
+func (h *StreamHandler) write(w io.Writer, msg []byte, records int, acks []func(error)) error {
+	w = h.bufferedWriter(w)
+	start := time.Now()
+	h.writeStarted(start)
+	_, err := w.Write(msg)
+	h.writeDone()
+	h.counters.countWrite(time.Since(start))
+	if err != nil {
+		h.report(fmt.Errorf("write error: %w", err))
+		h.counters.countError(err)
+		h.counters.countDropped(records)
+	} else {
+		h.counters.countHandled(records)
+	}
+	if err == nil && h.buffer != nil && w == io.Writer(h.buffer.bw) {
+		h.buffer.acks = append(h.buffer.acks, acks...)
+	} else {
+		acknowledge(acks, err)
+	}
+	h.written(w, err)
+	return err
+}
+
+// report reports an error to the ErrorHandler.
+func (h *StreamHandler) report(err error) {
+	if h.self != nil {
+		reportError(h.self, err)
+	} else {
+		reportError(h, err)
+	}
+}
+
+func (h *StreamHandler) handlerStats() HandlerStats {
+	return h.counters.read(len(h.CommitChannel), cap(h.CommitChannel))
+}
+
+// Stats returns a snapshot of the handler's counters (as ReadStats does): the records queued
+// (in asynchronous mode), written, dropped, and the errors with the last one's time, e.g. for
+// health checks to verify that the handler keeps up.
+func (h *StreamHandler) Stats() HandlerStats {
+	stats := h.handlerStats()
+	if h.self != nil {
+		stats.Name = handlerName(h.self)
+	} else {
+		stats.Name = handlerName(h)
+	}
+	return stats
+}
+
 // SetFormatter sets the handler's Formatter.
 func (h *StreamHandler) SetFormatter(formatter Formatter) {
 	if formatter == nil {
-		_, _ = fmt.Fprintln(os.Stderr, "log4go.StreamHandler: setting nil formatter")
+		h.report(errors.New("setting nil formatter"))
 	}
 
 	h.StreamFormatter = formatter
@@ -135,90 +671,219 @@ func (h *StreamHandler) Formatter() Formatter {
 }
 
 // WatchedFileHandler watches the log file: if file is moved the filename is re-opened.
+//
+// Moves and removals are detected through fsnotify events on the file's directory; when no
+// watcher can be set up (or events are lost, e.g. on network file systems) the file is
+// stat'ed at most once every stat interval (see SetStatInterval).
 type WatchedFileHandler struct {
+	statInterval int64 // time.Duration, see SetStatInterval, accessed atomically
+
 	*StreamHandler
 
 	fp       *os.File // we want to use Sync()
 	filename string
-	append   bool
+	options  FileOptions
 	inode    uint64
 	dev      uint64
+
+	watcher  *fsnotify.Watcher
+	moved    int32 // set (atomically) by the watcher goroutine
+	lastStat time.Time
+
+	releaseOnce sync.Once
 }
 
+// DefaultStatInterval is the default minimum time between two fallback stat calls of a
+// WatchedFileHandler, see SetStatInterval.
+const DefaultStatInterval = time.Second
+
 // NewWatchedFileHandler returns a new WatchedFileHandler instance writing to the specified file name.
 func NewWatchedFileHandler(filename string, append bool, writeStartHeader bool) (*WatchedFileHandler, error) {
+	return NewWatchedFileHandlerWithOptions(filename, FileOptions{Append: append, WriteStartHeader: writeStartHeader})
+}
+
+// NewWatchedFileHandlerWithOptions returns a new WatchedFileHandler instance writing to the
+// specified file name, opened (and re-opened) according to options.
+func NewWatchedFileHandlerWithOptions(filename string, options FileOptions) (*WatchedFileHandler, error) {
+	if len(options.DateDirs) > 0 {
+		return nil, errors.New("date dirs not supported by watched files")
+	}
 	wfh := &WatchedFileHandler{
-		filename: filename,
-		append:   append,
+		statInterval: int64(DefaultStatInterval),
+		filename:     filename,
+		options:      options,
 	}
 	err := wfh.open()
-	if  err != nil {
+	if err != nil {
 		return nil, err
 	}
-	if writeStartHeader {
-		_, err = wfh.StreamHandler.Writer.Write([]byte("START LOGS\n"))
+	writer, err := fileWriter(wfh.fp, options)
+	if err != nil {
+		_ = wfh.fp.Close()
+		return nil, err
+	}
+	if options.WriteStartHeader {
+		_, err = writer.Write([]byte("START LOGS\n"))
 		if err != nil {
+			_ = wfh.fp.Close()
 			return nil, err
 		}
 	}
+	wfh.options = reopenOptions(options)
+
+	wfh.watch()
+
+	s, err := NewStreamHandler(writer)
+	if err != nil {
+		wfh.close()
+		return nil, err
+	}
+	s.preWrite = wfh.onPreWrite
+	s.reopen = wfh.reopen
+	s.self = wfh
+	wfh.StreamHandler = s
+	guardFile(s, filename, "", filename)
 
 	return wfh, nil
 }
 
+// Shutdown shuts down the handler, stops watching and closes the file, waiting for the queued
+// records at most the shutdown timeout if set (see SetShutdownTimeout).
+func (h *WatchedFileHandler) Shutdown() {
+	ctx, cancel := h.shutdownContext()
+	defer cancel()
+	_ = h.ShutdownContext(ctx)
+}
+
+// ShutdownContext shuts down the handler once all queued records have been written, then
+// stops watching and closes the file.
+func (h *WatchedFileHandler) ShutdownContext(ctx context.Context) error {
+	if err := h.StreamHandler.ShutdownContext(ctx); err != nil {
+		return err // the committer may still be writing, leave the file open
+	}
+	h.release()
+	return nil
+}
+
+func (h *WatchedFileHandler) release() {
+	h.releaseOnce.Do(func() {
+		if h.watcher != nil {
+			_ = h.watcher.Close()
+		}
+		h.close()
+	})
+}
+
 // called when committer is about to write a message
 func (h *WatchedFileHandler) onPreWrite() {
 	if h.fileHasMoved() {
 		// just re-open, with same filename
-		h.close()
-		_ = h.open()
-		h.Writer = h.fp
+		h.flushBuffer(true)
+		h.buffer = nil
+		if err := h.reopen(); err != nil {
+			h.report(fmt.Errorf("re-open error: %w", err))
+		}
 	}
 }
 
-func (h WatchedFileHandler) fileHasMoved() bool {
-	// TODO: use fsnotify to detect when the file has moved?
+// reopen closes and reopens the file; called by the committer.
+func (h *WatchedFileHandler) reopen() error {
+	h.close()
+	if err := h.open(); err != nil {
+		h.Writer = ioutil.Discard
+		return err
+	}
+	h.Writer, _ = fileWriter(h.fp, h.options) // the key was checked already
+	return nil
+}
+
+// SetStatInterval sets the minimum time between two fallback stat calls (default
+// DefaultStatInterval), safe for concurrent use.
+func (h *WatchedFileHandler) SetStatInterval(interval time.Duration) {
+	atomic.StoreInt64(&h.statInterval, int64(interval))
+}
+
+// StatInterval returns the minimum time between two fallback stat calls.
+func (h *WatchedFileHandler) StatInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.statInterval))
+}
+
+func (h *WatchedFileHandler) fileHasMoved() bool {
+	if atomic.SwapInt32(&h.moved, 0) != 0 {
+		return true
+	}
+
+	// fallback: stat the file now and then
+	now := time.Now()
+	if now.Sub(h.lastStat) < h.StatInterval() {
+		return false
+	}
+	h.lastStat = now
 
 	dev, ino := h.statFile()
 	// in case statFile() returns (0, 0) this will return true also
 	return dev != h.dev || ino != h.inode
 }
 
+// watch starts watching the file's directory (the file itself can't be watched across renames).
+func (h *WatchedFileHandler) watch() {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return // stat fallback only
+	}
+	if err = watcher.Add(filepath.Dir(h.filename)); err != nil {
+		_ = watcher.Close()
+		return
+	}
+	h.watcher = watcher
+
+	name := filepath.Clean(h.filename)
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) == name && event.Op&(fsnotify.Rename|fsnotify.Remove) != 0 {
+					atomic.StoreInt32(&h.moved, 1)
+				}
+			case _, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				// events may have been lost, the stat fallback will catch up
+			}
+		}
+	}()
+}
+
 func (h *WatchedFileHandler) close() {
 	if h.fp != nil {
 		_ = h.fp.Sync()
 		_ = h.fp.Close()
 		h.fp = nil
-		h.Writer = nil
 	}
 }
 
 func (h *WatchedFileHandler) open() error {
-	flags := os.O_WRONLY | os.O_CREATE
-	if h.append {
-		flags |= os.O_APPEND
-	} else {
-		flags |= os.O_TRUNC
-	}
-
-	fp, err := os.OpenFile(h.filename, flags, 0664)
+	fp, err := openFile(h.filename, h.options)
 	if err != nil {
 		return err
 	}
-	s, err := NewStreamHandler(h.fp)
-	if err != nil {
-		return err
-	}
-
-	h.StreamHandler = s
-	h.Writer = fp
+	h.fp = fp
 
 	h.dev, h.inode = h.statFile()
+	h.lastStat = time.Now()
 
 	return nil
 }
 
-func (h WatchedFileHandler) statFile() (uint64, uint64) {
-	info, _ := os.Stat(h.filename)
+func (h *WatchedFileHandler) statFile() (uint64, uint64) {
+	info, err := os.Stat(h.filename)
+	if err != nil {
+		return 0, 0
+	}
 	stat, ok := info.Sys().(*syscall.Stat_t)
 	if !ok {
 		return 0, 0
@@ -0,0 +1,121 @@
+package log4go
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// HealthStatus is the status of a handler, or of the logging subsystem, see Health.
+type HealthStatus string
+
+// Health statuses, from best to worst.
+const (
+	HealthOK       HealthStatus = "ok"
+	HealthDegraded HealthStatus = "degraded"
+	HealthFailed   HealthStatus = "failed"
+)
+
+// HealthErrorWindow is how long after an error a handler is degraded, see Health.
+var HealthErrorWindow = time.Minute
+
+// HealthReport is the status of the logging subsystem, see Health.
+type HealthReport struct {
+	// Status is the worst of the handlers' statuses, ok if there are none.
+	Status HealthStatus `json:"status"`
+	// Handlers are the (unique) handlers of all loggers keeping statistics.
+	Handlers []HandlerHealth `json:"handlers"`
+}
+
+// HandlerHealth is the status of a handler.
+type HandlerHealth struct {
+	// Name identifies the handler, see HandlerStats.Name.
+	Name   string       `json:"name"`
+	Status HealthStatus `json:"status"`
+	// Reason explains a status other than ok.
+	Reason string `json:"reason,omitempty"`
+	// LastError is the last error's message and LastErrorTime when it occurred, if any.
+	LastError     string     `json:"last_error,omitempty"`
+	LastErrorTime *time.Time `json:"last_error_time,omitempty"`
+	// QueueDepth and QueueCapacity are the number of queued records and the queue's size.
+	QueueDepth    int `json:"queue_depth"`
+	QueueCapacity int `json:"queue_capacity"`
+}
+
+// healthHandler is implemented by the handlers knowing of their failures.
+type healthHandler interface {
+	// failure returns why the handler doesn't write its records, "" if it does.
+	failure() string
+}
+
+// failure reports a handler shut down, stalled (see SetStallWatchdog) or dropping its records
+// over the disk budget (see GuardDiskUsage).
+func (h *StreamHandler) failure() string {
+	switch {
+	case atomic.LoadInt32(&h.stalled) != 0:
+		return "writer stalled"
+	case atomic.LoadInt32(&h.diskFull) != 0:
+		return "disk budget exceeded"
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if h.StreamShutdown {
+		return "shut down"
+	}
+	return ""
+}
+
+// Health returns the status of the handlers of all loggers, e.g. for a /healthz endpoint (see
+// HealthHandler): a handler is failed when it doesn't write its records (shut down, stalled,
+// or over the disk budget), degraded when it had an error within the HealthErrorWindow or
+// its queue is at least 90% full, else ok.
+func Health() HealthReport {
+	handlers, stats := readHandlerStats(loadAllHandlers())
+	report := HealthReport{Status: HealthOK, Handlers: make([]HandlerHealth, 0, len(handlers))}
+	for idx, h := range handlers {
+		hs := stats[idx]
+		health := HandlerHealth{
+			Name:          hs.Name,
+			Status:        HealthOK,
+			LastError:     hs.LastError,
+			QueueDepth:    hs.QueueDepth,
+			QueueCapacity: hs.QueueCapacity,
+		}
+		if !hs.LastErrorTime.IsZero() {
+			health.LastErrorTime = &hs.LastErrorTime
+		}
+
+		var failure string
+		if hh, ok := h.(healthHandler); ok {
+			failure = hh.failure()
+		}
+		switch {
+		case len(failure) > 0:
+			health.Status, health.Reason = HealthFailed, failure
+		case !hs.LastErrorTime.IsZero() && now().Sub(hs.LastErrorTime) < HealthErrorWindow:
+			health.Status, health.Reason = HealthDegraded, "recent error"
+		case hs.QueueCapacity > 0 && hs.QueueDepth*10 >= hs.QueueCapacity*9:
+			health.Status, health.Reason = HealthDegraded, "queue full"
+		}
+
+		if health.Status == HealthFailed || (health.Status == HealthDegraded && report.Status == HealthOK) {
+			report.Status = health.Status
+		}
+		report.Handlers = append(report.Handlers, health)
+	}
+	return report
+}
+
+// HealthHandler returns an http.Handler serving the Health report as JSON, with a 503 status
+// when failed.
+func HealthHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := Health()
+		w.Header().Set("Content-Type", "application/json")
+		if report.Status == HealthFailed {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		_ = json.NewEncoder(w).Encode(report)
+	})
+}
@@ -0,0 +1,222 @@
+package log4go
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaizer666/log4go/color"
+)
+
+// HighlightRule colors the records matching its condition: their whole line, or the value of
+// one of their fields written by the {fields} token. In JSON (see HandlerConfig.Highlight):
+//
+//	{"when": "duration > 1s", "field": "duration", "color": "red"}
+type HighlightRule struct {
+	// When is the condition: comparisons "<operand> <op> <value>" joined by "&&", where the
+	// operand is "level" or a field's key and op is ==, !=, <, <=, >, >= or ~ (the value is
+	// a regexp), e.g. "level >= ERROR && user == bob"; a key alone tests the field is present.
+	// Values can be quoted, and are compared as levels, durations, numbers or strings.
+	When string `json:"when"`
+	// Field is the field whose value is colored, empty to color the whole line.
+	Field string `json:"field"`
+	// Color is a color name or code (see color.ByName), or an escape sequence, e.g. color.Red.
+	Color string `json:"color"`
+}
+
+// highlightRule is a compiled HighlightRule.
+type highlightRule struct {
+	conditions []condition
+	field      string
+	color      string
+}
+
+// condition is a comparison of a HighlightRule's condition.
+type condition struct {
+	key string // "level" for the record's level
+	op  string // empty to test the field is present
+
+	value    string
+	level    Level
+	number   float64
+	isNumber bool
+	duration time.Duration
+	isDur    bool
+	pattern  *regexp.Regexp
+}
+
+var conditionPtn = regexp.MustCompile(`^([\w.\-/]+)\s*(?:(==|!=|<=|>=|<|>|~)\s*(.*))?$`)
+
+// SetHighlightRules sets the highlight rules, the first rule matching a record (or a field)
+// colors it, overriding the level and field colors; nil to disable.
+func (f *TemplateFormatter) SetHighlightRules(rules []HighlightRule) error {
+	compiled := make([]highlightRule, 0, len(rules))
+	for idx, rule := range rules {
+		hr, err := compileHighlightRule(rule)
+		if err != nil {
+			return fmt.Errorf("highlight rule %d: %w", idx, err)
+		}
+		compiled = append(compiled, hr)
+	}
+	if len(compiled) == 0 {
+		compiled = nil
+	}
+	f.highlightRules = compiled
+	return nil
+}
+
+func compileHighlightRule(rule HighlightRule) (highlightRule, error) {
+	hr := highlightRule{field: rule.Field, color: color.ByName(rule.Color)}
+	if len(hr.color) == 0 {
+		if !strings.HasPrefix(rule.Color, "\x1b[") {
+			return hr, fmt.Errorf("unknown color %q", rule.Color)
+		}
+		hr.color = rule.Color
+	}
+
+	for _, part := range strings.Split(rule.When, "&&") {
+		spec := conditionPtn.FindStringSubmatch(strings.TrimSpace(part))
+		if spec == nil {
+			return hr, fmt.Errorf("invalid condition %q", strings.TrimSpace(part))
+		}
+		c := condition{key: spec[1], op: spec[2], value: strings.TrimSpace(spec[3])}
+		if len(c.op) > 0 && len(c.value) == 0 {
+			return hr, fmt.Errorf("invalid condition %q: no value", strings.TrimSpace(part))
+		}
+		if unquoted, err := strconv.Unquote(c.value); err == nil {
+			c.value = unquoted
+		}
+		if c.key == "level" && len(c.op) == 0 {
+			return hr, fmt.Errorf("invalid condition %q: no level", strings.TrimSpace(part))
+		}
+
+		var err error
+		switch {
+		case len(c.op) == 0:
+		case c.op == "~":
+			if c.pattern, err = regexp.Compile(c.value); err != nil {
+				return hr, fmt.Errorf("invalid condition %q: %w", strings.TrimSpace(part), err)
+			}
+		case c.key == "level":
+			if c.level, err = ParseLevel(c.value); err != nil {
+				return hr, fmt.Errorf("invalid condition %q: %w", strings.TrimSpace(part), err)
+			}
+		default:
+			c.number, err = strconv.ParseFloat(c.value, 64)
+			c.isNumber = err == nil
+			c.duration, err = time.ParseDuration(c.value)
+			c.isDur = err == nil
+		}
+		hr.conditions = append(hr.conditions, c)
+	}
+	return hr, nil
+}
+
+// matches reports whether all the rule's conditions hold for the record.
+func (hr *highlightRule) matches(r *Record) bool {
+	for idx := range hr.conditions {
+		if !hr.conditions[idx].holds(r) {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *condition) holds(r *Record) bool {
+	if c.key == "level" {
+		if c.pattern != nil {
+			return c.pattern.MatchString(LevelName(r.Level))
+		}
+		return compareOrdered(c.op, float64(r.Level), float64(c.level))
+	}
+
+	value, exists := r.Get(c.key)
+	if !exists || len(c.op) == 0 {
+		return exists
+	}
+	if c.pattern != nil {
+		return c.pattern.MatchString(fieldString(value))
+	}
+
+	switch v := value.(type) {
+	case time.Duration:
+		return c.isDur && compareOrdered(c.op, float64(v), float64(c.duration))
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		n, _ := strconv.ParseFloat(fmt.Sprint(v), 64)
+		return c.isNumber && compareOrdered(c.op, n, c.number)
+	}
+
+	s := fieldString(value)
+	if c.op == "==" || c.op == "!=" {
+		return (s == c.value) == (c.op == "==")
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil && c.isNumber {
+		return compareOrdered(c.op, n, c.number)
+	}
+	if d, err := time.ParseDuration(s); err == nil && c.isDur {
+		return compareOrdered(c.op, float64(d), float64(c.duration))
+	}
+	return compareOrdered(c.op, float64(strings.Compare(s, c.value)), 0)
+}
+
+// compareOrdered applies the comparison op to a and b.
+func compareOrdered(op string, a, b float64) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
+
+// fieldString returns a field's value as a string, unquoted.
+func fieldString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	}
+	return fmt.Sprint(value)
+}
+
+// lineColor returns the color of the record's line: the first matching line rule's, else the
+// record level's, if any.
+func (f *TemplateFormatter) lineColor(r *Record) string {
+	for idx := range f.highlightRules {
+		if rule := &f.highlightRules[idx]; len(rule.field) == 0 && rule.matches(r) {
+			return rule.color
+		}
+	}
+	return f.levelColoring[r.Level]
+}
+
+// fieldHighlights returns the colors of the record's fields highlighted by the matching field
+// rules, nil if none.
+func (f *TemplateFormatter) fieldHighlights(r *Record) map[string]string {
+	var highlights map[string]string
+	for idx := range f.highlightRules {
+		rule := &f.highlightRules[idx]
+		if len(rule.field) == 0 || highlights[rule.field] != "" {
+			continue
+		}
+		if _, exists := r.Get(rule.field); exists && rule.matches(r) {
+			if highlights == nil {
+				highlights = make(map[string]string)
+			}
+			highlights[rule.field] = rule.color
+		}
+	}
+	return highlights
+}
@@ -0,0 +1,155 @@
+package log4go
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// LevelHandler returns an http.Handler exposing the loggers' levels as JSON.
+//
+// GET returns an object mapping logger names ("root" for the root logger) to their
+// (effective) level names. PUT takes an object of the same shape and sets the given levels
+// of existing loggers (an unknown logger is an error, so requests can't create loggers);
+// with a "ttl" query parameter (e.g. "?ttl=10m") the previous levels are restored after
+// that duration.
+func LevelHandler() http.Handler {
+	return &levelHandler{reverts: make(map[string]*levelRevert)}
+}
+
+type levelHandler struct {
+	mu      sync.Mutex
+	reverts map[string]*levelRevert
+}
+
+// levelRevert restores a logger's original level when its TTL expires.
+type levelRevert struct {
+	level Level
+	timer *time.Timer
+}
+
+func (h *levelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		if err := h.put(r); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	default:
+		w.Header().Set("Allow", "GET, PUT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(currentLevels())
+}
+
+func (h *levelHandler) put(r *http.Request) error {
+	var ttl time.Duration
+	if s := r.URL.Query().Get("ttl"); len(s) > 0 {
+		var err error
+		if ttl, err = time.ParseDuration(s); err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid ttl: '%s'", s)
+		}
+	}
+
+	var names map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&names); err != nil {
+		return fmt.Errorf("invalid levels: %v", err)
+	}
+
+	// validate everything before changing anything
+	levels := make(map[*Logger]Level, len(names))
+	for name, levelName := range names {
+		lvl, err := ParseLevel(levelName)
+		if err != nil {
+			return err
+		}
+		logger := existingLogger(name)
+		if logger == nil {
+			return fmt.Errorf("unknown logger: '%s'", name)
+		}
+		levels[logger] = lvl
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for logger, lvl := range levels {
+		name := logger.name
+		revert, pending := h.reverts[name]
+		if pending {
+			revert.timer.Stop()
+			delete(h.reverts, name)
+		}
+
+		if ttl > 0 {
+			original := logger.loadLevel()
+			if pending { // keep the level from before the first temporary change
+				original = revert.level
+			}
+			// a new revert, so the previous timer's callback (if it fired already and waits
+			// for the lock) finds it's no longer current
+			current := &levelRevert{level: original}
+			logger := logger
+			current.timer = time.AfterFunc(ttl, func() { h.revert(name, logger, current) })
+			h.reverts[name] = current
+		}
+
+		old := logger.loadLevel()
+		logger.setLevel(lvl)
+		logLevelChange(logger, old, "http "+r.RemoteAddr)
+	}
+	return nil
+}
+
+// revert restores the logger's level, unless revert is no longer its current revert.
+func (h *levelHandler) revert(name string, logger *Logger, revert *levelRevert) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.reverts[name] == revert {
+		delete(h.reverts, name)
+		old := logger.loadLevel()
+		logger.storeLevel(revert.level)
+		logLevelChange(logger, old, "http ttl")
+	}
+}
+
+// existingLogger returns the logger named name ("root" or "" for the root logger), nil if it
+// doesn't exist.
+func existingLogger(name string) *Logger {
+	if len(name) == 0 || name == "root" {
+		return GetLogger()
+	}
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	return loggers[name]
+}
+
+// currentLevels returns the effective level names of all loggers.
+func currentLevels() map[string]string {
+	root := GetLogger()
+
+	loggersLock.Lock()
+	all := make([]*Logger, 0, len(loggers)+1)
+	all = append(all, root)
+	for _, logger := range loggers {
+		all = append(all, logger)
+	}
+	loggersLock.Unlock()
+
+	levels := make(map[string]string, len(all))
+	for _, logger := range all {
+		name := logger.name
+		if len(name) == 0 {
+			name = "root"
+		}
+		levels[name] = LevelName(logger.Level())
+	}
+	return levels
+}
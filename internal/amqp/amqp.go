@@ -0,0 +1,258 @@
+// Package amqp implements the parts of the AMQP 0-9-1 wire format needed to publish messages
+// (frames, methods and content headers), so the AMQP handler doesn't need a client library.
+package amqp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ProtocolHeader starts an AMQP 0-9-1 connection.
+const ProtocolHeader = "AMQP\x00\x00\x09\x01"
+
+// Frame types.
+const (
+	FrameMethod    = 1
+	FrameHeader    = 2
+	FrameBody      = 3
+	FrameHeartbeat = 8
+
+	frameEnd = 0xce
+)
+
+// Class and method IDs.
+const (
+	ClassConnection = 10
+	ClassChannel    = 20
+	ClassBasic      = 60
+	ClassConfirm    = 85
+
+	ConnectionStart     = 10
+	ConnectionStartOk   = 11
+	ConnectionTune      = 30
+	ConnectionTuneOk    = 31
+	ConnectionOpen      = 40
+	ConnectionOpenOk    = 41
+	ConnectionClose     = 50
+	ConnectionCloseOk   = 51
+	ConnectionBlocked   = 60
+	ConnectionUnblocked = 61
+
+	ChannelOpen    = 10
+	ChannelOpenOk  = 11
+	ChannelClose   = 40
+	ChannelCloseOk = 41
+
+	BasicPublish = 40
+	BasicReturn  = 50
+	BasicAck     = 80
+	BasicNack    = 120
+
+	ConfirmSelect   = 10
+	ConfirmSelectOk = 11
+)
+
+// Frame is an AMQP frame.
+type Frame struct {
+	Type    byte
+	Channel uint16
+	Payload []byte
+}
+
+// ErrFrameEnd is returned when a frame doesn't end with the frame-end octet.
+var ErrFrameEnd = errors.New("amqp: invalid frame end")
+
+// ReadFrame reads a frame.
+func ReadFrame(r *bufio.Reader) (Frame, error) {
+	var header [7]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return Frame{}, err
+	}
+	f := Frame{
+		Type:    header[0],
+		Channel: binary.BigEndian.Uint16(header[1:3]),
+		Payload: make([]byte, binary.BigEndian.Uint32(header[3:7])+1),
+	}
+	if _, err := io.ReadFull(r, f.Payload); err != nil {
+		return Frame{}, err
+	}
+	if f.Payload[len(f.Payload)-1] != frameEnd {
+		return Frame{}, ErrFrameEnd
+	}
+	f.Payload = f.Payload[:len(f.Payload)-1]
+	return f, nil
+}
+
+// AppendFrame appends a frame to b.
+func AppendFrame(b []byte, frameType byte, channel uint16, payload []byte) []byte {
+	b = append(b, frameType)
+	b = appendUint16(b, channel)
+	b = appendUint32(b, uint32(len(payload)))
+	b = append(b, payload...)
+	return append(b, frameEnd)
+}
+
+// Method returns the class and method IDs of a method frame's payload, and the arguments.
+func Method(payload []byte) (uint16, uint16, *Decoder, error) {
+	if len(payload) < 4 {
+		return 0, 0, nil, io.ErrUnexpectedEOF
+	}
+	return binary.BigEndian.Uint16(payload), binary.BigEndian.Uint16(payload[2:]), &Decoder{b: payload[4:]}, nil
+}
+
+// Encoder builds method arguments and content headers.
+type Encoder struct {
+	b []byte
+}
+
+// NewMethod returns an Encoder for a method's payload.
+func NewMethod(class, method uint16) *Encoder {
+	e := &Encoder{}
+	return e.Short(class).Short(method)
+}
+
+// Bytes returns the encoded payload.
+func (e *Encoder) Bytes() []byte { return e.b }
+
+// Octet appends an octet (also used for packed bits).
+func (e *Encoder) Octet(v byte) *Encoder { e.b = append(e.b, v); return e }
+
+// Short appends a 16 bits integer.
+func (e *Encoder) Short(v uint16) *Encoder { e.b = appendUint16(e.b, v); return e }
+
+// Long appends a 32 bits integer.
+func (e *Encoder) Long(v uint32) *Encoder { e.b = appendUint32(e.b, v); return e }
+
+// LongLong appends a 64 bits integer.
+func (e *Encoder) LongLong(v uint64) *Encoder {
+	e.b = append(e.b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint64(e.b[len(e.b)-8:], v)
+	return e
+}
+
+// ShortStr appends a short string (truncated to 255 bytes).
+func (e *Encoder) ShortStr(s string) *Encoder {
+	if len(s) > 255 {
+		s = s[:255]
+	}
+	e.b = append(e.b, byte(len(s)))
+	e.b = append(e.b, s...)
+	return e
+}
+
+// LongStr appends a long string.
+func (e *Encoder) LongStr(s string) *Encoder {
+	e.b = appendUint32(e.b, uint32(len(s)))
+	e.b = append(e.b, s...)
+	return e
+}
+
+// Table appends a field table with string values.
+func (e *Encoder) Table(fields map[string]string) *Encoder {
+	var t Encoder
+	for name, value := range fields {
+		t.ShortStr(name).Octet('S').LongStr(value)
+	}
+	e.b = appendUint32(e.b, uint32(len(t.b)))
+	e.b = append(e.b, t.b...)
+	return e
+}
+
+// Decoder reads method arguments.
+type Decoder struct {
+	b   []byte
+	err error
+}
+
+// Err returns the first error met while decoding.
+func (d *Decoder) Err() error { return d.err }
+
+func (d *Decoder) next(n int) []byte {
+	if d.err != nil || len(d.b) < n {
+		d.err = io.ErrUnexpectedEOF
+		return make([]byte, n)
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+// Octet reads an octet.
+func (d *Decoder) Octet() byte { return d.next(1)[0] }
+
+// Short reads a 16 bits integer.
+func (d *Decoder) Short() uint16 { return binary.BigEndian.Uint16(d.next(2)) }
+
+// Long reads a 32 bits integer.
+func (d *Decoder) Long() uint32 { return binary.BigEndian.Uint32(d.next(4)) }
+
+// LongLong reads a 64 bits integer.
+func (d *Decoder) LongLong() uint64 { return binary.BigEndian.Uint64(d.next(8)) }
+
+// ShortStr reads a short string.
+func (d *Decoder) ShortStr() string { return string(d.next(int(d.Octet()))) }
+
+// LongStr reads a long string (or skips a table).
+func (d *Decoder) LongStr() string { return string(d.next(int(d.Long()))) }
+
+// Close is the arguments of Connection.Close and Channel.Close.
+type Close struct {
+	Code     uint16
+	Text     string
+	ClassID  uint16
+	MethodID uint16
+}
+
+// DecodeClose decodes a Close method's arguments.
+func DecodeClose(d *Decoder) *Close {
+	return &Close{Code: d.Short(), Text: d.ShortStr(), ClassID: d.Short(), MethodID: d.Short()}
+}
+
+func (c *Close) Error() string {
+	return fmt.Sprintf("amqp: closed by server: %d %s", c.Code, c.Text)
+}
+
+// Properties are the basic content properties used by publishers.
+type Properties struct {
+	ContentType  string
+	DeliveryMode byte // 2 for persistent messages
+	Timestamp    uint64
+	AppID        string
+}
+
+// ContentHeader returns the payload of a basic content header frame.
+func ContentHeader(bodySize uint64, p Properties) []byte {
+	var flags uint16
+	var props Encoder
+	if len(p.ContentType) != 0 {
+		flags |= 1 << 15
+		props.ShortStr(p.ContentType)
+	}
+	if p.DeliveryMode != 0 {
+		flags |= 1 << 12
+		props.Octet(p.DeliveryMode)
+	}
+	if p.Timestamp != 0 {
+		flags |= 1 << 6
+		props.LongLong(p.Timestamp)
+	}
+	if len(p.AppID) != 0 {
+		flags |= 1 << 3
+		props.ShortStr(p.AppID)
+	}
+
+	e := &Encoder{}
+	e.Short(ClassBasic).Short(0).LongLong(bodySize).Short(flags)
+	return append(e.b, props.b...)
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
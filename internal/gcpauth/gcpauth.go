@@ -0,0 +1,216 @@
+// Package gcpauth gets OAuth2 access tokens for Google Cloud APIs, from a service account key
+// file or from the metadata server, so the handlers talking to Google Cloud don't need the SDK.
+package gcpauth
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetadataHost is the host of the metadata server (overridden by $GCE_METADATA_HOST).
+var MetadataHost = "metadata.google.internal"
+
+// TokenSource returns OAuth2 access tokens, caching them until shortly before they expire.
+type TokenSource struct {
+	client *http.Client
+	scope  string
+	key    *serviceAccountKey // nil for the metadata server
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+type serviceAccountKey struct {
+	ProjectID    string `json:"project_id"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	PrivateKeyID string `json:"private_key_id"`
+	TokenURI     string `json:"token_uri"`
+
+	signer *rsa.PrivateKey
+}
+
+// NewTokenSource returns a TokenSource for scope using the service account key file at
+// credentialsFile, $GOOGLE_APPLICATION_CREDENTIALS if empty, or the metadata server if both
+// are empty. It also returns the key's project ID, if any.
+func NewTokenSource(client *http.Client, scope, credentialsFile string) (*TokenSource, string, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ts := &TokenSource{client: client, scope: scope}
+
+	if len(credentialsFile) == 0 {
+		credentialsFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	}
+	if len(credentialsFile) == 0 {
+		return ts, "", nil
+	}
+
+	data, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, "", err
+	}
+	key := &serviceAccountKey{}
+	if err = json.Unmarshal(data, key); err != nil {
+		return nil, "", fmt.Errorf("%s: %v", credentialsFile, err)
+	}
+	block, _ := pem.Decode([]byte(key.PrivateKey))
+	if block == nil {
+		return nil, "", fmt.Errorf("%s: invalid private key", credentialsFile)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", credentialsFile, err)
+	}
+	signer, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, "", fmt.Errorf("%s: not an RSA private key", credentialsFile)
+	}
+	key.signer = signer
+	if len(key.TokenURI) == 0 {
+		key.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	ts.key = key
+
+	return ts, key.ProjectID, nil
+}
+
+// Token returns a valid access token.
+func (ts *TokenSource) Token(ctx context.Context) (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if len(ts.token) != 0 && time.Now().Before(ts.expires) {
+		return ts.token, nil
+	}
+
+	var (
+		req *http.Request
+		err error
+	)
+	if ts.key != nil {
+		req, err = ts.jwtRequest()
+	} else {
+		req, err = http.NewRequest(http.MethodGet, metadataURL("instance/service-accounts/default/token"), nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err = ts.do(ctx, req, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.AccessToken) == 0 {
+		return "", errors.New("gcpauth: no access token in response")
+	}
+
+	ts.token = resp.AccessToken
+	ts.expires = time.Now().Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return ts.token, nil
+}
+
+// jwtRequest returns the token request for the service account (JWT bearer grant).
+func (ts *TokenSource) jwtRequest() (*http.Request, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": ts.key.PrivateKeyID})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   ts.key.ClientEmail,
+		"scope": ts.scope,
+		"aud":   ts.key.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, ts.key.signer, crypto.SHA256, hash[:])
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)},
+	}
+	req, err := http.NewRequest(http.MethodPost, ts.key.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+func (ts *TokenSource) do(ctx context.Context, req *http.Request, v interface{}) error {
+	resp, err := ts.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gcpauth: %s: %s", resp.Status, strings.TrimSpace(string(data)))
+	}
+	return json.Unmarshal(data, v)
+}
+
+// ProjectID returns the project ID from the metadata server.
+func ProjectID(ctx context.Context, client *http.Client) (string, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	req, err := http.NewRequest(http.MethodGet, metadataURL("project/project-id"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("gcpauth: %s", resp.Status)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func metadataURL(path string) string {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if len(host) == 0 {
+		host = MetadataHost
+	}
+	return "http://" + host + "/computeMetadata/v1/" + path
+}
@@ -0,0 +1,399 @@
+// Package msgpack is a minimal MessagePack encoder and decoder for log records.
+package msgpack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// ErrShortBuffer is returned when the data ends in the middle of a value.
+var ErrShortBuffer = errors.New("msgpack: short buffer")
+
+// AppendNil appends nil.
+func AppendNil(b []byte) []byte {
+	return append(b, 0xc0)
+}
+
+// AppendBool appends a boolean.
+func AppendBool(b []byte, v bool) []byte {
+	if v {
+		return append(b, 0xc3)
+	}
+	return append(b, 0xc2)
+}
+
+// AppendInt appends a signed integer, using the smallest representation.
+func AppendInt(b []byte, v int64) []byte {
+	switch {
+	case v >= 0:
+		return AppendUint(b, uint64(v))
+	case v >= -32:
+		return append(b, byte(v))
+	case v >= math.MinInt8:
+		return append(b, 0xd0, byte(v))
+	case v >= math.MinInt16:
+		return append(b, 0xd1, byte(v>>8), byte(v))
+	case v >= math.MinInt32:
+		return appendUint32(append(b, 0xd2), uint32(v))
+	default:
+		return appendUint64(append(b, 0xd3), uint64(v))
+	}
+}
+
+// AppendUint appends an unsigned integer, using the smallest representation.
+func AppendUint(b []byte, v uint64) []byte {
+	switch {
+	case v <= 0x7f:
+		return append(b, byte(v))
+	case v <= math.MaxUint8:
+		return append(b, 0xcc, byte(v))
+	case v <= math.MaxUint16:
+		return append(b, 0xcd, byte(v>>8), byte(v))
+	case v <= math.MaxUint32:
+		return appendUint32(append(b, 0xce), uint32(v))
+	default:
+		return appendUint64(append(b, 0xcf), v)
+	}
+}
+
+// AppendFloat appends a 64-bit float.
+func AppendFloat(b []byte, v float64) []byte {
+	return appendUint64(append(b, 0xcb), math.Float64bits(v))
+}
+
+// AppendString appends a string.
+func AppendString(b []byte, s string) []byte {
+	n := len(s)
+	switch {
+	case n <= 31:
+		b = append(b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		b = append(b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xda, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, 0xdb), uint32(n))
+	}
+	return append(b, s...)
+}
+
+// AppendBytes appends binary data.
+func AppendBytes(b []byte, data []byte) []byte {
+	n := len(data)
+	switch {
+	case n <= math.MaxUint8:
+		b = append(b, 0xc4, byte(n))
+	case n <= math.MaxUint16:
+		b = append(b, 0xc5, byte(n>>8), byte(n))
+	default:
+		b = appendUint32(append(b, 0xc6), uint32(n))
+	}
+	return append(b, data...)
+}
+
+// AppendArrayHeader appends the header of an array with n elements.
+func AppendArrayHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x90|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xdc, byte(n>>8), byte(n))
+	default:
+		return appendUint32(append(b, 0xdd), uint32(n))
+	}
+}
+
+// AppendMapHeader appends the header of a map with n key/value pairs.
+func AppendMapHeader(b []byte, n int) []byte {
+	switch {
+	case n <= 15:
+		return append(b, 0x80|byte(n))
+	case n <= math.MaxUint16:
+		return append(b, 0xde, byte(n>>8), byte(n))
+	default:
+		return appendUint32(append(b, 0xdf), uint32(n))
+	}
+}
+
+// AppendEventTime appends t as a Fluentd EventTime (extension type 0).
+func AppendEventTime(b []byte, t time.Time) []byte {
+	b = append(b, 0xd7, 0x00)
+	b = appendUint32(b, uint32(t.Unix()))
+	return appendUint32(b, uint32(t.Nanosecond()))
+}
+
+// Append appends any value: maps with string keys are written with sorted keys, times as
+// RFC 3339 strings, errors and unsupported types as their fmt.Sprint string.
+func Append(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case nil:
+		return AppendNil(b)
+	case bool:
+		return AppendBool(b, v)
+	case int:
+		return AppendInt(b, int64(v))
+	case int8:
+		return AppendInt(b, int64(v))
+	case int16:
+		return AppendInt(b, int64(v))
+	case int32:
+		return AppendInt(b, int64(v))
+	case int64:
+		return AppendInt(b, v)
+	case uint:
+		return AppendUint(b, uint64(v))
+	case uint8:
+		return AppendUint(b, uint64(v))
+	case uint16:
+		return AppendUint(b, uint64(v))
+	case uint32:
+		return AppendUint(b, uint64(v))
+	case uint64:
+		return AppendUint(b, v)
+	case float32:
+		return AppendFloat(b, float64(v))
+	case float64:
+		return AppendFloat(b, v)
+	case string:
+		return AppendString(b, v)
+	case []byte:
+		return AppendBytes(b, v)
+	case time.Time:
+		return AppendString(b, v.Format(time.RFC3339Nano))
+	case time.Duration:
+		return AppendString(b, v.String())
+	case error:
+		return AppendString(b, v.Error())
+	case fmt.Stringer:
+		return AppendString(b, v.String())
+	case []interface{}:
+		b = AppendArrayHeader(b, len(v))
+		for _, item := range v {
+			b = Append(b, item)
+		}
+		return b
+	case map[string]interface{}:
+		return appendMap(b, v)
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Map:
+		if rv.Type().Key().Kind() == reflect.String {
+			m := make(map[string]interface{}, rv.Len())
+			for _, key := range rv.MapKeys() {
+				m[key.String()] = rv.MapIndex(key).Interface()
+			}
+			return appendMap(b, m)
+		}
+	case reflect.Slice, reflect.Array:
+		b = AppendArrayHeader(b, rv.Len())
+		for idx := 0; idx < rv.Len(); idx++ {
+			b = Append(b, rv.Index(idx).Interface())
+		}
+		return b
+	}
+	return AppendString(b, fmt.Sprint(v))
+}
+
+func appendMap(b []byte, m map[string]interface{}) []byte {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	b = AppendMapHeader(b, len(keys))
+	for _, key := range keys {
+		b = AppendString(b, key)
+		b = Append(b, m[key])
+	}
+	return b
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	return append(b, byte(v>>56), byte(v>>48), byte(v>>40), byte(v>>32),
+		byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+// Decode decodes the first value in b, returning it and the remaining data.
+//
+// Integers decode as int64 (uint64 if too large), floats as float64, maps as
+// map[string]interface{} (non-string keys are formatted), arrays as []interface{},
+// EventTime extensions as time.Time and other extensions as []byte.
+func Decode(b []byte) (interface{}, []byte, error) {
+	if len(b) == 0 {
+		return nil, b, ErrShortBuffer
+	}
+	c := b[0]
+	b = b[1:]
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), b, nil
+	case c >= 0xe0:
+		return int64(int8(c)), b, nil
+	case c&0xf0 == 0x80:
+		return decodeMap(b, int(c&0x0f))
+	case c&0xf0 == 0x90:
+		return decodeArray(b, int(c&0x0f))
+	case c&0xe0 == 0xa0:
+		return decodeString(b, int(c&0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, b, nil
+	case 0xc2:
+		return false, b, nil
+	case 0xc3:
+		return true, b, nil
+	case 0xc4, 0xc5, 0xc6:
+		n, rest, err := readLength(b, c-0xc4)
+		if err != nil {
+			return nil, b, err
+		}
+		if len(rest) < n {
+			return nil, b, ErrShortBuffer
+		}
+		return append([]byte(nil), rest[:n]...), rest[n:], nil
+	case 0xc7, 0xc8, 0xc9:
+		n, rest, err := readLength(b, c-0xc7)
+		if err != nil {
+			return nil, b, err
+		}
+		return decodeExt(rest, n)
+	case 0xca:
+		if len(b) < 4 {
+			return nil, b, ErrShortBuffer
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b))), b[4:], nil
+	case 0xcb:
+		if len(b) < 8 {
+			return nil, b, ErrShortBuffer
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(b)), b[8:], nil
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		size := 1 << (c - 0xcc)
+		if len(b) < size {
+			return nil, b, ErrShortBuffer
+		}
+		v := readUint(b[:size])
+		if v > math.MaxInt64 {
+			return v, b[size:], nil
+		}
+		return int64(v), b[size:], nil
+	case 0xd0, 0xd1, 0xd2, 0xd3:
+		size := 1 << (c - 0xd0)
+		if len(b) < size {
+			return nil, b, ErrShortBuffer
+		}
+		v := readUint(b[:size])
+		shift := uint(64 - 8*size)
+		return int64(v<<shift) >> shift, b[size:], nil
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		return decodeExt(b, 1<<(c-0xd4))
+	case 0xd9, 0xda, 0xdb:
+		n, rest, err := readLength(b, c-0xd9)
+		if err != nil {
+			return nil, b, err
+		}
+		return decodeString(rest, n)
+	case 0xdc, 0xdd:
+		n, rest, err := readLength(b, c-0xdc+1)
+		if err != nil {
+			return nil, b, err
+		}
+		return decodeArray(rest, n)
+	case 0xde, 0xdf:
+		n, rest, err := readLength(b, c-0xde+1)
+		if err != nil {
+			return nil, b, err
+		}
+		return decodeMap(rest, n)
+	}
+	return nil, b, fmt.Errorf("msgpack: invalid type 0x%02x", c)
+}
+
+// readLength reads a 1, 2 or 4 byte length (sizeIdx 0, 1 or 2).
+func readLength(b []byte, sizeIdx byte) (int, []byte, error) {
+	size := 1 << sizeIdx
+	if len(b) < size {
+		return 0, b, ErrShortBuffer
+	}
+	return int(readUint(b[:size])), b[size:], nil
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+func decodeString(b []byte, n int) (interface{}, []byte, error) {
+	if len(b) < n {
+		return nil, b, ErrShortBuffer
+	}
+	return string(b[:n]), b[n:], nil
+}
+
+func decodeExt(b []byte, n int) (interface{}, []byte, error) {
+	if len(b) < n+1 {
+		return nil, b, ErrShortBuffer
+	}
+	typ, data := int8(b[0]), b[1:n+1]
+	if typ == 0 && n == 8 { // EventTime
+		sec := binary.BigEndian.Uint32(data)
+		nsec := binary.BigEndian.Uint32(data[4:])
+		return time.Unix(int64(sec), int64(nsec)), b[n+1:], nil
+	}
+	return append([]byte(nil), data...), b[n+1:], nil
+}
+
+func decodeArray(b []byte, n int) (interface{}, []byte, error) {
+	if n > len(b) { // every element takes at least a byte
+		return nil, b, ErrShortBuffer
+	}
+	arr := make([]interface{}, n)
+	for idx := range arr {
+		var err error
+		if arr[idx], b, err = Decode(b); err != nil {
+			return nil, b, err
+		}
+	}
+	return arr, b, nil
+}
+
+func decodeMap(b []byte, n int) (interface{}, []byte, error) {
+	if 2*n > len(b) {
+		return nil, b, ErrShortBuffer
+	}
+	m := make(map[string]interface{}, n)
+	for idx := 0; idx < n; idx++ {
+		var key, value interface{}
+		var err error
+		if key, b, err = Decode(b); err != nil {
+			return nil, b, err
+		}
+		if value, b, err = Decode(b); err != nil {
+			return nil, b, err
+		}
+		if s, ok := key.(string); ok {
+			m[s] = value
+		} else {
+			m[fmt.Sprint(key)] = value
+		}
+	}
+	return m, b, nil
+}
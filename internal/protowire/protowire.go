@@ -0,0 +1,139 @@
+// Package protowire encodes (and decodes, for tests and tools) the protocol buffers wire
+// format, so the handlers sending protobuf messages don't need the protobuf runtime.
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Wire types.
+const (
+	VarintType = 0
+	Fixed64    = 1
+	BytesType  = 2
+	Fixed32    = 5
+)
+
+// ErrTruncated is returned when decoding a truncated message.
+var ErrTruncated = errors.New("protowire: truncated message")
+
+// AppendTag appends a field's tag.
+func AppendTag(b []byte, field int, wireType int) []byte {
+	return AppendVarint(b, uint64(field)<<3|uint64(wireType))
+}
+
+// AppendVarint appends a varint.
+func AppendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+// AppendUint appends a varint field, omitted if zero (proto3 default).
+func AppendUint(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	return AppendVarint(AppendTag(b, field, VarintType), v)
+}
+
+// AppendInt appends an int32/int64 varint field, omitted if zero.
+func AppendInt(b []byte, field int, v int64) []byte {
+	return AppendUint(b, field, uint64(v))
+}
+
+// AppendBool appends a bool field, omitted if false.
+func AppendBool(b []byte, field int, v bool) []byte {
+	if !v {
+		return b
+	}
+	return AppendUint(b, field, 1)
+}
+
+// AppendFixed64 appends a fixed64 field, omitted if zero.
+func AppendFixed64(b []byte, field int, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = AppendTag(b, field, Fixed64)
+	b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+	binary.LittleEndian.PutUint64(b[len(b)-8:], v)
+	return b
+}
+
+// AppendDouble appends a double field, omitted if zero.
+func AppendDouble(b []byte, field int, v float64) []byte {
+	return AppendFixed64(b, field, math.Float64bits(v))
+}
+
+// AppendString appends a string field, omitted if empty.
+func AppendString(b []byte, field int, s string) []byte {
+	if len(s) == 0 {
+		return b
+	}
+	b = AppendVarint(AppendTag(b, field, BytesType), uint64(len(s)))
+	return append(b, s...)
+}
+
+// AppendBytes appends a bytes (or embedded message) field, even if empty.
+func AppendBytes(b []byte, field int, v []byte) []byte {
+	b = AppendVarint(AppendTag(b, field, BytesType), uint64(len(v)))
+	return append(b, v...)
+}
+
+// Field is a decoded field: Value holds varints and fixed values, Bytes length-delimited ones.
+type Field struct {
+	Number   int
+	WireType int
+	Value    uint64
+	Bytes    []byte
+}
+
+// Decode decodes the fields of a message, in order.
+func Decode(b []byte) ([]Field, error) {
+	var fields []Field
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil, ErrTruncated
+		}
+		b = b[n:]
+		f := Field{Number: int(tag >> 3), WireType: int(tag & 7)}
+
+		switch f.WireType {
+		case VarintType:
+			f.Value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return nil, ErrTruncated
+			}
+			b = b[n:]
+		case Fixed64:
+			if len(b) < 8 {
+				return nil, ErrTruncated
+			}
+			f.Value = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case Fixed32:
+			if len(b) < 4 {
+				return nil, ErrTruncated
+			}
+			f.Value = uint64(binary.LittleEndian.Uint32(b))
+			b = b[4:]
+		case BytesType:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return nil, ErrTruncated
+			}
+			f.Bytes = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			return nil, errors.New("protowire: unsupported wire type")
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
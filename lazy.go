@@ -0,0 +1,41 @@
+package log4go
+
+// Lazy wraps a function computing a log argument; it's only called when the message is
+// actually logged, e.g.:
+//
+//	log.Debug("state: %v", log4go.Lazy(func() interface{} { return dumpState() }))
+//
+// Plain func() string arguments are evaluated the same way.
+type Lazy func() interface{}
+
+// resolveLazy returns args with all lazy arguments evaluated, args itself is not modified.
+func resolveLazy(args []interface{}) []interface{} {
+	var resolved []interface{}
+	for idx, arg := range args {
+		var value interface{}
+		switch arg := arg.(type) {
+		case Lazy:
+			value = arg()
+		case func() string:
+			value = arg()
+		case func() interface{}:
+			value = arg()
+		default:
+			if resolved != nil {
+				resolved[idx] = arg
+			}
+			continue
+		}
+
+		if resolved == nil {
+			resolved = make([]interface{}, len(args))
+			copy(resolved, args[:idx])
+		}
+		resolved[idx] = value
+	}
+
+	if resolved == nil {
+		return args
+	}
+	return resolved
+}
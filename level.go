@@ -1,6 +1,12 @@
 package log4go
 
-import "fmt"
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
 
 // Level is a typed logging level.
 type Level int
@@ -33,11 +39,112 @@ var levelToName = map[Level]string{
 	TRACE:   "TRACE",
 }
 
-// LevelName returns the textual representation of the level.
+// levelDisplayNames holds the map[Level]string of the names set by SetLevelDisplayName,
+// replaced on each change.
+var levelDisplayNames atomic.Value
+
+// levelDisplayNamesLock serializes the SetLevelDisplayName calls.
+var levelDisplayNamesLock sync.Mutex
+
+// SetLevelDisplayName sets the name of the level written by LevelName (so by the formatters),
+// e.g. "WARN" for WARNING or a localized name, "" to restore the default. ParseLevel accepts
+// both names, so do the configurations and the level maps read from JSON.
+func SetLevelDisplayName(lvl Level, name string) {
+	levelDisplayNamesLock.Lock()
+	defer levelDisplayNamesLock.Unlock()
+
+	old, _ := levelDisplayNames.Load().(map[Level]string)
+	names := make(map[Level]string, len(old)+1)
+	for l, n := range old {
+		names[l] = n
+	}
+	if len(name) > 0 {
+		names[lvl] = name
+	} else {
+		delete(names, lvl)
+	}
+	levelDisplayNames.Store(names)
+}
+
+// LevelName returns the textual representation of the level, see SetLevelDisplayName.
 func LevelName(l Level) string {
+	if names, _ := levelDisplayNames.Load().(map[Level]string); len(names) > 0 {
+		if name, exists := names[l]; exists {
+			return name
+		}
+	}
 	name, exists := levelToName[l]
 	if !exists {
 		name = fmt.Sprintf("%d", l)
 	}
 	return name
 }
+
+// ParseLevel returns the level of the name, case insensitive: e.g. "warning", "WARN" (or
+// the short name "WRN"), a display name (see SetLevelDisplayName), or the number of one of
+// these levels.
+func ParseLevel(name string) (Level, error) {
+	names, _ := levelDisplayNames.Load().(map[Level]string)
+	for lvl, displayName := range names {
+		if strings.EqualFold(displayName, name) {
+			return lvl, nil
+		}
+	}
+	upper := strings.ToUpper(name)
+	for lvl, levelName := range levelToName {
+		if levelName == upper || levelToShortName[lvl] == upper {
+			return lvl, nil
+		}
+	}
+	if upper == "WARN" {
+		return WARNING, nil
+	}
+	if n, err := strconv.Atoi(name); err == nil {
+		_, defined := levelToName[Level(n)]
+		if _, named := names[Level(n)]; !defined && !named {
+			return NOTSET, fmt.Errorf("level out of range: '%s'", name)
+		}
+		return Level(n), nil
+	}
+	return NOTSET, fmt.Errorf("unknown level: '%s'", name)
+}
+
+// String returns the level's name, see LevelName.
+func (l Level) String() string {
+	return LevelName(l)
+}
+
+// MarshalText returns the level's name, implementing encoding.TextMarshaler.
+func (l Level) MarshalText() ([]byte, error) {
+	return []byte(LevelName(l)), nil
+}
+
+// UnmarshalText sets the level from its name, see ParseLevel; it implements
+// encoding.TextUnmarshaler, e.g. for JSON configurations.
+func (l *Level) UnmarshalText(text []byte) error {
+	lvl, err := ParseLevel(string(text))
+	if err != nil {
+		return err
+	}
+	*l = lvl
+	return nil
+}
+
+var levelToShortName = map[Level]string{
+	NOTSET:  "NOT",
+	FATAL:   "FTL",
+	ERROR:   "ERR",
+	WARNING: "WRN",
+	INFO:    "INF",
+	DEBUG:   "DBG",
+	TRACE:   "TRC",
+}
+
+// levelShortName returns the three letter representation of the level.
+func levelShortName(l Level) string {
+	name, exists := levelToShortName[l]
+	if !exists {
+		name = fmt.Sprintf("%3d", l)
+	}
+	return name
+}
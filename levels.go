@@ -0,0 +1,110 @@
+package log4go
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// LevelsEnvVar is the environment variable holding per-logger level overrides, read at init,
+// e.g. LOG4GO_LEVELS="app.db=DEBUG,app/http=WARNING" (use "root" for the root logger).
+const LevelsEnvVar = "LOG4GO_LEVELS"
+
+var levelOverrides map[string]Level // guarded by loggersLock
+
+func init() {
+	spec := os.Getenv(LevelsEnvVar)
+	if len(spec) == 0 {
+		return
+	}
+	levels, err := ParseLevelOverrides(spec)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "log4go: %s: %v\n", LevelsEnvVar, err)
+		return
+	}
+	SetLevelOverrides(levels)
+}
+
+// ParseLevelOverrides parses a "name=LEVEL,name=LEVEL" list of logger levels; the names can
+// use dots as separators ("app.db" is the app/db logger, "\." is a dot), level names are
+// case insensitive and may also be given as numbers.
+func ParseLevelOverrides(spec string) (map[string]Level, error) {
+	levels := make(map[string]Level)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+			return nil, fmt.Errorf("invalid level override: '%s'", item)
+		}
+		lvl, err := ParseLevel(strings.TrimSpace(parts[1]))
+		if err != nil {
+			return nil, err
+		}
+		levels[dotsToSlashes(strings.TrimSpace(parts[0]), false)] = lvl
+	}
+	return levels, nil
+}
+
+// dotsToSlashes returns name with its dots replaced by slashes, the separator of the logger
+// names, except the escaped ones ("\."), which are unescaped unless keepEscapes is set (e.g. for
+// path.Match patterns).
+func dotsToSlashes(name string, keepEscapes bool) string {
+	if !strings.Contains(name, ".") {
+		return name
+	}
+	var b strings.Builder
+	b.Grow(len(name))
+	for idx := 0; idx < len(name); idx++ {
+		switch c := name[idx]; {
+		case c == '\\' && idx+1 < len(name) && name[idx+1] == '.':
+			if keepEscapes {
+				b.WriteByte(c)
+			}
+			idx++
+			b.WriteByte('.')
+		case c == '.':
+			b.WriteByte('/')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// SetLevelOverrides sets the level of the named loggers, both existing and yet to be created,
+// replacing any previously set overrides. The overrides take precedence over levels set by
+// BasicConfig.
+func SetLevelOverrides(levels map[string]Level) {
+	loggersLock.Lock()
+	var snapshot *loggersSnapshot
+	if rootLogger != nil {
+		snapshot = snapshotLoggers(registeredLoggers(rootLogger))
+	}
+	defer snapshot.logChanges("overrides")
+	defer loggersLock.Unlock()
+
+	levelOverrides = make(map[string]Level, len(levels))
+	for name, lvl := range levels {
+		if name == "root" {
+			name = ""
+		}
+		levelOverrides[name] = lvl
+	}
+
+	if rootLogger != nil {
+		applyLevelOverride(rootLogger)
+	}
+	for _, logger := range loggers {
+		applyLevelOverride(logger)
+	}
+}
+
+// applyLevelOverride sets the logger's level if overridden, loggersLock must be held.
+func applyLevelOverride(l *Logger) {
+	if lvl, exists := levelOverrides[l.name]; exists {
+		l.setLevel(lvl)
+	}
+}
@@ -2,10 +2,10 @@
 package log4go
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"os"
-	"runtime"
 	"sync"
 	"syscall"
 	"time"
@@ -27,15 +27,7 @@ var rootLogger *Logger
 var loggersLock = &sync.Mutex{}
 var loggers map[string]*Logger
 
-var recordPool sync.Pool
-
 func init() {
-	recordPool = sync.Pool{
-		New: func() interface{} {
-			return &Record{}
-		},
-	}
-
 	loggers = make(map[string]*Logger)
 }
 
@@ -45,9 +37,11 @@ func BasicConfig(opts BasicConfigOpts) error {
 	defer loggersLock.Unlock()
 
 	// remove any/all created Logger, Handler and Formatter instances
-	Shutdown()
+	_ = shutdownAll(context.Background(), uniqueHandlers())
 	loggers = map[string]*Logger{}
 	rootLogger = nil
+	configured.loggers = nil
+	configured.handlers = nil
 
 	var err error
 
@@ -102,35 +96,71 @@ func BasicConfig(opts BasicConfigOpts) error {
 	}
 
 	rootLogger = createRootLogger(opts.Handlers...)
-	rootLogger.SetLevel(opts.Level)
+	rootLogger.setLevel(opts.Level)
+	applyLevelOverride(rootLogger)
 
 	return nil
 }
 
-// Shutdown shuts down all internals of log4go.
+// Shutdown shuts down all internals of log4go, waiting for all queued records to be written.
 func Shutdown() {
-	// close all commit channels
+	_ = ShutdownContext(context.Background())
+}
 
-	// first collect all unique handlers
+// ShutdownContext shuts down the handlers of all loggers, waiting until their queued records
+// have been written and files synced, or until ctx is done (then ctx.Err() is returned).
+// Handlers having a ShutdownContext(context.Context) error method are drained, others are
+// simply shut down.
+func ShutdownContext(ctx context.Context) error {
+	loggersLock.Lock()
+	allHandlers := uniqueHandlers()
+	configured.handlers = nil // shut down, so not kept by a reconfiguration
+	loggersLock.Unlock()
+	return shutdownAll(ctx, allHandlers)
+}
+
+// uniqueHandlers returns the unique handlers of all loggers, loggersLock must be held.
+func uniqueHandlers() []Handler {
 	uniqueHandlers := make(map[string]Handler, 10)
 	collectHandlers(rootLogger, uniqueHandlers)
 	allHandlers := make([]Handler, 0, len(uniqueHandlers))
 	for _, h := range uniqueHandlers {
 		allHandlers = append(allHandlers, h)
 	}
-	// then shut them all down
-	shutdownHandlers(allHandlers)
+	return allHandlers
+}
+
+// shutdownAll shuts down the handlers, concurrently, see ShutdownContext.
+func shutdownAll(ctx context.Context, allHandlers []Handler) error {
+	done := make(chan struct{})
+	go func() {
+		shutdownHandlers(ctx, allHandlers)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 
-	runtime.Gosched()
-	runtime.GC()
 	syscall.Sync()
+	return nil
+}
 
-	// TODO: wait for the commiters to finish (somehow)
+// exitShutdownTimeout is how long the handlers may take to write their queued records before
+// the process exits, see shutdownForExit.
+const exitShutdownTimeout = 5 * time.Second
 
-	// nice synchronization there, Bob!
-	time.Sleep(100 * time.Millisecond)
+// shutdownForExit shuts down the handlers before the process exits (e.g. on Fatal, or a re-panic), waiting at
+// most exitShutdownTimeout so that a hung writer doesn't keep the process from exiting.
+func shutdownForExit() {
+	ctx, cancel := context.WithTimeout(context.Background(), exitShutdownTimeout)
+	defer cancel()
+	_ = ShutdownContext(ctx)
 }
 
+// collectHandlers collects the handlers of log and its children, loggersLock must be held.
 func collectHandlers(log *Logger, uniqueHandlers map[string]Handler) {
 	if log == nil {
 		return
@@ -141,8 +171,8 @@ func collectHandlers(log *Logger, uniqueHandlers map[string]Handler) {
 		}
 	}
 
-	if log.handlers != nil {
-		for _, h := range log.handlers {
+	if handlers := log.loadHandlers(); handlers != nil {
+		for _, h := range handlers {
 			// use the pointer address as the unique key
 			hkey := fmt.Sprintf("%p", h)
 
@@ -150,10 +180,23 @@ func collectHandlers(log *Logger, uniqueHandlers map[string]Handler) {
 		}
 	}
 }
-func shutdownHandlers(allHandlers []Handler) {
+
+func shutdownHandlers(ctx context.Context, allHandlers []Handler) {
+	var wg sync.WaitGroup
 	for _, h := range allHandlers {
-		h.Shutdown()
+		wg.Add(1)
+		go func(h Handler) {
+			defer wg.Done()
+			if gh, ok := h.(interface {
+				ShutdownContext(context.Context) error
+			}); ok {
+				_ = gh.ShutdownContext(ctx)
+			} else {
+				h.Shutdown()
+			}
+		}(h)
 	}
+	wg.Wait()
 }
 
 // GetLogger returns the root logger while GetLogger(name) calls GetLogger(name) on the root logger.
@@ -0,0 +1,45 @@
+package log4gotest
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is a fake log4go.Clock, it only moves when told to:
+//
+//	clock := log4gotest.NewClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+//	log4go.SetClock(clock)
+//	defer log4go.SetClock(nil)
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewClock returns a new Clock set to t.
+func NewClock(t time.Time) *Clock {
+	return &Clock{now: t}
+}
+
+// Now returns the clock's time.
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Set sets the clock's time.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = t
+}
+
+// Advance moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+}
@@ -0,0 +1,160 @@
+// Package log4gotest provides a log4go handler capturing the records in memory, with
+// assertion helpers, to unit test logging behavior.
+package log4gotest
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/kaizer666/log4go"
+)
+
+// T is the subset of testing.TB used by the assertion helpers.
+type T interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// CaptureHandler keeps the records it handles in memory.
+type CaptureHandler struct {
+	mu        sync.Mutex
+	records   []log4go.Record
+	level     log4go.Level
+	formatter log4go.Formatter
+}
+
+// NewCaptureHandler returns a new CaptureHandler.
+func NewCaptureHandler() *CaptureHandler {
+	formatter, _ := log4go.NewTemplateFormatter("{level} {message}")
+	return &CaptureHandler{formatter: formatter}
+}
+
+// Capture returns a new CaptureHandler added to logger.
+func Capture(logger *log4go.Logger) *CaptureHandler {
+	h := NewCaptureHandler()
+	_ = logger.AddHandler(h)
+	return h
+}
+
+var _ log4go.Handler = &CaptureHandler{}
+
+// Handle keeps a copy of the record.
+func (h *CaptureHandler) Handle(rec *log4go.Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if rec.Level >= h.level {
+		h.records = append(h.records, *rec)
+	}
+	return nil
+}
+
+// Records returns the captured records.
+func (h *CaptureHandler) Records() []log4go.Record {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return append([]log4go.Record(nil), h.records...)
+}
+
+// Messages returns the captured records formatted with the handler's formatter ("{level}
+// {message}" by default).
+func (h *CaptureHandler) Messages() []string {
+	records := h.Records()
+	formatter := h.Formatter()
+	messages := make([]string, 0, len(records))
+	for i := range records {
+		msg, err := formatter.Format(&records[i])
+		if err != nil {
+			continue
+		}
+		messages = append(messages, string(msg))
+	}
+	return messages
+}
+
+// Reset forgets the captured records.
+func (h *CaptureHandler) Reset() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.records = nil
+}
+
+// Logged reports whether a record of the level with a message containing substring was
+// captured.
+func (h *CaptureHandler) Logged(level log4go.Level, substring string) bool {
+	for _, rec := range h.Records() {
+		if rec.Level == level && strings.Contains(rec.Message, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+// AssertLogged fails the test unless a record of the level with a message containing
+// substring was captured.
+func (h *CaptureHandler) AssertLogged(t T, level log4go.Level, substring string) {
+	t.Helper()
+	if !h.Logged(level, substring) {
+		t.Errorf("no %s record containing %q, got: %q", log4go.LevelName(level), substring, h.Messages())
+	}
+}
+
+// AssertNotLogged fails the test if a record of the level with a message containing
+// substring was captured.
+func (h *CaptureHandler) AssertNotLogged(t T, level log4go.Level, substring string) {
+	t.Helper()
+	if h.Logged(level, substring) {
+		t.Errorf("unexpected %s record containing %q", log4go.LevelName(level), substring)
+	}
+}
+
+// AssertCount fails the test unless n records of the level were captured.
+func (h *CaptureHandler) AssertCount(t T, level log4go.Level, n int) {
+	t.Helper()
+	count := 0
+	for _, rec := range h.Records() {
+		if rec.Level == level {
+			count++
+		}
+	}
+	if count != n {
+		t.Errorf("expected %d %s records, got %d: %q", n, log4go.LevelName(level), count, h.Messages())
+	}
+}
+
+// SetFormatter sets the formatter used by Messages.
+func (h *CaptureHandler) SetFormatter(formatter log4go.Formatter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.formatter = formatter
+}
+
+// Formatter returns the formatter used by Messages.
+func (h *CaptureHandler) Formatter() log4go.Formatter {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.formatter
+}
+
+// SetLevel sets the level the handler will (at least) capture.
+func (h *CaptureHandler) SetLevel(level log4go.Level) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.level = level
+}
+
+// Level returns the level previously set (or NOTSET if not set).
+func (h *CaptureHandler) Level() log4go.Level {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return h.level
+}
+
+// Shutdown does nothing, the records are kept for the assertions.
+func (h *CaptureHandler) Shutdown() {}
@@ -0,0 +1,75 @@
+package log4gotest
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/kaizer666/log4go"
+)
+
+type recorder struct {
+	failures []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func TestCaptureHandler(t *testing.T) {
+	log4go.BasicConfig(log4go.BasicConfigOpts{Level: log4go.INFO, Writer: ioutil.Discard})
+	defer log4go.Shutdown()
+	capture := Capture(log4go.GetLogger())
+
+	log := log4go.GetLogger("app")
+	log.SetLevel(log4go.INFO)
+	log.Info("user %s logged in", "joe")
+	log.Error("failed to save", log4go.Fields{"id": 42})
+	log.Debug("filtered out")
+
+	capture.AssertLogged(t, log4go.INFO, "joe logged in")
+	capture.AssertLogged(t, log4go.ERROR, "failed")
+	capture.AssertNotLogged(t, log4go.DEBUG, "filtered")
+	capture.AssertCount(t, log4go.INFO, 1)
+	if records := capture.Records(); len(records) != 2 || records[1].Fields["id"] != 42 {
+		t.Errorf("unexpected records: %+v", records)
+	}
+
+	r := &recorder{}
+	capture.AssertLogged(r, log4go.WARNING, "joe")
+	capture.AssertCount(r, log4go.ERROR, 2)
+	expected := []string{
+		`no WARNING record containing "joe", got: ["INFO user joe logged in" "ERROR failed to save"]`,
+		`expected 2 ERROR records, got 1: ["INFO user joe logged in" "ERROR failed to save"]`,
+	}
+	if fmt.Sprint(r.failures) != fmt.Sprint(expected) {
+		t.Errorf("unexpected failures: %q", r.failures)
+	}
+
+	capture.Reset()
+	if len(capture.Records()) != 0 {
+		t.Error("records not reset")
+	}
+}
+
+func TestClock(t *testing.T) {
+	clock := NewClock(time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC))
+	log4go.SetClock(clock)
+	defer log4go.SetClock(nil)
+
+	var buf bytes.Buffer
+	log4go.BasicConfig(log4go.BasicConfigOpts{Level: log4go.INFO, Writer: &buf, Format: "{time} {message}"})
+	log := log4go.GetLogger()
+	log.Info("first")
+	clock.Advance(90 * time.Second)
+	log.Info("second")
+	log4go.Shutdown()
+
+	if got := buf.String(); got != "2020-01-02 03:04:05 first\n2020-01-02 03:05:35 second\n" {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
@@ -6,27 +6,45 @@ import (
 	"fmt"
 	"os"
 	"strings"
-	"time"
+	"sync"
+	"sync/atomic"
 )
 
 // Logger objects.
 type Logger struct {
-	name     string
-	level    Level
-	handlers []Handler
-	parent   *Logger
-	children []*Logger
+	name       string
+	level      int32        // Level, accessed atomically
+	handlers   atomic.Value // []Handler, see loadHandlers
+	handlersMu sync.Mutex   // serializes the updates of handlers
+	parent     *Logger
+	children   []*Logger
 
 	staged []*Record
+
+	fields     Fields  // bound fields, see With
+	group      string  // prefix of the fields bound or logged from now on, see WithGroup
+	tags       Tags    // tags of all the records, see WithTags
+	stats      *Logger // the logger counting the records, for the loggers returned by With
+	caller     bool    // report the caller, see WithCaller
+	callerSkip int     // frames skipped above the logging call, see AddCallerSkip
+	mdc        *MDC    // mapped diagnostic context, see WithMDC
+
+	seq          uint64            // last record sequence number, shared with With's loggers, accessed atomically
+	logged       [FATAL + 1]uint64 // records logged per level, accessed atomically
+	limitedCount uint64            // records dropped by the rate limit, accessed atomically
+
+	limiter   atomic.Value // *rateLimiter, see SetRateLimit
+	occasions occasions    // see Once
 }
 
 var errNoFormatter = errors.New("handler has no formatter")
 
+// newLogger creates a new Logger, loggersLock must be held.
 func newLogger(parent *Logger, name string, lvl Level, handlers ...Handler) *Logger {
 	// use: sync.Pool ?
 	log := &Logger{
 		name:  name,
-		level: lvl,
+		level: int32(lvl),
 	}
 	if parent != nil {
 		log.parent = parent
@@ -37,9 +55,11 @@ func newLogger(parent *Logger, name string, lvl Level, handlers ...Handler) *Log
 	}
 
 	if len(handlers) > 0 {
-		log.handlers = handlers
+		log.storeHandlers(handlers)
 	}
 
+	applyLevelOverride(log)
+
 	return log
 }
 
@@ -68,46 +88,165 @@ func (l *Logger) GetLogger(subName string) *Logger {
 	return logger
 }
 
-// SetLevel sets the logging level of the logger.
+// Name returns the logger's name, "" for the root logger.
+func (l *Logger) Name() string {
+	return l.name
+}
+
+// SetLevel sets the logging level of the logger, safe for concurrent use.
 func (l *Logger) SetLevel(lvl Level) {
+	old := l.loadLevel()
+	l.setLevel(lvl)
+	logLevelChange(l, old, "api")
+}
+
+// setLevel sets the level, without logging the change (see SetMetaLogger).
+func (l *Logger) setLevel(lvl Level) {
 	if lvl == NOTSET {
 		lvl = DEBUG
 	}
-	l.level = lvl
+	l.storeLevel(lvl)
 }
 
 // Level returns the logger's (effective) level.
 func (l *Logger) Level() Level {
 	// as long as level is not set, ascend the ancestors
-	for l.level == NOTSET {
-		if l.parent != nil {
-			l = l.parent
-		} else { // no parent, use this logger's level
-			break
+	lvl := l.loadLevel()
+	for lvl == NOTSET && l.parent != nil {
+		l = l.parent
+		lvl = l.loadLevel()
+	}
+	return lvl
+}
+
+// IsEnabled reports whether a message of the given level would be logged by this logger.
+func (l *Logger) IsEnabled(lvl Level) bool {
+	return lvl != NOTSET && lvl >= l.Level()
+}
+
+// IsLevelEnabled reports whether a message of the given level would reach a handler: the
+// logger's level enables it (see IsEnabled), and one of the handlers of the logger or its
+// ancestors has a level (and maximum level, see LevelRangeHandler) letting it through. It's
+// cheap enough to guard expensive computations with:
+//
+//	if log.IsLevelEnabled(log4go.DEBUG) {
+//		log.Debug("state: %s", dumpState())
+//	}
+func (l *Logger) IsLevelEnabled(lvl Level) bool {
+	if !l.IsEnabled(lvl) {
+		return false
+	}
+	for logger := l; logger != nil; logger = logger.parent {
+		for _, handler := range logger.loadHandlers() {
+			if lvl < handler.Level() {
+				continue
+			}
+			if h, ok := handler.(interface{ MaxLevel() Level }); ok {
+				if max := h.MaxLevel(); max != NOTSET && lvl > max {
+					continue
+				}
+			}
+			return true
 		}
 	}
-	return l.level
+	return false
+}
+
+// IsDebugEnabled reports whether a DEBUG message would reach a handler, see IsLevelEnabled.
+func (l *Logger) IsDebugEnabled() bool {
+	return l.IsLevelEnabled(DEBUG)
+}
+
+func (l *Logger) loadLevel() Level {
+	return Level(atomic.LoadInt32(&l.level))
+}
+
+func (l *Logger) storeLevel(lvl Level) {
+	atomic.StoreInt32(&l.level, int32(lvl))
 }
 
-// AddHandler adds a log record handler.
+// AddHandler adds a log record handler. Handlers can be added and removed while logging, e.g.
+// to attach a debugging file to a live process: the records being logged use either the
+// previous handlers or the new ones.
 func (l *Logger) AddHandler(handler Handler) error {
+	old, err := l.addHandler(handler)
+	if err == nil {
+		logHandlersChange(l, old, "api")
+	}
+	return err
+}
+
+// addHandler adds a handler, returning the previous ones, without logging the change.
+func (l *Logger) addHandler(handler Handler) ([]Handler, error) {
 	if handler.Formatter() == nil {
-		return errNoFormatter
+		return nil, errNoFormatter
 	}
 
-	l.handlers = append(l.handlers, handler)
+	l.handlersMu.Lock()
+	defer l.handlersMu.Unlock()
+	handlers := l.loadHandlers()
+	l.handlers.Store(append(handlers[:len(handlers):len(handlers)], handler))
+	return handlers, nil
+}
+
+// RemoveHandler removes a handler added to the logger, and reports whether it was; see
+// AddHandler. It returns once the records being logged are done with the handler, which can
+// then be shut down (so it mustn't be called by a handler).
+func (l *Logger) RemoveHandler(handler Handler) bool {
+	old := l.removeHandler(handler)
+	if old == nil {
+		return false
+	}
+	waitHandlers()
+	logHandlersChange(l, old, "api")
+	return true
+}
+
+// removeHandler removes a handler, returning the previous ones, nil if it wasn't added.
+func (l *Logger) removeHandler(handler Handler) []Handler {
+	l.handlersMu.Lock()
+	defer l.handlersMu.Unlock()
+	handlers := l.loadHandlers()
+	for idx, h := range handlers {
+		if h == handler {
+			kept := make([]Handler, 0, len(handlers)-1)
+			kept = append(kept, handlers[:idx]...)
+			l.handlers.Store(append(kept, handlers[idx+1:]...))
+			return handlers
+		}
+	}
 	return nil
 }
 
 // ReplaceHandlers replaces all added handler with a new handler.
 func (l *Logger) ReplaceHandlers(handler Handler) {
-	l.RemoveHandlers()
-	l.AddHandler(handler)
+	handlers := []Handler{}
+	if handler.Formatter() != nil {
+		handlers = append(handlers, handler)
+	}
+	old := l.loadHandlers()
+	l.storeHandlers(handlers)
+	logHandlersChange(l, old, "api")
 }
 
 // RemoveHandlers removes all handlers from the Logger.
 func (l *Logger) RemoveHandlers() {
-	l.handlers = []Handler{}
+	old := l.loadHandlers()
+	l.storeHandlers([]Handler{})
+	logHandlersChange(l, old, "api")
+}
+
+// loadHandlers returns the logger's own handlers, the slice mustn't be modified.
+func (l *Logger) loadHandlers() []Handler {
+	handlers, _ := l.handlers.Load().([]Handler)
+	return handlers
+}
+
+// storeHandlers replaces the logger's own handlers.
+func (l *Logger) storeHandlers(handlers []Handler) {
+	l.handlersMu.Lock()
+	defer l.handlersMu.Unlock()
+	l.handlers.Store(handlers)
 }
 
 // Handlers returns all handlers used by this logger (i.e. this and all its parents' handlers).
@@ -115,60 +254,102 @@ func (l *Logger) Handlers() []Handler {
 	handlers := make([]Handler, 0, 10)
 	logger := l
 	for logger != nil {
-		if logger.handlers != nil && len(logger.handlers) > 0 {
-			handlers = append(handlers, logger.handlers...)
-		}
+		handlers = append(handlers, logger.loadHandlers()...)
 		logger = logger.parent
 	}
 	return handlers
 }
 
 // Log submits a Log message using specific level and message.
+//
+// Nothing is done (no Record, no formatting) for disabled levels, the logging methods check
+// the level first so such calls don't allocate; the caller may still allocate converting
+// non-constant arguments to interface{} values, guard expensive ones with IsEnabled or Lazy.
 func (l *Logger) log(lvl Level, stage bool, message string, args ...interface{}) {
-	if lvl < l.Level() || lvl == NOTSET {
+	if !l.IsEnabled(lvl) {
 		return
 	}
+	l.emit(lvl, stage, message, args...)
+}
+
+// emit passes the record to the handlers (or stages it), whatever the logger's level.
+func (l *Logger) emit(lvl Level, stage bool, message string, args ...interface{}) {
+	if l.rateLimited(message) {
+		return
+	}
+	l.countLogged(lvl)
 
 	var record *Record
+	calls := enterHandlers()
 
 	// traverse up this logger's ancestors, calling all handlers along the way
 	logger := l
 	for logger != nil {
-		if len(logger.handlers) > 0 { // we need handlers!
+		if handlers := logger.loadHandlers(); len(handlers) > 0 { // we need handlers!
 			if record == nil {
-				record = recordPool.Get().(*Record)
+				record = newRecord()
 
-				record.Time = time.Now()
+				record.Time = now()
 				record.Name = l.name
 				record.Level = lvl
-				record.Message = fmt.Sprintf(message, args...)
+				args, ctxFields := splitContextFields(args)
+				args, tags, fields := splitArgs(args)
+				args = resolveLazy(args)
+				record.Fields = mergeContextFields(ctxFields, l.recordFields(fields))
+				record.Tags = mergeTags(l.tags, tags)
+				record.MDC = l.mdc.Values()
+				if expanded, ok := expandTemplate(message, args, record.Fields); ok {
+					record.Message = expanded
+					record.Fields = withTemplate(record.Fields, message)
+				} else {
+					record.Message = fmt.Sprintf(message, args...)
+				}
+				record.Seq = l.nextSeq()
+				record.GoroutineID = goroutineID()
+				if l.reportsCaller() {
+					record.File, record.Line = caller(l.callerSkip)
+				}
 			}
 
 			if stage {
 				if l.staged == nil {
 					l.staged = make([]*Record, 0, 10)
 				}
-				l.staged = append(l.staged, record)
+				l.staged = append(l.staged, record.retain())
 			} else {
 				// invoke all handlers
-				for _, handler := range logger.handlers {
+				for _, handler := range handlers {
 					handler.Handle(record)
 				}
 			}
 		}
 		logger = logger.parent
 	}
+	leaveHandlers(calls)
 
 	if record != nil {
-		recordPool.Put(record)
+		record.release()
+	}
+}
+
+// clearStaged drops the staged records, without writing to the logger when there are none.
+func (l *Logger) clearStaged() {
+	if len(l.staged) > 0 {
+		for _, r := range l.staged {
+			r.release()
+		}
+		l.staged = l.staged[:0]
 	}
 }
 
 func (l *Logger) flushStaged() {
+	calls := enterHandlers()
+	defer leaveHandlers(calls)
 	for _, r := range l.staged {
-		for _, h := range l.handlers {
+		for _, h := range l.loadHandlers() {
 			h.Handle(r)
 		}
+		r.release()
 	}
 	l.staged = l.staged[:0]
 }
@@ -245,7 +426,7 @@ func (l *Logger) Crash(err interface{}, stack []byte, opts ...CrashOpts) {
 	}
 
 	if exitCode != 0 {
-		Shutdown()
+		shutdownForExit()
 		os.Exit(exitCode)
 	}
 }
@@ -260,7 +441,7 @@ func (l *Logger) Fatal(message string, args ...interface{}) {
 
 	l.log(FATAL, false, message, args...)
 
-	Shutdown()
+	shutdownForExit()
 	os.Exit(1)
 }
 
@@ -274,20 +455,26 @@ func (l *Logger) Error(message string, args ...interface{}) {
 
 // Warning logs message with WARNING level (clears staged messages).
 func (l *Logger) Warning(message string, args ...interface{}) {
-	l.staged = l.staged[:0]
-	l.log(WARNING, false, message, args...)
+	l.clearStaged()
+	if l.IsEnabled(WARNING) {
+		l.log(WARNING, false, message, args...)
+	}
 }
 
 // Info logs message with INFO level (clears staged messages).
 func (l *Logger) Info(message string, args ...interface{}) {
-	l.staged = l.staged[:0]
-	l.log(INFO, false, message, args...)
+	l.clearStaged()
+	if l.IsEnabled(INFO) {
+		l.log(INFO, false, message, args...)
+	}
 }
 
 // Debug logs message with DEBUG level (clears staged messages).
 func (l *Logger) Debug(message string, args ...interface{}) {
-	l.staged = l.staged[:0]
-	l.log(DEBUG, false, message, args...)
+	l.clearStaged()
+	if l.IsEnabled(DEBUG) {
+		l.log(DEBUG, false, message, args...)
+	}
 }
 
 // Log logs message with given level (clears staged messages).
@@ -295,8 +482,10 @@ func (l *Logger) Log(lvl Level, message string, args ...interface{}) {
 	if lvl == NOTSET {
 		return
 	}
-	l.staged = l.staged[:0]
-	l.log(lvl, false, message, args...)
+	l.clearStaged()
+	if l.IsEnabled(lvl) {
+		l.log(lvl, false, message, args...)
+	}
 }
 
 // ------------------------------------------------
@@ -3,14 +3,41 @@ package log4go
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/kaizer666/log4go/color"
+	"github.com/kaizer666/log4go/internal/amqp"
+	"github.com/kaizer666/log4go/internal/msgpack"
+	"github.com/kaizer666/log4go/internal/protowire"
+	"golang.org/x/net/websocket"
 )
 
 func TestOne(t *testing.T) {
@@ -247,6 +274,4617 @@ func TestMulti(t *testing.T) {
 	}
 }
 
+func TestLevelOverrides(t *testing.T) {
+	levels, err := ParseLevelOverrides("root=error, app.db=debug,app/http=4,app/v\\.2=info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if levels["root"] != ERROR || levels["app/db"] != DEBUG || levels["app/http"] != WARNING || levels["app/v.2"] != INFO {
+		t.Errorf("unexpected levels: %v", levels)
+	}
+	if _, err = ParseLevelOverrides("app=LOUD"); err == nil {
+		t.Error("no error for unknown level")
+	}
+
+	var buf bytes.Buffer
+	BasicConfig(BasicConfigOpts{
+		Level:  DEBUG,
+		Writer: &buf,
+	})
+	SetLevelOverrides(levels)
+	defer SetLevelOverrides(nil)
+
+	if lvl := GetLogger().Level(); lvl != ERROR {
+		t.Errorf("root level: expected ERROR, got %s", LevelName(lvl))
+	}
+	if lvl := GetLogger("app").GetLogger("http").Level(); lvl != WARNING {
+		t.Errorf("app/http level: expected WARNING, got %s", LevelName(lvl))
+	}
+	Shutdown()
+}
+
+func TestLevelHandler(t *testing.T) {
+	BasicConfig(BasicConfigOpts{Writer: ioutil.Discard})
+	defer Shutdown()
+
+	handler := LevelHandler()
+	web := GetLogger("web")
+
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"web": "debug"}`))
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("PUT: status %d: %s", rec.Code, rec.Body.String())
+	}
+	if !strings.Contains(rec.Body.String(), `"web":"DEBUG"`) {
+		t.Errorf("PUT: unexpected body %s", rec.Body.String())
+	}
+
+	req = httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"web": "LOUD"}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("PUT invalid level: status %d", rec.Code)
+	}
+
+	// requests can't create loggers
+	req = httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"nope": "INFO"}`))
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if rec.Code != http.StatusBadRequest || existingLogger("nope") != nil {
+		t.Errorf("PUT unknown logger: status %d", rec.Code)
+	}
+
+	// the callback of a replaced TTL doesn't revert the level
+	req = httptest.NewRequest(http.MethodPut, "/?ttl=1h", strings.NewReader(`{"web": "WARNING"}`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	lh := handler.(*levelHandler)
+	stale := lh.reverts["web"]
+	req = httptest.NewRequest(http.MethodPut, "/?ttl=1h", strings.NewReader(`{"web": "INFO"}`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	lh.revert("web", web, stale)
+	if web.Level() != INFO || lh.reverts["web"] == nil {
+		t.Errorf("expected the stale revert to be ignored, got %s", LevelName(web.Level()))
+	}
+	lh.revert("web", web, lh.reverts["web"])
+	if web.Level() != DEBUG {
+		t.Errorf("expected level reverted to DEBUG, got %s", LevelName(web.Level()))
+	}
+
+	web.SetLevel(ERROR)
+	req = httptest.NewRequest(http.MethodPut, "/?ttl=50ms", strings.NewReader(`{"web": "INFO"}`))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if web.Level() != INFO {
+		t.Errorf("expected INFO, got %s", LevelName(web.Level()))
+	}
+	time.Sleep(200 * time.Millisecond)
+	if web.Level() != ERROR {
+		t.Errorf("expected level reverted to ERROR, got %s", LevelName(web.Level()))
+	}
+}
+
+func TestConcurrentSetLevel(t *testing.T) {
+	BasicConfig(BasicConfigOpts{Level: INFO, Writer: ioutil.Discard})
+	defer Shutdown()
+
+	log := GetLogger()
+	done := make(chan bool)
+	go func() {
+		for idx := 0; idx < 1000; idx++ {
+			log.SetLevel(Level(DEBUG + idx%3))
+		}
+		done <- true
+	}()
+	for idx := 0; idx < 1000; idx++ {
+		log.IsEnabled(INFO)
+	}
+	<-done
+
+	log.SetLevel(WARNING)
+	if log.IsEnabled(INFO) || !log.IsEnabled(ERROR) || log.IsEnabled(NOTSET) {
+		t.Error("IsEnabled does not respect the WARNING level")
+	}
+}
+
+func TestLazyArguments(t *testing.T) {
+	var buf bytes.Buffer
+	BasicConfig(BasicConfigOpts{Level: INFO, Writer: &buf, Format: "{message}"})
+
+	calls := 0
+	expensive := func() string {
+		calls++
+		return "computed"
+	}
+
+	log := GetLogger()
+	log.Debug("skipped: %s", expensive)
+	log.Debug("skipped: %v", Lazy(func() interface{} { calls++; return 1 }))
+	log.Info("logged: %s %d", expensive, Lazy(func() interface{} { return 42 }))
+	Shutdown()
+
+	if calls != 1 {
+		t.Errorf("expected 1 evaluation, got %d", calls)
+	}
+	if got := buf.String(); got != "logged: computed 42\n" {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
+
+func TestLoggerWriter(t *testing.T) {
+	var buf bytes.Buffer
+	BasicConfig(BasicConfigOpts{Level: INFO, Writer: &buf, Format: "{level} {message}"})
+
+	w := GetLogger().Writer(ERROR)
+	fmt.Fprint(w, "first line\nsecond ")
+	fmt.Fprint(w, "line 100%\r\nincomplete")
+	Shutdown()
+
+	if got := buf.String(); got != "ERROR first line\nERROR second line 100%\n" {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
+
+func TestRedirectStdLog(t *testing.T) {
+	var buf bytes.Buffer
+	BasicConfig(BasicConfigOpts{Level: INFO, Writer: &buf, Format: "{level} {message}"})
+
+	restore := RedirectStdLog(GetLogger(), INFO)
+	log.Print("plain message")
+	log.Print("[ERROR] went wrong")
+	log.Print("warn: careful")
+	log.Print("[DEBUG] filtered out")
+	log.Print("note: not a level")
+	restore()
+	Shutdown()
+
+	expected := "INFO plain message\nERROR went wrong\nWARNING careful\nINFO note: not a level\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
+
+func TestThemes(t *testing.T) {
+	if c := color.Color256(208); c != "\x1b[38;5;208m" {
+		t.Errorf("unexpected 256 color: %q", c)
+	}
+	if c := color.Hex("#ff8000"); c != "\x1b[38;2;255;128;0m" {
+		t.Errorf("unexpected truecolor: %q", c)
+	}
+
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	if err := formatter.SetTheme("nope"); err == nil {
+		t.Error("no error for unknown theme")
+	}
+	if err := formatter.SetTheme("solarized"); err != nil {
+		t.Fatal(err)
+	}
+	out, _ := formatter.Format(&Record{Level: ERROR, Message: "'x'"})
+	if !strings.HasPrefix(string(out), color.Hex("#dc322f")) || strings.Contains(string(out), color.Hex("#2aa198")) {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	formatter.EnablePatternColoring(true)
+	if err := formatter.SetTheme("solarized"); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ = formatter.Format(&Record{Level: ERROR, Message: "'x'"}); !strings.Contains(string(out), color.Hex("#2aa198")) {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestStripANSI(t *testing.T) {
+	var colored, plain bytes.Buffer
+
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	formatter.EnableLevelColoring(true)
+	formatter.EnablePatternColoring(true)
+
+	coloredHandler, _ := NewStreamHandler(&colored)
+	coloredHandler.SetFormatter(formatter)
+	plainHandler, _ := NewStreamHandler(&plain)
+	plainHandler.SetFormatter(formatter)
+	plainHandler.SetStripANSI(true)
+
+	BasicConfig(BasicConfigOpts{Level: DEBUG, Handlers: []Handler{coloredHandler, plainHandler}})
+	GetLogger().Error("failed: 'quoted' (x)")
+	Shutdown()
+
+	if !strings.Contains(colored.String(), "\x1b[") {
+		t.Errorf("expected colors: %q", colored.String())
+	}
+	if got := plain.String(); got != "ERROR failed: 'quoted' (x)\n" {
+		t.Errorf("unexpected plain output: %q", got)
+	}
+}
+
+func TestPatternColoringNamedGroups(t *testing.T) {
+	formatter, _ := NewTemplateFormatter("{message}")
+	formatter.SetPatternColoring(map[string]string{
+		"quoted": "<q>",
+		"key":    "<k>",
+		"value":  "<v>",
+	}, []PatternColor{
+		{"quoted", regexp.MustCompile(`"[^"]*"`)},
+		{"kv", regexp.MustCompile(`(?P<key>\w+)=(?P<value>\w+)`)},
+	})
+
+	out, _ := formatter.Format(&Record{Level: INFO, Message: `user=bob said "a=b"`})
+	expected := `<k>user$=<v>bob$ said <q>"a=b"$`
+	if got := strings.Replace(string(out), "\x1b[0m", "$", -1); got != expected {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
+
+func TestPatternColoringSinglePass(t *testing.T) {
+	formatter, _ := NewTemplateFormatter("{message}")
+	formatter.EnablePatternColoring(true)
+
+	// the quoted string isn't recolored by the punct and brackets patterns, nor the colors' codes
+	out, _ := formatter.Format(&Record{Level: INFO, Message: `'a.b[1]' (x)`})
+	expected := color.Green + `'a.b[1]'` + colorReset + " " + color.Purple + "(" + colorReset + "x" +
+		color.Purple + ")" + colorReset
+	if string(out) != expected {
+		t.Errorf("unexpected output: %q", out)
+	}
+}
+
+func TestTemplateBraceEscaping(t *testing.T) {
+	formatter, err := NewTemplateFormatter("{{{level}}} {message} }}{{")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, _ := formatter.Format(&Record{Level: INFO, Message: "hello"})
+	if got := string(out); got != "{INFO} hello }{" {
+		t.Errorf("unexpected output: %q", got)
+	}
+
+	for template, offset := range map[string]string{
+		"{level} {nope}":  "offset 8",
+		"{level} } ":      "offset 8",
+		"{message} {name": "offset 10",
+		"{level} {}":      "offset 8",
+	} {
+		if err = formatter.SetFormat(template); err == nil || !strings.HasSuffix(err.Error(), offset) {
+			t.Errorf("%q: expected error at %s, got %v", template, offset, err)
+		}
+	}
+}
+
+func TestTemplateIntrospection(t *testing.T) {
+	formatter, _ := NewTemplateFormatter("{time} {name<20} {level>8} {message}")
+	if got := strings.Join(formatter.Tokens(), ","); got != "time,name,level,message" {
+		t.Errorf("unexpected tokens: %s", got)
+	}
+	if err := formatter.Validate("{timems} {nope}"); err == nil {
+		t.Error("no error for unknown token")
+	}
+	if err := formatter.Validate("{timems} {basename}"); err != nil {
+		t.Error(err)
+	}
+	if formatter.GetFormat() != "{time} {name<20} {level>8} {message}" {
+		t.Error("Validate changed the format")
+	}
+}
+
+func TestFieldWidth(t *testing.T) {
+	for template, expected := range map[string]string{
+		"[{message<6}]":       "[héllo ]",
+		"[{message>6}]":       "[ héllo]",
+		"[{message<3}]":       "[hél]",
+		"[{level>9:0}]":       "[00000INFO]",
+		"[{message>4}|]":      "[héll|]",
+		"[{name<6}{level}":    "[root  INFO",
+		"{level:short}":       "INF",
+		"{level:short,lower}": "inf",
+		"{level:lower}":       "info",
+		"{message:upper}":     "HÉLLO",
+	} {
+		formatter, err := NewTemplateFormatter(template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := formatter.Format(&Record{Level: INFO, Message: "héllo"})
+		if string(out) != expected {
+			t.Errorf("%s: expected %q, got %q", template, expected, out)
+		}
+	}
+
+	for _, template := range []string{"{level:0}", "{name:short}", "{level:loud}"} {
+		if _, err := NewTemplateFormatter(template); err == nil {
+			t.Errorf("%s: no error for invalid modifier", template)
+		}
+	}
+
+	// ANSI sequences don't count, and survive truncation
+	if got := alignField(color.Red+"héllo"+colorReset, tfFieldWidth+(2<<tfFieldWidthShift)); got != color.Red+"hél"+colorReset {
+		t.Errorf("unexpected colored field: %q", got)
+	}
+}
+
+func TestProcessTokens(t *testing.T) {
+	var buf bytes.Buffer
+	BasicConfig(BasicConfigOpts{Level: INFO, Writer: &buf, Format: "{pid} {hostname} {goid} {message}"})
+
+	log := GetLogger()
+	log.Info("without")
+	CaptureGoroutineID(true)
+	log.Info("with")
+	CaptureGoroutineID(false)
+	Shutdown()
+
+	host, _ := os.Hostname()
+	lines := strings.Split(buf.String(), "\n")
+	prefix := fmt.Sprintf("%d %s ", os.Getpid(), host)
+	if lines[0] != prefix+"- without" {
+		t.Errorf("unexpected line: %q", lines[0])
+	}
+	if !regexp.MustCompile(`^` + regexp.QuoteMeta(prefix) + `[1-9]\d* with$`).MatchString(lines[1]) {
+		t.Errorf("unexpected line: %q", lines[1])
+	}
+}
+
+func TestSequenceNumbers(t *testing.T) {
+	var buf bytes.Buffer
+	BasicConfig(BasicConfigOpts{Level: DEBUG, Writer: &buf, Format: "{name} {seq>3:0} {message}"})
+
+	GetLogger().Info("a")
+	GetLogger("seq").Info("b")
+	GetLogger().Debug("c")
+	GetLogger("seq").Info("d")
+	derived := GetLogger("seq").With("", "n", 1)
+	derived.Info("e")
+	derived.WithTags("t").Info("f")
+	GetLogger("seq").Info("g")
+	Shutdown()
+
+	if got := buf.String(); got != "root 001 a\nseq 001 b\nroot 002 c\nseq 002 d\nseq 003 e\nseq 004 f\nseq 005 g\n" {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
+
+func TestZeroPadding(t *testing.T) {
+	formatter, err := NewTemplateFormatter("{seq>8:0}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := formatter.Format(&Record{Level: INFO, Seq: 42}); string(out) != "00000042" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if _, err = NewTemplateFormatter("{seq:0}"); err == nil {
+		t.Error("expected an error zero padding without a width")
+	}
+}
+
+func TestElapsedTokens(t *testing.T) {
+	var buf bytes.Buffer
+	handler, _ := NewStreamHandler(&buf)
+	formatter, _ := NewTemplateFormatter("{delta} {message}")
+	handler.SetFormatter(formatter)
+
+	start := time.Now()
+	handler.Handle(&Record{Time: start, Level: INFO, Message: "first"})
+	handler.Handle(&Record{Time: start.Add(1500 * time.Millisecond), Level: INFO, Message: "second"})
+	handler.ShutdownContext(context.Background())
+
+	if got := buf.String(); got != "+0.000 first\n+1.500 second\n" {
+		t.Errorf("unexpected output: %q", got)
+	}
+
+	formatter.SetFormat("{uptime}")
+	out, _ := formatter.Format(&Record{Time: processStart.Add(2 * time.Second), Level: INFO})
+	if string(out) != "2.000" {
+		t.Errorf("unexpected uptime: %q", out)
+	}
+
+	clock := &testClock{t: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)}
+	SetClock(clock)
+	defer SetClock(nil)
+	out, _ = formatter.Format(&Record{Time: clock.t.Add(3 * time.Second), Level: INFO})
+	if string(out) != "3.000" {
+		t.Errorf("unexpected uptime with a clock: %q", out)
+	}
+}
+
+func TestMultiline(t *testing.T) {
+	formatter, _ := NewTemplateFormatter("{level<7} {message}")
+	rec := &Record{Level: ERROR, Message: "failed\n  at main()\n  at init()"}
+
+	for mode, expected := range map[MultilineMode]string{
+		MultilineAsIs:   "ERROR   failed\n  at main()\n  at init()",
+		MultilineRepeat: "ERROR   failed\nERROR     at main()\nERROR     at init()",
+		MultilineIndent: "ERROR   failed\n        |   at main()\n        |   at init()",
+		MultilinePrefix: "ERROR   failed\n|   at main()\n|   at init()",
+	} {
+		formatter.SetMultiline(mode, "| ")
+		out, _ := formatter.Format(rec)
+		if string(out) != expected {
+			t.Errorf("mode %d: unexpected output: %q", mode, out)
+		}
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	var buf bytes.Buffer
+	handler, _ := NewStreamHandler(&buf)
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	handler.SetMaxLength(10, "...")
+
+	handler.Handle(&Record{Level: INFO, Message: "short"})
+	handler.Handle(&Record{Level: INFO, Message: "ääääääää"}) // 16 bytes
+	handler.Handle(&Record{Level: INFO, Message: "0123456789abc"})
+	handler.ShutdownContext(context.Background())
+
+	if got := buf.String(); got != "short\näää...\n0123456...\n" {
+		t.Errorf("unexpected output: %q", got)
+	}
+
+	colored := []byte("ab" + color.Red + "cdefgh")
+	if got := string(truncateRecord(colored, 6, []byte("~"))); got != "ab~" {
+		t.Errorf("unexpected colored output: %q", got)
+	}
+	colored = []byte("ab" + color.Red + "cdefghij")
+	if got := string(truncateRecord(colored, 15, []byte("~"))); got != "ab"+color.Red+"c~"+colorReset || len(got) > 15 {
+		t.Errorf("unexpected colored output: %q", got)
+	}
+}
+
+func TestECSFormatter(t *testing.T) {
+	var buf bytes.Buffer
+	BasicConfig(BasicConfigOpts{Level: INFO, Writer: &buf})
+	formatter := NewECSFormatter()
+	formatter.ServiceName = "shop"
+	GetLogger().Handlers()[0].SetFormatter(formatter)
+
+	GetLogger("db").Error("query <failed>", Fields{
+		"error":    errors.New("timeout"),
+		"trace_id": "abc123",
+		"rows":     3,
+	})
+	Shutdown()
+
+	var doc map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("%v: %s", err, buf.String())
+	}
+	for key, expected := range map[string]interface{}{
+		"log.level":     "error",
+		"log.logger":    "db",
+		"message":       "query <failed>",
+		"error.message": "timeout",
+		"error.type":    "*errors.errorString",
+		"trace.id":      "abc123",
+		"rows":          float64(3),
+		"service.name":  "shop",
+		"ecs.version":   ECSVersion,
+	} {
+		if doc[key] != expected {
+			t.Errorf("%s: expected %v, got %v", key, expected, doc[key])
+		}
+	}
+	if _, err := time.Parse(time.RFC3339, doc["@timestamp"].(string)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRFC5424Formatter(t *testing.T) {
+	formatter := NewRFC5424Formatter()
+	formatter.AppName = "shop"
+	ts := time.Date(2024, 5, 1, 12, 30, 0, 123456000, time.UTC)
+
+	out, _ := formatter.Format(&Record{Time: ts, Name: "db", Level: ERROR, Message: "query failed",
+		Fields: Fields{
+			"table":                 "orders",
+			"sql":                   `"x"]`,
+			"origin@32473":          Fields{"ip": "10.0.0.1"},
+			"name with spaces=bad]": 1,
+		}})
+	expected := fmt.Sprintf(`<11>1 2024-05-01T12:30:00.123456Z %s shop %d db `, hostname, os.Getpid()) +
+		`[fields@32473 name_with_spaces_bad_="1" sql="\"x\"\]" table="orders"][origin@32473 ip="10.0.0.1"] query failed`
+	if string(out) != expected {
+		t.Errorf("unexpected output:\n%s\n%s", out, expected)
+	}
+
+	out, _ = formatter.Format(&Record{Time: ts, Level: INFO, Message: "plain"})
+	if !strings.HasSuffix(string(out), " - - plain") || !strings.HasPrefix(string(out), "<14>1 ") {
+		t.Errorf("unexpected output: %s", out)
+	}
+}
+
+func TestCSVFormatter(t *testing.T) {
+	formatter := NewCSVFormatter("level", "message", "user", "fields.missing")
+	if got := string(formatter.Header()); got != "level,message,user,fields.missing" {
+		t.Errorf("unexpected header: %q", got)
+	}
+	out, _ := formatter.Format(&Record{Level: WARNING, Message: `say "hi", bob`, Fields: Fields{"user": 7}})
+	if got := string(out); got != `WARNING,"say ""hi"", bob",7,` {
+		t.Errorf("unexpected row: %q", got)
+	}
+
+	out, _ = NewTSVFormatter("name", "message").Format(&Record{Level: INFO, Message: "a\tb"})
+	if got := string(out); got != "root\t\"a\tb\"" {
+		t.Errorf("unexpected TSV row: %q", got)
+	}
+}
+
+func TestMsgpackFormatter(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 5, time.UTC)
+	rec := &Record{Time: ts, Name: "db/pool", Level: INFO, Message: "ok", Seq: 9, Fields: Fields{"n": 1}}
+
+	out, _ := NewMsgpackFormatter().Format(rec)
+	v, rest, err := msgpack.Decode(out)
+	if err != nil || len(rest) != 0 {
+		t.Fatal(err, rest)
+	}
+	m := v.(map[string]interface{})
+	if m["logger"] != "db/pool" || m["level"] != "INFO" || m["message"] != "ok" || m["n"] != int64(1) || m["time"] != ts.Format(time.RFC3339Nano) {
+		t.Errorf("unexpected map: %v", m)
+	}
+
+	out, _ = NewFluentMsgpackFormatter("app").Format(rec)
+	v, _, err = msgpack.Decode(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := v.([]interface{})
+	if msg[0] != "app.db.pool" || !msg[1].(time.Time).Equal(ts) {
+		t.Errorf("unexpected forward message: %v", msg)
+	}
+	if _, hasTime := msg[2].(map[string]interface{})["time"]; hasTime {
+		t.Error("unexpected time key in forward record")
+	}
+}
+
+// fakeFluentd accepts a single connection, performs the shared key handshake and acks
+// PackedForward messages, sending the decoded entries' records on the returned channel.
+func fakeFluentd(t *testing.T, sharedKey string) (string, chan map[string]interface{}) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make(chan map[string]interface{}, 10)
+
+	go func() {
+		defer ln.Close()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		h := &FluentHandler{conn: conn}
+
+		nonce := []byte("nonce")
+		helo := msgpack.Append(nil, []interface{}{"HELO", map[string]interface{}{"nonce": nonce, "auth": []byte{}, "keepalive": true}})
+		conn.Write(helo)
+		v, err := h.read()
+		if err != nil {
+			return
+		}
+		ping := v.([]interface{})
+		salt := ping[2].([]byte)
+		ok := ping[3] == sha512Hex(salt, []byte(ping[1].(string)), nonce, []byte(sharedKey))
+		conn.Write(msgpack.Append(nil, []interface{}{"PONG", ok, "", "server", sha512Hex(salt, []byte("server"), nonce, []byte(sharedKey))}))
+
+		for {
+			v, err := h.read()
+			if err != nil {
+				close(records)
+				return
+			}
+			msg := v.([]interface{})
+			entries := msg[1].([]byte)
+			for len(entries) > 0 {
+				var entry interface{}
+				entry, entries, _ = msgpack.Decode(entries)
+				record := entry.([]interface{})[1].(map[string]interface{})
+				record["tag"] = msg[0]
+				records <- record
+			}
+			option := msg[2].(map[string]interface{})
+			conn.Write(msgpack.Append(nil, map[string]interface{}{"ack": option["chunk"]}))
+		}
+	}()
+	return ln.Addr().String(), records
+}
+
+func TestFluentHandler(t *testing.T) {
+	address, records := fakeFluentd(t, "secret")
+
+	handler, err := NewFluentHandler(FluentConfig{
+		Address:    address,
+		TagPrefix:  "app",
+		SharedKey:  "secret",
+		RequireAck: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Handle(&Record{Time: time.Now(), Name: "db", Level: INFO, Message: "first", Fields: Fields{"n": 1}})
+	handler.Handle(&Record{Time: time.Now(), Name: "db", Level: ERROR, Message: "second"})
+	handler.Shutdown()
+
+	var got []string
+	for record := range records {
+		got = append(got, fmt.Sprintf("%s %s %s", record["tag"], record["level"], record["message"]))
+	}
+	if strings.Join(got, ",") != "app.db INFO first,app.db ERROR second" {
+		t.Errorf("unexpected records: %v", got)
+	}
+}
+
+func TestCloudWatchHandler(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		actions []string
+		stream  bool
+		token   = "token-1"
+		events  []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			t.Errorf("unsigned request: %v", r.Header)
+		}
+		action := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "Logs_20140328.")
+		actions = append(actions, action)
+
+		var input struct {
+			SequenceToken string
+			LogEvents     []cloudWatchEvent
+		}
+		_ = json.NewDecoder(r.Body).Decode(&input)
+
+		fail := func(errorType string) {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{
+				"__type":                "com.amazonaws.logs#" + errorType,
+				"expectedSequenceToken": token,
+			})
+		}
+		switch action {
+		case "CreateLogStream":
+			stream = true
+		case "PutLogEvents":
+			if !stream {
+				fail("ResourceNotFoundException")
+				return
+			}
+			if input.SequenceToken != token {
+				fail("InvalidSequenceTokenException")
+				return
+			}
+			for _, e := range input.LogEvents {
+				events = append(events, e.Message)
+			}
+			token = "token-2"
+			_ = json.NewEncoder(w).Encode(map[string]string{"nextSequenceToken": token})
+		}
+	}))
+	defer server.Close()
+
+	handler, err := NewCloudWatchHandler(CloudWatchConfig{
+		Region:          "eu-west-1",
+		LogGroup:        "app",
+		LogStream:       "test",
+		AccessKeyID:     "AKID",
+		SecretAccessKey: "secret",
+		Endpoint:        server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	handler.SetFormatter(formatter)
+
+	now := time.Now()
+	handler.Handle(&Record{Time: now.Add(time.Second), Level: ERROR, Message: "second"})
+	handler.Handle(&Record{Time: now, Level: INFO, Message: "first"})
+	handler.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got := strings.Join(actions, ","); got != "CreateLogStream,PutLogEvents,PutLogEvents" {
+		t.Errorf("unexpected calls: %s", got)
+	}
+	if got := strings.Join(events, ","); got != "INFO first,ERROR second" {
+		t.Errorf("unexpected events: %s", got)
+	}
+}
+
+func TestGCPHandler(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		request map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/computeMetadata/v1/instance/service-accounts/default/token":
+			_, _ = io.WriteString(w, `{"access_token": "tok", "expires_in": 3600}`)
+		case "/v2/entries:write":
+			if r.Header.Get("Authorization") != "Bearer tok" {
+				t.Errorf("unexpected authorization: %q", r.Header.Get("Authorization"))
+			}
+			mu.Lock()
+			_ = json.NewDecoder(r.Body).Decode(&request)
+			mu.Unlock()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	defer os.Setenv("GCE_METADATA_HOST", os.Getenv("GCE_METADATA_HOST"))
+	defer os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	os.Setenv("GCE_METADATA_HOST", strings.TrimPrefix(server.URL, "http://"))
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+
+	handler, err := NewGCPHandler(GCPConfig{
+		ProjectID:      "proj",
+		LogID:          "app",
+		ResourceType:   "generic_node",
+		ResourceLabels: map[string]string{"node_id": "n1"},
+		Endpoint:       server.URL + "/v2/entries:write",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	header := http.Header{}
+	header.Set("X-Cloud-Trace-Context", "105445aa7843bc8bf206b12000100000/1;o=1")
+	fields := TraceFields(header)
+	fields["user"] = "bob"
+	handler.Handle(&Record{Time: time.Now(), Name: "api", Level: FATAL, Message: "down", Fields: fields})
+	handler.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if request["logName"] != "projects/proj/logs/app" {
+		t.Errorf("unexpected log name: %v", request["logName"])
+	}
+	if resource, _ := request["resource"].(map[string]interface{}); resource["type"] != "generic_node" {
+		t.Errorf("unexpected resource: %v", resource)
+	}
+	entries, _ := request["entries"].([]interface{})
+	if len(entries) != 1 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	entry := entries[0].(map[string]interface{})
+	payload := entry["jsonPayload"].(map[string]interface{})
+	if entry["severity"] != "CRITICAL" || payload["message"] != "down" || payload["logger"] != "api" || payload["user"] != "bob" {
+		t.Errorf("unexpected entry: %v", entry)
+	}
+	if entry["trace"] != "projects/proj/traces/105445aa7843bc8bf206b12000100000" ||
+		entry["spanId"] != "0000000000000001" || entry["traceSampled"] != true {
+		t.Errorf("unexpected trace correlation: %v", entry)
+	}
+}
+
+func TestDatadogHandler(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests int
+		logs     []map[string]interface{}
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("DD-API-KEY") != "key" || r.Header.Get("Content-Encoding") != "gzip" {
+			t.Errorf("unexpected headers: %v", r.Header)
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = json.NewDecoder(zr).Decode(&logs); err != nil {
+			t.Error(err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	handler, err := NewDatadogHandler(DatadogConfig{
+		APIKey:   "key",
+		Endpoint: server.URL,
+		Service:  "api",
+		Tags:     []string{"env:test", "team:core"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Handle(&Record{Time: time.Now(), Name: "db", Level: WARNING, Message: "slow", Fields: Fields{"ms": 250}})
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "failed", Fields: Fields{"err": errors.New("boom")}})
+	handler.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 2 || len(logs) != 2 {
+		t.Fatalf("expected a retried request with 2 logs, got %d requests: %v", requests, logs)
+	}
+	if logs[0]["message"] != "slow" || logs[0]["status"] != "warning" || logs[0]["ms"] != 250.0 ||
+		logs[0]["service"] != "api" || logs[0]["ddtags"] != "env:test,team:core" {
+		t.Errorf("unexpected log: %v", logs[0])
+	}
+	if e, _ := logs[1]["error"].(map[string]interface{}); e["message"] != "boom" || logs[1]["status"] != "error" {
+		t.Errorf("unexpected log: %v", logs[1])
+	}
+}
+
+func TestBatchHandlerCircuitBreaker(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	handler, err := NewDatadogHandler(DatadogConfig{APIKey: "key", Endpoint: server.URL, BatchSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dropped []error
+	SetErrorHandler(func(h Handler, err error) { dropped = append(dropped, err) })
+	defer SetErrorHandler(nil)
+	handler.SetCircuitBreaker(CircuitBreakerOptions{FailureThreshold: 1, MinBackoff: time.Minute})
+
+	// the failed request opens the circuit, failing the retry and the next batch
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "first"})
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "second"})
+	handler.Shutdown()
+
+	if n := atomic.LoadInt32(&requests); n != 1 {
+		t.Errorf("%d requests, expected 1", n)
+	}
+	if len(dropped) != 2 {
+		t.Fatalf("unexpected errors: %v", dropped)
+	}
+	if err, ok := dropped[1].(*DroppedError); !ok || err.Err != ErrCircuitOpen {
+		t.Errorf("unexpected error: %v", dropped[1])
+	}
+	if stats := handler.handlerStats(); stats.Dropped != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// fakeNATS accepts a single connection, acking JetStream publications, and sends the
+// published "subject payload" strings to the returned channel.
+func fakeNATS(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := make(chan string, 10)
+
+	go func() {
+		defer close(published)
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		_, _ = io.WriteString(conn, "INFO {\"max_payload\":1048576}\r\n")
+		r := bufio.NewReader(conn)
+		seq := 0
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			fields := strings.Fields(line)
+			switch fields[0] {
+			case "PING":
+				_, _ = io.WriteString(conn, "PONG\r\n")
+			case "PUB":
+				size, _ := strconv.Atoi(fields[len(fields)-1])
+				payload := make([]byte, size+2)
+				if _, err = io.ReadFull(r, payload); err != nil {
+					return
+				}
+				published <- fields[1] + " " + string(payload[:size])
+				if len(fields) == 4 {
+					seq++
+					ack := fmt.Sprintf(`{"stream":"LOGS","seq":%d}`, seq)
+					_, _ = fmt.Fprintf(conn, "MSG %s 1 %d\r\n%s\r\n", fields[2], len(ack), ack)
+				}
+			}
+		}
+	}()
+
+	return listener.Addr().String(), published
+}
+
+func TestNATSHandler(t *testing.T) {
+	address, published := fakeNATS(t)
+
+	handler, err := NewNATSHandler(NATSConfig{
+		URL:       "nats://" + address,
+		Subject:   "app.{level}.{name}",
+		JetStream: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+
+	handler.Handle(&Record{Time: time.Now(), Name: "db/pool", Level: INFO, Message: "first"})
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "second"})
+	handler.Shutdown()
+
+	var got []string
+	for msg := range published {
+		got = append(got, msg)
+	}
+	if strings.Join(got, ",") != "app.info.db.pool first,app.error.root second" {
+		t.Errorf("unexpected publications: %v", got)
+	}
+}
+
+// fakeAMQPBroker accepts a single connection with publisher confirms, acking every two
+// messages at once, and sends the published "routing-key body" strings to the returned channel.
+func fakeAMQPBroker(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	published := make(chan string, 10)
+
+	go func() {
+		defer close(published)
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		header := make([]byte, 8)
+		if _, err = io.ReadFull(r, header); err != nil || string(header) != amqp.ProtocolHeader {
+			t.Errorf("unexpected protocol header: %q", header)
+			return
+		}
+		send := func(method *amqp.Encoder, channel uint16) {
+			_, _ = conn.Write(amqp.AppendFrame(nil, amqp.FrameMethod, channel, method.Bytes()))
+		}
+		send(amqp.NewMethod(amqp.ClassConnection, amqp.ConnectionStart).
+			Octet(0).Octet(9).Table(nil).LongStr("PLAIN").LongStr("en_US"), 0)
+
+		var (
+			routingKey string
+			body       []byte
+			size       uint64
+			tag        uint64
+		)
+		for {
+			frame, err := amqp.ReadFrame(r)
+			if err != nil {
+				return
+			}
+			switch frame.Type {
+			case amqp.FrameHeader:
+				size = binary.BigEndian.Uint64(frame.Payload[4:])
+				body = body[:0]
+				continue
+			case amqp.FrameBody:
+				body = append(body, frame.Payload...)
+				if uint64(len(body)) == size {
+					published <- routingKey + " " + string(body)
+					if tag++; tag%2 == 0 {
+						send(amqp.NewMethod(amqp.ClassBasic, amqp.BasicAck).LongLong(tag).Octet(1), 1)
+					}
+				}
+				continue
+			}
+
+			class, method, args, _ := amqp.Method(frame.Payload)
+			switch {
+			case class == amqp.ClassConnection && method == amqp.ConnectionStartOk:
+				args.LongStr() // client properties
+				if mechanism, response := args.ShortStr(), args.LongStr(); mechanism != "PLAIN" || response != "\x00user\x00pass" {
+					t.Errorf("unexpected credentials: %s %q", mechanism, response)
+				}
+				send(amqp.NewMethod(amqp.ClassConnection, amqp.ConnectionTune).Short(0).Long(0).Short(0), 0)
+			case class == amqp.ClassConnection && method == amqp.ConnectionOpen:
+				if vhost := args.ShortStr(); vhost != "logs" {
+					t.Errorf("unexpected vhost: %s", vhost)
+				}
+				send(amqp.NewMethod(amqp.ClassConnection, amqp.ConnectionOpenOk).ShortStr(""), 0)
+			case class == amqp.ClassChannel && method == amqp.ChannelOpen:
+				send(amqp.NewMethod(amqp.ClassChannel, amqp.ChannelOpenOk).LongStr(""), 1)
+			case class == amqp.ClassConfirm && method == amqp.ConfirmSelect:
+				send(amqp.NewMethod(amqp.ClassConfirm, amqp.ConfirmSelectOk), 1)
+			case class == amqp.ClassBasic && method == amqp.BasicPublish:
+				args.Short()
+				if exchange := args.ShortStr(); exchange != "logs" {
+					t.Errorf("unexpected exchange: %s", exchange)
+				}
+				routingKey = args.ShortStr()
+			}
+		}
+	}()
+
+	return listener.Addr().String(), published
+}
+
+func TestAMQPHandler(t *testing.T) {
+	address, published := fakeAMQPBroker(t)
+
+	handler, err := NewAMQPHandler(AMQPConfig{
+		URL:      "amqp://user:pass@" + address + "/logs",
+		Exchange: "logs",
+		Confirm:  true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+
+	handler.Handle(&Record{Time: time.Now(), Name: "db/pool", Level: INFO, Message: "first"})
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: strings.Repeat("x", 200000)})
+	handler.Shutdown()
+
+	var got []string
+	for msg := range published {
+		got = append(got, msg)
+	}
+	if len(got) != 2 || got[0] != "db.pool.info first" || got[1] != "root.error "+strings.Repeat("x", 200000) {
+		t.Errorf("unexpected publications: %.100v", got)
+	}
+}
+
+// fakeRedis replies to the commands of a single connection, and sends them (space
+// separated) to the returned channel.
+func fakeRedis(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commands := make(chan string, 10)
+
+	go func() {
+		defer close(commands)
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		for {
+			var args []string
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			count, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+			for i := 0; i < count; i++ {
+				line, _ = r.ReadString('\n')
+				size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+				arg := make([]byte, size+2)
+				if _, err = io.ReadFull(r, arg); err != nil {
+					return
+				}
+				args = append(args, string(arg[:size]))
+			}
+
+			commands <- strings.Join(args, " ")
+			switch args[0] {
+			case "XADD":
+				_, _ = io.WriteString(conn, "$3\r\n1-0\r\n")
+			case "RPUSH":
+				_, _ = io.WriteString(conn, ":1\r\n")
+			default:
+				_, _ = io.WriteString(conn, "+OK\r\n")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), commands
+}
+
+func TestRedisHandler(t *testing.T) {
+	address, commands := fakeRedis(t)
+	handler, err := NewRedisHandler(RedisConfig{
+		Address:  address,
+		Password: "secret",
+		DB:       2,
+		Key:      "logs:{name}",
+		MaxLen:   1000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Handle(&Record{Time: time.Unix(0, 0), Name: "db", Level: INFO, Message: "connected"})
+	handler.Shutdown()
+
+	var got []string
+	for command := range commands {
+		got = append(got, command)
+	}
+	expected := "AUTH secret,SELECT 2," +
+		"XADD logs:db MAXLEN ~ 1000 * time 1970-01-01T00:00:00Z level INFO logger db message connected"
+	if strings.Join(got, ",") != expected {
+		t.Errorf("unexpected commands: %q", got)
+	}
+
+	address, commands = fakeRedis(t)
+	handler, err = NewRedisHandler(RedisConfig{
+		Address: address,
+		List:    true,
+		MaxLen:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	handler.SetFormatter(formatter)
+	handler.Handle(&Record{Time: time.Now(), Level: INFO, Message: "first"})
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "second"})
+	handler.Shutdown()
+
+	got = got[:0]
+	for command := range commands {
+		got = append(got, command)
+	}
+	if strings.Join(got, ",") != "RPUSH logs INFO first,RPUSH logs ERROR second,LTRIM logs -10 -1" {
+		t.Errorf("unexpected commands: %q", got)
+	}
+}
+
+// recordingDriver is a database/sql driver recording the statements executed, queries
+// return rows.
+type recordingDriver struct {
+	mu   sync.Mutex
+	logs []string
+	rows [][]driver.Value
+}
+
+// recordingDrivers are the drivers of TestDBHandler and TestSQLiteHandler, registered once
+// (sql.Register panics when called twice, e.g. with -count=2).
+var recordingDrivers = map[string]*recordingDriver{
+	"log4go-recording": {},
+	"log4go-sqlite":    {},
+}
+
+func init() {
+	for name, d := range recordingDrivers {
+		sql.Register(name, d)
+	}
+}
+
+// reset clears the driver's statements and rows, for a new test.
+func (d *recordingDriver) reset() *recordingDriver {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.logs = nil
+	d.rows = nil
+	return d
+}
+
+func (d *recordingDriver) log(format string, args ...interface{}) {
+	d.mu.Lock()
+	d.logs = append(d.logs, fmt.Sprintf(format, args...))
+	d.mu.Unlock()
+}
+
+func (d *recordingDriver) Open(string) (driver.Conn, error) { return recordingConn{d}, nil }
+
+type recordingConn struct{ d *recordingDriver }
+
+func (c recordingConn) Prepare(query string) (driver.Stmt, error) {
+	return recordingStmt{c.d, query}, nil
+}
+func (c recordingConn) Close() error              { return nil }
+func (c recordingConn) Begin() (driver.Tx, error) { c.d.log("BEGIN"); return recordingTx{c.d}, nil }
+
+type recordingTx struct{ d *recordingDriver }
+
+func (tx recordingTx) Commit() error   { tx.d.log("COMMIT"); return nil }
+func (tx recordingTx) Rollback() error { tx.d.log("ROLLBACK"); return nil }
+
+type recordingStmt struct {
+	d     *recordingDriver
+	query string
+}
+
+func (s recordingStmt) Close() error  { return nil }
+func (s recordingStmt) NumInput() int { return -1 }
+func (s recordingStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.log("%s %v", s.query, args)
+	return driver.RowsAffected(1), nil
+}
+func (s recordingStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.log("%s %v", s.query, args)
+	return &recordingRows{rows: s.d.rows}, nil
+}
+
+type recordingRows struct {
+	rows [][]driver.Value
+}
+
+func (r *recordingRows) Columns() []string {
+	if len(r.rows) == 0 {
+		return nil
+	}
+	return make([]string, len(r.rows[0]))
+}
+func (r *recordingRows) Close() error { return nil }
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestDBHandler(t *testing.T) {
+	d := recordingDrivers["log4go-recording"].reset()
+	db, err := sql.Open("log4go-recording", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	handler, err := NewDBHandler(DBConfig{
+		DB:          db,
+		Table:       "app_logs",
+		Placeholder: "$",
+		CreateTable: true,
+		Columns: []DBColumn{
+			{Name: "lvl", Value: "level_no"},
+			{Name: "msg", Value: "message"},
+			{Name: "user_id", Value: "user"},
+			{Name: "extra", Value: "fields"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Handle(&Record{Time: time.Now(), Level: INFO, Message: "login", Fields: Fields{"user": 42}})
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "failed"})
+	handler.Shutdown()
+
+	expected := []string{
+		"CREATE TABLE IF NOT EXISTS app_logs (lvl BIGINT, msg TEXT, user_id VARCHAR(255), extra TEXT) []",
+		"BEGIN",
+		`INSERT INTO app_logs (lvl, msg, user_id, extra) VALUES ($1, $2, $3, $4) [3 login 42 {"user":42}]`,
+		"INSERT INTO app_logs (lvl, msg, user_id, extra) VALUES ($1, $2, $3, $4) [5 failed <nil> <nil>]",
+		"COMMIT",
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if strings.Join(d.logs, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("unexpected statements:\n%s", strings.Join(d.logs, "\n"))
+	}
+}
+
+func TestSQLiteHandler(t *testing.T) {
+	d := recordingDrivers["log4go-sqlite"].reset()
+	db, err := sql.Open("log4go-sqlite", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	handler, err := NewSQLiteHandler(SQLiteConfig{DB: db, MaxRows: 500})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Handle(&Record{Time: time.Unix(0, 100), Name: "db", Level: INFO, Message: "hi", Seq: 1})
+	handler.Shutdown()
+
+	d.mu.Lock()
+	d.rows = [][]driver.Value{{int64(100), int64(INFO), "db/pool", "hi", `{"n":1}`, int64(1)}}
+	d.logs = d.logs[:0]
+	d.mu.Unlock()
+
+	records, err := handler.Query(context.Background(), LogQuery{MinLevel: INFO, From: time.Unix(0, 50), Logger: "db", Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Name != "db/pool" || records[0].Time.UnixNano() != 100 || records[0].Fields["n"] != 1.0 {
+		t.Errorf("unexpected records: %+v", records)
+	}
+	query := `SELECT time_ns, level, logger, message, fields, seq FROM logs ` +
+		`WHERE level >= ? AND time_ns >= ? AND (logger = ? OR logger LIKE ? ESCAPE '\') ` +
+		`ORDER BY time_ns DESC LIMIT 10 [3 50 db db/%]`
+	if len(d.logs) != 1 || d.logs[0] != query {
+		t.Errorf("unexpected query: %q", d.logs)
+	}
+}
+
+func TestGRPCHandler(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		received []string
+	)
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != GRPCExportMethod || r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("unexpected request: %s %v", r.URL.Path, r.Header)
+		}
+		count := 0
+		for {
+			header := make([]byte, 5)
+			if _, err := io.ReadFull(r.Body, header); err != nil {
+				break
+			}
+			message := make([]byte, binary.BigEndian.Uint32(header[1:]))
+			if _, err := io.ReadFull(r.Body, message); err != nil {
+				t.Error(err)
+				return
+			}
+			fields, err := protowire.Decode(message)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			var parts []string
+			for _, f := range fields {
+				switch f.Number {
+				case 2, 4, 5:
+					parts = append(parts, string(f.Bytes))
+				case 6:
+					entry, _ := protowire.Decode(f.Bytes)
+					parts = append(parts, string(entry[0].Bytes)+"="+string(entry[1].Bytes))
+				}
+			}
+			mu.Lock()
+			received = append(received, strings.Join(parts, " "))
+			mu.Unlock()
+			count++
+		}
+
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		summary := protowire.AppendUint(nil, 1, uint64(count))
+		_, _ = w.Write(append([]byte{0, 0, 0, 0, byte(len(summary))}, summary...))
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	server.EnableHTTP2 = true
+	server.StartTLS()
+	defer server.Close()
+
+	handler, err := NewGRPCHandler(GRPCConfig{
+		Address:   server.Listener.Addr().String(),
+		TLSConfig: server.Client().Transport.(*http.Transport).TLSClientConfig,
+		Metadata:  map[string]string{"authorization": "Bearer token"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Handle(&Record{Time: time.Now(), Name: "db", Level: INFO, Message: "first", Fields: Fields{"n": 1}})
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "second"})
+	handler.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(received, ",") != "db INFO first n=1,root ERROR second" {
+		t.Errorf("unexpected records: %q", received)
+	}
+}
+
+func TestWebSocketHandler(t *testing.T) {
+	handler, err := NewWebSocketHandler()
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{level} {name}: {message}")
+	handler.SetFormatter(formatter)
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	conn, err := websocket.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/?level=info&logger=db", "", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for handler.Clients() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	handler.Handle(&Record{Time: time.Now(), Name: "db/pool", Level: DEBUG, Message: "too low"})
+	handler.Handle(&Record{Time: time.Now(), Name: "http", Level: ERROR, Message: "other logger"})
+	handler.Handle(&Record{Time: time.Now(), Name: "db/pool", Level: INFO, Message: "connected"})
+	handler.Handle(&Record{Time: time.Now(), Name: "db", Level: ERROR, Message: "failed"})
+	handler.Shutdown()
+
+	var got []string
+	for {
+		var msg string
+		if err = websocket.Message.Receive(conn, &msg); err != nil {
+			break
+		}
+		got = append(got, msg)
+	}
+	if strings.Join(got, ",") != "INFO db/pool: connected,ERROR db: failed" {
+		t.Errorf("unexpected messages: %q", got)
+	}
+}
+
+// protoFields returns the fields of the message with the given number.
+func protoFields(t *testing.T, message []byte, number int) [][]byte {
+	fields, err := protowire.Decode(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values [][]byte
+	for _, f := range fields {
+		if f.Number == number {
+			if f.WireType == protowire.BytesType {
+				values = append(values, f.Bytes)
+			} else {
+				values = append(values, []byte(strconv.FormatUint(f.Value, 10)))
+			}
+		}
+	}
+	return values
+}
+
+func TestOTLPHandler(t *testing.T) {
+	requests := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/logs" || r.Header.Get("Content-Type") != "application/x-protobuf" ||
+			r.Header.Get("Api-Key") != "secret" {
+			t.Errorf("unexpected request: %s %v", r.URL.Path, r.Header)
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(zr)
+		requests <- body
+	}))
+	defer server.Close()
+
+	handler, err := NewOTLPHandler(OTLPConfig{
+		Endpoint:           server.URL + "/v1/logs",
+		Headers:            map[string]string{"api-key": "secret"},
+		Compression:        "gzip",
+		ServiceName:        "api",
+		ResourceAttributes: map[string]string{"deployment.environment": "test"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.Handle(&Record{Time: time.Unix(1, 0), Name: "db", Level: WARNING, Message: "slow", Fields: Fields{
+		"ms":       250,
+		"trace_id": "0102030405060708090a0b0c0d0e0f10",
+	}})
+	handler.Shutdown()
+
+	request := <-requests
+	resourceLogs := protoFields(t, request, 1)[0]
+	resource := protoFields(t, resourceLogs, 1)[0]
+	var attributes []string
+	for _, kv := range protoFields(t, resource, 1) {
+		attributes = append(attributes, string(protoFields(t, kv, 1)[0]))
+	}
+	if strings.Join(attributes, ",") != "deployment.environment,host.name,process.pid,service.name" {
+		t.Errorf("unexpected resource attributes: %v", attributes)
+	}
+
+	scopeLogs := protoFields(t, resourceLogs, 2)[0]
+	if scope := protoFields(t, protoFields(t, scopeLogs, 1)[0], 1); string(scope[0]) != "db" {
+		t.Errorf("unexpected scope: %q", scope)
+	}
+	record := protoFields(t, scopeLogs, 2)[0]
+	if severity := string(protoFields(t, record, 2)[0]); severity != "13" {
+		t.Errorf("unexpected severity: %s", severity)
+	}
+	if body := string(protoFields(t, protoFields(t, record, 5)[0], 1)[0]); body != "slow" {
+		t.Errorf("unexpected body: %s", body)
+	}
+	if traceID := protoFields(t, record, 9); len(traceID) != 1 || hex.EncodeToString(traceID[0]) != "0102030405060708090a0b0c0d0e0f10" {
+		t.Errorf("unexpected trace ID: %x", traceID)
+	}
+	kv := protoFields(t, record, 6)
+	if len(kv) != 1 || string(protoFields(t, kv[0], 1)[0]) != "ms" || string(protoFields(t, protoFields(t, kv[0], 2)[0], 3)[0]) != "250" {
+		t.Errorf("unexpected attributes: %q", kv)
+	}
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestReadStats(t *testing.T) {
+	var buf bytes.Buffer
+	formatter, _ := NewTemplateFormatter("{message}")
+	good, _ := NewStreamHandler(&buf)
+	good.SetFormatter(formatter)
+	bad, _ := NewStreamHandler(failingWriter{})
+	bad.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{good}})
+	log := GetLogger("stats")
+	log.SetLevel(INFO)
+	if err := log.AddHandler(bad); err != nil {
+		t.Fatal(err)
+	}
+
+	log.Info("one")
+	log.Warning("two")
+	log.Debug("filtered out")
+	GetLogger().Error("three")
+	Shutdown()
+
+	stats := ReadStats()
+	if len(stats.Loggers) != 2 {
+		t.Fatalf("unexpected loggers: %+v", stats.Loggers)
+	}
+	if root := stats.Loggers[0]; root.Name != "root" || len(root.Logged) != 1 || root.Logged[ERROR] != 1 {
+		t.Errorf("unexpected root stats: %+v", root)
+	}
+	if sub := stats.Loggers[1]; sub.Name != "stats" || len(sub.Logged) != 2 || sub.Logged[INFO] != 1 || sub.Logged[WARNING] != 1 {
+		t.Errorf("unexpected stats logger stats: %+v", sub)
+	}
+
+	if len(stats.Handlers) != 2 {
+		t.Fatalf("unexpected handlers: %+v", stats.Handlers)
+	}
+	if h := stats.Handlers[0]; h.Name != "StreamHandler" || h.Handled != 3 || h.Dropped != 0 || h.Errors != 0 ||
+		h.Writes != 3 || h.QueueCapacity != 100 {
+		t.Errorf("unexpected root handler stats: %+v", h)
+	}
+	if h := stats.Handlers[1]; h.Name != "StreamHandler#2" || h.Handled != 0 || h.Dropped != 2 || h.Errors != 2 {
+		t.Errorf("unexpected failing handler stats: %+v", h)
+	}
+}
+
+func TestPublishExpvar(t *testing.T) {
+	formatter, _ := NewTemplateFormatter("{message}")
+	bad, _ := NewStreamHandler(failingWriter{})
+	bad.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{bad}})
+	PublishExpvar("log4go_test")
+	PublishExpvar("log4go_test") // no-op
+
+	GetLogger().Info("one")
+	GetLogger().Warning("two")
+	Shutdown()
+
+	var vars struct {
+		Logged   map[string]uint64
+		Dropped  uint64
+		Errors   uint64
+		Handlers map[string]struct {
+			Errors    uint64
+			LastError string `json:"last_error"`
+		}
+	}
+	if err := json.Unmarshal([]byte(expvar.Get("log4go_test").String()), &vars); err != nil {
+		t.Fatal(err)
+	}
+	if vars.Logged["INFO"] != 1 || vars.Logged["WARNING"] != 1 || vars.Dropped != 2 || vars.Errors != 2 {
+		t.Errorf("unexpected totals: %+v", vars)
+	}
+	if h := vars.Handlers["StreamHandler"]; h.Errors != 2 || h.LastError != "disk full" {
+		t.Errorf("unexpected handler vars: %+v", h)
+	}
+}
+
+func TestErrorHandler(t *testing.T) {
+	formatter, _ := NewTemplateFormatter("{message}")
+	bad, _ := NewStreamHandler(failingWriter{})
+	bad.SetFormatter(formatter)
+
+	var mu sync.Mutex
+	var reported []error
+	SetErrorHandler(func(h Handler, err error) {
+		if h != bad {
+			t.Errorf("unexpected handler: %T", h)
+		}
+		mu.Lock()
+		reported = append(reported, err)
+		mu.Unlock()
+	})
+	defer SetErrorHandler(nil)
+
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{bad}})
+	GetLogger().Info("one")
+	Shutdown()
+
+	if len(reported) != 1 || reported[0].Error() != "write error: disk full" {
+		t.Errorf("unexpected errors: %v", reported)
+	}
+}
+
+func TestDiagnosticsLogger(t *testing.T) {
+	var buf bytes.Buffer
+	good, _ := NewStreamHandler(&buf)
+	good.SetFormatter(NewCSVFormatter("name", "level", "message", "handler"))
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{good}})
+	formatter, _ := NewTemplateFormatter("{message}")
+	bad, _ := NewStreamHandler(failingWriter{})
+	bad.SetFormatter(formatter)
+	app := GetLogger("app")
+	app.RemoveHandlers()
+	if err := app.AddHandler(bad); err != nil {
+		t.Fatal(err)
+	}
+	SetDiagnosticsLogger(GetLogger("log4go"))
+	defer SetDiagnosticsLogger(nil)
+
+	app.Info("one")
+	_ = bad.ShutdownContext(context.Background())
+	Shutdown()
+
+	expected := "app,INFO,one,\n" +
+		"log4go,ERROR,write error: disk full,StreamHandler\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
+
+func TestConsoleHandler(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	h, _ := newConsoleHandler(&stdout, &stderr)
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	h.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: DEBUG, Handlers: []Handler{h}})
+
+	log := GetLogger()
+	log.Info("started")
+	log.Warning("slow")
+	log.Error("failed")
+	Shutdown()
+
+	if got := stdout.String(); got != "INFO started\n" {
+		t.Errorf("unexpected stdout: %q", got)
+	}
+	if got := stderr.String(); got != "WARNING slow\nERROR failed\n" {
+		t.Errorf("unexpected stderr: %q", got)
+	}
+
+	h, _ = newConsoleHandler(&stdout, &stderr)
+	h.SetFormatter(formatter)
+	h.SetSplitLevel(ERROR)
+	stdout.Reset()
+	stderr.Reset()
+	BasicConfig(BasicConfigOpts{Level: DEBUG, Handlers: []Handler{h}})
+	GetLogger().Warning("slow")
+	Shutdown()
+
+	if stdout.String() != "WARNING slow\n" || stderr.Len() != 0 {
+		t.Errorf("unexpected output: %q, %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestRingHandler(t *testing.T) {
+	ring, _ := NewRingHandler(3)
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	ring.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: DEBUG, Writer: ioutil.Discard})
+	log := GetLogger()
+	if err := log.AddHandler(ring); err != nil {
+		t.Fatal(err)
+	}
+
+	log.Debug("one")
+	log.Debug("two")
+	var buf bytes.Buffer
+	if err := ring.Dump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != "DEBUG one\nDEBUG two\n" {
+		t.Errorf("unexpected dump: %q", got)
+	}
+
+	for i := 3; i <= 5; i++ {
+		log.Info("record %d", i)
+	}
+	Shutdown()
+
+	r, w := io.Pipe()
+	stop := ring.DumpOnSignal(w, syscall.SIGUSR1)
+	defer stop()
+	_ = syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+	dump := bufio.NewReader(r)
+	for _, expected := range []string{"INFO record 3\n", "INFO record 4\n", "INFO record 5\n"} {
+		if line, err := dump.ReadString('\n'); err != nil || line != expected {
+			t.Errorf("unexpected dump line: %q (%v)", line, err)
+		}
+	}
+}
+
+func TestRecoverAndLog(t *testing.T) {
+	var buf bytes.Buffer
+	BasicConfig(BasicConfigOpts{Level: INFO, Writer: &buf, Format: "{level} {message}"})
+	ring, _ := NewRingHandler(10)
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	ring.SetFormatter(formatter)
+	log := GetLogger("worker")
+	if err := log.AddHandler(ring); err != nil {
+		t.Fatal(err)
+	}
+
+	func() {
+		defer RecoverAndLog(log)
+		log.Debug("loading")
+		panic("boom")
+	}()
+	log.Info("still running")
+
+	repanicked := func() (v interface{}) {
+		defer func() { v = recover() }()
+		defer RecoverAndLog(log, RecoverOpts{Repanic: true})
+		panic("again")
+	}()
+	if repanicked != "again" {
+		t.Errorf("unexpected re-panic: %v", repanicked)
+	}
+
+	out := buf.String()
+	if !strings.HasPrefix(out, "DEBUG loading\nFATAL PANIC: boom\ngithub.com/kaizer666/log4go.TestRecoverAndLog.func1(") {
+		t.Errorf("unexpected report: %q", out)
+	}
+	if !strings.Contains(out, "\nrecent records:\nDEBUG loading\nINFO still running\n"+
+		"FATAL PANIC: again\ngithub.com/kaizer666/log4go.TestRecoverAndLog.func2(") {
+		t.Errorf("unexpected history: %q", out)
+	}
+	if strings.Contains(out, "debug.Stack") {
+		t.Errorf("unexpected stack trace: %q", out)
+	}
+}
+
+func TestDisabledLevelAllocs(t *testing.T) {
+	BasicConfig(BasicConfigOpts{Level: INFO, Writer: ioutil.Discard})
+	defer Shutdown()
+	log := GetLogger("quiet")
+	log.SetLevel(INFO)
+
+	allocs := testing.AllocsPerRun(1000, func() {
+		log.Debug("request %s took %d ms", "GET /", 42)
+		log.StageDebug("staged")
+		log.Log(TRACE, "trace %v", true)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per disabled call", allocs)
+	}
+}
+
+func TestSharedRecords(t *testing.T) {
+	var out1, out2 bytes.Buffer
+	h1, _ := NewStreamHandler(&out1)
+	f1, _ := NewTemplateFormatter("{message} {delta}")
+	h1.SetFormatter(f1)
+	h2, _ := NewStreamHandler(&out2)
+	f2, _ := NewTemplateFormatter("{seq} {message}")
+	h2.SetFormatter(f2)
+	BasicConfig(BasicConfigOpts{Level: DEBUG, Handlers: []Handler{h1, h2}})
+
+	log := GetLogger()
+	log.StageInfo("staged 1")
+	log.StageInfo("staged 2")
+	other := GetLogger("other")
+	for i := 0; i < 10; i++ {
+		other.Debug("reusing pooled records %d", i)
+	}
+	log.Error("failed")
+	Shutdown()
+
+	lines := strings.Split(strings.TrimSpace(out1.String()), "\n")
+	if len(lines) != 13 || !strings.HasPrefix(lines[0], "reusing pooled records 0 +0.000") ||
+		!strings.HasPrefix(lines[10], "staged 1 +") || !strings.HasPrefix(lines[12], "failed +") {
+		t.Errorf("unexpected output: %q", out1.String())
+	}
+	if !strings.HasSuffix(out2.String(), "1 staged 1\n2 staged 2\n3 failed\n") {
+		t.Errorf("unexpected output: %q", out2.String())
+	}
+}
+
+func TestAppendFormat(t *testing.T) {
+	f, _ := NewTemplateFormatter("{time} {timems} {seq} {seq>4:0} {level:lower} {name} {message}")
+	rec := &Record{
+		Time:    time.Date(2021, 3, 4, 5, 6, 7, 89e6, time.UTC),
+		Level:   INFO,
+		Name:    "db",
+		Message: "ready",
+		Seq:     12,
+	}
+	out, err := f.AppendFormat([]byte("> "), rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "> 2021-03-04 05:06:07 2021-03-04 05:06:07.089 12 0012 info db ready"
+	if string(out) != expected {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if formatted, _ := f.Format(rec); string(formatted) != expected[2:] {
+		t.Errorf("unexpected Format output: %q", formatted)
+	}
+
+	plain, _ := NewTemplateFormatter("{timems} {seq} {level} {name}: {message}")
+	buf := make([]byte, 0, 256)
+	allocs := testing.AllocsPerRun(100, func() {
+		buf, _ = plain.AppendFormat(buf[:0], rec)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations per AppendFormat", allocs)
+	}
+}
+
+func TestStreamHandlerBatching(t *testing.T) {
+	w := &recordingWriter{}
+	handler, _ := NewStreamHandler(w)
+	defer handler.Shutdown()
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	handler.SetFormatter(formatter)
+	handler.SetBatching(1<<20, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		_ = handler.Handle(&Record{Level: INFO, Message: fmt.Sprint("batched ", i)})
+	}
+	if err := handler.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if writes := w.Writes(); len(writes) != 1 || writes[0] != "INFO batched 0\nINFO batched 1\nINFO batched 2\n" {
+		t.Errorf("unexpected writes: %q", writes)
+	}
+
+	// by size
+	handler.SetBatching(20, time.Hour)
+	for i := 0; i < 3; i++ {
+		_ = handler.Handle(&Record{Level: INFO, Message: fmt.Sprint("sized ", i)})
+	}
+	_ = handler.Flush()
+	if writes := w.Writes(); len(writes) != 3 || writes[1] != "INFO sized 0\nINFO sized 1\n" || writes[2] != "INFO sized 2\n" {
+		t.Errorf("unexpected writes: %q", writes)
+	}
+
+	// by delay
+	handler.SetBatching(1<<20, 10*time.Millisecond)
+	_ = handler.Handle(&Record{Level: INFO, Message: "delayed"})
+	deadline := time.Now().Add(time.Second)
+	for len(w.Writes()) != 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if writes := w.Writes(); len(writes) != 4 || writes[3] != "INFO delayed\n" {
+		t.Errorf("unexpected writes: %q", writes)
+	}
+
+	if stats := handler.handlerStats(); stats.Handled != 7 || stats.Writes != 4 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestStreamHandlerBuffering(t *testing.T) {
+	w := &syncingWriter{}
+	handler, _ := NewStreamHandler(w)
+	defer handler.Shutdown()
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	handler.SetBuffering(BufferOptions{FlushInterval: time.Hour})
+
+	handled := func(n uint64) {
+		deadline := time.Now().Add(time.Second)
+		for handler.handlerStats().Handled != n && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	_ = handler.Handle(&Record{Level: INFO, Message: "one"})
+	_ = handler.Handle(&Record{Level: INFO, Message: "two"})
+	handled(2)
+	if writes, _ := w.state(); len(writes) != 0 {
+		t.Errorf("unexpected writes: %q", writes)
+	}
+	if err := handler.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if writes, syncs := w.state(); len(writes) != 1 || writes[0] != "one\ntwo\n" || syncs != 0 {
+		t.Errorf("unexpected writes: %q, %d syncs", writes, syncs)
+	}
+
+	handler.SetBuffering(BufferOptions{Sync: SyncEveryRecord})
+	_ = handler.Handle(&Record{Level: INFO, Message: "three"})
+	handled(3)
+	if writes, syncs := w.state(); len(writes) != 2 || writes[1] != "three\n" || syncs != 1 {
+		t.Errorf("unexpected writes: %q, %d syncs", writes, syncs)
+	}
+
+	handler.SetBuffering(BufferOptions{FlushInterval: 10 * time.Millisecond, Sync: SyncInterval})
+	_ = handler.Handle(&Record{Level: INFO, Message: "four"})
+	deadline := time.Now().Add(time.Second)
+	for _, syncs := w.state(); syncs != 2 && time.Now().Before(deadline); _, syncs = w.state() {
+		time.Sleep(time.Millisecond)
+	}
+	if writes, syncs := w.state(); len(writes) != 3 || writes[2] != "four\n" || syncs != 2 {
+		t.Errorf("unexpected writes: %q, %d syncs", writes, syncs)
+	}
+
+	handler.SetBuffering(BufferOptions{})
+	_ = handler.Handle(&Record{Level: INFO, Message: "five"})
+	handled(5)
+	if writes, _ := w.state(); len(writes) != 4 {
+		t.Errorf("unexpected writes: %q", writes)
+	}
+}
+
+// recordingHandler is a StreamHandler recording the formatted records.
+type recordingHandler struct {
+	*StreamHandler
+	w *recordingWriter
+}
+
+func newRecordingHandler() *recordingHandler {
+	w := &recordingWriter{}
+	h, _ := NewStreamHandler(w)
+	return &recordingHandler{StreamHandler: h, w: w}
+}
+
+// lines returns the records written, separated by "|".
+func (h *recordingHandler) lines() string {
+	_ = h.Flush()
+	return strings.TrimSuffix(strings.Replace(strings.Join(h.w.Writes(), ""), "\n", "|", -1), "|")
+}
+
+type syncingWriter struct {
+	recordingWriter
+	syncs int
+}
+
+func (w *syncingWriter) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.syncs++
+	return nil
+}
+
+func (w *syncingWriter) state() ([]string, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.writes...), w.syncs
+}
+
+type recordingWriter struct {
+	mu     sync.Mutex
+	writes []string
+}
+
+func (w *recordingWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func (w *recordingWriter) Writes() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string(nil), w.writes...)
+}
+
+type blockingWriter struct {
+	release chan struct{}
+}
+
+func (w *blockingWriter) Write(p []byte) (int, error) {
+	<-w.release
+	return len(p), nil
+}
+
+func TestShutdownContextDeadline(t *testing.T) {
+	w := &blockingWriter{release: make(chan struct{})}
+	defer close(w.release)
+
+	BasicConfig(BasicConfigOpts{
+		Level:  DEBUG,
+		Writer: w,
+	})
+	GetLogger().Info("stuck in the writer")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := ShutdownContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("expected deadline exceeded, got %v", err)
+	}
+}
+
+func TestUnixSocketHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	address := filepath.Join(dir, "log.sock")
+	conn, err := net.ListenPacket("unixgram", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	handler, err := NewUnixSocketHandler(address, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	handler.SetFormatter(formatter)
+
+	handler.Handle(&Record{Time: time.Now(), Level: INFO, Message: "over the socket"})
+
+	buf := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	handler.Shutdown()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "INFO over the socket\n" {
+		t.Errorf("unexpected datagram: %q", got)
+	}
+}
+
+func TestWatchedFileHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "watched.log")
+	handler, err := NewWatchedFileHandler(fileName, true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetStatInterval(time.Hour) // only rely on fsnotify here
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+
+	waitFor := func(name, content string) {
+		for i := 0; i < 200; i++ {
+			if data, _ := ioutil.ReadFile(name); string(data) == content {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("%s: expected content %q", name, content)
+	}
+
+	handler.Handle(&Record{Level: INFO, Message: "before"})
+	waitFor(fileName, "before\n")
+
+	if err = os.Rename(fileName, fileName+".1"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond) // let the watcher see the rename
+
+	handler.Handle(&Record{Level: INFO, Message: "after"})
+	waitFor(fileName, "after\n")
+	waitFor(fileName+".1", "before\n")
+
+	handler.Shutdown()
+}
+
+func TestFileOptions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "a", "b", "options.log")
+	if _, err = NewFileHandler(fileName, true, false); err == nil {
+		t.Fatal("expected an error without CreateDirs")
+	}
+
+	options := FileOptions{
+		Mode:       0600,
+		CreateDirs: true,
+		Exclusive:  true,
+		Chown:      true,
+		UID:        os.Getuid(),
+		GID:        -1,
+	}
+	handler, err := NewFileHandlerWithOptions(fileName, options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(fileName); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("unexpected file info: %v, %v", info, err)
+	}
+	if err = handler.Reopen(); err != nil {
+		t.Errorf("exclusive file not re-opened: %v", err)
+	}
+	handler.Shutdown()
+
+	if _, err = NewFileHandlerWithOptions(fileName, options); !os.IsExist(err) {
+		t.Errorf("expected an existing file error, got %v", err)
+	}
+}
+
+func TestEncryptedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := bytes.Repeat([]byte{7}, 32)
+	fileName := filepath.Join(dir, "encrypted.log")
+	handler, err := NewFileHandlerWithOptions(fileName, FileOptions{EncryptionKey: key, WriteStartHeader: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	_ = handler.Handle(&Record{Level: INFO, Message: "secret one"})
+	_ = handler.Handle(&Record{Level: INFO, Message: "secret two"})
+	if err = handler.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	_ = handler.Handle(&Record{Level: INFO, Message: "secret three"})
+	_ = handler.ShutdownContext(context.Background())
+
+	data, _ := ioutil.ReadFile(fileName)
+	if bytes.Contains(data, []byte("secret")) || bytes.Contains(data, []byte("START")) {
+		t.Fatalf("plaintext in the encrypted file: %q", data)
+	}
+	r, _ := NewDecryptingReader(bytes.NewReader(data), key)
+	plain, err := ioutil.ReadAll(r)
+	if err != nil || string(plain) != "START LOGS\nsecret one\nsecret two\nsecret three\n" {
+		t.Errorf("unexpected decrypted content: %q, %v", plain, err)
+	}
+
+	r, _ = NewDecryptingReader(bytes.NewReader(data[:len(data)-1]), key)
+	if _, err = ioutil.ReadAll(r); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected an unexpected EOF error, got %v", err)
+	}
+	data[len(data)-1] ^= 1
+	r, _ = NewDecryptingReader(bytes.NewReader(data), key)
+	if _, err = ioutil.ReadAll(r); err == nil {
+		t.Error("expected an authentication error")
+	}
+	r, _ = NewDecryptingReader(strings.NewReader("plain text log\n"), key)
+	if _, err = ioutil.ReadAll(r); err != ErrEncryptedFormat {
+		t.Errorf("expected ErrEncryptedFormat, got %v", err)
+	}
+	if _, err = NewFileHandlerWithOptions(fileName, FileOptions{EncryptionKey: []byte("short")}); err == nil {
+		t.Error("expected an invalid key error")
+	}
+}
+
+func TestAuditHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key := []byte("audit key")
+	fileName := filepath.Join(dir, "audit.log")
+	write := func(messages ...string) {
+		handler, err := NewAuditHandler(fileName, key, FileOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		formatter, _ := NewTemplateFormatter("{level} {message}")
+		handler.SetFormatter(formatter)
+		for _, message := range messages {
+			_ = handler.Handle(&Record{Level: INFO, Message: message})
+		}
+		_ = handler.ShutdownContext(context.Background())
+	}
+	write("login alice", "grant admin\nto alice")
+	write("logout alice") // the chain continues
+
+	data, _ := ioutil.ReadFile(fileName)
+	lines := strings.SplitAfter(string(data), "\n")
+	if len(lines) != 4 || !strings.HasSuffix(lines[1], " INFO grant admin\\nto alice\n") {
+		t.Fatalf("unexpected audit log: %q", data)
+	}
+	verifier := NewAuditVerifier(key)
+	if err = verifier.Verify(bytes.NewReader(data)); err != nil || verifier.Records() != 3 {
+		t.Fatalf("unexpected verification: %v, %d records", err, verifier.Records())
+	}
+
+	tampered := strings.Replace(string(data), "admin", "guest", 1)
+	if err = VerifyAudit(strings.NewReader(tampered), key); err == nil || err.(*AuditError).Line != 2 {
+		t.Errorf("tampered record not detected: %v", err)
+	}
+	removed := lines[0] + lines[2]
+	if err = VerifyAudit(strings.NewReader(removed), key); err == nil || err.(*AuditError).Line != 2 {
+		t.Errorf("removed record not detected: %v", err)
+	}
+	if err = VerifyAudit(bytes.NewReader(data), []byte("other key")); err == nil {
+		t.Error("wrong key not detected")
+	}
+
+	// rotated files verify in sequence, the first record may follow deleted ones
+	verifier = NewAuditVerifier(key)
+	if err = verifier.Verify(strings.NewReader(lines[1])); err != nil {
+		t.Error(err)
+	}
+	if err = verifier.Verify(strings.NewReader(lines[2])); err != nil {
+		t.Error(err)
+	}
+
+	// a record cut by a crash stays invalid, the next ones are written on their own line
+	_ = ioutil.WriteFile(fileName, data[:len(data)-5], 0664)
+	write("restarted")
+	data, _ = ioutil.ReadFile(fileName)
+	if err = VerifyAudit(bytes.NewReader(data), key); err == nil || err.(*AuditError).Line != 3 {
+		t.Errorf("incomplete record not detected: %v", err)
+	}
+	if lines = strings.SplitAfter(string(data), "\n"); len(lines) != 5 || !strings.HasSuffix(lines[3], " INFO restarted\n") {
+		t.Errorf("unexpected audit log: %q", data)
+	}
+}
+
+func TestRoutingHandler(t *testing.T) {
+	access, app, db := newRecordingHandler(), newRecordingHandler(), newRecordingHandler()
+	defer access.Shutdown()
+	defer app.Shutdown()
+	defer db.Shutdown()
+	router, _ := NewRoutingHandler(app)
+	if err := router.AddRoute("app.access.*", access); err != nil {
+		t.Fatal(err)
+	}
+	if err := router.AddRoute("[", access); err == nil {
+		t.Error("expected an invalid pattern error")
+	}
+	formatter, _ := NewTemplateFormatter("{name} {message}")
+	router.SetFormatter(formatter)
+
+	for _, name := range []string{"app/access", "app/access/http/v2", "app/accessory", "app", ""} {
+		_ = router.Handle(&Record{Level: INFO, Name: name, Message: "routed"})
+	}
+	if got := access.lines(); got != "app/access routed|app/access/http/v2 routed" {
+		t.Errorf("unexpected access records: %q", got)
+	}
+	if got := app.lines(); got != "app/accessory routed|app routed|root routed" {
+		t.Errorf("unexpected app records: %q", got)
+	}
+
+	// at runtime
+	_ = router.AddRoute("db/*", db)
+	router.SetDefault(nil)
+	if removed := router.RemoveRoute("app.access.*"); len(removed) != 1 || removed[0] != Handler(access) {
+		t.Errorf("unexpected removed handlers: %v", removed)
+	}
+	for _, name := range []string{"app/access", "db/pool", "other"} {
+		_ = router.Handle(&Record{Level: INFO, Name: name, Message: "rerouted"})
+	}
+	if got := db.lines(); got != "db/pool rerouted" {
+		t.Errorf("unexpected db records: %q", got)
+	}
+	if got := access.lines() + app.lines(); strings.Contains(got, "rerouted") {
+		t.Errorf("unexpected records: %q", got)
+	}
+	if db.Formatter() != formatter {
+		t.Error("default formatter not set on the added route")
+	}
+
+	// dots in patterns are separators, unless escaped
+	for pattern, expected := range map[string]string{
+		"app.v1":  "app/v1",
+		"app/v1":  "app/v1",
+		`app\.v1`: "app.v1",
+		"app.*":   "app/v1",
+	} {
+		var matched []string
+		for _, name := range []string{"app/v1", "app.v1"} {
+			if matchLoggerName(routeMatch(pattern), name) {
+				matched = append(matched, name)
+			}
+		}
+		if strings.Join(matched, ",") != expected {
+			t.Errorf("pattern %q: unexpected matches %v", pattern, matched)
+		}
+	}
+}
+
+func TestLevelSplitHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	appLog, errorLog := filepath.Join(dir, "app.log"), filepath.Join(dir, "error.log")
+	handler, err := NewLevelSplitFileHandler(appLog, errorLog, ERROR, FileOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	BasicConfig(BasicConfigOpts{Level: DEBUG, Handlers: []Handler{handler}, Format: "{level} {message}"})
+	log := GetLogger()
+	log.Info("started")
+	log.Warning("slow")
+	log.Error("failed")
+	Shutdown()
+
+	if data, _ := ioutil.ReadFile(appLog); string(data) != "INFO started\nWARNING slow\nERROR failed\n" {
+		t.Errorf("unexpected app.log: %q", data)
+	}
+	if data, _ := ioutil.ReadFile(errorLog); string(data) != "ERROR failed\n" {
+		t.Errorf("unexpected error.log: %q", data)
+	}
+}
+
+func TestLevelRangeHandler(t *testing.T) {
+	inner := newRecordingHandler()
+	defer inner.Shutdown()
+	handler, err := NewLevelRangeHandler(inner, INFO, WARNING)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = NewLevelRangeHandler(inner, ERROR, INFO); err == nil {
+		t.Error("expected an invalid range error")
+	}
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+
+	for _, lvl := range []Level{DEBUG, INFO, WARNING, ERROR} {
+		_ = handler.Handle(&Record{Level: lvl, Message: LevelName(lvl)})
+	}
+	if got := inner.lines(); got != "INFO|WARNING" {
+		t.Errorf("unexpected records: %q", got)
+	}
+	handler.SetMaxLevel(NOTSET)
+	_ = handler.Handle(&Record{Level: FATAL, Message: "FATAL"})
+	if got := inner.lines(); got != "INFO|WARNING|FATAL" {
+		t.Errorf("unexpected records: %q", got)
+	}
+}
+
+func TestDedupHandler(t *testing.T) {
+	inner := newRecordingHandler()
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	inner.SetFormatter(formatter)
+	handler, _ := NewDedupHandler(inner, 0)
+	for _, message := range []string{"a", "a", "a", "b", "a", "b", "b"} {
+		_ = handler.Handle(&Record{Level: INFO, Message: message})
+	}
+	_ = handler.Handle(&Record{Level: WARNING, Message: "b"})
+	handler.Flush()
+	expected := "INFO a|INFO last message repeated 2 times|INFO b|INFO a|INFO b|INFO last message repeated 1 time|WARNING b"
+	if got := inner.lines(); got != expected {
+		t.Errorf("unexpected records: %q", got)
+	}
+	handler.Shutdown()
+
+	inner = newRecordingHandler()
+	inner.SetFormatter(formatter)
+	handler, _ = NewDedupHandler(inner, 50*time.Millisecond)
+	defer handler.Shutdown()
+	for _, message := range []string{"a", "b", "a", "a", "b"} {
+		_ = handler.Handle(&Record{Level: INFO, Message: message})
+	}
+	expected = "INFO a|INFO b|INFO message repeated 2 times: a|INFO message repeated 1 time: b"
+	deadline := time.Now().Add(time.Second)
+	for inner.lines() != expected && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := inner.lines(); got != expected && got != "INFO a|INFO b|INFO message repeated 1 time: b|INFO message repeated 2 times: a" {
+		t.Errorf("unexpected records: %q", got)
+	}
+	_ = handler.Handle(&Record{Level: INFO, Message: "a"}) // the window has ended
+	if got := inner.lines(); !strings.HasSuffix(got, "|INFO a") {
+		t.Errorf("unexpected records: %q", got)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	SetClock(clock)
+	defer SetClock(nil)
+
+	handler := newRecordingHandler()
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: DEBUG, Handlers: []Handler{handler}})
+	defer Shutdown()
+	log := GetLogger("limited")
+	log.SetLevel(DEBUG)
+
+	log.SetRateLimit(2, 3) // 2 per second, bursts of 3
+	for i := 0; i < 5; i++ {
+		log.Info("burst %d", i)
+	}
+	clock.t = clock.t.Add(time.Second)
+	for i := 0; i < 3; i++ {
+		log.Info("refilled %d", i)
+	}
+	if got := handler.lines(); got != "burst 0|burst 1|burst 2|refilled 0|refilled 1" {
+		t.Errorf("unexpected records: %q", got)
+	}
+
+	log.SetMessageRateLimit(1, 1)
+	log.Info("hot %d", 1)
+	log.Info("hot %d", 2)
+	log.Info("cold")
+	GetLogger("other").Info("not limited")
+	if got := handler.lines(); !strings.HasSuffix(got, "|refilled 1|hot 1|cold|not limited") {
+		t.Errorf("unexpected records: %q", got)
+	}
+
+	// the derived loggers share the limit
+	log.With("request", "id", 1).Info("hot %d", 3)
+	log.WithTags("audit").Info("cold")
+	if got := handler.lines(); !strings.HasSuffix(got, "|cold|not limited") {
+		t.Errorf("unexpected records: %q", got)
+	}
+
+	log.SetRateLimit(0, 0)
+	log.Info("unlimited")
+	for _, stats := range ReadStats().Loggers {
+		if stats.Name == "limited" && stats.RateLimited != 6 {
+			t.Errorf("unexpected rate limited count: %d", stats.RateLimited)
+		}
+	}
+}
+
+type testClock struct {
+	t time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.t
+}
+
+func TestOccasionalLogger(t *testing.T) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	SetClock(clock)
+	defer SetClock(nil)
+
+	handler := newRecordingHandler()
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{handler}})
+	defer Shutdown()
+	log := GetLogger()
+
+	for i := 0; i < 3; i++ {
+		log.Once("deprecated").Debug("disabled") // doesn't use the occasion
+		log.Once("deprecated").Warning("once %d", i)
+		log.EveryN("loop", 2).Info("every 2nd %d", i)
+		log.Every("tick", time.Minute).Info("every minute %d", i)
+		clock.t = clock.t.Add(40 * time.Second)
+	}
+	expected := "once 0|every 2nd 0|every minute 0|every 2nd 2|every minute 2"
+	if got := handler.lines(); got != expected {
+		t.Errorf("unexpected records: %q", got)
+	}
+	GetLogger("other").Once("deprecated").Warning("other logger")
+	if got := handler.lines(); got != expected+"|other logger" {
+		t.Errorf("unexpected records: %q", got)
+	}
+
+	// the derived loggers, e.g. per request, share the keys
+	for id := 0; id < 3; id++ {
+		GetLogger("other").With("", "request_id", id).Once("per-request").Warning("request %d", id)
+	}
+	if got := handler.lines(); got != expected+"|other logger|request 0" {
+		t.Errorf("unexpected records: %q", got)
+	}
+}
+
+func TestLoggerWith(t *testing.T) {
+	handler := newRecordingHandler()
+	formatter, _ := NewTemplateFormatter("{name} {message}")
+	handler.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{handler}})
+	defer Shutdown()
+	service := GetLogger("service")
+	service.SetLevel(INFO)
+	ring, _ := NewRingHandler(10)
+	_ = service.AddHandler(ring)
+
+	bound := service.With("", "service", "api", Fields{"version": "1.2"})
+	request := bound.With("http", "request_id", 42, "version", "1.3", "dangling")
+	request.Debug("disabled") // the level is inherited
+	request.Info("handled", Fields{"status": 200, "request_id": 43})
+	bound.Info("started")
+	if got := handler.lines(); got != "service/http handled|service started" {
+		t.Errorf("unexpected records: %q", got)
+	}
+
+	expected := []Fields{
+		{"service": "api", "version": "1.3", "request_id": 43, "status": 200, "dangling": nil},
+		{"service": "api", "version": "1.2"},
+	}
+	var fields []Fields
+	for _, rec := range ring.Records() {
+		fields = append(fields, rec.Fields)
+	}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Errorf("unexpected fields: %v", fields)
+	}
+	if len(bound.fields) != 2 {
+		t.Errorf("parent fields modified: %v", bound.fields)
+	}
+	for _, stats := range ReadStats().Loggers {
+		if stats.Name == "service" && stats.Logged[INFO] != 2 {
+			t.Errorf("unexpected logged count: %v", stats.Logged)
+		}
+	}
+}
+
+func TestConfigureFromFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler := newRecordingHandler()
+	formatter, _ := NewTemplateFormatter("{name} {message}")
+	handler.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{handler}})
+	defer Shutdown()
+
+	configFile := filepath.Join(dir, "log4go.json")
+	appLog := filepath.Join(dir, "app.log")
+	dbLog := filepath.Join(dir, "db.log")
+	config := `{
+		"handlers": {
+			"app": {"type": "file", "filename": "` + appLog + `", "format": "{name} {level} {message}", "level": "INFO"},
+			"db": {"type": "file", "filename": "` + dbLog + `", "format": "{message}"}
+		},
+		"loggers": {
+			"root": {"level": "DEBUG", "handlers": ["app"]},
+			"cfg/db": {"level": "TRACE", "handlers": ["db"]}
+		}
+	}`
+	if err = ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ConfigureFromFile(configFile); err != nil {
+		t.Fatal(err)
+	}
+
+	db := GetLogger("cfg/db")
+	db.Log(TRACE, "query")
+	db.Info("connected")
+	GetLogger().Debug("filtered by the handler")
+	var names []string
+	for _, logger := range Loggers() {
+		names = append(names, logger.Name())
+	}
+	if names[0] != "" || names[len(names)-1] != "cfg/db" {
+		t.Errorf("unexpected loggers: %q", names)
+	}
+
+	// reload: the db logger isn't configured anymore
+	config = `{
+		"handlers": {"app": {"type": "file", "filename": "` + appLog + `", "format": "{message}"}},
+		"loggers": {"root": {"level": "WARNING", "handlers": ["app"]}}
+	}`
+	if err = ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ConfigureFromFile(configFile); err != nil {
+		t.Fatal(err)
+	}
+	db.Info("default level")
+	db.Warning("reloaded")
+
+	if err = ioutil.WriteFile(configFile, []byte(`{"loggers": {"root": {"handlers": ["missing"]}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ConfigureFromFile(configFile); err == nil {
+		t.Error("expected an unknown handler error")
+	}
+	GetLogger().Warning("kept")
+
+	Shutdown()
+	if data, _ := ioutil.ReadFile(dbLog); string(data) != "query\nconnected\n" {
+		t.Errorf("unexpected db log: %q", data)
+	}
+	if data, _ := ioutil.ReadFile(appLog); string(data) != "cfg/db INFO connected\ndefault level\nreloaded\nkept\n" {
+		t.Errorf("unexpected app log: %q", data)
+	}
+	if got := handler.lines(); got != "" {
+		t.Errorf("unexpected records: %q", got)
+	}
+}
+
+func TestConfigureKeepsUnchangedHandlers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	BasicConfig(BasicConfigOpts{Handlers: []Handler{newRecordingHandler()}})
+	defer Shutdown()
+
+	appLog := filepath.Join(dir, "app.log")
+	noAppend := false
+	config := Config{
+		Handlers: map[string]HandlerConfig{
+			"app": {Type: "file", Filename: appLog, Append: &noAppend, Format: "{message}"},
+		},
+		Loggers: map[string]LoggerConfig{"keep": {Level: INFO, Handlers: []string{"app"}}},
+	}
+	if err = Configure(config); err != nil {
+		t.Fatal(err)
+	}
+	logger := GetLogger("keep")
+	handler := logger.Handlers()[0]
+	logger.Info("first")
+
+	config.Loggers["keep"] = LoggerConfig{Level: DEBUG, Handlers: []string{"app"}}
+	if err = Configure(config); err != nil {
+		t.Fatal(err)
+	}
+	if logger.Handlers()[0] != handler {
+		t.Error("unchanged handler recreated")
+	}
+	logger.Debug("second")
+
+	config.Handlers["app"] = HandlerConfig{Type: "file", Filename: filepath.Join(dir, "other.log"), Format: "{message}"}
+	if err = Configure(config); err != nil {
+		t.Fatal(err)
+	}
+	if logger.Handlers()[0] == handler {
+		t.Error("changed handler kept")
+	}
+
+	Shutdown()
+	if data, _ := ioutil.ReadFile(appLog); string(data) != "first\nsecond\n" {
+		t.Errorf("unexpected app log: %q", data)
+	}
+}
+
+func TestConfiguredSpoolDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := newConfiguredHandler(HandlerConfig{Type: "stderr", SpoolDir: dir}); err == nil {
+		t.Error("expected a spool dir error")
+	}
+
+	address := filepath.Join(dir, "log.sock")
+	conn, err := net.ListenPacket("unixgram", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	h, err := newConfiguredHandler(HandlerConfig{Type: "socket", Network: "unixgram", Address: address, SpoolDir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Shutdown()
+	if w, ok := h.(*SocketHandler).Writer.(*CircuitWriter); !ok || w.spool == nil {
+		t.Errorf("expected a spooling writer, got %T", h.(*SocketHandler).Writer)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{newRecordingHandler()}})
+	defer Shutdown()
+
+	configFile := filepath.Join(dir, "log4go.json")
+	writeConfig := func(file, level string) {
+		config := `{
+			"handlers": {"file": {"type": "file", "filename": "` + filepath.Join(dir, file) + `", "format": "{message}"}},
+			"loggers": {"root": {"level": "` + level + `", "handlers": ["file"]}}
+		}`
+		if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitLevel := func(level Level) {
+		for i := 0; i < 500 && GetLogger().Level() != level; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if GetLogger().Level() != level {
+			t.Fatalf("configuration not reloaded, level %v", GetLogger().Level())
+		}
+	}
+	writeConfig("1.log", "INFO")
+	stop, err := WatchConfig(configFile, syscall.SIGUSR1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+
+	// log concurrently with the reloads, no record may be lost
+	reloaded := make(chan struct{})
+	done := make(chan int)
+	go func() {
+		count := 0
+		for {
+			select {
+			case <-reloaded:
+				done <- count
+				return
+			default:
+				GetLogger().Error("record %d", count)
+				count++
+			}
+		}
+	}()
+
+	writeConfig("2.log", "WARNING") // reloaded on change
+	waitLevel(WARNING)
+	writeConfig("3.log", "ERROR")
+	if err = syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	waitLevel(ERROR)
+	close(reloaded)
+	count := <-done
+	Shutdown()
+
+	lines := 0
+	for _, file := range []string{"1.log", "2.log", "3.log"} {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, file))
+		lines += strings.Count(string(data), "\n")
+	}
+	if lines != count {
+		t.Errorf("%d records written, expected %d", lines, count)
+	}
+}
+
+func TestWatchConfigKeepsFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	BasicConfig(BasicConfigOpts{Handlers: []Handler{newRecordingHandler()}})
+	defer Shutdown()
+
+	configFile := filepath.Join(dir, "log4go.json")
+	appLog := filepath.Join(dir, "app.log")
+	writeConfig := func(loggerLevel, handlerLevel string) {
+		config := `{
+			"handlers": {"app": {"type": "file", "filename": "` + appLog + `", "append": false, "format": "{message}", "level": "` + handlerLevel + `"}},
+			"loggers": {"watched": {"level": "` + loggerLevel + `", "handlers": ["app"]}}
+		}`
+		if err := ioutil.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	logger := GetLogger("watched")
+	reload := func(level Level) {
+		if err := syscall.Kill(os.Getpid(), syscall.SIGUSR2); err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 500 && logger.Level() != level; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if logger.Level() != level {
+			t.Fatalf("configuration not reloaded, level %v", logger.Level())
+		}
+	}
+	writeConfig("INFO", "DEBUG")
+	stop, err := WatchConfig(configFile, syscall.SIGUSR2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stop()
+	logger.Info("first")
+
+	writeConfig("DEBUG", "DEBUG") // the handler is kept
+	reload(DEBUG)
+	logger.Debug("second")
+
+	writeConfig("WARNING", "INFO") // the handler is replaced, appending
+	reload(WARNING)
+	logger.Warning("third")
+
+	Shutdown()
+	if data, _ := ioutil.ReadFile(appLog); string(data) != "first\nsecond\nthird\n" {
+		t.Errorf("unexpected app log: %q", data)
+	}
+}
+
+func TestNewLogger(t *testing.T) {
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{newRecordingHandler()}})
+	defer Shutdown()
+
+	handler := newRecordingHandler()
+	ring, _ := NewRingHandler(10)
+	log, err := NewLogger("options/db", WithLevel(WARNING), WithCaller(), WithFields("db", "main"),
+		WithHandler(handler, WithFormat("{name} {caller} {message}"), WithHandlerLevel(ERROR)),
+		WithHandler(ring, WithFormat("{message}")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if GetLogger("options/db") != log {
+		t.Error("logger not registered")
+	}
+	if handler.Level() != ERROR {
+		t.Errorf("unexpected handler level: %v", handler.Level())
+	}
+	if _, err = NewLogger("options/db"); err == nil {
+		t.Error("expected an error creating an existing logger")
+	}
+	if _, err = NewLogger("options/bad", WithHandler(handler, WithFormat("{unknown}"))); err == nil {
+		t.Error("expected an invalid format error")
+	}
+
+	log.Info("disabled")
+	log.Warning("warning")
+	_, _, line, _ := runtime.Caller(0)
+	log.With("query").Error("error")
+	log.Once("once").Error("once")
+	if got, expected := handler.lines(), fmt.Sprintf("options/db/query logging_test.go:%d error|options/db logging_test.go:%d once", line+1, line+2); got != expected {
+		t.Errorf("unexpected records: %q, expected %q", got, expected)
+	}
+	records := ring.Records()
+	if len(records) != 3 || records[0].Fields["db"] != "main" || records[0].File != "logging_test.go" || records[0].Line != line-1 {
+		t.Errorf("unexpected records: %+v", records)
+	}
+}
+
+func TestRequestLogger(t *testing.T) {
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{newRecordingHandler()}})
+	defer Shutdown()
+	ring, _ := NewRingHandler(10)
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	ring.SetFormatter(formatter)
+	logger := GetLogger("requests")
+	_ = logger.AddHandler(ring)
+
+	if _, err := NewRequestLogger(RequestLogOptions{Format: "{method} {unknown}"}); err == nil {
+		t.Error("expected an unknown token error")
+	}
+	rl, err := NewRequestLogger(RequestLogOptions{
+		Logger:     logger,
+		Format:     "{remote_ip} {user} {method} {path} {query} {status} {size} {request_id}",
+		TrustProxy: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		FromContext(r.Context()).Info("handling")
+		if r.URL.Path == "/fail" {
+			http.Error(w, "failed", http.StatusInternalServerError)
+			return
+		}
+		_, _ = w.Write([]byte("hello"))
+	}))
+
+	r := httptest.NewRequest("GET", "/hello?x=1", nil)
+	r.Header.Set("X-Forwarded-For", "10.0.0.1, 10.0.0.2")
+	r.Header.Set("X-Request-Id", "abc")
+	r.SetBasicAuth("alice", "secret")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	if w.Header().Get("X-Request-Id") != "abc" {
+		t.Errorf("unexpected request ID header: %q", w.Header().Get("X-Request-Id"))
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/fail", nil))
+
+	records := ring.Records()
+	if len(records) != 4 {
+		t.Fatalf("unexpected records: %+v", records)
+	}
+	if records[0].Fields["request_id"] != "abc" || records[0].Fields["path"] != "/hello" {
+		t.Errorf("unexpected request-scoped fields: %v", records[0].Fields)
+	}
+	if records[1].Message != "10.0.0.1 alice GET /hello x=1 200 5 abc" || records[1].Fields["size"] != int64(5) {
+		t.Errorf("unexpected record: %q %v", records[1].Message, records[1].Fields)
+	}
+	id, _ := records[2].Fields["request_id"].(string)
+	if records[3].Level != ERROR || records[3].Message != "192.0.2.1 - POST /fail - 500 7 "+id || len(id) != 16 {
+		t.Errorf("unexpected record: %v %q", records[3].Level, records[3].Message)
+	}
+}
+
+func TestAccessLogFormatter(t *testing.T) {
+	rec := &Record{
+		Time:    time.Date(2024, 10, 10, 13, 55, 37, 0, time.FixedZone("", -7*3600)),
+		Level:   INFO,
+		Message: "GET /index.html 200",
+		Fields: Fields{
+			"method": "GET", "path": "/index.html", "query": "q=1", "proto": "HTTP/1.1",
+			"status": 200, "size": int64(2326), "latency": 1500 * time.Millisecond,
+			"remote_ip": "127.0.0.1", "user": "frank", "referer": "http://example.com/",
+			"user_agent": `Mozilla/4.08 "quoted"`,
+		},
+	}
+	common, err := NewAccessLogFormatter(AccessLogCommon).Format(rec)
+	if err != nil || string(common) != `127.0.0.1 - frank [10/Oct/2024:13:55:35 -0700] "GET /index.html?q=1 HTTP/1.1" 200 2326` {
+		t.Errorf("unexpected common line: %q %v", common, err)
+	}
+	rec.Fields["size"] = int64(0)
+	delete(rec.Fields, "user")
+	combined, err := NewAccessLogFormatter(AccessLogCombined).Format(rec)
+	if err != nil || string(combined) != `127.0.0.1 - - [10/Oct/2024:13:55:35 -0700] "GET /index.html?q=1 HTTP/1.1" 200 - "http://example.com/" "Mozilla/4.08 \"quoted\""` {
+		t.Errorf("unexpected combined line: %q %v", combined, err)
+	}
+	if _, err = NewAccessLogFormatter(AccessLogCommon).Format(&Record{Level: INFO, Message: "not a request"}); err != ErrorNotSet {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, expected := range map[string]Level{"warning": WARNING, "WARN": WARNING, "Err": ERROR, "trc": TRACE, "3": INFO, "NOTSET": NOTSET} {
+		if lvl, err := ParseLevel(name); err != nil || lvl != expected {
+			t.Errorf("ParseLevel(%q) = %v, %v", name, lvl, err)
+		}
+	}
+	for _, name := range []string{"loud", "7", "-1", "99999999999999999999"} {
+		if _, err := ParseLevel(name); err == nil {
+			t.Errorf("%s: expected an unknown level error", name)
+		}
+	}
+
+	var config struct {
+		Level  Level
+		Levels map[string]Level
+	}
+	if err := json.Unmarshal([]byte(`{"Level": "debug", "Levels": {"app": "ERROR"}}`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Level != DEBUG || config.Levels["app"] != ERROR {
+		t.Errorf("unexpected levels: %+v", config)
+	}
+	data, _ := json.Marshal(config)
+	if string(data) != `{"Level":"DEBUG","Levels":{"app":"ERROR"}}` {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+	if err := json.Unmarshal([]byte(`{"Level": "loud"}`), &config); err == nil {
+		t.Error("expected an unknown level error")
+	}
+	if s := fmt.Sprint(Level(WARNING), Level(42)); s != "WARNING 42" {
+		t.Errorf("unexpected string: %q", s)
+	}
+}
+
+func TestRegisterFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	flags := RegisterFlags(fs)
+	if err = fs.Parse([]string{"-log-level", "warn", "-log-level", "bad"}); err == nil {
+		t.Error("expected an invalid level error")
+	}
+	fileName := filepath.Join(dir, "cli.log")
+	if err = fs.Parse([]string{"-log-level", "info", "-log-format", "{level} {message}", "-log-file", fileName}); err != nil {
+		t.Fatal(err)
+	}
+	if flags.Level != INFO || fs.Lookup("log-level").Value.String() != "INFO" {
+		t.Errorf("unexpected level: %v", flags.Level)
+	}
+	if err = flags.BasicConfig(); err != nil {
+		t.Fatal(err)
+	}
+	GetLogger().Debug("disabled")
+	GetLogger().Info("enabled")
+	Shutdown()
+	if data, _ := ioutil.ReadFile(fileName); string(data) != "INFO enabled\n" {
+		t.Errorf("unexpected log: %q", data)
+	}
+
+	flags.Format = "yaml"
+	if err = flags.BasicConfig(); err == nil {
+		t.Error("expected an unknown format error")
+	}
+}
+
+func TestMessageTemplates(t *testing.T) {
+	handler := newRecordingHandler()
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{handler}})
+	defer Shutdown()
+	ring, _ := NewRingHandler(10)
+	log := GetLogger().With("", "service", "api")
+	_ = GetLogger().AddHandler(ring)
+
+	fields := Fields{"user": "bob", "ip": "10.0.0.1"}
+	log.Info("user {user} logged in from {ip} ({service})", fields)
+	log.Info("user {0} has {1} {{roles}} {unknown}", "bob", 2)
+	log.Info("printf {%d}", 42)
+	log.Info("{unknown} %s", "printf")
+	log.Info("loaded %d items for {user}", 3, fields)
+	log.Info("{0} is %-4.1f%% done", 12.5)
+	log.Info("{user} is 100% {0}", "done", fields)
+	if got := handler.lines(); got != "user bob logged in from 10.0.0.1 (api)|user bob has 2 {roles} {unknown}|printf {42}|{unknown} printf|"+
+		"loaded 3 items for {user}|{0} is 12.5% done|bob is 100% done" {
+		t.Errorf("unexpected messages: %q", got)
+	}
+
+	records := ring.Records()
+	if records[0].Fields[MessageTemplateField] != "user {user} logged in from {ip} ({service})" || records[0].Fields["user"] != "bob" {
+		t.Errorf("unexpected fields: %v", records[0].Fields)
+	}
+	for _, i := range []int{2, 4, 5} {
+		if _, ok := records[i].Fields[MessageTemplateField]; ok {
+			t.Errorf("unexpected template field: %v", records[i].Fields)
+		}
+	}
+	if len(fields) != 2 {
+		t.Errorf("fields modified: %v", fields)
+	}
+}
+
+func TestPrintfMethods(t *testing.T) {
+	handler := newRecordingHandler()
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	handler.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{handler}})
+	defer Shutdown()
+	ring, _ := NewRingHandler(10)
+	_ = GetLogger().AddHandler(ring)
+	log := GetLogger()
+
+	formatted := false
+	expensive := Lazy(func() interface{} { formatted = true; return "state" })
+	log.Debugf("%v", expensive)
+	log.Debugln("state:", expensive)
+	if formatted {
+		t.Error("disabled level formatted")
+	}
+
+	log.Infof("user %s", "bob")
+	log.Warnf("%d retries", 3)
+	log.Errorf("failed: %v", "timeout")
+	log.Infoln("user", "bob", 42, Fields{"ip": "10.0.0.1"})
+	log.Warnln("state:", expensive)
+	log.Errorln("100%", "done")
+	if got := handler.lines(); got != "INFO user bob|WARNING 3 retries|ERROR failed: timeout|INFO user bob 42|WARNING state: state|ERROR 100% done" {
+		t.Errorf("unexpected messages: %q", got)
+	}
+	if records := ring.Records(); records[3].Fields["ip"] != "10.0.0.1" {
+		t.Errorf("unexpected fields: %v", records[3].Fields)
+	}
+}
+
+func TestWithGroup(t *testing.T) {
+	handler := newRecordingHandler()
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{handler}})
+	defer Shutdown()
+	ring, _ := NewRingHandler(10)
+	_ = GetLogger().AddHandler(ring)
+
+	log := GetLogger().With("", "service", "api").WithGroup("http").With("", "method", "GET")
+	if GetLogger().WithGroup("") != GetLogger() {
+		t.Error("empty group not ignored")
+	}
+	log.Info("handled", Fields{"status": 200})
+	log.WithGroup("tls").Info("handshake", Fields{"version": "1.3"})
+
+	records := ring.Records()
+	want := Fields{"service": "api", "http.method": "GET", "http.status": 200}
+	if !reflect.DeepEqual(records[0].Fields, want) {
+		t.Errorf("unexpected fields: %v", records[0].Fields)
+	}
+	want = Fields{"service": "api", "http.method": "GET", "http.tls.version": "1.3"}
+	if !reflect.DeepEqual(records[1].Fields, want) {
+		t.Errorf("unexpected fields: %v", records[1].Fields)
+	}
+
+	doc, err := NewECSFormatter().Format(&records[0])
+	if err != nil || !strings.Contains(string(doc), `"http.method":"GET"`) || !strings.Contains(string(doc), `"http.status":200`) {
+		t.Errorf("unexpected document: %s (%v)", doc, err)
+	}
+}
+
+func TestIsLevelEnabled(t *testing.T) {
+	ring, _ := NewRingHandler(10)
+	ring.SetLevel(INFO)
+	BasicConfig(BasicConfigOpts{Level: DEBUG, Handlers: []Handler{ring}})
+	defer Shutdown()
+
+	log := GetLogger("guards")
+	if log.IsDebugEnabled() || !log.IsLevelEnabled(INFO) || log.IsLevelEnabled(NOTSET) {
+		t.Error("handler level not taken into account")
+	}
+
+	errors, _ := NewRingHandler(10)
+	ranged, _ := NewLevelRangeHandler(errors, DEBUG, Level(DEBUG))
+	_ = log.AddHandler(ranged)
+	if !log.IsDebugEnabled() || log.IsLevelEnabled(TRACE) {
+		t.Error("sub-logger handler not taken into account")
+	}
+	log.SetLevel(WARNING)
+	if log.IsDebugEnabled() || !log.IsLevelEnabled(ERROR) {
+		t.Error("logger level not taken into account")
+	}
+	if GetLogger("unhandled").IsLevelEnabled(DEBUG) {
+		t.Error("enabled without handlers below their levels")
+	}
+}
+
+func TestIsLevelEnabledAgreesWithStreamHandler(t *testing.T) {
+	handler := newRecordingHandler()
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	handler.SetLevel(INFO)
+	BasicConfig(BasicConfigOpts{Level: DEBUG, Handlers: []Handler{handler}})
+	defer Shutdown()
+
+	log := GetLogger("guards")
+	for _, level := range []Level{TRACE, DEBUG, INFO, WARNING} {
+		log.Log(level, "%s", level)
+	}
+	if log.IsDebugEnabled() || !log.IsLevelEnabled(INFO) {
+		t.Error("handler level not taken into account")
+	}
+	if got := handler.lines(); got != "INFO|WARNING" {
+		t.Errorf("unexpected messages: %q", got)
+	}
+}
+
+func TestTagHandler(t *testing.T) {
+	handler := newRecordingHandler()
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	audit := newRecordingHandler()
+	audit.SetFormatter(formatter)
+	tagged, _ := NewTagHandler(audit, "audit", "security")
+	tagged.SetLevel(INFO)
+	BasicConfig(BasicConfigOpts{Level: DEBUG, Handlers: []Handler{handler, tagged}})
+	defer Shutdown()
+	ring, _ := NewRingHandler(10)
+	_ = GetLogger().AddHandler(ring)
+
+	log := GetLogger("auth")
+	log.SetLevel(INFO)
+	log.Info("login %s", "bob", Tags{"audit"}, Fields{"ip": "10.0.0.1"})
+	log.Info("request %s", "bob")
+	log.WithTags("security").Warning("password changed", Fields{"user": "bob"}, Tags{"metrics"})
+	log.Infoln("logout", "bob", Tags{"audit"})
+	log.Debug("debug %s", "bob", Tags{"audit"})
+
+	if got := handler.lines(); got != "login bob|request bob|password changed|logout bob" {
+		t.Errorf("unexpected messages: %q", got)
+	}
+	if got := audit.lines(); got != "login bob|password changed|logout bob" {
+		t.Errorf("unexpected tagged messages: %q", got)
+	}
+	records := ring.Records()
+	if !reflect.DeepEqual(records[2].Tags, Tags{"security", "metrics"}) || records[2].Fields["user"] != "bob" || records[0].Fields["ip"] != "10.0.0.1" {
+		t.Errorf("unexpected records: %+v", records)
+	}
+	if doc, _ := NewECSFormatter().Format(&records[0]); !strings.Contains(string(doc), `"tags":["audit"]`) {
+		t.Errorf("unexpected document: %s", doc)
+	}
+}
+
+func TestAddRemoveHandlerWhileLogging(t *testing.T) {
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{newRecordingHandler()}})
+	defer Shutdown()
+	log := GetLogger("live")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					log.Info("record")
+				}
+			}
+		}()
+	}
+
+	added := make([]*RingHandler, 20)
+	var adders sync.WaitGroup
+	for i := range added {
+		added[i], _ = NewRingHandler(1)
+		adders.Add(1)
+		go func(h Handler) {
+			defer adders.Done()
+			_ = log.AddHandler(h)
+		}(added[i])
+	}
+	adders.Wait()
+	if n := len(log.loadHandlers()); n != len(added) {
+		t.Errorf("%d handlers added, want %d", n, len(added))
+	}
+
+	for _, h := range added {
+		for len(h.Records()) == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		if !log.RemoveHandler(h) {
+			t.Error("handler not removed")
+		}
+		// the records of concurrent calls may replace each other in the ring, count the calls
+		handled := atomic.LoadUint64(&h.next)
+		time.Sleep(time.Millisecond)
+		if atomic.LoadUint64(&h.next) != handled {
+			t.Error("removed handler still called")
+		}
+	}
+	if log.RemoveHandler(added[0]) || len(log.loadHandlers()) != 0 {
+		t.Error("unexpected handlers")
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func TestStreamHandlerStats(t *testing.T) {
+	fixed := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	SetClock(&testClock{t: fixed})
+	defer SetClock(nil)
+
+	var buf bytes.Buffer
+	formatter, _ := NewTemplateFormatter("{message}")
+	good, _ := NewStreamHandler(&buf)
+	good.SetFormatter(formatter)
+	bad, _ := NewStreamHandler(failingWriter{})
+	bad.SetFormatter(formatter)
+	log := GetLogger("handler-stats")
+	log.ReplaceHandlers(good)
+	_ = log.AddHandler(bad)
+	defer log.RemoveHandlers()
+
+	log.Info("one")
+	log.Warning("two")
+	_ = good.Flush()
+	_ = bad.Flush()
+
+	if stats := good.Stats(); stats.Name != "StreamHandler" || stats.Handled != 2 || stats.Errors != 0 || stats.QueueCapacity != 100 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats := bad.Stats(); stats.Handled != 0 || stats.Errors == 0 || stats.LastError != "disk full" || !stats.LastErrorTime.Equal(fixed) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+// gatedWriter blocks the writes until released.
+type gatedWriter struct {
+	release chan struct{}
+	writes  int32
+}
+
+func (w *gatedWriter) Write(p []byte) (int, error) {
+	<-w.release
+	atomic.AddInt32(&w.writes, 1)
+	return len(p), nil
+}
+
+func TestStreamHandlerBlocking(t *testing.T) {
+	formatter, _ := NewTemplateFormatter("{message}")
+	rec := &Record{Level: INFO, Message: "record"}
+
+	w := &gatedWriter{release: make(chan struct{})}
+	h, _ := NewStreamHandler(w)
+	h.SetFormatter(formatter)
+	h.SetBlocking(false)
+	for i := 0; i < 150; i++ {
+		_ = h.Handle(rec) // never waits
+	}
+	close(w.release)
+	_ = h.ShutdownContext(context.Background())
+	stats := h.Stats()
+	if stats.Dropped == 0 || stats.Handled+stats.Dropped != 150 || int(atomic.LoadInt32(&w.writes)) != int(stats.Handled) {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	w = &gatedWriter{release: make(chan struct{})}
+	h, _ = NewStreamHandler(w)
+	h.SetFormatter(formatter)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 150; i++ {
+			_ = h.Handle(rec)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Error("Handle didn't block with a full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(w.release)
+	<-done
+	_ = h.ShutdownContext(context.Background())
+	if stats := h.Stats(); stats.Dropped != 0 || stats.Handled != 150 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestStreamHandlerShutdownDrains(t *testing.T) {
+	formatter, _ := NewTemplateFormatter("{message}")
+	rec := &Record{Level: INFO, Message: "record"}
+
+	w := &gatedWriter{release: make(chan struct{})}
+	h, _ := NewStreamHandler(w)
+	h.SetFormatter(formatter)
+	for i := 0; i < 50; i++ {
+		_ = h.Handle(rec)
+	}
+	time.AfterFunc(20*time.Millisecond, func() { close(w.release) })
+	h.Shutdown()
+	if writes := atomic.LoadInt32(&w.writes); writes != 50 {
+		t.Errorf("%d records written on shutdown, want 50", writes)
+	}
+
+	w = &gatedWriter{release: make(chan struct{})} // never released
+	h, _ = NewStreamHandler(w)
+	h.SetFormatter(formatter)
+	h.SetShutdownTimeout(20 * time.Millisecond)
+	_ = h.Handle(rec)
+	start := time.Now()
+	h.Shutdown()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %v despite the timeout", elapsed)
+	}
+	if err := h.Handle(rec); err != nil || h.Stats().Dropped != 1 {
+		t.Errorf("unexpected stats after shutdown: %+v", h.Stats())
+	}
+	close(w.release)
+}
+
+func TestShutdownTimeoutOfFileAndSocketHandlers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	address := filepath.Join(dir, "log.sock")
+	conn, err := net.ListenPacket("unixgram", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	socket, err := NewUnixSocketHandler(address, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watched, err := NewWatchedFileHandler(filepath.Join(dir, "watched.log"), true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formatter, _ := NewTemplateFormatter("{message}")
+	w := &gatedWriter{release: make(chan struct{})} // released once shut down
+	defer close(w.release)
+	for _, h := range []*StreamHandler{socket.StreamHandler, watched.StreamHandler} {
+		h.Writer = w
+		h.SetFormatter(formatter)
+		h.SetShutdownTimeout(20 * time.Millisecond)
+		_ = h.Handle(&Record{Level: INFO, Message: "record"})
+	}
+
+	start := time.Now()
+	socket.Shutdown()
+	watched.Shutdown()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("shutdown took %v despite the timeouts", elapsed)
+	}
+}
+
+func TestRecordClone(t *testing.T) {
+	rec := newRecord()
+	rec.Message = "message"
+	rec.Fields = Fields{"user": "bob"}
+	rec.Tags = Tags{"audit"}
+	c := rec.Clone()
+	c.Fields["user"] = "alice"
+	c.Tags[0] = "metrics"
+	c.release() // not pooled, no-op
+	if rec.Fields["user"] != "bob" || rec.Tags[0] != "audit" || c.Message != "message" || c.pool != nil {
+		t.Errorf("unexpected records: %+v %+v", rec, c)
+	}
+	rec.release()
+
+	ring, _ := NewRingHandler(10)
+	_ = GetLogger("clone").AddHandler(ring)
+	defer GetLogger("clone").RemoveHandlers()
+	log := GetLogger("clone").With("", "service", "api")
+	log.Info("first")
+	ring.Records()[0].Fields["service"] = "modified"
+	log.Info("second")
+	if records := ring.Records(); records[len(records)-1].Fields["service"] != "api" {
+		t.Errorf("bound fields modified through a kept record: %v", records)
+	}
+}
+
+func TestCallerSkip(t *testing.T) {
+	ring, _ := NewRingHandler(10)
+	log, err := NewLogger("caller-skip", WithCaller(), AddCallerSkip(1), WithHandler(ring))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer log.RemoveHandlers()
+	warn := func(message string) { log.Warning(message) }
+	helper := log.With("helper").WithCallerSkip(1)
+	fail := func(message string) { helper.Error(message) }
+	nested := func(message string) { fail(message) }
+
+	_, _, line, _ := runtime.Caller(0)
+	warn("wrapped")
+	nested("nested")
+	log.WithCallerSkip(-1).Info("direct")
+	records := ring.Records()
+	if len(records) != 3 || records[0].Line != line+1 || records[1].Line != line+2 || records[2].Line != line+3 || records[2].File != "logging_test.go" {
+		t.Errorf("unexpected records: %+v", records)
+	}
+}
+
+func TestDuration(t *testing.T) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	SetClock(clock)
+	defer SetClock(nil)
+
+	handler := newRecordingHandler()
+	formatter, _ := NewTemplateFormatter("{level} {message}")
+	handler.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{handler}})
+	defer Shutdown()
+	ring, _ := NewRingHandler(10)
+	_ = GetLogger().AddHandler(ring)
+
+	log := GetLogger()
+	done := log.Duration(WARNING, "load config", "file", "app.json")
+	debug := log.TimeIt("parse")
+	clock.t = clock.t.Add(1500 * time.Millisecond)
+	debug() // disabled
+	done()
+	GetLogger("timed").TimeIt("query")()
+
+	if got := handler.lines(); got != "WARNING load config took 1.5s|DEBUG query took 0s" {
+		t.Errorf("unexpected messages: %q", got)
+	}
+	want := Fields{OperationField: "load config", DurationField: 1500 * time.Millisecond, "file": "app.json"}
+	if records := ring.Records(); !reflect.DeepEqual(records[0].Fields, want) {
+		t.Errorf("unexpected fields: %v", records[0].Fields)
+	}
+}
+
+func TestMetaLogger(t *testing.T) {
+	BasicConfig(BasicConfigOpts{Writer: ioutil.Discard})
+	defer Shutdown()
+	meta, _ := NewRingHandler(20)
+	metaLog, _ := NewLogger("meta-log", WithHandler(meta))
+	SetMetaLogger(metaLog)
+	defer SetMetaLogger(nil)
+
+	log := GetLogger("meta/app")
+	log.SetLevel(INFO)
+	log.SetLevel(INFO) // unchanged
+	ring, _ := NewRingHandler(10)
+	_ = log.AddHandler(ring)
+	log.RemoveHandler(ring)
+
+	req := httptest.NewRequest(http.MethodPut, "/", strings.NewReader(`{"meta/app": "error"}`))
+	req.RemoteAddr = "10.0.0.1:1234"
+	LevelHandler().ServeHTTP(httptest.NewRecorder(), req)
+
+	SetLevelOverrides(map[string]Level{"meta/app": WARNING})
+	SetLevelOverrides(nil)
+	if err := Configure(Config{
+		Handlers: map[string]HandlerConfig{"ring": {Type: "stdout"}},
+		Loggers:  map[string]LoggerConfig{"meta/db": {Level: ERROR, Handlers: []string{"ring"}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer Configure(Config{})
+
+	var messages []string
+	for _, rec := range meta.Records() {
+		messages = append(messages, rec.Message)
+	}
+	expected := []string{
+		"level of logger meta/app changed from DEBUG to INFO by api",
+		"handlers of logger meta/app changed from none to RingHandler by api",
+		"handlers of logger meta/app changed from RingHandler to none by api",
+		"level of logger meta/app changed from INFO to ERROR by http 10.0.0.1:1234",
+		"level of logger meta/app changed from ERROR to WARNING by overrides",
+		"level of logger meta/db changed from DEBUG to ERROR by config",
+		"handlers of logger meta/db changed from none to StreamHandler by config",
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Errorf("unexpected changes:\n%s", strings.Join(messages, "\n"))
+	}
+	if rec := meta.Records()[0]; rec.Level != INFO || rec.Fields["source"] != "api" || rec.Fields["old"] != "DEBUG" || rec.Fields["logger"] != "meta/app" {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+}
+
+func TestComposedFormatter(t *testing.T) {
+	template, _ := NewTemplateFormatter("{level} {message}")
+	skipHealth := RecordProcessor(func(rec *Record) *Record {
+		if rec.Fields["path"] == "/health" {
+			return nil
+		}
+		return rec
+	})
+	addUser := RecordProcessor(func(rec *Record) *Record {
+		if user, ok := rec.Fields["user"]; ok {
+			c := rec.Clone()
+			c.Message += fmt.Sprintf(" (user %v)", user)
+			return c
+		}
+		return rec
+	})
+	formatter := Compose(template, skipHealth, RedactFields("user", "token"), addUser,
+		ColorizeByLevel(map[Level]string{ERROR: "<red>"}),
+		OutputProcessor(func(rec *Record, out []byte) ([]byte, error) { return append(out, '!'), nil }))
+
+	fields := Fields{"user": "bob", "path": "/login"}
+	rec := &Record{Level: ERROR, Message: "login failed", Fields: fields}
+	if out, err := formatter.Format(rec); err != nil || string(out) != "<red>ERROR login failed (user [REDACTED])"+colorReset+"!" {
+		t.Errorf("unexpected output: %q (%v)", out, err)
+	}
+	if fields["user"] != "bob" || rec.Message != "login failed" {
+		t.Errorf("record modified: %+v", rec)
+	}
+	if out, err := formatter.AppendFormat([]byte("> "), &Record{Level: INFO, Message: "ok"}); err != nil || string(out) != "> INFO ok!" {
+		t.Errorf("unexpected output: %q (%v)", out, err)
+	}
+	if _, err := formatter.Format(&Record{Level: INFO, Fields: Fields{"path": "/health"}}); err != ErrorNotSet {
+		t.Errorf("record not skipped: %v", err)
+	}
+
+	handler := newRecordingHandler()
+	handler.SetFormatter(Compose(template, skipHealth))
+	_ = handler.Handle(&Record{Level: INFO, Message: "skipped", Fields: Fields{"path": "/health"}})
+	_ = handler.Handle(&Record{Level: INFO, Message: "kept"})
+	if got := handler.lines(); got != "INFO kept" {
+		t.Errorf("unexpected records: %q", got)
+	}
+}
+
+func TestFormatPresets(t *testing.T) {
+	rec := &Record{Level: WARNING, Name: "app", Message: "message", Time: time.Date(2024, 1, 2, 3, 4, 5, 0, time.Local)}
+	minimal, err := NewFormatter("minimal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := minimal.Format(rec); string(out) != "WRN message" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	if f, err := NewFormatter("json"); err != nil || reflect.TypeOf(f) != reflect.TypeOf(&ECSFormatter{}) {
+		t.Errorf("unexpected json formatter: %T (%v)", f, err)
+	}
+	if _, err := NewFormatter("unknown"); err == nil {
+		t.Error("unknown format accepted")
+	}
+
+	if err := RegisterTemplateFormat("tagged", "[app] {@minimal}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterTemplateFormat("broken", "{@unknown} {message}"); err == nil {
+		t.Error("unknown base format accepted")
+	}
+	if template, ok := FormatTemplate("tagged"); !ok || template != "[app] {level:short} {message}" {
+		t.Errorf("unexpected template: %q", template)
+	}
+	if _, ok := FormatTemplate("json"); ok {
+		t.Error("json has no template")
+	}
+	names := FormatNames()
+	if !reflect.DeepEqual(names[:5], []string{"default", "detailed", "json", "minimal", "tagged"}) {
+		t.Errorf("unexpected names: %v", names)
+	}
+
+	for format, expected := range map[string]string{
+		`"format": "tagged"`:              "[app] WRN message",
+		`"formatter": "minimal"`:          "WRN message",
+		`"format": "{@minimal} ({name})"`: "WRN message (app)",
+		`"format": ""`:                    "2024-01-02 03:04:05 app WARNING message",
+	} {
+		var hc HandlerConfig
+		if err := json.Unmarshal([]byte("{"+format+"}"), &hc); err != nil {
+			t.Fatal(err)
+		}
+		formatter, err := newConfiguredFormatter(hc)
+		if err != nil {
+			t.Errorf("%s: %v", format, err)
+			continue
+		}
+		if out, _ := formatter.Format(rec); string(out) != expected {
+			t.Errorf("%s: unexpected output %q", format, out)
+		}
+	}
+}
+
+func TestFieldColoring(t *testing.T) {
+	f, err := NewTemplateFormatter("{message} {fields}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &Record{Level: INFO, Message: "done", Fields: Fields{"msg": "hello world", "a": 1, "err": errors.New("failed")}}
+	if out, _ := f.Format(rec); string(out) != `done a=1 err=failed msg="hello world"` {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	f.SetFieldColoring(&FieldColoring{Key: color.Faint, Value: color.Green, Highlight: map[string]string{"err": color.Red}})
+	rec.Fields = Fields{"a": 1, "err": "x"}
+	expected := "done " + color.Faint + "a" + colorReset + "=" + color.Green + "1" + colorReset + " " +
+		color.Faint + "err" + colorReset + "=" + color.Red + "x" + colorReset
+	if out, _ := f.Format(rec); string(out) != expected {
+		t.Errorf("unexpected output: %q", out)
+	}
+
+	var hc HandlerConfig
+	if err := json.Unmarshal([]byte(`{"format": "{message} {fields}", "field_colors": {"key": "faint", "err": "#ff0000"}}`), &hc); err != nil {
+		t.Fatal(err)
+	}
+	coloring, err := parseFieldColors(hc.FieldColors)
+	if err != nil || coloring.Key != color.Faint || coloring.Highlight["err"] != color.Hex("#ff0000") {
+		t.Errorf("unexpected coloring: %+v (%v)", coloring, err)
+	}
+	hc.FieldColors["value"] = "mauve"
+	if _, err := newConfiguredHandler(hc); err == nil {
+		t.Error("unknown color accepted")
+	}
+	hc.FieldColors = map[string]string{"key": "red"}
+	hc.Formatter = "ecs"
+	if _, err := newConfiguredHandler(hc); err == nil {
+		t.Error("field colors accepted without a template formatter")
+	}
+}
+
+func TestHighlightRules(t *testing.T) {
+	f, _ := NewTemplateFormatter("{message} {fields}")
+	f.EnableLevelColoring(true)
+	if err := f.SetHighlightRules([]HighlightRule{
+		{When: "duration > 1s", Field: "duration", Color: "red"},
+		{When: "level >= ERROR && user == \"bob smith\"", Color: "purple"},
+		{When: "status ~ ^5", Field: "status", Color: color.Yellow},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		rec      Record
+		expected string
+	}{
+		{Record{Level: INFO, Message: "fast", Fields: Fields{"duration": time.Second}},
+			color.Normal + "fast duration=1s" + colorReset},
+		{Record{Level: INFO, Message: "slow", Fields: Fields{"duration": 2 * time.Second}},
+			color.Normal + "slow duration=" + color.Red + "2s" + color.Normal + colorReset},
+		{Record{Level: ERROR, Message: "oops", Fields: Fields{"user": "bob smith"}},
+			color.Purple + `oops user="bob smith"` + colorReset},
+		{Record{Level: WARNING, Message: "oops", Fields: Fields{"user": "bob smith"}},
+			color.Yellow + `oops user="bob smith"` + colorReset},
+		{Record{Level: DEBUG, Message: "get", Fields: Fields{"status": 503}},
+			color.Faint + "get status=" + color.Yellow + "503" + color.Faint + colorReset},
+	} {
+		if out, _ := f.Format(&test.rec); string(out) != test.expected {
+			t.Errorf("%s: unexpected output: %q", test.rec.Message, out)
+		}
+	}
+
+	for _, rule := range []HighlightRule{
+		{When: "level", Color: "red"},
+		{When: "level > LOUD", Color: "red"},
+		{When: "x ~ (", Color: "red"},
+		{When: "a = b", Color: "red"},
+		{When: "a", Color: "mauve"},
+	} {
+		if err := f.SetHighlightRules([]HighlightRule{rule}); err == nil {
+			t.Errorf("%+v accepted", rule)
+		}
+	}
+
+	var hc HandlerConfig
+	if err := json.Unmarshal([]byte(`{"format": "{message}", "highlight": [{"when": "retries >= 3", "color": "yellow"}]}`), &hc); err != nil {
+		t.Fatal(err)
+	}
+	h, err := newConfiguredHandler(hc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Shutdown()
+	hc.Highlight[0].When = "retries >"
+	if _, err := newConfiguredHandler(hc); err == nil {
+		t.Error("invalid highlight rule accepted")
+	}
+}
+
+func TestRecordExtras(t *testing.T) {
+	rec := &Record{Level: INFO, Message: "hello", Fields: Fields{"a": 1, "b": 2}}
+	rec.Set("b", 3)
+	rec.Set("request_id", "r1")
+	if value, ok := rec.Get("b"); !ok || value != 3 {
+		t.Errorf("unexpected b: %v", value)
+	}
+	if value, ok := rec.Get("a"); !ok || value != 1 {
+		t.Errorf("unexpected a: %v", value)
+	}
+	if _, ok := rec.Get("c"); ok {
+		t.Error("unexpected c")
+	}
+	clone := rec.Clone()
+	clone.Set("request_id", "r2")
+	if rec.Extras["request_id"] != "r1" {
+		t.Error("clone shares the extras")
+	}
+
+	template, _ := NewTemplateFormatter("{message} {fields}")
+	if out, _ := template.Format(rec); string(out) != "hello a=1 b=3 request_id=r1" {
+		t.Errorf("unexpected template output: %q", out)
+	}
+	out, _ := NewECSFormatter().Format(rec)
+	var doc map[string]interface{}
+	if err := json.Unmarshal(out, &doc); err != nil || doc["request_id"] != "r1" || doc["b"] != 3.0 {
+		t.Errorf("unexpected ECS output: %s (%v)", out, err)
+	}
+	csv := NewCSVFormatter("message", "fields.request_id")
+	if out, _ := csv.Format(rec); string(out) != "hello,r1" {
+		t.Errorf("unexpected CSV output: %q", out)
+	}
+
+	redacted := RedactFields("request_id")(rec)
+	if redacted.Extras["request_id"] != Redacted || rec.Extras["request_id"] != "r1" {
+		t.Errorf("unexpected redaction: %v", redacted.Extras)
+	}
+}
+
+func TestContextFields(t *testing.T) {
+	ring, _ := NewRingHandler(10)
+	log := GetLogger("ctxfields").With("", "service", "api", "user", "bound")
+	log.SetLevel(DEBUG)
+	log.AddHandler(ring)
+	defer log.RemoveHandler(ring)
+
+	ctx := ContextWithFields(context.Background(), "request_id", "r1", "user", "ctx")
+	ctx = ContextWithFields(ctx, Fields{"tenant": "t1"})
+	log.InfoCtx(ctx, "hello %s", "world", Fields{"tenant": "call"})
+	log.DebugCtx(context.Background(), "no fields")
+
+	records := ring.Records()
+	if len(records) != 2 {
+		t.Fatalf("unexpected records: %d", len(records))
+	}
+	expected := Fields{"service": "api", "user": "bound", "request_id": "r1", "tenant": "call"}
+	if records[0].Message != "hello world" || !reflect.DeepEqual(records[0].Fields, expected) {
+		t.Errorf("unexpected record: %q %v", records[0].Message, records[0].Fields)
+	}
+	if !reflect.DeepEqual(records[1].Fields, Fields{"service": "api", "user": "bound"}) {
+		t.Errorf("unexpected fields: %v", records[1].Fields)
+	}
+	if fields := FieldsFromContext(ctx); len(fields) != 3 {
+		t.Errorf("unexpected context fields: %v", fields)
+	}
+
+	rl, err := NewRequestLogger(RequestLogOptions{Logger: log})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var requestID interface{}
+	handler := rl.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requestID = FieldsFromContext(r.Context())["request_id"]
+	}))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest("GET", "/", nil))
+	if requestID == nil || requestID != recorder.Header().Get(rl.RequestIDHeader()) {
+		t.Errorf("unexpected request ID: %v", requestID)
+	}
+}
+
+func TestSampledDebug(t *testing.T) {
+	ring, _ := NewRingHandler(10)
+	log := GetLogger("sampled")
+	log.SetLevel(INFO)
+	log.AddHandler(ring)
+	defer log.RemoveHandler(ring)
+
+	sampled := ContextWithFields(context.Background(), TraceFields(http.Header{
+		"Traceparent": {"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+	}))
+	flagged := ContextWithVerbose(context.Background(), true)
+	plain := context.Background()
+
+	log.DebugCtx(sampled, "not sampled yet")
+	SampledDebug(true)
+	defer SampledDebug(false)
+	log.DebugCtx(sampled, "sampled")
+	log.LogCtx(flagged, TRACE, "flagged")
+	log.DebugCtx(plain, "plain")
+	log.DebugCtx(ContextWithVerbose(sampled, false), "unflagged")
+	log.InfoCtx(plain, "info")
+
+	var messages []string
+	for _, rec := range ring.Records() {
+		messages = append(messages, rec.Message)
+	}
+	if !reflect.DeepEqual(messages, []string{"sampled", "flagged", "info"}) {
+		t.Errorf("unexpected messages: %v", messages)
+	}
+	if !IsVerbose(sampled) || IsVerbose(plain) {
+		t.Error("unexpected verbosity")
+	}
+}
+
+func TestMDC(t *testing.T) {
+	ring, _ := NewRingHandler(10)
+	logger := GetLogger("mdc")
+	logger.AddHandler(ring)
+	defer logger.RemoveHandler(ring)
+
+	mdc := PushContext(nil, "user", "bob")
+	log := logger.WithMDC(mdc).With("", "bound", 1)
+	PushContext(mdc, Fields{"order": 42, "user": "alice smith"})
+	log.Info("shipped")
+	if frame := PopContext(mdc); !reflect.DeepEqual(frame, Fields{"order": 42, "user": "alice smith"}) {
+		t.Errorf("unexpected frame: %v", frame)
+	}
+	log.Info("done")
+	PopContext(mdc)
+	log.Info("out")
+	if PopContext(mdc) != nil || mdc.Depth() != 0 {
+		t.Error("unexpected frames")
+	}
+
+	f, _ := NewTemplateFormatter("{message} [{mdc}]")
+	var lines []string
+	records := ring.Records()
+	for idx := range records {
+		out, _ := f.Format(&records[idx])
+		lines = append(lines, string(out))
+	}
+	expected := []string{`shipped [order=42 user="alice smith"]`, "done [user=bob]", "out []"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("unexpected lines: %q", lines)
+	}
+}
+
+func TestLevelDisplayName(t *testing.T) {
+	SetLevelDisplayName(WARNING, "WARN")
+	SetLevelDisplayName(ERROR, "Ошибка")
+	defer SetLevelDisplayName(WARNING, "")
+	defer SetLevelDisplayName(ERROR, "")
+
+	if LevelName(WARNING) != "WARN" || Level(ERROR).String() != "Ошибка" || LevelName(INFO) != "INFO" {
+		t.Errorf("unexpected names: %s %s %s", LevelName(WARNING), LevelName(ERROR), LevelName(INFO))
+	}
+	f, _ := NewTemplateFormatter("{level} {message}")
+	if out, _ := f.Format(&Record{Level: WARNING, Message: "m"}); string(out) != "WARN m" {
+		t.Errorf("unexpected output: %q", out)
+	}
+	for name, expected := range map[string]Level{"warn": WARNING, "WARNING": WARNING, "ОШИБКА": ERROR, "error": ERROR} {
+		if lvl, err := ParseLevel(name); err != nil || lvl != expected {
+			t.Errorf("%s: unexpected level %v (%v)", name, lvl, err)
+		}
+	}
+	var colors map[Level]string
+	if err := json.Unmarshal([]byte(`{"ошибка": "red", "WARNING": "yellow"}`), &colors); err != nil ||
+		colors[ERROR] != "red" || colors[WARNING] != "yellow" {
+		t.Errorf("unexpected colors: %v (%v)", colors, err)
+	}
+
+	SetLevelDisplayName(WARNING, "")
+	if LevelName(WARNING) != "WARNING" {
+		t.Errorf("unexpected name: %s", LevelName(WARNING))
+	}
+}
+
+func TestDateDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clock := &testClock{time.Date(2024, 5, 1, 23, 59, 0, 0, time.Local)}
+	SetClock(clock)
+	defer SetClock(nil)
+
+	filename := filepath.Join(dir, "app.log")
+	h, err := NewFileHandlerWithOptions(filename, FileOptions{Append: true, DateDirs: "{year}/{month}/{day}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, _ := NewTemplateFormatter("{message}")
+	h.SetFormatter(f)
+	_ = h.Handle(&Record{Level: INFO, Message: "first", Time: clock.Now()})
+	_ = h.Flush()
+	clock.t = clock.t.Add(2 * time.Minute)
+	_ = h.Handle(&Record{Level: INFO, Message: "second", Time: clock.Now()})
+	h.Shutdown()
+
+	for path, expected := range map[string]string{"2024/05/01/app.log": "first\n", "2024/05/02/app.log": "second\n"} {
+		if data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(path))); err != nil || string(data) != expected {
+			t.Errorf("%s: unexpected content %q (%v)", path, data, err)
+		}
+	}
+
+	if got := datedFilename("logs/app.log", "{isoyear}/W{week}", time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)); got != filepath.FromSlash("logs/2020/W53/app.log") {
+		t.Errorf("unexpected ISO week file name: %s", got)
+	}
+	if _, err := NewFileHandlerWithOptions(filename, FileOptions{DateDirs: "archive"}); err == nil {
+		t.Error("date dirs without date token accepted")
+	}
+}
+
+func TestDiskGuard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ring, _ := NewRingHandler(10)
+	diagnostics := GetLogger("diskguard")
+	diagnostics.AddHandler(ring)
+	defer diagnostics.RemoveHandler(ring)
+	SetDiagnosticsLogger(diagnostics)
+	defer SetDiagnosticsLogger(nil)
+
+	filename := filepath.Join(dir, "app.log")
+	for idx, name := range []string{"app.log.2", "app.log.1"} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, make([]byte, 100), 0644); err != nil {
+			t.Fatal(err)
+		}
+		mtime := time.Now().Add(time.Duration(idx-2) * time.Hour)
+		_ = os.Chtimes(path, mtime, mtime)
+	}
+	_ = ioutil.WriteFile(filepath.Join(dir, "other.log"), make([]byte, 1000), 0644)
+	h, err := NewFileHandlerWithOptions(filename, FileOptions{Append: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Shutdown()
+	f, _ := NewTemplateFormatter("{message}")
+	h.SetFormatter(f)
+	_ = h.Handle(&Record{Level: INFO, Message: strings.Repeat("x", 49)})
+	_ = h.Flush()
+
+	guard := &diskGuard{options: DiskGuardOptions{MaxBytes: 200, DeleteRotated: true}}
+	guard.check() // 250 bytes: the oldest rotated file is deleted
+	if _, err := os.Stat(filepath.Join(dir, "app.log.2")); !os.IsNotExist(err) {
+		t.Errorf("oldest rotated file not deleted: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "app.log.1")); err != nil {
+		t.Errorf("rotated file deleted: %v", err)
+	}
+
+	guard.options = DiskGuardOptions{MaxBytes: 100}
+	guard.check() // 150 bytes, nothing to delete: the records are dropped
+	_ = h.Handle(&Record{Level: INFO, Message: "dropped"})
+	if stats := h.Stats(); stats.Dropped != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	guard.options.MaxBytes = 1000
+	guard.check()
+	_ = h.Handle(&Record{Level: INFO, Message: "written"})
+	_ = h.Flush()
+	if data, _ := ioutil.ReadFile(filename); !strings.HasSuffix(string(data), "written\n") {
+		t.Errorf("unexpected file content: %q", data)
+	}
+
+	var warnings []string
+	for _, rec := range ring.Records() {
+		warnings = append(warnings, rec.Message)
+	}
+	if len(warnings) != 3 || !strings.Contains(warnings[0], "deleted 1 rotated") ||
+		!strings.Contains(warnings[1], "dropping records") || !strings.Contains(warnings[2], "writing records") {
+		t.Errorf("unexpected warnings: %q", warnings)
+	}
+
+	if _, err := GuardDiskUsage(DiskGuardOptions{}); err == nil {
+		t.Error("no budget accepted")
+	}
+	stop, err := GuardDiskUsage(DiskGuardOptions{MaxBytes: 1 << 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stop()
+}
+
+func TestStallWatchdog(t *testing.T) {
+	formatter, _ := NewTemplateFormatter("{message}")
+	w := &gatedWriter{release: make(chan struct{})}
+	h, _ := NewStreamHandler(w)
+	h.SetFormatter(formatter)
+	failover, _ := NewRingHandler(10)
+	h.SetStallWatchdog(StallOptions{Timeout: 20 * time.Millisecond, Failover: failover})
+
+	_ = h.Handle(&Record{Level: INFO, Message: "hung"})
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&h.stalled) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = h.Handle(&Record{Level: INFO, Message: "failed over"})
+	if records := failover.Records(); len(records) != 1 || records[0].Message != "failed over" {
+		t.Errorf("unexpected failover records: %v", records)
+	}
+
+	close(w.release)
+	for atomic.LoadInt32(&h.stalled) != 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	_ = h.Handle(&Record{Level: INFO, Message: "written"})
+	h.Shutdown()
+	if writes := atomic.LoadInt32(&w.writes); writes != 2 {
+		t.Errorf("unexpected writes: %d", writes)
+	}
+
+	w = &gatedWriter{release: make(chan struct{})}
+	h, _ = NewStreamHandler(w)
+	h.SetFormatter(formatter)
+	h.SetStallWatchdog(StallOptions{Timeout: 20 * time.Millisecond, Drop: true})
+	_ = h.Handle(&Record{Level: INFO, Message: "hung"})
+	for atomic.LoadInt32(&h.stalled) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	for idx := 0; idx < 200; idx++ { // more than the queue holds, without blocking
+		_ = h.Handle(&Record{Level: INFO, Message: "dropped"})
+	}
+	if stats := h.Stats(); stats.Dropped != 200 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	close(w.release)
+	h.Shutdown()
+}
+
+func TestHealth(t *testing.T) {
+	formatter, _ := NewTemplateFormatter("{message}")
+	good, _ := NewStreamHandler(ioutil.Discard)
+	good.SetFormatter(formatter)
+	bad, _ := NewStreamHandler(failingWriter{})
+	bad.SetFormatter(formatter)
+	BasicConfig(BasicConfigOpts{Level: INFO, Handlers: []Handler{good, bad}})
+	defer Shutdown()
+
+	if report := Health(); report.Status != HealthOK || len(report.Handlers) != 2 {
+		t.Errorf("unexpected report: %+v", report)
+	}
+	GetLogger().Error("failing")
+	_ = bad.Flush()
+	report := Health()
+	if report.Status != HealthDegraded || report.Handlers[0].Status != HealthOK ||
+		report.Handlers[1].Status != HealthDegraded || report.Handlers[1].LastError != "disk full" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	clock := &testClock{time.Now().Add(HealthErrorWindow)}
+	SetClock(clock)
+	defer SetClock(nil)
+	if report := Health(); report.Status != HealthOK {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	atomic.StoreInt32(&good.stalled, 1)
+	defer atomic.StoreInt32(&good.stalled, 0)
+	recorder := httptest.NewRecorder()
+	HealthHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/healthz", nil))
+	var served HealthReport
+	if err := json.Unmarshal(recorder.Body.Bytes(), &served); err != nil || recorder.Code != http.StatusServiceUnavailable ||
+		served.Status != HealthFailed || served.Handlers[0].Reason != "writer stalled" {
+		t.Errorf("unexpected response: %d %s (%v)", recorder.Code, recorder.Body, err)
+	}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	clock := &testClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	SetClock(clock)
+	defer SetClock(nil)
+
+	b := NewCircuitBreaker(CircuitBreakerOptions{FailureThreshold: 2, MinBackoff: time.Second, MaxBackoff: 4 * time.Second})
+	fail := func() error { return errors.New("unreachable") }
+	calls := 0
+	succeed := func() error { calls++; return nil }
+
+	_ = b.Do(fail)
+	if b.State() != CircuitClosed {
+		t.Fatalf("expected closed after 1 failure, got %s", b.State())
+	}
+	_ = b.Do(fail)
+	if b.State() != CircuitOpen {
+		t.Fatalf("expected open after 2 failures, got %s", b.State())
+	}
+	if err := b.Do(succeed); err != ErrCircuitOpen || calls != 0 {
+		t.Fatalf("expected ErrCircuitOpen without calling, got %v (%d calls)", err, calls)
+	}
+
+	// the backoff is 1s ±20%, the failed probe reopens the circuit for 2s ±20%
+	clock.t = clock.t.Add(1300 * time.Millisecond)
+	_ = b.Do(fail)
+	if b.State() != CircuitOpen {
+		t.Fatalf("expected open after the failed probe, got %s", b.State())
+	}
+	clock.t = clock.t.Add(1300 * time.Millisecond)
+	if err := b.Do(succeed); err != ErrCircuitOpen {
+		t.Fatalf("expected the backoff to double, got %v", err)
+	}
+	clock.t = clock.t.Add(1300 * time.Millisecond)
+	if err := b.Do(succeed); err != nil || calls != 1 || b.State() != CircuitClosed {
+		t.Fatalf("expected the probe to close the circuit, got %v, %d calls, %s", err, calls, b.State())
+	}
+}
+
+func TestCircuitWriterSpill(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w := NewCircuitWriter(failingWriter{}, NewCircuitBreaker(CircuitBreakerOptions{FailureThreshold: 1}))
+	if _, err := w.Write([]byte("lost\n")); err == nil {
+		t.Fatal("expected an error without spill file")
+	}
+
+	spill := filepath.Join(dir, "spill", "overflow.log")
+	w.SetSpillFile(spill)
+	for _, line := range []string{"first\n", "second\n"} {
+		if n, err := w.Write([]byte(line)); err != nil || n != len(line) {
+			t.Fatalf("expected the write to be spilled, got %d, %v", n, err)
+		}
+	}
+	_ = w.Close()
+
+	data, err := ioutil.ReadFile(spill)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "first\nsecond\n" || w.Spilled() != uint64(len(data)) {
+		t.Fatalf("unexpected spill file: %q (%d bytes spilled)", data, w.Spilled())
+	}
+}
+
+// flakyWriter collects the writes, failing them while down is set.
+type flakyWriter struct {
+	down   bool
+	writes []string
+}
+
+func (w *flakyWriter) Write(p []byte) (int, error) {
+	if w.down {
+		return 0, errors.New("connection refused")
+	}
+	w.writes = append(w.writes, string(p))
+	return len(p), nil
+}
+
+func TestSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	spool, err := OpenSpool(SpoolOptions{Dir: dir, SegmentSize: 16, MaxBytes: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range []string{"one", "two", "three", "four"} {
+		if err := spool.Append([]byte(entry)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := spool.Append(make([]byte, 64)); err != ErrSpoolFull {
+		t.Fatalf("expected ErrSpoolFull, got %v", err)
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(segments) < 2 {
+		t.Fatalf("expected several segments, got %v", segments)
+	}
+
+	// stop at the first error, the entry is replayed again
+	var sent []string
+	failOn := "three"
+	send := func(p []byte) error {
+		if string(p) == failOn {
+			return errors.New("connection refused")
+		}
+		sent = append(sent, string(p))
+		return nil
+	}
+	if err := spool.Replay(send); err == nil {
+		t.Fatal("expected the replay to fail")
+	}
+	_ = spool.Close()
+
+	// the entries left are kept for the next process
+	spool, err = OpenSpool(SpoolOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer spool.Close()
+	failOn = ""
+	if err := spool.Replay(send); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sent, []string{"one", "two", "three", "four"}) || spool.Len() != 0 {
+		t.Fatalf("unexpected replay: %v (%d bytes left)", sent, spool.Len())
+	}
+	if segments, _ := filepath.Glob(filepath.Join(dir, "*.seg")); len(segments) != 0 {
+		t.Fatalf("expected the segments to be deleted, got %v", segments)
+	}
+
+	// a corrupted entry, its length exceeding its segment, ends the segment
+	corrupted := append([]byte{0xff, 0xff, 0xff, 0xf0}, "five"...)
+	if err := ioutil.WriteFile(filepath.Join(dir, "00000100.seg"), corrupted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenSpool(SpoolOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if err := reopened.Replay(send); err != nil || len(sent) != 4 || reopened.Len() != 0 {
+		t.Fatalf("unexpected replay: %v, %v (%d bytes left)", err, sent, reopened.Len())
+	}
+}
+
+func TestBatchHandlerSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		mu       sync.Mutex
+		down     = true
+		messages []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusForbidden) // not retried
+			return
+		}
+		var logs []map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&logs)
+		for _, log := range logs {
+			messages = append(messages, fmt.Sprint(log["message"]))
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	handler, err := NewDatadogHandler(DatadogConfig{
+		APIKey:             "key",
+		Endpoint:           server.URL,
+		BatchSize:          1,
+		FlushInterval:      10 * time.Millisecond,
+		DisableCompression: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetErrorHandler(func(Handler, error) {})
+	defer SetErrorHandler(nil)
+	spool, err := OpenSpool(SpoolOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetCircuitBreaker(CircuitBreakerOptions{FailureThreshold: 1, MinBackoff: 200 * time.Millisecond})
+	handler.SetSpool(spool)
+
+	// the first record opens the circuit (and is dropped), the next ones are spooled
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "dropped"})
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "second", Tags: Tags{"audit"},
+		MDC: Fields{"user": "bob"}, Extras: map[string]interface{}{"trace_id": "abc"}, File: "db.go", Line: 42})
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "third"})
+	for i := 0; i < 100 && spool.Len() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if spool.Len() == 0 {
+		t.Fatal("no record spooled")
+	}
+	var spooled *Record
+	_ = spool.Scan(func(p []byte) error {
+		if spooled == nil {
+			spooled, err = DecodeBinaryRecord(p)
+		}
+		return err
+	})
+	if spooled == nil || spooled.Message != "second" || !reflect.DeepEqual(spooled.Tags, Tags{"audit"}) ||
+		!reflect.DeepEqual(spooled.MDC, Fields{"user": "bob"}) || spooled.Extras["trace_id"] != "abc" ||
+		spooled.File != "db.go" || spooled.Line != 42 {
+		t.Errorf("unexpected spooled record: %+v (%v)", spooled, err)
+	}
+
+	// once the backoff elapsed, the spooled records are replayed in order
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	handler.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "fourth"})
+	for i := 0; i < 200 && spool.Len() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	handler.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(messages, []string{"second", "third", "fourth"}) {
+		t.Errorf("unexpected messages: %q", messages)
+	}
+}
+
+func TestCircuitWriterSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	clock := &testClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	SetClock(clock)
+	defer SetClock(nil)
+
+	spool, err := OpenSpool(SpoolOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	remote := &flakyWriter{down: true}
+	w := NewCircuitWriter(remote, NewCircuitBreaker(CircuitBreakerOptions{FailureThreshold: 1}))
+	w.SetSpool(spool)
+	defer w.Close()
+
+	for _, line := range []string{"a", "b"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	remote.down = false
+	_, _ = w.Write([]byte("c")) // the circuit is still open
+	clock.t = clock.t.Add(2 * time.Second)
+	_, _ = w.Write([]byte("d"))
+
+	if !reflect.DeepEqual(remote.writes, []string{"a", "b", "c", "d"}) || spool.Len() != 0 {
+		t.Fatalf("unexpected writes: %v (%d bytes spooled)", remote.writes, spool.Len())
+	}
+}
+
+func TestHandleAck(t *testing.T) {
+	w := &recordingWriter{}
+	h, _ := NewStreamHandler(w)
+	formatter, _ := NewTemplateFormatter("{message}")
+	h.SetFormatter(formatter)
+	h.SetBuffering(BufferOptions{FlushInterval: time.Hour})
+
+	acks := make(chan error, 3)
+	ack := func(err error) { acks <- err }
+	_ = h.HandleAck(&Record{Level: INFO, Message: "one"}, ack)
+	_ = h.Handle(&Record{Level: INFO, Message: "two"})
+	_ = h.HandleAck(&Record{Level: NOTSET, Message: "three"}, ack)
+
+	if err := <-acks; err != ErrorNotSet {
+		t.Fatalf("expected the NOTSET record to fail, got %v", err)
+	}
+	select {
+	case err := <-acks:
+		t.Fatalf("unexpected ack before the buffer is flushed: %v", err)
+	default:
+	}
+	_ = h.Flush()
+	if err := <-acks; err != nil {
+		t.Fatalf("expected the record to be acknowledged, got %v", err)
+	}
+
+	h.Shutdown()
+	_ = h.HandleAck(&Record{Level: INFO, Message: "four"}, ack)
+	if err := <-acks; err != ErrDropped {
+		t.Fatalf("expected ErrDropped after shutdown, got %v", err)
+	}
+	if writes := strings.Join(w.Writes(), ""); writes != "one\ntwo\n" {
+		t.Fatalf("unexpected writes: %q", writes)
+	}
+}
+
+func TestWALHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var errorsMu sync.Mutex
+	var errs []error
+	SetErrorHandler(func(h Handler, err error) {
+		errorsMu.Lock()
+		defer errorsMu.Unlock()
+		if _, ok := h.(*WALHandler); ok {
+			errs = append(errs, err)
+		}
+	})
+	defer SetErrorHandler(nil)
+
+	// the records can't be written, so they're not acknowledged
+	broken, _ := NewStreamHandler(failingWriter{})
+	formatter, _ := NewTemplateFormatter("{message} {fields}")
+	broken.SetFormatter(formatter)
+	wal, err := NewWALHandler(broken, WALOptions{Dir: dir, AckInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, message := range []string{"one", "two", "three"} {
+		_ = wal.Handle(&Record{Level: INFO, Message: message, Fields: Fields{"n": len(message)}})
+	}
+	_ = wal.Flush()
+	errorsMu.Lock()
+	if wal.Pending() != 3 || len(errs) != 3 || !strings.Contains(errs[0].Error(), "disk full") {
+		t.Fatalf("expected the records not to be acknowledged, got %d pending, errors %v", wal.Pending(), errs)
+	}
+	errorsMu.Unlock()
+	// the process dies
+	close(wal.stop)
+	<-wal.done
+	_ = wal.wal.Close()
+	broken.Shutdown()
+
+	handler := newRecordingHandler()
+	handler.SetFormatter(formatter)
+	wal, err = NewWALHandler(handler, WALOptions{Dir: dir, AckInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = wal.Handle(&Record{Level: INFO, Message: "four", Fields: Fields{"n": 4}})
+	if lines := handler.lines(); lines != "one n=3|two n=3|three n=5|four n=4" {
+		t.Fatalf("unexpected records: %q", lines)
+	}
+	if err := wal.Flush(); err != nil || wal.Pending() != 0 {
+		t.Fatalf("expected the records to be acknowledged, got %v (%d pending)", err, wal.Pending())
+	}
+	wal.Shutdown()
+
+	handler = newRecordingHandler()
+	handler.SetFormatter(formatter)
+	wal, err = NewWALHandler(handler, WALOptions{Dir: dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Shutdown()
+	if lines := handler.lines(); lines != "" {
+		t.Fatalf("expected no records replayed, got %q", lines)
+	}
+}
+
+func TestWALHandlerRetries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var (
+		mu       sync.Mutex
+		down     = true
+		messages []string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if down {
+			w.WriteHeader(http.StatusForbidden) // not retried by the handler
+			return
+		}
+		var logs []map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&logs)
+		for _, log := range logs {
+			messages = append(messages, fmt.Sprint(log["message"]))
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	var errs int32
+	SetErrorHandler(func(h Handler, err error) {
+		if _, ok := h.(*WALHandler); ok {
+			atomic.AddInt32(&errs, 1)
+		}
+	})
+	defer SetErrorHandler(nil)
+
+	handler, err := NewDatadogHandler(DatadogConfig{APIKey: "key", Endpoint: server.URL, BatchSize: 1, DisableCompression: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.SetLevel(INFO)
+	wal, err := NewWALHandler(handler, WALOptions{Dir: dir, AckInterval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer wal.Shutdown()
+
+	_ = wal.Handle(&Record{Time: time.Now(), Level: DEBUG, Message: "below the level"})
+	if wal.wal.Len() != 0 || wal.Pending() != 0 {
+		t.Fatalf("record below the level logged, %d pending", wal.Pending())
+	}
+	_ = wal.Handle(&Record{Time: time.Now(), Level: ERROR, Message: "failed"})
+	for i := 0; i < 200 && atomic.LoadInt32(&errs) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := wal.Flush(); err != nil || wal.Pending() != 1 || atomic.LoadInt32(&errs) != 1 {
+		t.Fatalf("expected the record not to be acknowledged, got %v (%d pending)", err, wal.Pending())
+	}
+
+	// the failed record is read from the log and sent again
+	mu.Lock()
+	down = false
+	mu.Unlock()
+	if err := wal.retry(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200 && wal.Pending() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := wal.Flush(); err != nil || wal.Pending() != 0 || wal.wal.Len() != 0 {
+		t.Fatalf("expected the record to be acknowledged, got %v (%d pending)", err, wal.Pending())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(messages, []string{"failed"}) {
+		t.Errorf("unexpected messages: %q", messages)
+	}
+}
+
+func TestReopenOnSignal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	fileName := filepath.Join(dir, "reopened.log")
+	BasicConfig(BasicConfigOpts{Level: INFO, FileName: fileName, Format: "{message}"})
+	defer Shutdown()
+	log := GetLogger()
+	console, _ := NewConsoleHandler()
+	console.SetLevel(FATAL)
+	_ = log.AddHandler(console) // not reopened
+
+	log.Info("before")
+	if err = os.Rename(fileName, fileName+".1"); err != nil {
+		t.Fatal(err)
+	}
+	stop := ReopenOnSignal(syscall.SIGUSR1)
+	defer stop()
+	if err = syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		if _, err = os.Stat(fileName); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	log.Info("after")
+	Shutdown()
+
+	if data, _ := ioutil.ReadFile(fileName + ".1"); string(data) != "before\n" {
+		t.Errorf("unexpected rotated content: %q", data)
+	}
+	if data, _ := ioutil.ReadFile(fileName); string(data) != "after\n" {
+		t.Errorf("unexpected content: %q", data)
+	}
+	if err = console.Reopen(); err == nil {
+		t.Error("expected an error reopening a console handler")
+	}
+}
+
 func BenchmarkAllLogged(b *testing.B) {
 	BasicConfig(BasicConfigOpts{
 		FileName: "/dev/null",
@@ -285,6 +4923,19 @@ func BenchmarkNoneLogged(b *testing.B) {
 	printPerf(b.N, duration)
 }
 
+func BenchmarkDisabledDebug(b *testing.B) {
+	BasicConfig(BasicConfigOpts{Level: INFO, Writer: ioutil.Discard})
+	defer Shutdown()
+	log := GetLogger("quiet")
+	log.SetLevel(INFO)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		log.Debug("request %s took %d ms", "GET /", 42)
+	}
+}
+
 func BenchmarkMultiAllLogged(b *testing.B) {
 	BasicConfig(BasicConfigOpts{
 		Level:    DEBUG,
@@ -318,6 +4969,36 @@ func BenchmarkMultiAllLogged(b *testing.B) {
 	printPerf(width*b.N, duration)
 }
 
+func BenchmarkWatchedFileStatPerWrite(b *testing.B) {
+	benchmarkWatchedFile(b, 0)
+}
+
+func BenchmarkWatchedFileNotify(b *testing.B) {
+	benchmarkWatchedFile(b, DefaultStatInterval)
+}
+
+func benchmarkWatchedFile(b *testing.B, statInterval time.Duration) {
+	dir, err := ioutil.TempDir("", "log4go")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	handler, err := NewWatchedFileHandler(filepath.Join(dir, "bench.log"), true, false)
+	if err != nil {
+		b.Fatal(err)
+	}
+	formatter, _ := NewTemplateFormatter("{message}")
+	handler.SetFormatter(formatter)
+	defer handler.Shutdown()
+	handler.SetStatInterval(statInterval)
+
+	b.ResetTimer()
+	for idx := 0; idx < b.N; idx++ {
+		handler.fileHasMoved()
+	}
+}
+
 func printPerf(n int, d time.Duration) {
 	secs := d.Seconds()
 
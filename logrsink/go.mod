@@ -0,0 +1,20 @@
+module github.com/kaizer666/log4go/logrsink
+
+// To build against the log4go checkout, rather than the required version, use the workspace
+// created from the repository's root by:
+//
+//	go work init . ./echolog ./fiberlog ./ginlog ./logrsink ./prometheus
+
+go 1.22
+
+require (
+	github.com/go-logr/logr v1.2.4
+	github.com/kaizer666/log4go v0.0.0-20261017070848-da72bd423885
+)
+
+require (
+	github.com/fsnotify/fsnotify v1.7.0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+)
@@ -0,0 +1,100 @@
+// Package logrsink provides a github.com/go-logr/logr LogSink logging through log4go.
+package logrsink
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/kaizer666/log4go"
+)
+
+// New returns a logr.Logger logging through the given log4go Logger.
+func New(logger *log4go.Logger) logr.Logger {
+	return logr.New(NewSink(logger))
+}
+
+// NewSink returns a logr.LogSink logging through the given log4go Logger.
+//
+// V-levels are mapped as: V(0) -> INFO, V(1) -> DEBUG, V(2) and above -> TRACE; WithName
+// uses the corresponding log4go sub-logger. Key/value pairs are appended to the message.
+func NewSink(logger *log4go.Logger) logr.LogSink {
+	return &sink{logger: logger}
+}
+
+type sink struct {
+	logger *log4go.Logger
+	values []interface{}
+}
+
+var _ logr.LogSink = &sink{}
+
+// Level maps a logr V-level to a log4go Level.
+func Level(v int) log4go.Level {
+	switch {
+	case v <= 0:
+		return log4go.INFO
+	case v == 1:
+		return log4go.DEBUG
+	default:
+		return log4go.TRACE
+	}
+}
+
+func (s *sink) Init(logr.RuntimeInfo) {}
+
+func (s *sink) Enabled(level int) bool {
+	return s.logger.IsEnabled(Level(level))
+}
+
+func (s *sink) Info(level int, msg string, keysAndValues ...interface{}) {
+	s.logger.Log(Level(level), "%s", s.format(msg, nil, keysAndValues))
+}
+
+func (s *sink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.logger.Log(log4go.ERROR, "%s", s.format(msg, err, keysAndValues))
+}
+
+func (s *sink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	values := make([]interface{}, 0, len(s.values)+len(keysAndValues))
+	values = append(values, s.values...)
+	values = append(values, keysAndValues...)
+	return &sink{logger: s.logger, values: values}
+}
+
+func (s *sink) WithName(name string) logr.LogSink {
+	return &sink{logger: s.logger.GetLogger(name), values: s.values}
+}
+
+func (s *sink) format(msg string, err error, keysAndValues []interface{}) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	if err != nil {
+		writeValue(&b, "error", err)
+	}
+	writeValues(&b, s.values)
+	writeValues(&b, keysAndValues)
+	return b.String()
+}
+
+func writeValues(b *strings.Builder, keysAndValues []interface{}) {
+	for idx := 0; idx < len(keysAndValues); idx += 2 {
+		key := fmt.Sprint(keysAndValues[idx])
+		var value interface{} = "(MISSING)"
+		if idx+1 < len(keysAndValues) {
+			value = keysAndValues[idx+1]
+		}
+		writeValue(b, key, value)
+	}
+}
+
+func writeValue(b *strings.Builder, key string, value interface{}) {
+	s := fmt.Sprint(value)
+	if strings.ContainsAny(s, " \t\n\"=") {
+		s = fmt.Sprintf("%q", s)
+	}
+	b.WriteString(" ")
+	b.WriteString(key)
+	b.WriteString("=")
+	b.WriteString(s)
+}
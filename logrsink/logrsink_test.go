@@ -0,0 +1,32 @@
+package logrsink
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/kaizer666/log4go"
+)
+
+func TestSink(t *testing.T) {
+	var buf bytes.Buffer
+	log4go.BasicConfig(log4go.BasicConfigOpts{
+		Level:  log4go.DEBUG,
+		Writer: &buf,
+		Format: "{name} {level} {message}",
+	})
+
+	log := New(log4go.GetLogger()).WithName("ctrl").WithValues("pod", "web 1")
+	log.Info("reconciled", "count", 3)
+	log.V(1).Info("details")
+	log.V(2).Info("filtered out")
+	log.Error(errors.New("boom"), "failed")
+	log4go.Shutdown()
+
+	expected := "ctrl INFO reconciled pod=\"web 1\" count=3\n" +
+		"ctrl DEBUG details pod=\"web 1\"\n" +
+		"ctrl ERROR failed error=boom pod=\"web 1\"\n"
+	if got := buf.String(); got != expected {
+		t.Errorf("unexpected output: %q", got)
+	}
+}
@@ -0,0 +1,111 @@
+package log4go
+
+import (
+	"sort"
+	"sync"
+)
+
+// MDC is a mapped diagnostic context, as log4j's: a stack of key/value frames pushed and popped
+// around the code they describe, whose values are attached to the records of the loggers using
+// it (see WithMDC) and written by the {mdc} template token. Rather than being bound to the
+// goroutine, an MDC is a handle passed along (or kept) by the code it's scoped to:
+//
+//	mdc := log4go.PushContext(nil, "user", user)
+//	log := logger.WithMDC(mdc)
+//	log4go.PushContext(mdc, "order", id)
+//	log.Info("shipped") // user=... order=...
+//	log4go.PopContext(mdc)
+//
+// It's safe for concurrent use.
+type MDC struct {
+	mu     sync.Mutex
+	frames []Fields
+	values Fields // the frames merged, the later ones overriding the others; not modified
+}
+
+// PushContext pushes a frame of the key/value pairs (or Fields) of kv on mdc, a new MDC if nil,
+// and returns mdc.
+func PushContext(mdc *MDC, kv ...interface{}) *MDC {
+	if mdc == nil {
+		mdc = &MDC{}
+	}
+	mdc.mu.Lock()
+	defer mdc.mu.Unlock()
+
+	mdc.frames = append(mdc.frames, bindFields(nil, "", kv))
+	mdc.merge()
+	return mdc
+}
+
+// PopContext pops the last frame pushed on mdc, if any, returning its values.
+func PopContext(mdc *MDC) Fields {
+	mdc.mu.Lock()
+	defer mdc.mu.Unlock()
+
+	if len(mdc.frames) == 0 {
+		return nil
+	}
+	frame := mdc.frames[len(mdc.frames)-1]
+	mdc.frames = mdc.frames[:len(mdc.frames)-1]
+	mdc.merge()
+	return frame
+}
+
+// Depth returns the number of frames of the MDC.
+func (mdc *MDC) Depth() int {
+	mdc.mu.Lock()
+	defer mdc.mu.Unlock()
+	return len(mdc.frames)
+}
+
+// Values returns the values of the MDC's frames, the later frames' overriding the others, nil
+// if none; they must not be modified.
+func (mdc *MDC) Values() Fields {
+	if mdc == nil {
+		return nil
+	}
+	mdc.mu.Lock()
+	defer mdc.mu.Unlock()
+	return mdc.values
+}
+
+// merge updates the merged values, mdc.mu must be held.
+func (mdc *MDC) merge() {
+	var values Fields
+	for _, frame := range mdc.frames {
+		if len(frame) > 0 && values == nil {
+			values = make(Fields)
+		}
+		for key, value := range frame {
+			values[key] = value
+		}
+	}
+	mdc.values = values
+}
+
+// WithMDC returns a child logger (see With) attaching the values of mdc at the time of the
+// logging calls to their records, see Record.MDC.
+func (l *Logger) WithMDC(mdc *MDC) *Logger {
+	child := l.With("")
+	child.mdc = mdc
+	return child
+}
+
+// appendMDC appends the values as space separated key=value pairs, sorted by key, the values
+// quoted if needed.
+func appendMDC(dst []byte, values Fields) []byte {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for idx, key := range keys {
+		if idx > 0 {
+			dst = append(dst, ' ')
+		}
+		dst = append(dst, key...)
+		dst = append(dst, '=')
+		dst = append(dst, fieldValue(values[key])...)
+	}
+	return dst
+}
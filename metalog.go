@@ -0,0 +1,103 @@
+package log4go
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+// metaLogger is the logger of the loggers' changes, see SetMetaLogger.
+var metaLogger atomic.Value // metaLoggerValue
+
+// metaLoggerValue wraps the meta logger for atomic.Value (which needs a consistent type).
+type metaLoggerValue struct {
+	*Logger
+}
+
+// SetMetaLogger sets the logger recording the runtime changes of the loggers' levels and
+// handlers, as INFO records like "level of logger app/db changed from INFO to DEBUG by http
+// 10.0.0.1:51234" with the fields "logger", "change" ("level" or "handlers"), "old", "new"
+// and "source", what made the change:
+//
+//   - "api": the Logger methods (SetLevel, AddHandler...)
+//   - "http <remote address>": a LevelHandler request, "http ttl" when its TTL expires
+//   - "overrides": SetLevelOverrides
+//   - "config": Configure (and ConfigureFromFile, WatchConfig)
+//
+// nil, the default, records nothing.
+func SetMetaLogger(l *Logger) {
+	metaLogger.Store(metaLoggerValue{l})
+}
+
+// getMetaLogger returns the meta logger, nil if none.
+func getMetaLogger() *Logger {
+	meta, _ := metaLogger.Load().(metaLoggerValue)
+	return meta.Logger
+}
+
+// logChange logs a change of a logger to the meta logger, if any and the values differ.
+func logChange(l *Logger, change, old, new, source string) {
+	meta := getMetaLogger()
+	if meta == nil || old == new || !meta.IsEnabled(INFO) {
+		return
+	}
+	name := l.name
+	if len(name) == 0 {
+		name = "root"
+	}
+	meta.log(INFO, false, "%s of logger %s changed from %s to %s by %s", change, name, old, new, source,
+		Fields{"logger": name, "change": change, "old": old, "new": new, "source": source})
+}
+
+// logLevelChange logs the change of a logger's level from old.
+func logLevelChange(l *Logger, old Level, source string) {
+	logChange(l, "level", old.String(), l.loadLevel().String(), source)
+}
+
+// logHandlersChange logs the change of a logger's handlers from old.
+func logHandlersChange(l *Logger, old []Handler, source string) {
+	if getMetaLogger() != nil {
+		logChange(l, "handlers", handlerNames(old), handlerNames(l.loadHandlers()), source)
+	}
+}
+
+// handlerNames returns the names of the handlers (see handlerName), "none" if there are none.
+func handlerNames(handlers []Handler) string {
+	if len(handlers) == 0 {
+		return "none"
+	}
+	names := make([]string, len(handlers))
+	for idx, h := range handlers {
+		names[idx] = handlerName(h)
+	}
+	return strings.Join(names, ", ")
+}
+
+// loggersSnapshot is the state of loggers, whose changes are logged by logChanges.
+type loggersSnapshot struct {
+	loggers []*Logger
+	states  []loggerState
+}
+
+// snapshotLoggers returns the state of the loggers, nil if there's no meta logger to log their
+// changes to.
+func snapshotLoggers(loggers []*Logger) *loggersSnapshot {
+	if getMetaLogger() == nil {
+		return nil
+	}
+	s := &loggersSnapshot{loggers: loggers, states: make([]loggerState, len(loggers))}
+	for idx, l := range loggers {
+		s.states[idx] = loggerState{level: l.loadLevel(), handlers: l.loadHandlers()}
+	}
+	return s
+}
+
+// logChanges logs the changes of the loggers since the snapshot, if any.
+func (s *loggersSnapshot) logChanges(source string) {
+	if s == nil {
+		return
+	}
+	for idx, l := range s.loggers {
+		logLevelChange(l, s.states[idx].level, source)
+		logHandlersChange(l, s.states[idx].handlers, source)
+	}
+}
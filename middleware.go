@@ -0,0 +1,312 @@
+package log4go
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RequestLogOptions configures a RequestLogger.
+type RequestLogOptions struct {
+	// Logger logs the requests (default the "http" logger).
+	Logger *Logger
+	// Level is the level of the requests' records (default INFO); the 5xx responses are
+	// logged at ERROR level.
+	Level Level
+	// Format is the template of the records' messages, where {method}, {path}, {query},
+	// {proto}, {status}, {size}, {latency}, {remote_ip}, {user}, {user_agent}, {referer} and
+	// {request_id} are replaced by the request's values (default "{method} {path} {status}
+	// {size} {latency}").
+	Format string
+	// RequestIDHeader is the header of the requests' IDs, which are generated when missing
+	// and set in the responses (default "X-Request-Id").
+	RequestIDHeader string
+	// TrustProxy takes the remote IP from the X-Forwarded-For (or X-Real-IP) header, to be
+	// set only behind proxies setting it.
+	TrustProxy bool
+}
+
+// RequestInfo describes a handled HTTP request, as logged by a RequestLogger. Its values are
+// the fields of the records: "method", "path", "query", "proto", "status", "size" (int64),
+// "latency" (time.Duration), "remote_ip", "user", "user_agent", "referer" and "request_id",
+// the empty ones being omitted.
+type RequestInfo struct {
+	Method    string
+	Path      string
+	Query     string
+	Proto     string
+	Status    int
+	Size      int64 // of the response body
+	Latency   time.Duration
+	RemoteIP  string
+	User      string // from the basic authentication
+	UserAgent string
+	Referer   string
+	RequestID string
+}
+
+// RequestLogger logs HTTP requests: see Handler for net/http, other frameworks can call
+// NewRequestInfo, RequestScoped and Log.
+type RequestLogger struct {
+	options RequestLogOptions
+	format  []formatPart
+}
+
+// formatPart is a literal part of a message format, or a request value (field) to replace.
+type formatPart struct {
+	literal string
+	field   string
+}
+
+// NewRequestLogger returns a new RequestLogger, or an error if the format has unknown tokens.
+func NewRequestLogger(options RequestLogOptions) (*RequestLogger, error) {
+	if options.Logger == nil {
+		options.Logger = GetLogger("http")
+	}
+	if options.Level == NOTSET {
+		options.Level = INFO
+	}
+	if len(options.Format) == 0 {
+		options.Format = "{method} {path} {status} {size} {latency}"
+	}
+	if len(options.RequestIDHeader) == 0 {
+		options.RequestIDHeader = "X-Request-Id"
+	}
+
+	rl := &RequestLogger{options: options}
+	format := options.Format
+	for len(format) > 0 {
+		start, end := strings.IndexByte(format, '{'), -1
+		if start >= 0 {
+			end = strings.IndexByte(format[start:], '}') + start
+		}
+		if start < 0 || end < start {
+			rl.format = append(rl.format, formatPart{literal: format})
+			break
+		}
+		if start > 0 {
+			rl.format = append(rl.format, formatPart{literal: format[:start]})
+		}
+		field := format[start+1 : end]
+		if _, ok := (&RequestInfo{}).value(field); !ok {
+			return nil, fmt.Errorf("log4go.RequestLogger: unknown token {%s}", field)
+		}
+		rl.format = append(rl.format, formatPart{field: field})
+		format = format[end+1:]
+	}
+	return rl, nil
+}
+
+// Handler returns a net/http middleware logging the requests handled by next, once handled.
+// The requests' contexts carry a request-scoped logger (see RequestScoped and FromContext), and
+// the request ID as a field for the Ctx logging methods (see ContextWithFields).
+func (rl *RequestLogger) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := now()
+		info := rl.NewRequestInfo(r)
+		w.Header().Set(rl.options.RequestIDHeader, info.RequestID)
+		ctx := ContextWithFields(r.Context(), "request_id", info.RequestID)
+		r = r.WithContext(NewContext(ctx, rl.RequestScoped(info)))
+
+		rw := &responseWriter{ResponseWriter: w}
+		defer func() {
+			info.Status, info.Size = rw.status, rw.size
+			if info.Status == 0 {
+				info.Status = http.StatusOK
+			}
+			info.Latency = now().Sub(start)
+			rl.Log(info)
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// RequestIDHeader returns the header of the requests' IDs.
+func (rl *RequestLogger) RequestIDHeader() string {
+	return rl.options.RequestIDHeader
+}
+
+// NewRequestInfo returns the RequestInfo of a request (to complete once handled), with its
+// ID, from the RequestIDHeader or generated.
+func (rl *RequestLogger) NewRequestInfo(r *http.Request) RequestInfo {
+	info := RequestInfo{
+		Method:    r.Method,
+		Path:      r.URL.Path,
+		Query:     r.URL.RawQuery,
+		Proto:     r.Proto,
+		RemoteIP:  rl.remoteIP(r),
+		UserAgent: r.UserAgent(),
+		Referer:   r.Referer(),
+		RequestID: r.Header.Get(rl.options.RequestIDHeader),
+	}
+	if user, _, ok := r.BasicAuth(); ok {
+		info.User = user
+	}
+	if len(info.RequestID) == 0 {
+		info.RequestID = NewRequestID()
+	}
+	return info
+}
+
+func (rl *RequestLogger) remoteIP(r *http.Request) string {
+	if rl.options.TrustProxy {
+		if forwarded := r.Header.Get("X-Forwarded-For"); len(forwarded) > 0 {
+			if i := strings.IndexByte(forwarded, ','); i >= 0 {
+				forwarded = forwarded[:i]
+			}
+			return strings.TrimSpace(forwarded)
+		}
+		if ip := r.Header.Get("X-Real-IP"); len(ip) > 0 {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// NewRequestID returns a new random request ID.
+func NewRequestID() string {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// RequestScoped returns the request's logger, a child of the logger binding the request's ID
+// and method and path (see Logger.With).
+func (rl *RequestLogger) RequestScoped(info RequestInfo) *Logger {
+	return rl.options.Logger.With("", "request_id", info.RequestID, "method", info.Method, "path", info.Path)
+}
+
+// Log logs a handled request.
+func (rl *RequestLogger) Log(info RequestInfo) {
+	level := rl.options.Level
+	if info.Status >= 500 {
+		level = ERROR
+	}
+	logger := rl.options.Logger
+	if !logger.IsEnabled(level) {
+		return
+	}
+
+	var message strings.Builder
+	for _, part := range rl.format {
+		if len(part.field) == 0 {
+			message.WriteString(part.literal)
+		} else if s, _ := info.value(part.field); len(s) > 0 {
+			message.WriteString(s)
+		} else {
+			message.WriteByte('-')
+		}
+	}
+	logger.Log(level, "%s", message.String(), info.fields())
+}
+
+// value returns the request's value named field, as a string.
+func (info *RequestInfo) value(field string) (string, bool) {
+	switch field {
+	case "method":
+		return info.Method, true
+	case "path":
+		return info.Path, true
+	case "query":
+		return info.Query, true
+	case "proto":
+		return info.Proto, true
+	case "status":
+		return strconv.Itoa(info.Status), true
+	case "size":
+		return strconv.FormatInt(info.Size, 10), true
+	case "latency":
+		return info.Latency.String(), true
+	case "remote_ip":
+		return info.RemoteIP, true
+	case "user":
+		return info.User, true
+	case "user_agent":
+		return info.UserAgent, true
+	case "referer":
+		return info.Referer, true
+	case "request_id":
+		return info.RequestID, true
+	}
+	return "", false
+}
+
+func (info *RequestInfo) fields() Fields {
+	fields := Fields{
+		"method":  info.Method,
+		"path":    info.Path,
+		"status":  info.Status,
+		"size":    info.Size,
+		"latency": info.Latency,
+	}
+	add := func(key, value string) {
+		if len(value) > 0 {
+			fields[key] = value
+		}
+	}
+	add("query", info.Query)
+	add("proto", info.Proto)
+	add("remote_ip", info.RemoteIP)
+	add("user", info.User)
+	add("user_agent", info.UserAgent)
+	add("referer", info.Referer)
+	add("request_id", info.RequestID)
+	return fields
+}
+
+// responseWriter records the status and size of a response.
+type responseWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (w *responseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Flush flushes the response, if the underlying ResponseWriter supports it.
+func (w *responseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack hijacks the connection, if the underlying ResponseWriter supports it.
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		if w.status == 0 {
+			w.status = http.StatusSwitchingProtocols
+		}
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("log4go: the ResponseWriter doesn't support hijacking")
+}
+
+// Unwrap returns the underlying ResponseWriter, for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,89 @@
+package log4go
+
+import (
+	"strings"
+	"time"
+
+	"github.com/kaizer666/log4go/internal/msgpack"
+)
+
+// MsgpackFormatter formats records as MessagePack maps with the keys "time", "level",
+// "logger", "message" and "seq" plus the record's fields.
+//
+// With Forward set the output is a Fluentd forward protocol message, [tag, time, record]:
+// the time is an EventTime and the tag is TagPrefix followed by the logger name (with
+// slashes replaced by dots).
+type MsgpackFormatter struct {
+	Forward   bool
+	TagPrefix string
+}
+
+// NewMsgpackFormatter returns a new MsgpackFormatter producing plain maps.
+func NewMsgpackFormatter() *MsgpackFormatter {
+	return &MsgpackFormatter{}
+}
+
+// NewFluentMsgpackFormatter returns a new MsgpackFormatter producing Fluentd forward messages.
+func NewFluentMsgpackFormatter(tagPrefix string) *MsgpackFormatter {
+	return &MsgpackFormatter{Forward: true, TagPrefix: tagPrefix}
+}
+
+var _ RawFormatter = &MsgpackFormatter{}
+
+// RawOutput is always true, MessagePack must not be altered.
+func (f *MsgpackFormatter) RawOutput() bool {
+	return true
+}
+
+// Format returns the record as MessagePack.
+func (f *MsgpackFormatter) Format(r *Record) ([]byte, error) {
+	if r.Level == NOTSET {
+		return []byte{}, ErrorNotSet
+	}
+
+	b := make([]byte, 0, 64+len(r.Message))
+	if f.Forward {
+		b = msgpack.AppendArrayHeader(b, 3)
+		b = msgpack.AppendString(b, FluentTag(f.TagPrefix, r.Name))
+		b = msgpack.AppendEventTime(b, r.Time)
+	}
+	return appendMsgpackRecord(b, r, !f.Forward), nil
+}
+
+// appendMsgpackRecord appends the record's map, withTime adds the "time" key.
+func appendMsgpackRecord(b []byte, r *Record, withTime bool) []byte {
+	name := r.Name
+	if len(name) == 0 {
+		name = "root"
+	}
+
+	fields := r.allFields()
+	record := make(map[string]interface{}, 5+len(fields))
+	for key, value := range fields {
+		record[key] = value
+	}
+	if withTime {
+		record["time"] = r.Time.Format(time.RFC3339Nano)
+	}
+	record["level"] = LevelName(r.Level)
+	record["logger"] = name
+	record["message"] = r.Message
+	record["seq"] = r.Seq
+
+	return msgpack.Append(b, record)
+}
+
+// FluentTag returns the Fluentd tag for a logger name: prefix "app" and name "db/pool" give
+// "app.db.pool"; the root logger gives the prefix (or "root" without prefix).
+func FluentTag(prefix, name string) string {
+	name = strings.Trim(strings.Replace(name, "/", ".", -1), ".")
+	switch {
+	case len(prefix) == 0 && len(name) == 0:
+		return "root"
+	case len(prefix) == 0:
+		return name
+	case len(name) == 0:
+		return prefix
+	}
+	return prefix + "." + name
+}
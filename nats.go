@@ -0,0 +1,478 @@
+package log4go
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NATSConfig configures a NATSHandler.
+type NATSConfig struct {
+	// URL is the server's URL, nats://[user:password@]host[:port] or tls://... (default
+	// nats://127.0.0.1:4222).
+	URL string
+	// Subject is the subject template, "{name}" is replaced by the logger name (with dots
+	// instead of slashes, "root" for the root logger) and "{level}" by the lowercase level
+	// name (default "logs.{name}.{level}").
+	Subject string
+	// Token, or User and Password, authenticate the connection.
+	Token    string
+	User     string
+	Password string
+	// Name is the connection name shown by the server (default: the program's name).
+	Name string
+	// TLSConfig, if set, is used to secure the connection (it's also used when the server
+	// requires TLS).
+	TLSConfig *tls.Config
+	// JetStream makes the handler wait for the JetStream acks of the published records (a
+	// stream must capture the subjects).
+	JetStream bool
+	// BatchSize is the maximum number of records published before they're confirmed (default 100).
+	BatchSize int
+	// FlushInterval is the maximum time records wait for their batch (default 1s).
+	FlushInterval time.Duration
+	// Timeout limits connecting and waiting for the server (default 5s).
+	Timeout time.Duration
+	// QueueSize is the number of records buffered before Handle blocks (default 1000).
+	QueueSize int
+	// MaxRetries is the number of times a batch is republished before it's dropped (default 3).
+	MaxRetries int
+}
+
+// NATSHandler publishes the formatted records (ECS JSON by default) to NATS subjects, and
+// optionally waits for their JetStream acks.
+type NATSHandler struct {
+	batchHandler
+
+	config  NATSConfig
+	address string
+	user    *url.Userinfo
+	useTLS  bool
+
+	pending []natsMsg
+	retries int
+	lastErr error
+
+	conn       net.Conn // owned by the sender goroutine
+	r          *bufio.Reader
+	inbox      string
+	maxPayload int
+}
+
+type natsMsg struct {
+	subject string
+	data    []byte
+	ack     func(error) // see takeAck
+}
+
+// NewNATSHandler returns a new NATSHandler, connecting lazily to config.URL.
+func NewNATSHandler(config NATSConfig) (*NATSHandler, error) {
+	if len(config.URL) == 0 {
+		config.URL = "nats://127.0.0.1:4222"
+	}
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "nats", "tls":
+	default:
+		return nil, fmt.Errorf("log4go.NATSHandler: unsupported URL scheme: '%s'", u.Scheme)
+	}
+	address := u.Host
+	if len(u.Port()) == 0 {
+		address = net.JoinHostPort(u.Hostname(), "4222")
+	}
+
+	if len(config.Subject) == 0 {
+		config.Subject = "logs.{name}.{level}"
+	}
+	if len(config.Name) == 0 {
+		config.Name = os.Args[0]
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+
+	h := &NATSHandler{
+		config:  config,
+		address: address,
+		user:    u.User,
+		useTLS:  u.Scheme == "tls" || config.TLSConfig != nil,
+	}
+	h.init(config.QueueSize, NewECSFormatter())
+	h.run(h, config.FlushInterval)
+
+	return h, nil
+}
+
+var _ Handler = &NATSHandler{}
+
+// NATSSubject returns the subject of the record for the template (see NATSConfig.Subject).
+func NATSSubject(template string, rec *Record) string {
+	return expandTopic(template, rec)
+}
+
+// expandTopic returns the template with "{name}" replaced by the record's logger name (with
+// dots instead of slashes, "root" for the root logger) and "{level}" by its lowercase level
+// name, and white space replaced by underscores; it's used for the NATS subjects,
+// the AMQP routing keys and the Redis keys.
+func expandTopic(template string, rec *Record) string {
+	name := rec.Name
+	if len(name) == 0 {
+		name = "root"
+	}
+	topic := strings.NewReplacer(
+		"{name}", strings.Replace(name, "/", ".", -1),
+		"{level}", strings.ToLower(LevelName(rec.Level)),
+	).Replace(template)
+	return strings.Join(strings.Fields(topic), "_")
+}
+
+func (h *NATSHandler) add(rec *Record) bool {
+	msg, err := h.Formatter().Format(rec)
+	if err != nil {
+		if err != ErrorNotSet {
+			h.report(fmt.Errorf("formatter error: %w", err))
+			h.counters.countError(err)
+		}
+		return false
+	}
+	if !isRawFormatter(h.Formatter()) {
+		msg = bytes.TrimSuffix(msg, []byte{'\n'})
+	}
+
+	if len(h.pending) >= h.config.BatchSize {
+		h.flush()
+		if len(h.pending) != 0 {
+			h.drop() // the flush failed, and there's no room left to keep retrying
+		}
+	}
+	h.pending = append(h.pending, natsMsg{
+		subject: NATSSubject(h.config.Subject, rec),
+		data:    append([]byte(nil), msg...),
+		ack:     takeAck(rec),
+	})
+	return len(h.pending) >= h.config.BatchSize
+}
+
+func (h *NATSHandler) flush() {
+	if len(h.pending) == 0 {
+		return
+	}
+	if err := h.attempt(h.publish); err != nil {
+		h.closeConn()
+		h.lastErr = err
+		h.retries++
+		h.counters.countError(err)
+		if h.retries <= h.config.MaxRetries {
+			return // keep the unconfirmed ones for the next flush
+		}
+		h.drop()
+		return
+	}
+	h.counters.countHandled(len(h.pending)) // what keepUnacked didn't count
+	acknowledge(h.acks(), nil)
+	h.reset()
+}
+
+func (h *NATSHandler) drop() {
+	h.counters.countDropped(len(h.pending))
+	h.report(&DroppedError{Count: len(h.pending), Err: h.lastErr})
+	acknowledgeDropped(h.acks(), h.lastErr)
+	h.reset()
+}
+
+// acks returns the acks of the pending records.
+func (h *NATSHandler) acks() []func(error) {
+	var acks []func(error)
+	for _, msg := range h.pending {
+		if msg.ack != nil {
+			acks = append(acks, msg.ack)
+		}
+	}
+	return acks
+}
+
+func (h *NATSHandler) reset() {
+	h.pending = h.pending[:0]
+	h.retries = 0
+	h.lastErr = nil
+}
+
+func (h *NATSHandler) close() {
+	h.closeConn()
+}
+
+// publish publishes the pending records, then waits for the server's PONG (and the JetStream
+// acks); the confirmed records are removed from pending, even on error.
+func (h *NATSHandler) publish() error {
+	if h.conn == nil {
+		if err := h.connect(); err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	for i, msg := range h.pending {
+		if h.maxPayload > 0 && len(msg.data) > h.maxPayload {
+			return fmt.Errorf("record of %d bytes exceeds the server's max payload", len(msg.data))
+		}
+		if h.config.JetStream {
+			fmt.Fprintf(&buf, "PUB %s %s%d %d\r\n", msg.subject, h.inbox, i, len(msg.data))
+		} else {
+			fmt.Fprintf(&buf, "PUB %s %d\r\n", msg.subject, len(msg.data))
+		}
+		buf.Write(msg.data)
+		buf.WriteString("\r\n")
+	}
+	buf.WriteString("PING\r\n")
+
+	_ = h.conn.SetDeadline(time.Now().Add(h.config.Timeout))
+	if _, err := h.conn.Write(buf.Bytes()); err != nil {
+		return err
+	}
+
+	acked := make([]bool, len(h.pending))
+	waiting := 0
+	if h.config.JetStream {
+		waiting = len(h.pending)
+	}
+	pong := false
+	var ackErr error
+	for !pong || waiting > 0 {
+		op, subject, payload, err := h.readOp()
+		if err != nil {
+			h.keepUnacked(acked)
+			return err
+		}
+		switch op {
+		case "PONG":
+			pong = true
+			if !h.config.JetStream {
+				return nil
+			}
+		case "MSG":
+			i, convErr := strconv.Atoi(strings.TrimPrefix(subject, h.inbox))
+			if convErr != nil || i < 0 || i >= len(acked) || acked[i] {
+				continue // a late ack from a previous attempt
+			}
+			waiting--
+			var ack struct {
+				Error *struct {
+					Description string `json:"description"`
+				} `json:"error"`
+			}
+			if err = json.Unmarshal(payload, &ack); err != nil || ack.Error != nil {
+				if ack.Error != nil {
+					err = errors.New(ack.Error.Description)
+				}
+				ackErr = fmt.Errorf("JetStream: %v", err)
+				continue
+			}
+			acked[i] = true
+		case "HMSG":
+			// a status without payload, e.g. 503 when no stream captures the subject
+			i, convErr := strconv.Atoi(strings.TrimPrefix(subject, h.inbox))
+			if convErr == nil && i >= 0 && i < len(acked) && !acked[i] {
+				waiting--
+				ackErr = fmt.Errorf("JetStream: %s", bytes.TrimSpace(bytes.SplitN(payload, []byte("\r\n"), 2)[0]))
+			}
+		}
+	}
+
+	h.keepUnacked(acked)
+	return ackErr
+}
+
+// keepUnacked removes the acked records from pending.
+func (h *NATSHandler) keepUnacked(acked []bool) {
+	if !h.config.JetStream {
+		return
+	}
+	kept := h.pending[:0]
+	for i, msg := range h.pending {
+		if !acked[i] {
+			kept = append(kept, msg)
+		} else if msg.ack != nil {
+			msg.ack(nil)
+		}
+	}
+	h.counters.countHandled(len(h.pending) - len(kept))
+	h.pending = kept
+}
+
+func (h *NATSHandler) connect() error {
+	conn, err := net.DialTimeout("tcp", h.address, h.config.Timeout)
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	h.r = bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(h.config.Timeout))
+
+	op, _, payload, err := h.readOp()
+	if err != nil {
+		h.closeConn()
+		return err
+	}
+	var info struct {
+		TLSRequired bool `json:"tls_required"`
+		MaxPayload  int  `json:"max_payload"`
+		Headers     bool `json:"headers"`
+	}
+	if op != "INFO" || json.Unmarshal(payload, &info) != nil {
+		h.closeConn()
+		return fmt.Errorf("unexpected server greeting: %s %s", op, payload)
+	}
+	h.maxPayload = info.MaxPayload
+
+	if h.useTLS || info.TLSRequired {
+		config := h.config.TLSConfig
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if len(config.ServerName) == 0 {
+			config = config.Clone()
+			config.ServerName, _, _ = net.SplitHostPort(h.address)
+		}
+		tlsConn := tls.Client(conn, config)
+		if err = tlsConn.Handshake(); err != nil {
+			h.closeConn()
+			return err
+		}
+		h.conn = tlsConn
+		h.r = bufio.NewReader(tlsConn)
+	}
+
+	options := map[string]interface{}{
+		"verbose":  false,
+		"pedantic": false,
+		"lang":     "go",
+		"version":  "log4go",
+		"protocol": 1,
+		"name":     h.config.Name,
+	}
+	if info.Headers {
+		options["headers"] = true
+		options["no_responders"] = true
+	}
+	if len(h.config.Token) != 0 {
+		options["auth_token"] = h.config.Token
+	}
+	user, password := h.config.User, h.config.Password
+	if h.user != nil && len(user) == 0 {
+		user = h.user.Username()
+		password, _ = h.user.Password()
+	}
+	if len(user) != 0 {
+		options["user"] = user
+		options["pass"] = password
+	}
+	connect, _ := json.Marshal(options)
+
+	cmd := "CONNECT " + string(connect) + "\r\n"
+	if h.config.JetStream {
+		id := make([]byte, 8)
+		_, _ = rand.Read(id)
+		h.inbox = "_INBOX." + hex.EncodeToString(id) + "."
+		cmd += "SUB " + h.inbox + "* 1\r\n"
+	}
+	cmd += "PING\r\n"
+	if _, err = h.conn.Write([]byte(cmd)); err != nil {
+		h.closeConn()
+		return err
+	}
+	if op, _, _, err = h.readOp(); err != nil || op != "PONG" {
+		h.closeConn()
+		if err == nil {
+			err = fmt.Errorf("unexpected response to CONNECT: %s", op)
+		}
+		return err
+	}
+	return nil
+}
+
+// readOp reads a protocol message, answering the server's PINGs and returning -ERR as
+// errors: it returns the operation, the subject (MSG and HMSG) and the payload (or the
+// arguments for INFO).
+func (h *NATSHandler) readOp() (string, string, []byte, error) {
+	for {
+		line, err := h.r.ReadString('\n')
+		if err != nil {
+			return "", "", nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		op, args := splitOnce(line, " ")
+		op = strings.ToUpper(op)
+
+		switch op {
+		case "PING":
+			if _, err = h.conn.Write([]byte("PONG\r\n")); err != nil {
+				return "", "", nil, err
+			}
+		case "+OK":
+		case "-ERR":
+			return "", "", nil, fmt.Errorf("server error: %s", strings.Trim(args, "' "))
+		case "INFO":
+			return "INFO", "", []byte(args), nil
+		case "MSG", "HMSG":
+			// MSG <subject> <sid> [reply] <size>, HMSG <subject> <sid> [reply] <header size> <total size>
+			fields := strings.Fields(args)
+			sizes := 1
+			if op == "HMSG" {
+				sizes = 2
+			}
+			if len(fields) < 2+sizes {
+				return "", "", nil, fmt.Errorf("invalid %s: %s", op, args)
+			}
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 0 {
+				return "", "", nil, fmt.Errorf("invalid %s: %s", op, args)
+			}
+			payload := make([]byte, size+2)
+			if _, err = io.ReadFull(h.r, payload); err != nil {
+				return "", "", nil, err
+			}
+			if op == "HMSG" {
+				// the status, e.g. "NATS/1.0 503"
+				return op, fields[0], bytes.TrimPrefix(payload[:size], []byte("NATS/1.0")), nil
+			}
+			return op, fields[0], payload[:size], nil
+		default:
+			return op, "", []byte(args), nil
+		}
+	}
+}
+
+func (h *NATSHandler) closeConn() {
+	if h.conn != nil {
+		_ = h.conn.Close()
+		h.conn = nil
+		h.r = nil
+	}
+}
@@ -0,0 +1,125 @@
+package log4go
+
+import (
+	"sync"
+	"time"
+)
+
+// OccasionalLogger logs with a logger only occasionally, for messages which would otherwise
+// be logged on every iteration of a loop; see Logger.Once, EveryN and Every. Whether a record
+// is logged is decided only if its level is enabled.
+type OccasionalLogger struct {
+	logger *Logger
+	key    string
+	n      uint64        // EveryN
+	period time.Duration // Every
+}
+
+// occasions are the states of a logger's occasional records, by key.
+type occasions struct {
+	mu     sync.Mutex
+	states map[string]*occasion
+}
+
+type occasion struct {
+	count uint64 // records logged or skipped
+	last  time.Time
+}
+
+// Once returns an OccasionalLogger logging only the first record of key, e.g.
+//
+//	log.Once("deprecated-config").Warning("the config format is deprecated")
+//
+// The keys are the logger's, shared with the loggers derived from it (see With, WithGroup,
+// WithTags...), and are never forgotten: they must not be built dynamically.
+func (l *Logger) Once(key string) OccasionalLogger {
+	return OccasionalLogger{logger: l, key: key}
+}
+
+// EveryN returns an OccasionalLogger logging the first record of key, then one record in n.
+func (l *Logger) EveryN(key string, n int) OccasionalLogger {
+	if n < 1 {
+		n = 1
+	}
+	return OccasionalLogger{logger: l, key: key, n: uint64(n)}
+}
+
+// Every returns an OccasionalLogger logging the first record of key, then at most one record
+// per period.
+func (l *Logger) Every(key string, period time.Duration) OccasionalLogger {
+	return OccasionalLogger{logger: l, key: key, period: period}
+}
+
+// allow returns true if the record of the given level must be logged, counting it.
+func (o OccasionalLogger) allow(lvl Level) bool {
+	if !o.logger.IsEnabled(lvl) {
+		return false
+	}
+	owner := o.logger
+	if owner.stats != nil { // derived, see With
+		owner = owner.stats
+	}
+	occ := &owner.occasions
+	occ.mu.Lock()
+	defer occ.mu.Unlock()
+
+	state := occ.states[o.key]
+	if state == nil {
+		if occ.states == nil {
+			occ.states = make(map[string]*occasion)
+		}
+		state = &occasion{}
+		occ.states[o.key] = state
+	}
+	count := state.count
+	state.count++
+
+	switch {
+	case o.n > 0:
+		return count%o.n == 0
+	case o.period > 0:
+		t := now()
+		if count > 0 && t.Sub(state.last) < o.period {
+			return false
+		}
+		state.last = t
+		return true
+	default:
+		return count == 0
+	}
+}
+
+// Error logs message with ERROR level, if it's the occasion.
+func (o OccasionalLogger) Error(message string, args ...interface{}) {
+	if o.allow(ERROR) {
+		o.logger.Error(message, args...)
+	}
+}
+
+// Warning logs message with WARNING level, if it's the occasion.
+func (o OccasionalLogger) Warning(message string, args ...interface{}) {
+	if o.allow(WARNING) {
+		o.logger.Warning(message, args...)
+	}
+}
+
+// Info logs message with INFO level, if it's the occasion.
+func (o OccasionalLogger) Info(message string, args ...interface{}) {
+	if o.allow(INFO) {
+		o.logger.Info(message, args...)
+	}
+}
+
+// Debug logs message with DEBUG level, if it's the occasion.
+func (o OccasionalLogger) Debug(message string, args ...interface{}) {
+	if o.allow(DEBUG) {
+		o.logger.Debug(message, args...)
+	}
+}
+
+// Log logs message with given level, if it's the occasion.
+func (o OccasionalLogger) Log(lvl Level, message string, args ...interface{}) {
+	if lvl != NOTSET && o.allow(lvl) {
+		o.logger.Log(lvl, message, args...)
+	}
+}
@@ -0,0 +1,177 @@
+package log4go
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// LoggerOption configures a logger created by NewLogger.
+type LoggerOption func(l *Logger) error
+
+// HandlerOption configures a handler added by WithHandler.
+type HandlerOption func(h Handler) error
+
+// NewLogger creates the logger named name (a child of the root logger, as GetLogger(name)
+// would), configured by the options before it's registered, e.g.
+//
+//	log, err := NewLogger("app/db", WithLevel(INFO), WithHandler(h, WithFormat("{message}")))
+//
+// It fails if a logger of that name already exists.
+func NewLogger(name string, options ...LoggerOption) (*Logger, error) {
+	if len(name) == 0 || name == "root" {
+		return nil, errors.New("log4go: the root logger already exists")
+	}
+	root := GetLogger()
+
+	// configure the logger before anyone can get it
+	l := &Logger{name: name, level: int32(DEBUG), parent: root}
+	for _, option := range options {
+		if err := option(l); err != nil {
+			return nil, fmt.Errorf("log4go: logger %q: %w", name, err)
+		}
+	}
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+
+	if _, exists := loggers[name]; exists {
+		return nil, fmt.Errorf("log4go: logger %q already exists", name)
+	}
+	root.children = append(root.children, l)
+	applyLevelOverride(l)
+	loggers[name] = l
+	return l, nil
+}
+
+// WithLevel sets the logger's level.
+func WithLevel(level Level) LoggerOption {
+	return func(l *Logger) error {
+		l.setLevel(level)
+		return nil
+	}
+}
+
+// WithHandler adds a handler to the logger, configured by the options first; the handler must
+// have a formatter.
+func WithHandler(handler Handler, options ...HandlerOption) LoggerOption {
+	return func(l *Logger) error {
+		for _, option := range options {
+			if err := option(handler); err != nil {
+				return err
+			}
+		}
+		_, err := l.addHandler(handler)
+		return err
+	}
+}
+
+// WithCaller makes the logger and its descendants report the source file and line of the
+// logging calls in their records (see Record.File), at the cost of a stack walk per record.
+func WithCaller() LoggerOption {
+	return func(l *Logger) error {
+		l.caller = true
+		return nil
+	}
+}
+
+// AddCallerSkip makes the logger report the caller n frames further up the stack (see
+// WithCaller), for the loggers used by wrapper functions, to report the call site of the
+// wrapper rather than the wrapper itself; see also Logger.WithCallerSkip.
+func AddCallerSkip(n int) LoggerOption {
+	return func(l *Logger) error {
+		l.callerSkip += n
+		return nil
+	}
+}
+
+// WithFields binds fields to the logger's records, as With does: kv are key/value pairs, or
+// Fields.
+func WithFields(kv ...interface{}) LoggerOption {
+	return func(l *Logger) error {
+		l.fields = bindFields(l.fields, l.group, kv)
+		return nil
+	}
+}
+
+// WithFormatter sets the handler's formatter.
+func WithFormatter(formatter Formatter) HandlerOption {
+	return func(h Handler) error {
+		h.SetFormatter(formatter)
+		return nil
+	}
+}
+
+// WithFormat sets the handler's formatter to a TemplateFormatter of the format.
+func WithFormat(format string) HandlerOption {
+	return func(h Handler) error {
+		formatter, err := NewTemplateFormatter(format)
+		if err != nil {
+			return err
+		}
+		h.SetFormatter(formatter)
+		return nil
+	}
+}
+
+// WithHandlerLevel sets the handler's level.
+func WithHandlerLevel(level Level) HandlerOption {
+	return func(h Handler) error {
+		h.SetLevel(level)
+		return nil
+	}
+}
+
+// reportsCaller reports whether the logger, or one of its ancestors, reports the caller.
+func (l *Logger) reportsCaller() bool {
+	for ; l != nil; l = l.parent {
+		if l.caller {
+			return true
+		}
+	}
+	return false
+}
+
+// packagePath is log4go's import path, whose functions are skipped looking for the caller.
+var packagePath = reflect.TypeOf(Logger{}).PkgPath()
+
+// WithCallerSkip returns a child logger (see With) reporting the caller n more frames up the
+// stack, see AddCallerSkip:
+//
+//	var log = log4go.GetLogger("app").WithCallerSkip(1)
+//
+//	func logFailure(err error) { log.Error("failure: %v", err) } // reports logFailure's caller
+func (l *Logger) WithCallerSkip(n int) *Logger {
+	child := l.With("")
+	child.callerSkip += n
+	return child
+}
+
+// caller returns the source file (base name) and line of the logging call: the first caller
+// outside of log4go (its tests excepted), or the skip-th one above it.
+func caller(skip int) (string, int) {
+	if skip < 0 {
+		skip = 0
+	}
+	pcs := make([]uintptr, 16+skip)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	found := false
+	for {
+		frame, more := frames.Next()
+		if !found && (!strings.HasPrefix(frame.Function, packagePath+".") || strings.HasSuffix(frame.File, "_test.go")) {
+			found = true
+		}
+		if found {
+			if skip == 0 {
+				return filepath.Base(frame.File), frame.Line
+			}
+			skip--
+		}
+		if !more {
+			return "", 0
+		}
+	}
+}
@@ -0,0 +1,495 @@
+package log4go
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kaizer666/log4go/internal/protowire"
+)
+
+// OTLP protocols.
+const (
+	OTLPProtocolHTTP = "http/protobuf"
+	OTLPProtocolGRPC = "grpc"
+)
+
+// OTLPLogsExportMethod is the OTLP/gRPC logs export method.
+const OTLPLogsExportMethod = "/opentelemetry.proto.collector.logs.v1.LogsService/Export"
+
+// OTLPConfig configures an OTLPHandler. The empty settings are taken from the standard
+// OTEL_* environment variables when set.
+type OTLPConfig struct {
+	// Protocol is OTLPProtocolHTTP (default, $OTEL_EXPORTER_OTLP_LOGS_PROTOCOL or
+	// $OTEL_EXPORTER_OTLP_PROTOCOL) or OTLPProtocolGRPC.
+	Protocol string
+	// Endpoint is the collector's URL ($OTEL_EXPORTER_OTLP_LOGS_ENDPOINT, or
+	// $OTEL_EXPORTER_OTLP_ENDPOINT plus "/v1/logs" for HTTP), default
+	// http://localhost:4318/v1/logs for HTTP and http://localhost:4317 for gRPC.
+	Endpoint string
+	// Headers are added to the requests ($OTEL_EXPORTER_OTLP_HEADERS, "key=value,...").
+	Headers map[string]string
+	// TLSConfig is used for https endpoints.
+	TLSConfig *tls.Config
+	// Compression is "gzip" or "" (none, default).
+	Compression string
+	// ServiceName is the service.name resource attribute (default: $OTEL_SERVICE_NAME, then
+	// the program's name).
+	ServiceName string
+	// ResourceAttributes are added to the resource, with the ones of
+	// $OTEL_RESOURCE_ATTRIBUTES, host.name and process.pid.
+	ResourceAttributes map[string]string
+	// BatchSize is the maximum number of log records per export (default 512).
+	BatchSize int
+	// FlushInterval is the maximum time records wait for their batch (default 1s).
+	FlushInterval time.Duration
+	// Timeout limits each export (default 10s).
+	Timeout time.Duration
+	// QueueSize is the number of records buffered before Handle blocks (default 2048).
+	QueueSize int
+	// MaxRetries is the number of times a batch is exported again, after a retryable error,
+	// before it's dropped (default 3).
+	MaxRetries int
+}
+
+// OTLPHandler exports the records as OpenTelemetry log records, with OTLP over HTTP or gRPC.
+//
+// The body is the formatted record (the plain message by default), the level is mapped to
+// the severity number, the fields are the attributes except "trace_id" and "span_id" (hex
+// strings) which set the record's trace context; the logger name is the instrumentation
+// scope.
+type OTLPHandler struct {
+	batchHandler
+
+	config   OTLPConfig
+	client   *http.Client
+	url      string
+	resource []byte // the encoded Resource
+	records  map[string][][]byte
+	count    int
+	retries  int
+	lastErr  error
+	acks     []func(error) // of the pending records, see takeAck
+}
+
+// NewOTLPHandler returns a new OTLPHandler.
+func NewOTLPHandler(config OTLPConfig) (*OTLPHandler, error) {
+	if len(config.Protocol) == 0 {
+		config.Protocol = firstEnv("OTEL_EXPORTER_OTLP_LOGS_PROTOCOL", "OTEL_EXPORTER_OTLP_PROTOCOL")
+	}
+	if len(config.Protocol) == 0 {
+		config.Protocol = OTLPProtocolHTTP
+	}
+	if config.Protocol != OTLPProtocolHTTP && config.Protocol != OTLPProtocolGRPC {
+		return nil, fmt.Errorf("log4go.OTLPHandler: unsupported protocol: '%s'", config.Protocol)
+	}
+	if len(config.Endpoint) == 0 {
+		config.Endpoint = os.Getenv("OTEL_EXPORTER_OTLP_LOGS_ENDPOINT")
+	}
+	if len(config.Endpoint) == 0 {
+		if endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); len(endpoint) != 0 {
+			config.Endpoint = endpoint
+			if config.Protocol == OTLPProtocolHTTP {
+				config.Endpoint = strings.TrimSuffix(endpoint, "/") + "/v1/logs"
+			}
+		}
+	}
+	if len(config.Endpoint) == 0 {
+		config.Endpoint = "http://localhost:4318/v1/logs"
+		if config.Protocol == OTLPProtocolGRPC {
+			config.Endpoint = "http://localhost:4317"
+		}
+	}
+	if config.Headers == nil {
+		config.Headers = parseKeyValues(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	}
+	if len(config.ServiceName) == 0 {
+		config.ServiceName = os.Getenv("OTEL_SERVICE_NAME")
+	}
+	if len(config.ServiceName) == 0 {
+		config.ServiceName = filepath.Base(os.Args[0])
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 512
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 10 * time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 2048
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+
+	u, err := url.Parse(config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	h := &OTLPHandler{
+		config:  config,
+		url:     config.Endpoint,
+		records: make(map[string][][]byte),
+	}
+	if config.Protocol == OTLPProtocolGRPC {
+		var tlsConfig *tls.Config
+		if u.Scheme == "https" {
+			tlsConfig = config.TLSConfig
+			if tlsConfig == nil {
+				tlsConfig = &tls.Config{}
+			}
+		}
+		var scheme string
+		h.client, scheme = newGRPCClient(tlsConfig, config.Timeout)
+		h.url = scheme + "://" + u.Host + OTLPLogsExportMethod
+	} else {
+		h.client = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: config.TLSConfig,
+		}}
+	}
+
+	attributes := parseKeyValues(os.Getenv("OTEL_RESOURCE_ATTRIBUTES"))
+	for key, value := range config.ResourceAttributes {
+		attributes[key] = value
+	}
+	attributes["service.name"] = config.ServiceName
+	if _, exists := attributes["host.name"]; !exists {
+		attributes["host.name"] = hostname
+	}
+	resource := make(map[string]interface{}, len(attributes)+1)
+	for key, value := range attributes {
+		resource[key] = value
+	}
+	resource["process.pid"] = pid
+	h.resource = appendOTLPAttributes(nil, 1, resource)
+
+	formatter, err := NewTemplateFormatter("{message}")
+	if err != nil {
+		return nil, err
+	}
+	h.init(config.QueueSize, formatter)
+	h.run(h, config.FlushInterval)
+
+	return h, nil
+}
+
+var _ Handler = &OTLPHandler{}
+
+var levelToOTLPSeverity = map[Level]uint64{
+	TRACE:   1,
+	DEBUG:   5,
+	INFO:    9,
+	WARNING: 13,
+	ERROR:   17,
+	FATAL:   21,
+}
+
+func (h *OTLPHandler) add(rec *Record) bool {
+	msg, err := h.Formatter().Format(rec)
+	if err != nil {
+		if err != ErrorNotSet {
+			h.report(fmt.Errorf("formatter error: %w", err))
+			h.counters.countError(err)
+		}
+		return false
+	}
+
+	if h.count >= h.config.BatchSize {
+		h.flush()
+		if h.count != 0 {
+			h.drop() // the flush failed, and there's no room left to keep retrying
+		}
+	}
+
+	name := rec.Name
+	if len(name) == 0 {
+		name = "root"
+	}
+	h.records[name] = append(h.records[name], h.logRecord(rec, msg))
+	h.count++
+	if ack := takeAck(rec); ack != nil {
+		h.acks = append(h.acks, ack)
+	}
+	return h.count >= h.config.BatchSize
+}
+
+// logRecord returns the record as an encoded LogRecord.
+func (h *OTLPHandler) logRecord(rec *Record, msg []byte) []byte {
+	b := protowire.AppendFixed64(nil, 1, uint64(rec.Time.UnixNano()))
+	b = protowire.AppendFixed64(b, 11, uint64(now().UnixNano()))
+	b = protowire.AppendUint(b, 2, levelToOTLPSeverity[rec.Level])
+	b = protowire.AppendString(b, 3, LevelName(rec.Level))
+	b = protowire.AppendBytes(b, 5, appendOTLPValue(nil, string(bytes.TrimSuffix(msg, []byte{'\n'}))))
+
+	fields := rec.allFields()
+	attributes := make(map[string]interface{}, len(fields))
+	for key, value := range fields {
+		switch key {
+		case "trace_id", "span_id":
+			if id, err := hex.DecodeString(fmt.Sprint(value)); err == nil && (len(id) == 16 || len(id) == 8) {
+				field := 9
+				if key == "span_id" {
+					field = 10
+				}
+				b = protowire.AppendBytes(b, field, id)
+				continue
+			}
+		}
+		attributes[key] = value
+	}
+	return appendOTLPAttributes(b, 6, attributes)
+}
+
+// appendOTLPAttributes appends the attributes as repeated KeyValue fields, sorted by key.
+func appendOTLPAttributes(b []byte, field int, attributes map[string]interface{}) []byte {
+	keys := make([]string, 0, len(attributes))
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		kv := protowire.AppendString(nil, 1, key)
+		kv = protowire.AppendBytes(kv, 2, appendOTLPValue(nil, attributes[key]))
+		b = protowire.AppendBytes(b, field, kv)
+	}
+	return b
+}
+
+// appendOTLPValue appends v as an AnyValue (the oneof fields are written even if zero).
+func appendOTLPValue(b []byte, v interface{}) []byte {
+	switch v := v.(type) {
+	case string:
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(len(v)))
+		return append(b, v...)
+	case bool:
+		var value uint64
+		if v {
+			value = 1
+		}
+		return protowire.AppendVarint(protowire.AppendTag(b, 2, protowire.VarintType), value)
+	case int:
+		return protowire.AppendVarint(protowire.AppendTag(b, 3, protowire.VarintType), uint64(v))
+	case int32:
+		return protowire.AppendVarint(protowire.AppendTag(b, 3, protowire.VarintType), uint64(v))
+	case int64:
+		return protowire.AppendVarint(protowire.AppendTag(b, 3, protowire.VarintType), uint64(v))
+	case uint32:
+		return protowire.AppendVarint(protowire.AppendTag(b, 3, protowire.VarintType), uint64(v))
+	case float64:
+		b = protowire.AppendTag(b, 4, protowire.Fixed64)
+		b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(b[len(b)-8:], math.Float64bits(v))
+		return b
+	case float32:
+		return appendOTLPValue(b, float64(v))
+	case []byte:
+		return protowire.AppendBytes(b, 7, v)
+	case error:
+		return appendOTLPValue(b, v.Error())
+	case map[string]interface{}:
+		return protowire.AppendBytes(b, 6, appendOTLPAttributes(nil, 1, v))
+	case Fields:
+		return appendOTLPValue(b, map[string]interface{}(v))
+	case []interface{}:
+		var values []byte
+		for _, item := range v {
+			values = protowire.AppendBytes(values, 1, appendOTLPValue(nil, item))
+		}
+		return protowire.AppendBytes(b, 5, values)
+	}
+	return appendOTLPValue(b, fmt.Sprint(v))
+}
+
+func (h *OTLPHandler) flush() {
+	if h.count == 0 {
+		return
+	}
+	if err := h.attempt(h.export); err != nil {
+		h.lastErr = err
+		h.retries++
+		h.counters.countError(err)
+		if isOTLPRetryable(err) && h.retries <= h.config.MaxRetries {
+			return // keep them for the next flush
+		}
+		h.drop()
+		return
+	}
+	h.counters.countHandled(h.count)
+	acknowledge(h.acks, nil)
+	h.reset()
+}
+
+func (h *OTLPHandler) drop() {
+	h.counters.countDropped(h.count)
+	h.report(&DroppedError{Count: h.count, Err: h.lastErr})
+	acknowledgeDropped(h.acks, h.lastErr)
+	h.reset()
+}
+
+func (h *OTLPHandler) reset() {
+	h.acks = h.acks[:0]
+	h.records = make(map[string][][]byte)
+	h.count = 0
+	h.retries = 0
+	h.lastErr = nil
+}
+
+func (h *OTLPHandler) close() {}
+
+// request returns the encoded ExportLogsServiceRequest of the pending records.
+func (h *OTLPHandler) request() []byte {
+	names := make([]string, 0, len(h.records))
+	for name := range h.records {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	resourceLogs := protowire.AppendBytes(nil, 1, h.resource)
+	for _, name := range names {
+		scopeLogs := protowire.AppendBytes(nil, 1, protowire.AppendString(nil, 1, name))
+		for _, record := range h.records[name] {
+			scopeLogs = protowire.AppendBytes(scopeLogs, 2, record)
+		}
+		resourceLogs = protowire.AppendBytes(resourceLogs, 2, scopeLogs)
+	}
+	return protowire.AppendBytes(nil, 1, resourceLogs)
+}
+
+// otlpStatusError is an OTLP/HTTP error response.
+type otlpStatusError struct {
+	status int
+	text   string
+}
+
+func (e *otlpStatusError) Error() string {
+	return fmt.Sprintf("HTTP status %d: %s", e.status, e.text)
+}
+
+// isOTLPRetryable tells whether the export may succeed later (per the OTLP specification).
+func isOTLPRetryable(err error) bool {
+	switch err := err.(type) {
+	case *otlpStatusError:
+		switch err.status {
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	case *grpcError:
+		switch err.code {
+		case 1, 4, 8, 10, 11, 14, 15: // CANCELLED, DEADLINE_EXCEEDED, RESOURCE_EXHAUSTED, ABORTED, OUT_OF_RANGE, UNAVAILABLE, DATA_LOSS
+			return true
+		}
+		return false
+	}
+	return true // network errors
+}
+
+func (h *OTLPHandler) export() error {
+	body := h.request()
+	compressed := h.config.Compression == "gzip"
+	if compressed {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		_, _ = zw.Write(body)
+		_ = zw.Close()
+		body = buf.Bytes()
+	}
+
+	var contentType string
+	if h.config.Protocol == OTLPProtocolGRPC {
+		contentType = "application/grpc"
+		framed := make([]byte, 5, 5+len(body))
+		if compressed {
+			framed[0] = 1
+		}
+		binary.BigEndian.PutUint32(framed[1:], uint32(len(body)))
+		body = append(framed, body...)
+	} else {
+		contentType = "application/x-protobuf"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequest(http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range h.config.Headers {
+		req.Header.Set(key, value)
+	}
+	if h.config.Protocol == OTLPProtocolGRPC {
+		req.Header.Set("TE", "trailers")
+		if compressed {
+			req.Header.Set("Grpc-Encoding", "gzip")
+		}
+	} else if compressed {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := h.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 64*1024))
+
+	if h.config.Protocol == OTLPProtocolGRPC {
+		if resp.StatusCode != http.StatusOK {
+			return &otlpStatusError{status: resp.StatusCode, text: resp.Status}
+		}
+		return grpcStatusError(resp)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return &otlpStatusError{status: resp.StatusCode, text: strings.TrimSpace(string(data))}
+	}
+	return nil
+}
+
+// firstEnv returns the value of the first set environment variable.
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); len(value) != 0 {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseKeyValues parses "key=value,key=value" (as used by OTEL_* variables), the values
+// being URL-decoded.
+func parseKeyValues(s string) map[string]string {
+	values := make(map[string]string)
+	for _, item := range strings.Split(s, ",") {
+		key, value := splitOnce(item, "=")
+		key = strings.TrimSpace(key)
+		if len(key) == 0 {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(strings.TrimSpace(value)); err == nil {
+			value = unescaped
+		}
+		values[key] = value
+	}
+	return values
+}
@@ -0,0 +1,121 @@
+package log4go
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// formatPreset is a named format, see RegisterFormat.
+type formatPreset struct {
+	template     string // the template of the template formats
+	newFormatter func() (Formatter, error)
+}
+
+var formatsLock sync.RWMutex
+var formats map[string]formatPreset
+
+func init() {
+	formats = map[string]formatPreset{
+		"json": {newFormatter: func() (Formatter, error) { return NewECSFormatter(), nil }},
+	}
+	for name, template := range map[string]string{
+		"default":  "{time} {name} {level} {message}",
+		"detailed": "{time} {name} {level<8} {caller} [{pid}:{goid}] {message}",
+		"minimal":  "{level:short} {message}",
+	} {
+		formats[name] = templatePreset(template)
+	}
+}
+
+func templatePreset(template string) formatPreset {
+	return formatPreset{template: template, newFormatter: func() (Formatter, error) {
+		return NewTemplateFormatter(template)
+	}}
+}
+
+// RegisterFormat adds (or replaces) a named format: NewFormatter(name) returns the formatter
+// made by newFormatter, and so do the configurations referencing it (see HandlerConfig).
+func RegisterFormat(name string, newFormatter func() (Formatter, error)) {
+	formatsLock.Lock()
+	defer formatsLock.Unlock()
+
+	formats[name] = formatPreset{newFormatter: newFormatter}
+}
+
+// RegisterTemplateFormat adds (or replaces) a named TemplateFormatter template, see
+// RegisterFormat. The template can extend the one of another named format, "{@name}" being
+// replaced by it, e.g. "{@detailed} {hostname}".
+func RegisterTemplateFormat(name, template string) error {
+	expanded, err := expandFormatPresets(template)
+	if err != nil {
+		return err
+	}
+	if _, err := compileTemplate(expanded); err != nil {
+		return err
+	}
+
+	formatsLock.Lock()
+	defer formatsLock.Unlock()
+
+	formats[name] = templatePreset(expanded)
+	return nil
+}
+
+// FormatTemplate returns the template of the named format, if it's a template format.
+func FormatTemplate(name string) (string, bool) {
+	formatsLock.RLock()
+	defer formatsLock.RUnlock()
+
+	preset, exists := formats[name]
+	return preset.template, exists && len(preset.template) > 0
+}
+
+// FormatNames returns the names of the formats, sorted.
+func FormatNames() []string {
+	formatsLock.RLock()
+	defer formatsLock.RUnlock()
+
+	names := make([]string, 0, len(formats))
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewFormatter returns a new formatter of the named format: "default" ("{time} {name} {level}
+// {message}"), "detailed" (adding the caller, process and goroutine IDs), "minimal" ("{level:short}
+// {message}"), "json" (see ECSFormatter), or a registered one.
+func NewFormatter(name string) (Formatter, error) {
+	formatsLock.RLock()
+	preset, exists := formats[name]
+	formatsLock.RUnlock()
+
+	if !exists {
+		return nil, fmt.Errorf("unknown format %q", name)
+	}
+	return preset.newFormatter()
+}
+
+// expandFormatPresets returns the template with the "{@name}" references to the named template
+// formats replaced by their templates.
+func expandFormatPresets(template string) (string, error) {
+	for {
+		start := strings.Index(template, "{@")
+		if start < 0 {
+			return template, nil
+		}
+		end := strings.IndexByte(template[start:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("invalid format template string: unterminated token at offset %d", start)
+		}
+		name := template[start+2 : start+end]
+		base, ok := FormatTemplate(name)
+		if !ok {
+			return "", fmt.Errorf("unknown template format %q at offset %d", name, start)
+		}
+		template = template[:start] + base + template[start+end+1:]
+	}
+}
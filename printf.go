@@ -0,0 +1,78 @@
+package log4go
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The f methods are the logging methods under the names of the logrus and standard library
+// loggers, for easier migrations; the ln methods format their arguments as fmt.Sprintln does
+// (without the newline). As with the other logging methods, nothing is formatted for disabled
+// levels and trailing Fields are attached to the records.
+
+// Fatalf logs message with FATAL level and exits, see Fatal.
+func (l *Logger) Fatalf(message string, args ...interface{}) {
+	l.Fatal(message, args...)
+}
+
+// Errorf logs message with ERROR level, see Error.
+func (l *Logger) Errorf(message string, args ...interface{}) {
+	l.Error(message, args...)
+}
+
+// Warnf logs message with WARNING level, see Warning.
+func (l *Logger) Warnf(message string, args ...interface{}) {
+	l.Warning(message, args...)
+}
+
+// Infof logs message with INFO level, see Info.
+func (l *Logger) Infof(message string, args ...interface{}) {
+	l.Info(message, args...)
+}
+
+// Debugf logs message with DEBUG level, see Debug.
+func (l *Logger) Debugf(message string, args ...interface{}) {
+	l.Debug(message, args...)
+}
+
+// Fatalln logs its arguments with FATAL level and exits, see Fatal.
+func (l *Logger) Fatalln(args ...interface{}) {
+	l.Fatal("%s", lnArgs(args)...)
+}
+
+// Errorln logs its arguments with ERROR level, see Error.
+func (l *Logger) Errorln(args ...interface{}) {
+	l.Error("%s", lnArgs(args)...)
+}
+
+// Warnln logs its arguments with WARNING level, see Warning.
+func (l *Logger) Warnln(args ...interface{}) {
+	l.Warning("%s", lnArgs(args)...)
+}
+
+// Infoln logs its arguments with INFO level, see Info.
+func (l *Logger) Infoln(args ...interface{}) {
+	l.Info("%s", lnArgs(args)...)
+}
+
+// Debugln logs its arguments with DEBUG level, see Debug.
+func (l *Logger) Debugln(args ...interface{}) {
+	l.Debug("%s", lnArgs(args)...)
+}
+
+// lnArgs returns the arguments of a "%s" logging call formatting args as fmt.Sprintln does
+// (without the newline), lazily, with their trailing Tags and Fields.
+func lnArgs(args []interface{}) []interface{} {
+	args, tags, fields := splitArgs(args)
+	message := Lazy(func() interface{} {
+		return strings.TrimSuffix(fmt.Sprintln(resolveLazy(args)...), "\n")
+	})
+	lnArgs := []interface{}{message}
+	if tags != nil {
+		lnArgs = append(lnArgs, tags)
+	}
+	if fields != nil {
+		lnArgs = append(lnArgs, fields)
+	}
+	return lnArgs
+}
@@ -0,0 +1,51 @@
+package log4go
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+var pid = os.Getpid()
+var processID = strconv.Itoa(pid)
+var processStart = time.Now()
+var hostname string
+
+var captureGoroutineID int32 // accessed atomically
+
+func init() {
+	hostname, _ = os.Hostname()
+	if len(hostname) == 0 {
+		hostname = "localhost"
+	}
+}
+
+// CaptureGoroutineID enables (or disables) recording the ID of the logging goroutine in each
+// Record, as used by the {goid} template token. It is off by default since getting the ID
+// is relatively expensive.
+func CaptureGoroutineID(enable bool) {
+	var value int32
+	if enable {
+		value = 1
+	}
+	atomic.StoreInt32(&captureGoroutineID, value)
+}
+
+// goroutineID returns the current goroutine's ID, or 0 if capturing it is disabled.
+func goroutineID() uint64 {
+	if atomic.LoadInt32(&captureGoroutineID) == 0 {
+		return 0
+	}
+
+	var buf [64]byte
+	b := buf[:runtime.Stack(buf[:], false)]
+	b = bytes.TrimPrefix(b, []byte("goroutine "))
+	if idx := bytes.IndexByte(b, ' '); idx > 0 {
+		b = b[:idx]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
@@ -0,0 +1,28 @@
+module github.com/kaizer666/log4go/prometheus
+
+// To build against the log4go checkout, rather than the required version, use the workspace
+// created from the repository's root by:
+//
+//	go work init . ./echolog ./fiberlog ./ginlog ./logrsink ./prometheus
+
+go 1.22
+
+require (
+	github.com/kaizer666/log4go v0.0.0-20261017070848-da72bd423885
+	github.com/prometheus/client_golang v1.12.2
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/fsnotify/fsnotify v1.7.0 // indirect
+	github.com/golang/protobuf v1.5.2 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.32.1 // indirect
+	github.com/prometheus/procfs v0.7.3 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	google.golang.org/protobuf v1.30.0 // indirect
+)
@@ -0,0 +1,88 @@
+// Package prometheus provides a github.com/prometheus/client_golang Collector exposing
+// log4go's statistics, to alert when logging itself misbehaves.
+package prometheus
+
+import (
+	"github.com/kaizer666/log4go"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector collects the log4go.ReadStats counters as metrics:
+//
+//	log4go_records_logged_total{logger, level}
+//	log4go_records_rate_limited_total{logger}
+//	log4go_handler_records_total{handler}
+//	log4go_handler_dropped_total{handler}
+//	log4go_handler_errors_total{handler}
+//	log4go_handler_queue_depth{handler}
+//	log4go_handler_queue_capacity{handler}
+//	log4go_handler_write_duration_seconds{handler} (a summary, without quantiles)
+type Collector struct {
+	logged        *prom.Desc
+	rateLimited   *prom.Desc
+	handled       *prom.Desc
+	dropped       *prom.Desc
+	errors        *prom.Desc
+	queueDepth    *prom.Desc
+	queueCapacity *prom.Desc
+	writeDuration *prom.Desc
+}
+
+// NewCollector returns a new Collector, e.g. for prometheus.MustRegister.
+func NewCollector() *Collector {
+	handler := []string{"handler"}
+	return &Collector{
+		logged: prom.NewDesc("log4go_records_logged_total",
+			"Number of records logged, per logger and level.", []string{"logger", "level"}, nil),
+		rateLimited: prom.NewDesc("log4go_records_rate_limited_total",
+			"Number of records dropped by the logger's rate limit.", []string{"logger"}, nil),
+		handled: prom.NewDesc("log4go_handler_records_total",
+			"Number of records written (or sent) by the handler.", handler, nil),
+		dropped: prom.NewDesc("log4go_handler_dropped_total",
+			"Number of records dropped by the handler.", handler, nil),
+		errors: prom.NewDesc("log4go_handler_errors_total",
+			"Number of formatting, write and send errors of the handler.", handler, nil),
+		queueDepth: prom.NewDesc("log4go_handler_queue_depth",
+			"Number of records queued by the handler.", handler, nil),
+		queueCapacity: prom.NewDesc("log4go_handler_queue_capacity",
+			"Size of the handler's queue.", handler, nil),
+		writeDuration: prom.NewDesc("log4go_handler_write_duration_seconds",
+			"Duration of the handler's writes (or batch sends).", handler, nil),
+	}
+}
+
+var _ prom.Collector = &Collector{}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	ch <- c.logged
+	ch <- c.rateLimited
+	ch <- c.handled
+	ch <- c.dropped
+	ch <- c.errors
+	ch <- c.queueDepth
+	ch <- c.queueCapacity
+	ch <- c.writeDuration
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	stats := log4go.ReadStats()
+
+	for _, logger := range stats.Loggers {
+		for lvl, n := range logger.Logged {
+			ch <- prom.MustNewConstMetric(c.logged, prom.CounterValue, float64(n),
+				logger.Name, log4go.LevelName(lvl))
+		}
+		ch <- prom.MustNewConstMetric(c.rateLimited, prom.CounterValue, float64(logger.RateLimited), logger.Name)
+	}
+
+	for _, h := range stats.Handlers {
+		ch <- prom.MustNewConstMetric(c.handled, prom.CounterValue, float64(h.Handled), h.Name)
+		ch <- prom.MustNewConstMetric(c.dropped, prom.CounterValue, float64(h.Dropped), h.Name)
+		ch <- prom.MustNewConstMetric(c.errors, prom.CounterValue, float64(h.Errors), h.Name)
+		ch <- prom.MustNewConstMetric(c.queueDepth, prom.GaugeValue, float64(h.QueueDepth), h.Name)
+		ch <- prom.MustNewConstMetric(c.queueCapacity, prom.GaugeValue, float64(h.QueueCapacity), h.Name)
+		ch <- prom.MustNewConstSummary(c.writeDuration, h.Writes, h.WriteTime.Seconds(), nil, h.Name)
+	}
+}
@@ -0,0 +1,65 @@
+package prometheus
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kaizer666/log4go"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+func TestCollector(t *testing.T) {
+	var buf bytes.Buffer
+	log4go.BasicConfig(log4go.BasicConfigOpts{
+		Level:  log4go.INFO,
+		Writer: &buf,
+		Format: "{message}",
+	})
+	log := log4go.GetLogger("prom")
+	log.SetLevel(log4go.INFO)
+	log.Info("one")
+	log.Info("two")
+	log.Error("three")
+	log.Debug("filtered out")
+	log4go.Shutdown()
+
+	registry := prom.NewRegistry()
+	registry.MustRegister(NewCollector())
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make(map[string]float64)
+	for _, family := range families {
+		for _, m := range family.GetMetric() {
+			key := family.GetName()
+			for _, label := range m.GetLabel() {
+				key += "," + label.GetName() + "=" + label.GetValue()
+			}
+			switch {
+			case m.GetCounter() != nil:
+				values[key] = m.GetCounter().GetValue()
+			case m.GetGauge() != nil:
+				values[key] = m.GetGauge().GetValue()
+			case m.GetSummary() != nil:
+				values[key] = float64(m.GetSummary().GetSampleCount())
+			}
+		}
+	}
+
+	expected := map[string]float64{
+		"log4go_records_logged_total,level=INFO,logger=prom":          2,
+		"log4go_records_logged_total,level=ERROR,logger=prom":         1,
+		"log4go_handler_records_total,handler=StreamHandler":          3,
+		"log4go_handler_dropped_total,handler=StreamHandler":          0,
+		"log4go_handler_errors_total,handler=StreamHandler":           0,
+		"log4go_handler_queue_capacity,handler=StreamHandler":         100,
+		"log4go_handler_write_duration_seconds,handler=StreamHandler": 3,
+	}
+	for key, value := range expected {
+		if got, exists := values[key]; !exists || got != value {
+			t.Errorf("%s: expected %v, got %v (exists: %v)", key, value, got, exists)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package log4go
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxRateLimitKeys bounds the number of messages rate limited separately by a logger: the
+// buckets are reset once reached (e.g. for messages built without a constant format string).
+const maxRateLimitKeys = 10000
+
+// SetRateLimit limits the records logged with the logger (not its descendants) to rate per
+// second on average, with bursts of up to burst records (at least 1), so that a hot code path
+// can't drown the handlers; the records over the limit are dropped, and counted (see
+// LoggerStats.RateLimited). The loggers derived from the logger (see With, WithGroup,
+// WithTags...) share its limit, unless they have their own. A zero rate removes the limit, or
+// the one of SetMessageRateLimit.
+func (l *Logger) SetRateLimit(rate float64, burst int) {
+	l.setRateLimiter(rate, burst, false)
+}
+
+// SetMessageRateLimit is like SetRateLimit, but limits each message (format string) logged
+// with the logger separately, e.g. 10 per second with bursts of 50 for each.
+func (l *Logger) SetMessageRateLimit(rate float64, burst int) {
+	l.setRateLimiter(rate, burst, true)
+}
+
+func (l *Logger) setRateLimiter(rate float64, burst int, perMessage bool) {
+	if rate <= 0 {
+		l.limiter.Store((*rateLimiter)(nil))
+		return
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	l.limiter.Store(&rateLimiter{rate: rate, burst: float64(burst), perMessage: perMessage})
+}
+
+// rateLimited returns true if the record with message must be dropped, counting it.
+func (l *Logger) rateLimited(message string) bool {
+	r, _ := l.limiter.Load().(*rateLimiter)
+	if r == nil && l.stats != nil { // derived, see With
+		r, _ = l.stats.limiter.Load().(*rateLimiter)
+	}
+	if r == nil || r.allow(message) {
+		return false
+	}
+	if l.stats != nil {
+		l = l.stats
+	}
+	atomic.AddUint64(&l.limitedCount, 1)
+	return true
+}
+
+// rateLimiter is a logger's token bucket rate limit.
+type rateLimiter struct {
+	rate       float64 // tokens per second
+	burst      float64
+	perMessage bool
+
+	mu      sync.Mutex
+	bucket  tokenBucket             // of the logger
+	buckets map[string]*tokenBucket // per message
+}
+
+func (r *rateLimiter) allow(message string) bool {
+	t := now()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if !r.perMessage {
+		return r.bucket.take(t, r.rate, r.burst)
+	}
+	b := r.buckets[message]
+	if b == nil {
+		if r.buckets == nil || len(r.buckets) >= maxRateLimitKeys {
+			r.buckets = make(map[string]*tokenBucket)
+		}
+		b = &tokenBucket{}
+		r.buckets[message] = b
+	}
+	return b.take(t, r.rate, r.burst)
+}
+
+// tokenBucket is a token bucket, full when first used.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// take takes a token at t if there's one, the bucket being refilled at rate tokens per second.
+func (b *tokenBucket) take(t time.Time, rate, burst float64) bool {
+	if b.last.IsZero() {
+		b.tokens = burst
+	} else if elapsed := t.Sub(b.last); elapsed > 0 {
+		b.tokens += elapsed.Seconds() * rate
+		if b.tokens > burst {
+			b.tokens = burst
+		}
+	}
+	b.last = t
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
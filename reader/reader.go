@@ -0,0 +1,115 @@
+// Package reader decodes log files written with log4go's BinaryFormatter, for replaying,
+// filtering or converting them to text.
+package reader
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/kaizer666/log4go"
+)
+
+// ErrInvalidRecord is returned for records which can't be decoded.
+var ErrInvalidRecord = errors.New("reader: invalid record")
+
+// maxRecordSize protects against reading garbage as a huge length.
+const maxRecordSize = 64 << 20
+
+// Filter selects records, returning true for those to keep.
+type Filter func(rec *log4go.Record) bool
+
+// Reader reads binary records.
+type Reader struct {
+	r *bufio.Reader
+}
+
+// NewReader returns a new Reader reading binary records from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReader(r)}
+}
+
+// Next returns the next record, or io.EOF when there are no more records.
+func (r *Reader) Next() (*log4go.Record, error) {
+	size, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("%w: size %d", ErrInvalidRecord, size)
+	}
+
+	payload := make([]byte, size)
+	if _, err = io.ReadFull(r.r, payload); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return Decode(payload)
+}
+
+// Decode decodes a record's payload (i.e. without the length prefix), see
+// log4go.DecodeBinaryRecord.
+func Decode(payload []byte) (*log4go.Record, error) {
+	rec, err := log4go.DecodeBinaryRecord(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecord, err)
+	}
+	return rec, nil
+}
+
+// Convert reads all records from src and writes those accepted by filter (all if nil),
+// formatted by f, to dst, one per line.
+func Convert(dst io.Writer, src io.Reader, f log4go.Formatter, filter Filter) error {
+	r := NewReader(src)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if filter != nil && !filter(rec) {
+			continue
+		}
+
+		out, err := f.Format(rec)
+		if err != nil {
+			return err
+		}
+		if _, err = dst.Write(append(out, '\n')); err != nil {
+			return err
+		}
+	}
+}
+
+// Replay reads all records from src and passes those accepted by filter (all if nil) to h.
+func Replay(src io.Reader, h log4go.Handler, filter Filter) error {
+	r := NewReader(src)
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if filter != nil && !filter(rec) {
+			continue
+		}
+		if err = h.Handle(rec); err != nil {
+			return err
+		}
+	}
+}
+
+// MinLevel returns a Filter keeping records of at least the given level.
+func MinLevel(lvl log4go.Level) Filter {
+	return func(rec *log4go.Record) bool {
+		return rec.Level >= lvl
+	}
+}
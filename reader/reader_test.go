@@ -0,0 +1,106 @@
+package reader
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/kaizer666/log4go"
+	"github.com/kaizer666/log4go/internal/msgpack"
+)
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	handler, _ := log4go.NewStreamHandler(&buf)
+	handler.SetFormatter(log4go.NewBinaryFormatter())
+
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	handler.Handle(&log4go.Record{Time: ts, Name: "db", Level: log4go.DEBUG, Message: "connecting\nto db", Seq: 1})
+	handler.Handle(&log4go.Record{Time: ts, Name: "db", Level: log4go.ERROR, Message: "failed", Seq: 2,
+		Fields: log4go.Fields{"attempt": 3, "host": "db1", "tags": []string{"a", "b"}}})
+	handler.ShutdownContext(context.Background())
+
+	r := NewReader(bytes.NewReader(buf.Bytes()))
+	first, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first.Time.Equal(ts) || first.Name != "db" || first.Level != log4go.DEBUG || first.Message != "connecting\nto db" || first.Fields != nil {
+		t.Errorf("unexpected record: %+v", first)
+	}
+	second, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.Seq != 2 || second.Fields["attempt"] != int64(3) || second.Fields["host"] != "db1" || len(second.Fields["tags"].([]interface{})) != 2 {
+		t.Errorf("unexpected record: %+v", second)
+	}
+	if _, err = r.Next(); err != io.EOF {
+		t.Errorf("expected EOF, got %v", err)
+	}
+
+	var text bytes.Buffer
+	formatter, _ := log4go.NewTemplateFormatter("{name} {level} {message}")
+	if err = Convert(&text, bytes.NewReader(buf.Bytes()), formatter, MinLevel(log4go.WARNING)); err != nil {
+		t.Fatal(err)
+	}
+	if got := text.String(); got != "db ERROR failed\n" {
+		t.Errorf("unexpected text: %q", got)
+	}
+
+	if _, err = NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3])).Next(); err != nil {
+		t.Fatal(err) // the first record is complete
+	}
+	r = NewReader(bytes.NewReader(buf.Bytes()[:buf.Len()-3]))
+	r.Next()
+	if _, err = r.Next(); err != io.ErrUnexpectedEOF {
+		t.Errorf("expected unexpected EOF, got %v", err)
+	}
+}
+
+func TestRoundTripMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	handler, _ := log4go.NewStreamHandler(&buf)
+	handler.SetFormatter(log4go.NewBinaryFormatter())
+
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	handler.Handle(&log4go.Record{Time: ts, Name: "db", Level: log4go.INFO, Message: "query", Seq: 3,
+		Fields: log4go.Fields{"rows": 2}, Extras: map[string]interface{}{"trace_id": "abc"},
+		Tags: log4go.Tags{"audit"}, MDC: log4go.Fields{"user": "bob"}, File: "db.go", Line: 42})
+	handler.ShutdownContext(context.Background())
+
+	rec, err := NewReader(bytes.NewReader(buf.Bytes())).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Seq != 3 || !reflect.DeepEqual(rec.Fields, log4go.Fields{"rows": int64(2)}) ||
+		!reflect.DeepEqual(rec.Extras, map[string]interface{}{"trace_id": "abc"}) ||
+		!reflect.DeepEqual(rec.Tags, log4go.Tags{"audit"}) || !reflect.DeepEqual(rec.MDC, log4go.Fields{"user": "bob"}) ||
+		rec.File != "db.go" || rec.Line != 42 {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+
+	// version 1 records: [version, time, name, level, message, seq, goroutine ID, fields]
+	payload := msgpack.AppendArrayHeader(nil, 8)
+	payload = msgpack.AppendUint(payload, 1)
+	payload = msgpack.AppendInt(payload, ts.UnixNano())
+	payload = msgpack.AppendString(payload, "db")
+	payload = msgpack.AppendInt(payload, int64(log4go.INFO))
+	payload = msgpack.AppendString(payload, "old")
+	payload = msgpack.AppendUint(payload, 1)
+	payload = msgpack.AppendUint(payload, 0)
+	payload = msgpack.Append(payload, map[string]interface{}{"trace_id": "abc"})
+	if rec, err = Decode(payload); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Message != "old" || rec.Fields["trace_id"] != "abc" || rec.Tags != nil || rec.Line != 0 {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+	if _, err = Decode(payload[:len(payload)-4]); !errors.Is(err, ErrInvalidRecord) {
+		t.Errorf("expected an invalid record error, got %v", err)
+	}
+}
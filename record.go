@@ -1,11 +1,154 @@
 package log4go
 
-import "time"
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
 
 // Record is a log message container.
+//
+// A record is shared by all the handlers it's passed to, and its Fields with the logger's
+// bound fields, so handlers (and formatters) must treat it as immutable: to keep it beyond
+// Handle, or to modify it, they work on a Clone.
 type Record struct {
 	Time    time.Time
 	Name    string
 	Level   Level
 	Message string
+	// Fields are the structured key/value pairs of the record, may be nil.
+	Fields Fields
+	// Tags are the record's tags, for the TagHandlers, may be nil.
+	Tags Tags
+	// Extras are custom metadata attached to the record, e.g. by middleware or record
+	// processors (see Set), rendered by the formatters along with the Fields; may be nil.
+	Extras map[string]interface{}
+	// MDC are the values of the logger's mapped diagnostic context at the logging call (see
+	// Logger.WithMDC), may be nil.
+	MDC Fields
+	// Seq is the record's sequence number, increasing per logger (starting at 1).
+	Seq uint64
+	// GoroutineID is the logging goroutine's ID, 0 unless enabled by CaptureGoroutineID.
+	GoroutineID uint64
+	// File and Line are the source file (base name) and line of the logging call, unset unless
+	// the logger reports the caller (see WithCaller).
+	File string
+	Line int
+
+	// previous is the time of the previous record handled by the same handler, if known
+	previous time.Time
+
+	// pool is set on the records of the record pool, see newRecord
+	pool *pooledRecord
+
+	// ack is called once a handler wrote the record, see ReliableHandler
+	ack func(error)
+}
+
+// pooledRecord is a Record of the record pool, with the number of its holders: the logger
+// while calling the handlers, and the handlers that queued it instead of copying it.
+type pooledRecord struct {
+	Record
+	refs int32 // accessed atomically
+}
+
+var recordPool = sync.Pool{
+	New: func() interface{} {
+		p := &pooledRecord{}
+		p.pool = p
+		return p
+	},
+}
+
+// newRecord returns a cleared record from the pool, held once by the caller.
+func newRecord() *Record {
+	p := recordPool.Get().(*pooledRecord)
+	p.Record = Record{pool: p}
+	p.refs = 1
+	return &p.Record
+}
+
+// retain returns the record for a handler to queue: the record itself, held once more, if it
+// comes from the pool, else a copy.
+func (r *Record) retain() *Record {
+	if r.pool != nil && &r.pool.Record == r { // not a copy of a pooled record
+		atomic.AddInt32(&r.pool.refs, 1)
+		return r
+	}
+	c := *r
+	c.pool = nil
+	c.ack = nil
+	return &c
+}
+
+// Clone returns a copy of the record that can be kept and modified: its Fields, Tags, MDC and
+// Extras are copied as well (not their values).
+func (r *Record) Clone() *Record {
+	c := *r
+	c.pool = nil
+	c.ack = nil
+	if r.Fields != nil {
+		c.Fields = make(Fields, len(r.Fields))
+		for key, value := range r.Fields {
+			c.Fields[key] = value
+		}
+	}
+	if r.Tags != nil {
+		c.Tags = append(make(Tags, 0, len(r.Tags)), r.Tags...)
+	}
+	if r.MDC != nil {
+		c.MDC = make(Fields, len(r.MDC))
+		for key, value := range r.MDC {
+			c.MDC[key] = value
+		}
+	}
+	if r.Extras != nil {
+		c.Extras = make(map[string]interface{}, len(r.Extras))
+		for key, value := range r.Extras {
+			c.Extras[key] = value
+		}
+	}
+	return &c
+}
+
+// Get returns the value of the record's extra or field named key, the extra's if both exist.
+func (r *Record) Get(key string) (interface{}, bool) {
+	if value, exists := r.Extras[key]; exists {
+		return value, true
+	}
+	value, exists := r.Fields[key]
+	return value, exists
+}
+
+// Set sets the record's extra named key; like any change, on a record the caller owns (see
+// Clone).
+func (r *Record) Set(key string, value interface{}) {
+	if r.Extras == nil {
+		r.Extras = make(map[string]interface{})
+	}
+	r.Extras[key] = value
+}
+
+// allFields returns the record's fields and extras, as rendered by the formatters: the Fields
+// if it has no extras, else a new map where the extras take precedence.
+func (r *Record) allFields() Fields {
+	if len(r.Extras) == 0 {
+		return r.Fields
+	}
+	all := make(Fields, len(r.Fields)+len(r.Extras))
+	for key, value := range r.Fields {
+		all[key] = value
+	}
+	for key, value := range r.Extras {
+		all[key] = value
+	}
+	return all
+}
+
+// release drops a hold of a record returned by newRecord or retain, putting it back in the
+// pool with the last one.
+func (r *Record) release() {
+	if r.pool != nil && &r.pool.Record == r && atomic.AddInt32(&r.pool.refs, -1) == 0 {
+		recordPool.Put(r.pool)
+	}
 }
@@ -0,0 +1,83 @@
+package log4go
+
+import (
+	"bufio"
+	"bytes"
+	"runtime/debug"
+	"strings"
+)
+
+// RecoverOpts controls how RecoverAndLog operates.
+type RecoverOpts struct {
+	// Ring is the RingHandler whose records (the recent history) are added to the report,
+	// by default the first RingHandler of the logger (or its ancestors), if any.
+	Ring *RingHandler
+	// Repanic makes RecoverAndLog shut down the logging (writing all queued records) and
+	// panic again with the recovered value, once logged.
+	Repanic bool
+}
+
+// RecoverAndLog recovers a panic and logs it at FATAL level, with the stack trace and the
+// ring buffer history, without exiting; it must be deferred:
+//
+//	defer log4go.RecoverAndLog(log, log4go.RecoverOpts{Repanic: true})
+func RecoverAndLog(logger *Logger, opts ...RecoverOpts) {
+	v := recover()
+	if v == nil {
+		return
+	}
+	stack := debug.Stack()
+
+	if len(opts) == 0 {
+		opts = append(opts, RecoverOpts{})
+	}
+	ring := opts[0].Ring
+	if ring == nil {
+		for _, h := range logger.Handlers() {
+			if r, ok := h.(*RingHandler); ok {
+				ring = r
+				break
+			}
+		}
+	}
+
+	if len(logger.staged) > 0 {
+		logger.flushStaged()
+	}
+
+	message := "PANIC: %v\n%s"
+	args := []interface{}{v, panicStack(stack)}
+	if ring != nil {
+		var history bytes.Buffer
+		if err := ring.Dump(&history); err == nil && history.Len() > 0 {
+			message += "\nrecent records:\n%s"
+			args = append(args, strings.TrimSuffix(history.String(), "\n"))
+		}
+	}
+	logger.log(FATAL, false, message, args...)
+
+	if opts[0].Repanic {
+		shutdownForExit()
+		panic(v)
+	}
+}
+
+// panicStack returns the stack trace from the function that panicked, i.e. without the
+// debug.Stack, deferred function and panic frames.
+func panicStack(stack []byte) string {
+	lines := make([]string, 0, 20)
+	skipped := 0
+	for scanner := bufio.NewScanner(bytes.NewReader(stack)); scanner.Scan(); {
+		line := scanner.Text()
+		if skipped > 0 || strings.HasPrefix(line, "panic(") {
+			skipped++
+			if skipped >= 3 {
+				lines = append(lines, line)
+			}
+		}
+	}
+	if len(lines) == 0 {
+		return strings.TrimSpace(string(stack)) // no panic frame, keep it all
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,364 @@
+package log4go
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RedisConfig configures a RedisHandler.
+type RedisConfig struct {
+	// Address is the server's host:port (default "localhost:6379").
+	Address string
+	// Username (Redis 6 ACLs) and Password authenticate the connection, DB selects the database.
+	Username string
+	Password string
+	DB       int
+	// TLSConfig, if set, is used to secure the connection.
+	TLSConfig *tls.Config
+	// Key is the stream (or list) key template, "{name}" is replaced by the logger name (with
+	// dots instead of slashes, "root" for the root logger) and "{level}" by the lowercase
+	// level name (default "logs").
+	Key string
+	// List makes the handler RPUSH the formatted records (ECS JSON by default) to a list,
+	// instead of XADDing entries (with time, level, logger, message and the fields) to a stream.
+	List bool
+	// MaxLen, if positive, caps the stream (approximately, with MAXLEN ~) or list length.
+	MaxLen int64
+	// BatchSize is the maximum number of records per pipeline (default 100).
+	BatchSize int
+	// FlushInterval is the maximum time records wait for their batch (default 1s).
+	FlushInterval time.Duration
+	// Timeout limits connecting and each pipeline (default 5s).
+	Timeout time.Duration
+	// QueueSize is the number of records buffered before Handle blocks (default 1000).
+	QueueSize int
+	// MaxRetries is the number of times a batch is resent, reconnecting first, before it's
+	// dropped (default 3).
+	MaxRetries int
+}
+
+// RedisHandler adds records to a Redis stream (XADD) or list (RPUSH), pipelining the
+// commands of a batch.
+type RedisHandler struct {
+	batchHandler
+
+	config  RedisConfig
+	pending []redisEntry
+	retries int
+	lastErr error
+	acks    []func(error) // of the pending records, see takeAck
+
+	conn net.Conn // owned by the sender goroutine
+	r    *bufio.Reader
+}
+
+type redisEntry struct {
+	key  string
+	args []string // the XADD field/value pairs, or the RPUSH value
+}
+
+// NewRedisHandler returns a new RedisHandler, connecting lazily to config.Address.
+func NewRedisHandler(config RedisConfig) (*RedisHandler, error) {
+	if len(config.Address) == 0 {
+		config.Address = "localhost:6379"
+	}
+	if len(config.Key) == 0 {
+		config.Key = "logs"
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 100
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = time.Second
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = 5 * time.Second
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 1000
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 3
+	}
+
+	var formatter Formatter = NewECSFormatter()
+	if !config.List {
+		template, err := NewTemplateFormatter("{message}")
+		if err != nil {
+			return nil, err
+		}
+		formatter = template
+	}
+
+	h := &RedisHandler{config: config}
+	h.init(config.QueueSize, formatter)
+	h.run(h, config.FlushInterval)
+
+	return h, nil
+}
+
+var _ Handler = &RedisHandler{}
+
+func (h *RedisHandler) add(rec *Record) bool {
+	msg, err := h.Formatter().Format(rec)
+	if err != nil {
+		if err != ErrorNotSet {
+			h.report(fmt.Errorf("formatter error: %w", err))
+			h.counters.countError(err)
+		}
+		return false
+	}
+	if !isRawFormatter(h.Formatter()) {
+		msg = bytes.TrimSuffix(msg, []byte{'\n'})
+	}
+
+	entry := redisEntry{key: expandTopic(h.config.Key, rec)}
+	if h.config.List {
+		entry.args = []string{string(msg)}
+	} else {
+		name := rec.Name
+		if len(name) == 0 {
+			name = "root"
+		}
+		fields := rec.allFields()
+		entry.args = make([]string, 0, 8+2*len(fields))
+		entry.args = append(entry.args,
+			"time", rec.Time.UTC().Format(time.RFC3339Nano),
+			"level", LevelName(rec.Level),
+			"logger", name,
+			"message", string(msg),
+		)
+		for key, value := range fields {
+			entry.args = append(entry.args, key, fmt.Sprint(value))
+		}
+	}
+
+	if len(h.pending) >= h.config.BatchSize {
+		h.flush()
+		if len(h.pending) != 0 {
+			h.drop() // the flush failed, and there's no room left to keep retrying
+		}
+	}
+	h.pending = append(h.pending, entry)
+	if ack := takeAck(rec); ack != nil {
+		h.acks = append(h.acks, ack)
+	}
+	return len(h.pending) >= h.config.BatchSize
+}
+
+func (h *RedisHandler) flush() {
+	if len(h.pending) == 0 {
+		return
+	}
+	if err := h.attempt(h.send); err != nil {
+		h.closeConn()
+		h.lastErr = err
+		h.retries++
+		h.counters.countError(err)
+		if h.retries <= h.config.MaxRetries {
+			return // keep them for the next flush
+		}
+		h.drop()
+		return
+	}
+	h.counters.countHandled(len(h.pending))
+	acknowledge(h.acks, nil)
+	h.reset()
+}
+
+func (h *RedisHandler) drop() {
+	h.counters.countDropped(len(h.pending))
+	h.report(&DroppedError{Count: len(h.pending), Err: h.lastErr})
+	acknowledgeDropped(h.acks, h.lastErr)
+	h.reset()
+}
+
+func (h *RedisHandler) reset() {
+	h.acks = h.acks[:0]
+	h.pending = h.pending[:0]
+	h.retries = 0
+	h.lastErr = nil
+}
+
+func (h *RedisHandler) close() {
+	h.closeConn()
+}
+
+// send pipelines the commands of the pending records; the list trimming, if any, is done
+// once per key.
+func (h *RedisHandler) send() error {
+	if h.conn == nil {
+		if err := h.connect(); err != nil {
+			return err
+		}
+	}
+
+	var buf []byte
+	commands := 0
+	trimmed := make(map[string]bool)
+	for _, entry := range h.pending {
+		if h.config.List {
+			buf = appendRedisCommand(buf, append([]string{"RPUSH", entry.key}, entry.args...)...)
+			trimmed[entry.key] = true
+		} else {
+			args := []string{"XADD", entry.key}
+			if h.config.MaxLen > 0 {
+				args = append(args, "MAXLEN", "~", strconv.FormatInt(h.config.MaxLen, 10))
+			}
+			args = append(args, "*")
+			buf = appendRedisCommand(buf, append(args, entry.args...)...)
+		}
+		commands++
+	}
+	if h.config.List && h.config.MaxLen > 0 {
+		for key := range trimmed {
+			buf = appendRedisCommand(buf, "LTRIM", key, strconv.FormatInt(-h.config.MaxLen, 10), "-1")
+			commands++
+		}
+	}
+
+	_ = h.conn.SetDeadline(time.Now().Add(h.config.Timeout))
+	if _, err := h.conn.Write(buf); err != nil {
+		return err
+	}
+
+	// read all the replies to stay in sync, reporting the first error
+	var replyErr error
+	for i := 0; i < commands; i++ {
+		if _, err := h.readReply(); err != nil {
+			var redisErr redisError
+			if !errors.As(err, &redisErr) {
+				return err
+			}
+			if replyErr == nil {
+				replyErr = err
+			}
+		}
+	}
+	if replyErr != nil {
+		// e.g. WRONGTYPE, resending won't help
+		h.report(replyErr)
+	}
+	return nil
+}
+
+func (h *RedisHandler) connect() error {
+	var (
+		conn net.Conn
+		err  error
+	)
+	dialer := &net.Dialer{Timeout: h.config.Timeout}
+	if h.config.TLSConfig != nil {
+		conn, err = tls.DialWithDialer(dialer, "tcp", h.config.Address, h.config.TLSConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", h.config.Address)
+	}
+	if err != nil {
+		return err
+	}
+	h.conn = conn
+	h.r = bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(h.config.Timeout))
+
+	var commands [][]string
+	if len(h.config.Password) != 0 {
+		if len(h.config.Username) != 0 {
+			commands = append(commands, []string{"AUTH", h.config.Username, h.config.Password})
+		} else {
+			commands = append(commands, []string{"AUTH", h.config.Password})
+		}
+	}
+	if h.config.DB != 0 {
+		commands = append(commands, []string{"SELECT", strconv.Itoa(h.config.DB)})
+	}
+	for _, command := range commands {
+		if _, err = conn.Write(appendRedisCommand(nil, command...)); err == nil {
+			_, err = h.readReply()
+		}
+		if err != nil {
+			h.closeConn()
+			return fmt.Errorf("%s: %v", command[0], err)
+		}
+	}
+	return nil
+}
+
+// redisError is an error reply.
+type redisError string
+
+func (e redisError) Error() string { return string(e) }
+
+// readReply reads a RESP reply, returning error replies as redisError.
+func (h *RedisHandler) readReply() (interface{}, error) {
+	line, err := h.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return nil, errors.New("empty Redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, err // nil bulk string
+		}
+		data := make([]byte, size+2)
+		if _, err = io.ReadFull(h.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:size]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil || count < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, count)
+		for i := range items {
+			if items[i], err = h.readReply(); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("invalid Redis reply: %q", line)
+}
+
+func (h *RedisHandler) closeConn() {
+	if h.conn != nil {
+		_ = h.conn.Close()
+		h.conn = nil
+		h.r = nil
+	}
+}
+
+// appendRedisCommand appends a command as a RESP array of bulk strings.
+func appendRedisCommand(b []byte, args ...string) []byte {
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(len(args)), 10)
+	b = append(b, '\r', '\n')
+	for _, arg := range args {
+		b = append(b, '$')
+		b = strconv.AppendInt(b, int64(len(arg)), 10)
+		b = append(b, '\r', '\n')
+		b = append(b, arg...)
+		b = append(b, '\r', '\n')
+	}
+	return b
+}
@@ -0,0 +1,122 @@
+package log4go
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay is how long WatchConfig waits for a changed file to settle, editors often
+// writing it several times.
+const reloadDelay = 100 * time.Millisecond
+
+// WatchConfig configures the loggers from a JSON file (see ConfigureFromFile), then reloads it
+// whenever one of the signals (default SIGHUP) is received or the file changes, until the
+// returned function is called. A reload swaps the loggers' levels and handlers while they are
+// in use, without losing records: the replaced handlers are shut down once the records passed
+// to them are written. Invalid configurations are reported on stderr, and not applied.
+func WatchConfig(filename string, signals ...os.Signal) (stop func(), err error) {
+	if err = ConfigureFromFile(filename); err != nil {
+		return nil, err
+	}
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+
+	// watch the file's directory, the file is often replaced rather than written to
+	var events chan fsnotify.Event
+	var watchErrors chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(filepath.Dir(filename)); err != nil {
+			_ = watcher.Close()
+			watcher = nil
+		} else {
+			events, watchErrors = watcher.Events, watcher.Errors
+		}
+	}
+	name := filepath.Clean(filename)
+
+	done := make(chan struct{})
+	go func() {
+		var settled <-chan time.Time
+		for {
+			select {
+			case <-ch:
+				reloadConfig(filename)
+			case event, ok := <-events:
+				if !ok {
+					events = nil
+				} else if filepath.Clean(event.Name) == name && event.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					settled = time.After(reloadDelay)
+				}
+			case _, ok := <-watchErrors:
+				if !ok {
+					watchErrors = nil
+				}
+				// events may have been lost, the signals still reload
+			case <-settled:
+				settled = nil
+				reloadConfig(filename)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		if watcher != nil {
+			_ = watcher.Close()
+		}
+		close(done)
+	}, nil
+}
+
+func reloadConfig(filename string) {
+	if err := ConfigureFromFile(filename); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "%v\n", err)
+	}
+}
+
+// The log calls passing a record to handlers are counted (in one of two counters, switched by
+// Configure and RemoveHandler) until they return, so that Configure can wait for those which
+// may still be using the handlers it replaced before shutting them down.
+var (
+	handlersEpoch uint32
+	handlersCalls [2]int64
+	handlersWait  sync.Mutex // serializes waitHandlers, whose switches mustn't interleave
+)
+
+// enterHandlers counts a call of the handlers, to be passed to leaveHandlers when it returns.
+func enterHandlers() *int64 {
+	calls := &handlersCalls[atomic.LoadUint32(&handlersEpoch)&1]
+	atomic.AddInt64(calls, 1)
+	return calls
+}
+
+func leaveHandlers(calls *int64) {
+	atomic.AddInt64(calls, -1)
+}
+
+// waitHandlers waits for the calls of the handlers started before it's called to return. Each
+// counter is switched from, and then waited for until it drops to zero: the calls started
+// before are then done, and those started since only see the current handlers.
+func waitHandlers() {
+	handlersWait.Lock()
+	defer handlersWait.Unlock()
+	for i := 0; i < 2; i++ {
+		epoch := atomic.AddUint32(&handlersEpoch, 1) - 1
+		for atomic.LoadInt64(&handlersCalls[epoch&1]) != 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package log4go
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reopener is a handler writing to a file it can reopen, see StreamHandler.Reopen.
+type reopener interface {
+	Reopen() error
+	canReopen() bool
+}
+
+func (h *StreamHandler) canReopen() bool {
+	return h.reopen != nil
+}
+
+// reopenHandlers reopens the files of the file handlers, returning the first error; for the
+// handlers wrapping others.
+func reopenHandlers(handlers []Handler) error {
+	var first error
+	for _, handler := range handlers {
+		if r, ok := handler.(reopener); ok && r.canReopen() {
+			if err := r.Reopen(); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// canReopenHandlers returns true if one of the handlers is a file handler.
+func canReopenHandlers(handlers []Handler) bool {
+	for _, handler := range handlers {
+		if r, ok := handler.(reopener); ok && r.canReopen() {
+			return true
+		}
+	}
+	return false
+}
+
+// ReopenFiles reopens the files of all loggers' file handlers (see StreamHandler.Reopen),
+// returning the first error.
+func ReopenFiles() error {
+	handlers := make(map[string]Handler, 10)
+	collectHandlers(rootLogger, handlers)
+
+	var first error
+	for _, h := range handlers {
+		r, ok := h.(reopener)
+		if !ok || !r.canReopen() {
+			continue
+		}
+		if err := r.Reopen(); err != nil {
+			reportError(h, fmt.Errorf("re-open error: %w", err))
+			if first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
+
+// ReopenOnSignal reopens the files of all file handlers (see ReopenFiles) whenever one of the
+// signals (default SIGHUP) is received, until the returned function is called; it's what
+// logrotate expects from its postrotate script, e.g. kill -HUP.
+func ReopenOnSignal(signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ch:
+				_ = ReopenFiles() // errors reported already
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
@@ -0,0 +1,145 @@
+package log4go
+
+import (
+	"io"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+)
+
+// DefaultRingSize is the number of records kept by NewRingHandler(0).
+const DefaultRingSize = 1000
+
+// RingHandler keeps the last records in memory, in a lock-free ring buffer, so the recent
+// history can be dumped on a crash (or SIGQUIT) even if the other handlers only log INFO and
+// above. The loggers' levels still apply: to keep DEBUG records their level must allow them.
+type RingHandler struct {
+	level     int32 // Level, accessed atomically
+	formatter atomic.Value
+
+	slots []atomic.Value // ringEntry
+	next  uint64         // index of the next record, accessed atomically
+}
+
+type ringEntry struct {
+	index uint64
+	rec   *Record
+}
+
+// NewRingHandler returns a new RingHandler keeping the last size records (default
+// DefaultRingSize).
+func NewRingHandler(size int) (*RingHandler, error) {
+	if size <= 0 {
+		size = DefaultRingSize
+	}
+	formatter, err := NewTemplateFormatter("{time} {name} {level} {message}")
+	if err != nil {
+		return nil, err
+	}
+	h := &RingHandler{slots: make([]atomic.Value, size)}
+	h.formatter.Store(formatterValue{formatter})
+	return h, nil
+}
+
+var _ Handler = &RingHandler{}
+
+// Handle keeps a copy of the record, replacing the oldest one once the ring is full.
+func (h *RingHandler) Handle(rec *Record) error {
+	if rec.Level < h.Level() {
+		return nil
+	}
+	r := *rec
+	index := atomic.AddUint64(&h.next, 1) - 1
+	h.slots[index%uint64(len(h.slots))].Store(ringEntry{index: index, rec: &r})
+	return nil
+}
+
+// Records returns clones of the kept records, oldest first.
+func (h *RingHandler) Records() []Record {
+	next := atomic.LoadUint64(&h.next)
+	first := uint64(0)
+	if size := uint64(len(h.slots)); next > size {
+		first = next - size
+	}
+
+	records := make([]Record, 0, next-first)
+	for index := first; index < next; index++ {
+		entry, _ := h.slots[index%uint64(len(h.slots))].Load().(ringEntry)
+		if entry.rec == nil || entry.index != index {
+			continue // not stored yet, or already replaced by a newer record
+		}
+		records = append(records, *entry.rec.Clone()) // the fields may be shared
+	}
+	return records
+}
+
+// Dump writes the kept records to w, oldest first, using the handler's formatter.
+func (h *RingHandler) Dump(w io.Writer) error {
+	formatter := h.Formatter()
+	for _, rec := range h.Records() {
+		msg, err := formatter.Format(&rec)
+		if err != nil {
+			if err == ErrorNotSet {
+				continue
+			}
+			return err
+		}
+		if !isRawFormatter(formatter) {
+			msg = append(msg, '\n')
+		}
+		if _, err = w.Write(msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DumpOnSignal dumps the kept records to w whenever one of the signals (default SIGQUIT) is
+// received, until the returned function is called. Note that catching SIGQUIT disables Go's
+// default goroutine dump (and exit).
+func (h *RingHandler) DumpOnSignal(w io.Writer, signals ...os.Signal) (stop func()) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGQUIT}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, signals...)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-ch:
+				_ = h.Dump(w)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
+
+// SetFormatter sets the formatter used by Dump.
+func (h *RingHandler) SetFormatter(formatter Formatter) {
+	h.formatter.Store(formatterValue{formatter})
+}
+
+// Formatter returns the formatter used by Dump.
+func (h *RingHandler) Formatter() Formatter {
+	return h.formatter.Load().(formatterValue).Formatter
+}
+
+// SetLevel sets the level the handler will (at least) keep.
+func (h *RingHandler) SetLevel(level Level) {
+	atomic.StoreInt32(&h.level, int32(level))
+}
+
+// Level returns the level previously set (or NOTSET if not set).
+func (h *RingHandler) Level() Level {
+	return Level(atomic.LoadInt32(&h.level))
+}
+
+// Shutdown does nothing, the records are kept for dumping.
+func (h *RingHandler) Shutdown() {}
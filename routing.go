@@ -0,0 +1,206 @@
+package log4go
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// RoutingHandler sends each record to the handler of the first route matching its logger's
+// name, or to the default handler (if any), e.g. the "app.access.*" records to access.log and
+// the others to app.log. Routes can be changed at any time.
+//
+// Route patterns are path.Match patterns on the logger names, whose separator can be written
+// as a dot or a slash ("\." is a dot), "*" matching any characters, separators included; a
+// pattern ending with ".*" also matches the logger itself: "app.access.*" matches the
+// app/access logger and its descendants. The root logger's name is "".
+type RoutingHandler struct {
+	level     int32 // Level, accessed atomically
+	formatter atomic.Value
+
+	mu       sync.RWMutex
+	routes   []route
+	fallback Handler
+}
+
+type route struct {
+	pattern string // as given
+	match   string // see routeMatch
+	handler Handler
+}
+
+// NewRoutingHandler returns a new RoutingHandler sending the records matching no route to
+// fallback, or dropping them if nil.
+func NewRoutingHandler(fallback Handler) (*RoutingHandler, error) {
+	h := &RoutingHandler{fallback: fallback}
+	h.formatter.Store(formatterValue{})
+	return h, nil
+}
+
+var _ Handler = &RoutingHandler{}
+
+// AddRoute adds a route, after the existing ones, sending the records of the loggers matching
+// pattern to handler.
+func (h *RoutingHandler) AddRoute(pattern string, handler Handler) error {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid route pattern %q: %w", pattern, err)
+	}
+	h.setDefaultFormatter(handler)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.routes = append(h.routes, route{pattern: pattern, match: routeMatch(pattern), handler: handler})
+	return nil
+}
+
+// RemoveRoute removes the routes of pattern, returning their handlers, which aren't shut down.
+func (h *RoutingHandler) RemoveRoute(pattern string) []Handler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var removed []Handler
+	routes := h.routes[:0:0] // a new array, Handle may be iterating over the current one
+	for _, r := range h.routes {
+		if r.pattern == pattern {
+			removed = append(removed, r.handler)
+		} else {
+			routes = append(routes, r)
+		}
+	}
+	h.routes = routes
+	return removed
+}
+
+// SetDefault sets the handler of the records matching no route (nil dropping them), returning
+// the previous one, which isn't shut down.
+func (h *RoutingHandler) SetDefault(handler Handler) Handler {
+	if handler != nil {
+		h.setDefaultFormatter(handler)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	previous := h.fallback
+	h.fallback = handler
+	return previous
+}
+
+// Route returns the handler of the loggers named name, nil if their records are dropped.
+func (h *RoutingHandler) Route(name string) Handler {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, r := range h.routes {
+		if matchLoggerName(r.match, name) {
+			return r.handler
+		}
+	}
+	return h.fallback
+}
+
+// routeSeparator stands for the logger names' separator when matching, path.Match's "*" not
+// matching slashes.
+const routeSeparator = "\x00"
+
+// routeMatch returns the route pattern matched against the logger names: with its separators
+// (dots or slashes) replaced by routeSeparator.
+func routeMatch(pattern string) string {
+	return strings.Replace(dotsToSlashes(pattern, true), "/", routeSeparator, -1)
+}
+
+// matchLoggerName reports whether the logger name matches the route pattern, see routeMatch.
+func matchLoggerName(match, name string) bool {
+	name = strings.Replace(name, "/", routeSeparator, -1)
+	if strings.HasSuffix(match, routeSeparator+"*") && name == match[:len(match)-2] {
+		return true
+	}
+	matched, _ := path.Match(match, name)
+	return matched
+}
+
+// Handle sends the record to the handler of its route.
+func (h *RoutingHandler) Handle(rec *Record) error {
+	if rec.Level < h.Level() {
+		return nil
+	}
+	if handler := h.Route(rec.Name); handler != nil {
+		return handler.Handle(rec)
+	}
+	return nil
+}
+
+// handlers returns the routes' and the default handlers, without duplicates.
+func (h *RoutingHandler) handlers() []Handler {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	handlers := make([]Handler, 0, len(h.routes)+1)
+	add := func(handler Handler) {
+		for _, added := range handlers {
+			if added == handler {
+				return
+			}
+		}
+		handlers = append(handlers, handler)
+	}
+	for _, r := range h.routes {
+		add(r.handler)
+	}
+	if h.fallback != nil {
+		add(h.fallback)
+	}
+	return handlers
+}
+
+// SetFormatter sets the formatter of the routes' handlers having none, now and when added.
+func (h *RoutingHandler) SetFormatter(formatter Formatter) {
+	h.formatter.Store(formatterValue{formatter})
+	for _, handler := range h.handlers() {
+		h.setDefaultFormatter(handler)
+	}
+}
+
+func (h *RoutingHandler) setDefaultFormatter(handler Handler) {
+	if formatter := h.Formatter(); formatter != nil && handler.Formatter() == nil {
+		handler.SetFormatter(formatter)
+	}
+}
+
+// Formatter returns the formatter previously set, see SetFormatter.
+func (h *RoutingHandler) Formatter() Formatter {
+	return h.formatter.Load().(formatterValue).Formatter
+}
+
+// SetLevel sets the level the handler will (at least) route.
+func (h *RoutingHandler) SetLevel(level Level) {
+	atomic.StoreInt32(&h.level, int32(level))
+}
+
+// Level returns the level previously set (or NOTSET if not set).
+func (h *RoutingHandler) Level() Level {
+	return Level(atomic.LoadInt32(&h.level))
+}
+
+// Shutdown shuts down the routes' and the default handlers.
+func (h *RoutingHandler) Shutdown() {
+	_ = h.ShutdownContext(context.Background())
+}
+
+// ShutdownContext shuts down the routes' and the default handlers, waiting until their
+// queued records have been written, or returns ctx.Err() if ctx is done before that.
+func (h *RoutingHandler) ShutdownContext(ctx context.Context) error {
+	shutdownHandlers(ctx, h.handlers())
+	return ctx.Err()
+}
+
+// Reopen reopens the files of the routes' and the default file handlers.
+func (h *RoutingHandler) Reopen() error {
+	return reopenHandlers(h.handlers())
+}
+
+func (h *RoutingHandler) canReopen() bool {
+	return canReopenHandlers(h.handlers())
+}
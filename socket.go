@@ -0,0 +1,132 @@
+package log4go
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// SocketHandler writes the formatted records to a Unix domain socket (stream or datagram).
+type SocketHandler struct {
+	*StreamHandler
+
+	writer *socketWriter
+}
+
+// NewUnixSocketHandler returns a new SocketHandler connected to the Unix socket at address;
+// datagram selects a SOCK_DGRAM ("unixgram") socket instead of a SOCK_STREAM ("unix") one.
+func NewUnixSocketHandler(address string, datagram bool) (*SocketHandler, error) {
+	network := "unix"
+	if datagram {
+		network = "unixgram"
+	}
+	return NewSocketHandler(network, address)
+}
+
+// NewSocketHandler returns a new SocketHandler for the given Unix network ("unix", "unixgram" or "unixpacket").
+func NewSocketHandler(network, address string) (*SocketHandler, error) {
+	switch network {
+	case "unix", "unixgram", "unixpacket":
+	default:
+		return nil, fmt.Errorf("unsupported socket network: '%s'", network)
+	}
+
+	w := &socketWriter{
+		network: network,
+		address: address,
+	}
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+
+	s, err := NewStreamHandler(w)
+	if err != nil {
+		_ = w.Close()
+		return nil, err
+	}
+
+	h := &SocketHandler{
+		StreamHandler: s,
+		writer:        w,
+	}
+	s.self = h
+	return h, nil
+}
+
+// Shutdown shuts down the handler and closes the socket, waiting for the queued records at
+// most the shutdown timeout if set (see SetShutdownTimeout).
+func (h *SocketHandler) Shutdown() {
+	ctx, cancel := h.shutdownContext()
+	defer cancel()
+	_ = h.ShutdownContext(ctx)
+}
+
+// ShutdownContext shuts down the handler once all queued records have been sent, then closes the socket.
+func (h *SocketHandler) ShutdownContext(ctx context.Context) error {
+	if err := h.StreamHandler.ShutdownContext(ctx); err != nil {
+		return err
+	}
+	return h.closeWriter()
+}
+
+// closeWriter closes the socket, and the circuit writer's spill file if any.
+func (h *SocketHandler) closeWriter() error {
+	if w, ok := h.Writer.(*CircuitWriter); ok {
+		return w.Close()
+	}
+	return h.writer.Close()
+}
+
+// socketWriter (re)connects to the socket lazily, each Write is sent as a single message.
+type socketWriter struct {
+	network string
+	address string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (w *socketWriter) connect() error {
+	conn, err := net.Dial(w.network, w.address)
+	if err != nil {
+		return err
+	}
+	w.conn = conn
+	return nil
+}
+
+func (w *socketWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		if err := w.connect(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.conn.Write(p)
+	if err != nil {
+		// the peer may have restarted (e.g. the collector), reconnect and retry once
+		_ = w.conn.Close()
+		w.conn = nil
+		if err = w.connect(); err != nil {
+			return 0, err
+		}
+		n, err = w.conn.Write(p)
+	}
+	return n, err
+}
+
+func (w *socketWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,201 @@
+package log4go
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// LevelRangeHandler passes the records whose level is within a range to another handler,
+// e.g. the INFO and WARNING records only.
+type LevelRangeHandler struct {
+	min, max int32 // Level, accessed atomically
+	handler  Handler
+}
+
+// NewLevelRangeHandler returns a new LevelRangeHandler passing the records from level min to
+// max (included) to handler; a NOTSET max doesn't limit the levels.
+func NewLevelRangeHandler(handler Handler, min, max Level) (*LevelRangeHandler, error) {
+	if handler == nil {
+		return nil, errors.New("log4go.LevelRangeHandler: no handler")
+	}
+	if max != NOTSET && max < min {
+		return nil, errors.New("log4go.LevelRangeHandler: max level below min level")
+	}
+	return &LevelRangeHandler{min: int32(min), max: int32(max), handler: handler}, nil
+}
+
+var _ Handler = &LevelRangeHandler{}
+
+// Handle passes the record to the handler if its level is in the range.
+func (h *LevelRangeHandler) Handle(rec *Record) error {
+	if rec.Level < h.Level() {
+		return nil
+	}
+	if max := h.MaxLevel(); max != NOTSET && rec.Level > max {
+		return nil
+	}
+	return h.handler.Handle(rec)
+}
+
+// Handler returns the wrapped handler.
+func (h *LevelRangeHandler) Handler() Handler {
+	return h.handler
+}
+
+// SetFormatter sets the wrapped handler's formatter.
+func (h *LevelRangeHandler) SetFormatter(formatter Formatter) {
+	h.handler.SetFormatter(formatter)
+}
+
+// Formatter returns the wrapped handler's formatter.
+func (h *LevelRangeHandler) Formatter() Formatter {
+	return h.handler.Formatter()
+}
+
+// SetLevel sets the minimum level of the range.
+func (h *LevelRangeHandler) SetLevel(level Level) {
+	atomic.StoreInt32(&h.min, int32(level))
+}
+
+// Level returns the minimum level of the range.
+func (h *LevelRangeHandler) Level() Level {
+	return Level(atomic.LoadInt32(&h.min))
+}
+
+// SetMaxLevel sets the maximum level of the range, NOTSET for none.
+func (h *LevelRangeHandler) SetMaxLevel(level Level) {
+	atomic.StoreInt32(&h.max, int32(level))
+}
+
+// MaxLevel returns the maximum level of the range, NOTSET if none.
+func (h *LevelRangeHandler) MaxLevel() Level {
+	return Level(atomic.LoadInt32(&h.max))
+}
+
+// Shutdown shuts down the wrapped handler.
+func (h *LevelRangeHandler) Shutdown() {
+	_ = h.ShutdownContext(context.Background())
+}
+
+// ShutdownContext shuts down the wrapped handler, waiting until its queued records have been
+// written, or returns ctx.Err() if ctx is done before that.
+func (h *LevelRangeHandler) ShutdownContext(ctx context.Context) error {
+	shutdownHandlers(ctx, []Handler{h.handler})
+	return ctx.Err()
+}
+
+// Reopen reopens the wrapped handler's file, if it's a file handler.
+func (h *LevelRangeHandler) Reopen() error {
+	return reopenHandlers([]Handler{h.handler})
+}
+
+func (h *LevelRangeHandler) canReopen() bool {
+	return canReopenHandlers([]Handler{h.handler})
+}
+
+// LevelSplitHandler passes all records to a handler and, in addition, those of a level (e.g.
+// ERROR) and above to another: the usual app.log and error.log.
+type LevelSplitHandler struct {
+	level      int32 // Level, accessed atomically
+	splitLevel int32 // Level, accessed atomically
+
+	all, split Handler
+}
+
+// NewLevelSplitHandler returns a new LevelSplitHandler passing all records to all, and those
+// of splitLevel and above to split too.
+func NewLevelSplitHandler(all, split Handler, splitLevel Level) (*LevelSplitHandler, error) {
+	if all == nil || split == nil {
+		return nil, errors.New("log4go.LevelSplitHandler: no handler")
+	}
+	return &LevelSplitHandler{splitLevel: int32(splitLevel), all: all, split: split}, nil
+}
+
+// NewLevelSplitFileHandler returns a new LevelSplitHandler writing all records to filename,
+// and those of splitLevel and above to splitFilename too, both opened with options.
+func NewLevelSplitFileHandler(filename, splitFilename string, splitLevel Level, options FileOptions) (*LevelSplitHandler, error) {
+	all, err := NewFileHandlerWithOptions(filename, options)
+	if err != nil {
+		return nil, err
+	}
+	split, err := NewFileHandlerWithOptions(splitFilename, options)
+	if err != nil {
+		all.Shutdown()
+		return nil, err
+	}
+	return NewLevelSplitHandler(all, split, splitLevel)
+}
+
+var _ Handler = &LevelSplitHandler{}
+
+// Handle passes the record to the handlers, returning the first error.
+func (h *LevelSplitHandler) Handle(rec *Record) error {
+	if rec.Level < h.Level() {
+		return nil
+	}
+	err := h.all.Handle(rec)
+	if rec.Level >= h.SplitLevel() {
+		if serr := h.split.Handle(rec); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
+
+// Handlers returns the handler of all records and the one of the split records.
+func (h *LevelSplitHandler) Handlers() (all, split Handler) {
+	return h.all, h.split
+}
+
+// SetSplitLevel sets the level from which records are also passed to the split handler.
+func (h *LevelSplitHandler) SetSplitLevel(level Level) {
+	atomic.StoreInt32(&h.splitLevel, int32(level))
+}
+
+// SplitLevel returns the level from which records are also passed to the split handler.
+func (h *LevelSplitHandler) SplitLevel() Level {
+	return Level(atomic.LoadInt32(&h.splitLevel))
+}
+
+// SetFormatter sets the formatter of both handlers.
+func (h *LevelSplitHandler) SetFormatter(formatter Formatter) {
+	h.all.SetFormatter(formatter)
+	h.split.SetFormatter(formatter)
+}
+
+// Formatter returns the formatter of the handler of all records.
+func (h *LevelSplitHandler) Formatter() Formatter {
+	return h.all.Formatter()
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *LevelSplitHandler) SetLevel(level Level) {
+	atomic.StoreInt32(&h.level, int32(level))
+}
+
+// Level returns the level previously set (or NOTSET if not set).
+func (h *LevelSplitHandler) Level() Level {
+	return Level(atomic.LoadInt32(&h.level))
+}
+
+// Shutdown shuts down both handlers.
+func (h *LevelSplitHandler) Shutdown() {
+	_ = h.ShutdownContext(context.Background())
+}
+
+// ShutdownContext shuts down both handlers, waiting until their queued records have been
+// written, or returns ctx.Err() if ctx is done before that.
+func (h *LevelSplitHandler) ShutdownContext(ctx context.Context) error {
+	shutdownHandlers(ctx, []Handler{h.all, h.split})
+	return ctx.Err()
+}
+
+// Reopen reopens the files of the file handlers.
+func (h *LevelSplitHandler) Reopen() error {
+	return reopenHandlers([]Handler{h.all, h.split})
+}
+
+func (h *LevelSplitHandler) canReopen() bool {
+	return canReopenHandlers([]Handler{h.all, h.split})
+}
@@ -0,0 +1,360 @@
+package log4go
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrSpoolFull is returned by Spool.Append when the spool reached its MaxBytes.
+var ErrSpoolFull = errors.New("log4go: spool full")
+
+// DefaultSpoolSegmentSize is the default size of a Spool's segment files.
+const DefaultSpoolSegmentSize = 4 << 20
+
+// spoolSuffix is the suffix of a Spool's segment files.
+const spoolSuffix = ".seg"
+
+// spoolPosition is the file of a Spool's read position: the first segment's name and offset.
+const spoolPosition = "position"
+
+// SpoolOptions configures a Spool.
+type SpoolOptions struct {
+	// Dir is the directory of the segment files, created if missing.
+	Dir string
+	// SegmentSize is the size above which a new segment file is started (default
+	// DefaultSpoolSegmentSize).
+	SegmentSize int64
+	// MaxBytes is the size of all the segments above which the records are rejected with
+	// ErrSpoolFull (default unlimited).
+	MaxBytes int64
+	// Sync syncs the segment to disk after each Append, so the entries survive a crash of the
+	// system, not only of the process.
+	Sync bool
+}
+
+// Spool is an on-disk FIFO queue of records (or any messages), stored in segment files
+// ("00000001.seg", ...) as length-prefixed entries, e.g. holding the records a network
+// handler couldn't send while its remote was unavailable (see CircuitWriter.SetSpool).
+// The segments are deleted once replayed; the ones left by a previous process are replayed
+// too, from the read position saved after each Replay, so records may be sent twice (if the
+// process dies while replaying) but aren't lost. It's safe for concurrent use.
+type Spool struct {
+	options SpoolOptions
+
+	mu         sync.Mutex
+	segments   []spoolSegment // oldest first
+	file       *os.File       // the last segment, open for appending, nil if not yet
+	readOffset int64          // of the first segment
+	size       int64          // of the entries not replayed yet
+	seq        uint64         // of the last segment
+}
+
+type spoolSegment struct {
+	name string
+	size int64
+}
+
+// OpenSpool opens the spool in options.Dir, creating the directory if missing.
+func OpenSpool(options SpoolOptions) (*Spool, error) {
+	if len(options.Dir) == 0 {
+		return nil, errors.New("no spool directory")
+	}
+	if options.SegmentSize <= 0 {
+		options.SegmentSize = DefaultSpoolSegmentSize
+	}
+	if err := os.MkdirAll(options.Dir, 0755); err != nil {
+		return nil, err
+	}
+
+	names, err := filepath.Glob(filepath.Join(options.Dir, "*"+spoolSuffix))
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{options: options}
+	seqs := make(map[string]uint64, len(names))
+	for _, name := range names {
+		seq, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(name), spoolSuffix), 10, 64)
+		if err != nil {
+			continue // not a segment
+		}
+		info, err := os.Stat(name)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() == 0 {
+			_ = os.Remove(name)
+			continue
+		}
+		seqs[name] = seq
+		s.segments = append(s.segments, spoolSegment{name: name, size: info.Size()})
+		s.size += info.Size()
+	}
+	sort.Slice(s.segments, func(i, j int) bool {
+		return seqs[s.segments[i].name] < seqs[s.segments[j].name]
+	})
+	if len(s.segments) > 0 {
+		s.seq = seqs[s.segments[len(s.segments)-1].name]
+	}
+	if err := s.loadPosition(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadPosition restores the read position saved by savePosition, deleting the segments before
+// it (whose deletion was interrupted).
+func (s *Spool) loadPosition() error {
+	data, err := ioutil.ReadFile(filepath.Join(s.options.Dir, spoolPosition))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	var base string
+	var offset int64
+	if _, err := fmt.Sscanf(string(data), "%s %d", &base, &offset); err != nil {
+		return nil // ignore a corrupt position: replaying too much is better than too little
+	}
+	for idx, seg := range s.segments {
+		if filepath.Base(seg.name) != base {
+			continue
+		}
+		for _, consumed := range s.segments[:idx] {
+			if err := os.Remove(consumed.name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			s.size -= consumed.size
+		}
+		s.segments = s.segments[idx:]
+		if offset > 0 && offset <= seg.size {
+			s.readOffset = offset
+			s.size -= offset
+		}
+		break
+	}
+	return nil
+}
+
+// savePosition saves the read position, atomically.
+func (s *Spool) savePosition() error {
+	name := filepath.Join(s.options.Dir, spoolPosition)
+	if len(s.segments) == 0 || s.readOffset == 0 {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	tmp := name + ".tmp"
+	data := fmt.Sprintf("%s %d\n", filepath.Base(s.segments[0].name), s.readOffset)
+	if err := ioutil.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// Append appends p to the spool.
+func (s *Spool) Append(p []byte) error {
+	entry := make([]byte, 4+len(p))
+	binary.BigEndian.PutUint32(entry, uint32(len(p)))
+	copy(entry[4:], p)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.options.MaxBytes > 0 && s.size+int64(len(entry)) > s.options.MaxBytes {
+		return ErrSpoolFull
+	}
+	last := len(s.segments) - 1
+	if s.file == nil || s.segments[last].size+int64(len(entry)) > s.options.SegmentSize {
+		if err := s.roll(); err != nil {
+			return err
+		}
+		last = len(s.segments) - 1
+	}
+
+	if _, err := s.file.Write(entry); err != nil {
+		// don't leave a partial entry behind
+		_ = s.file.Truncate(s.segments[last].size)
+		return err
+	}
+	s.segments[last].size += int64(len(entry))
+	s.size += int64(len(entry))
+	if s.options.Sync {
+		return s.file.Sync()
+	}
+	return nil
+}
+
+// roll starts a new segment.
+func (s *Spool) roll() error {
+	s.seq++
+	name := filepath.Join(s.options.Dir, fmt.Sprintf("%08d%s", s.seq, spoolSuffix))
+	fp, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if s.file != nil {
+		_ = s.file.Close()
+	}
+	s.file = fp
+	s.segments = append(s.segments, spoolSegment{name: name})
+	return nil
+}
+
+// Replay sends the spooled entries in order, oldest first, deleting the segments once all
+// their entries are sent; it stops at the first error of send, which gets the entry again at
+// the next Replay.
+func (s *Spool) Replay(send func(p []byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.consume(-1, send)
+	if perr := s.savePosition(); err == nil {
+		err = perr
+	}
+	return err
+}
+
+// Discard drops the n oldest entries (or all of them if there are fewer), e.g. once they're
+// acknowledged.
+func (s *Spool) Discard(n int) error {
+	if n <= 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, err := s.consume(n, nil)
+	if perr := s.savePosition(); err == nil {
+		err = perr
+	}
+	return err
+}
+
+// Scan calls f with the spooled entries in order, oldest first, without consuming them; it
+// stops at the first error of f, and returns it.
+func (s *Spool) Scan(f func(p []byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx, seg := range s.segments {
+		offset := int64(0)
+		if idx == 0 {
+			offset = s.readOffset
+		}
+		if _, err := s.readSegment(seg, offset, -1, f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// consume consumes up to limit entries (all if negative), passing them to send if not nil,
+// and deletes the segments consumed; it returns the number of entries consumed.
+func (s *Spool) consume(limit int, send func(p []byte) error) (int, error) {
+	consumed := 0
+	for len(s.segments) > 0 && (limit < 0 || consumed < limit) {
+		seg := s.segments[0]
+		n, err := s.readSegment(seg, s.readOffset, limit-consumed, func(p []byte) error {
+			if send != nil {
+				if err := send(p); err != nil {
+					return err
+				}
+			}
+			s.readOffset += int64(4 + len(p))
+			s.size -= int64(4 + len(p))
+			return nil
+		})
+		consumed += n
+		if err != nil {
+			return consumed, err
+		}
+		if limit >= 0 && consumed == limit && s.readOffset < seg.size {
+			break // the segment has entries left
+		}
+
+		if len(s.segments) == 1 && s.file != nil {
+			_ = s.file.Close()
+			s.file = nil
+		}
+		if err := os.Remove(seg.name); err != nil && !os.IsNotExist(err) {
+			return consumed, err
+		}
+		s.size -= seg.size - s.readOffset
+		s.segments = s.segments[1:]
+		s.readOffset = 0
+	}
+	return consumed, nil
+}
+
+// readSegment passes up to limit entries (all if negative) of seg from offset to f, returning
+// the number of entries f took; a truncated entry at its end (e.g. the process was killed while
+// appending), or a corrupted one (its length exceeding the segment), ends it.
+func (s *Spool) readSegment(seg spoolSegment, offset int64, limit int, f func(p []byte) error) (int, error) {
+	fp, err := os.Open(seg.name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer fp.Close()
+	if _, err := fp.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	remaining := seg.size - offset
+	r := bufio.NewReader(io.LimitReader(fp, remaining))
+	var header [4]byte
+	n := 0
+	for limit < 0 || n < limit {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			break
+		}
+		size := int64(binary.BigEndian.Uint32(header[:]))
+		if remaining -= 4; size > remaining {
+			break // corrupted, or truncated: nothing can be read past it
+		}
+		p := make([]byte, size)
+		if _, err := io.ReadFull(r, p); err != nil {
+			break
+		}
+		remaining -= size
+		if err := f(p); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Len returns the size of the entries not replayed yet, in bytes (with their headers).
+func (s *Spool) Len() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Close closes the spool, keeping the entries not replayed yet for the next OpenSpool.
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
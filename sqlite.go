@@ -0,0 +1,228 @@
+package log4go
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLiteConfig configures a SQLiteHandler.
+type SQLiteConfig struct {
+	// DB is the SQLite database, opened with the application's SQLite driver (as writes are
+	// serialized by SQLite, limiting it to a single connection avoids "database is locked"
+	// errors: DB.SetMaxOpenConns(1)).
+	DB *sql.DB
+	// Table is the table name (default "logs").
+	Table string
+	// Retention is how long the records are kept (default 7 days).
+	Retention time.Duration
+	// MaxRows, if positive, caps the number of records kept.
+	MaxRows int64
+	// PruneInterval is the minimum time between two prunings of the old records (default 10m).
+	PruneInterval time.Duration
+	// BatchSize is the maximum number of records inserted per transaction (default 100).
+	BatchSize int
+	// FlushInterval is the maximum time records wait for their batch, i.e. before they can
+	// be queried (default 1s).
+	FlushInterval time.Duration
+	// QueueSize is the number of records buffered before Handle blocks (default 1000).
+	QueueSize int
+}
+
+// SQLiteHandler keeps the last days of records in a local SQLite database, for devices
+// without central logging, and lets them be queried (see Query).
+type SQLiteHandler struct {
+	*DBHandler
+
+	retention     time.Duration
+	maxRows       int64
+	pruneInterval time.Duration
+	lastPrune     time.Time
+}
+
+var sqliteColumns = []DBColumn{
+	{Name: "time_ns", Value: "time_ns", Type: "INTEGER NOT NULL"},
+	{Name: "level", Value: "level_no", Type: "INTEGER NOT NULL"},
+	{Name: "logger", Value: "name", Type: "TEXT NOT NULL"},
+	{Name: "message", Value: "message", Type: "TEXT"},
+	{Name: "fields", Value: "fields", Type: "TEXT"},
+	{Name: "seq", Value: "seq", Type: "INTEGER"},
+}
+
+// NewSQLiteHandler returns a new SQLiteHandler, creating the table and its indexes if needed
+// and pruning the expired records.
+func NewSQLiteHandler(config SQLiteConfig) (*SQLiteHandler, error) {
+	if len(config.Table) == 0 {
+		config.Table = "logs"
+	}
+	if config.Retention <= 0 {
+		config.Retention = 7 * 24 * time.Hour
+	}
+	if config.PruneInterval <= 0 {
+		config.PruneInterval = 10 * time.Minute
+	}
+
+	db, err := newDBHandler(DBConfig{
+		DB:            config.DB,
+		Table:         config.Table,
+		Columns:       sqliteColumns,
+		CreateTable:   true,
+		BatchSize:     config.BatchSize,
+		FlushInterval: config.FlushInterval,
+		QueueSize:     config.QueueSize,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h := &SQLiteHandler{
+		DBHandler:     db,
+		retention:     config.Retention,
+		maxRows:       config.MaxRows,
+		pruneInterval: config.PruneInterval,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+	defer cancel()
+	for _, index := range []string{
+		"CREATE INDEX IF NOT EXISTS " + config.Table + "_time ON " + config.Table + " (time_ns)",
+		"CREATE INDEX IF NOT EXISTS " + config.Table + "_logger ON " + config.Table + " (logger, time_ns)",
+	} {
+		if _, err = config.DB.ExecContext(ctx, index); err != nil {
+			return nil, err
+		}
+	}
+	if err = h.prune(ctx); err != nil {
+		return nil, err
+	}
+
+	h.run(h, h.config.FlushInterval)
+	return h, nil
+}
+
+var _ Handler = &SQLiteHandler{}
+
+func (h *SQLiteHandler) flush() {
+	h.DBHandler.flush()
+
+	if time.Since(h.lastPrune) >= h.pruneInterval {
+		ctx, cancel := context.WithTimeout(context.Background(), h.config.Timeout)
+		if err := h.prune(ctx); err != nil {
+			h.report(fmt.Errorf("prune error: %w", err))
+		}
+		cancel()
+	}
+}
+
+// prune deletes the expired records, and the oldest ones beyond MaxRows.
+func (h *SQLiteHandler) prune(ctx context.Context) error {
+	h.lastPrune = time.Now()
+	db, table := h.config.DB, h.config.Table
+
+	cutoff := now().Add(-h.retention).UnixNano()
+	if _, err := db.ExecContext(ctx, "DELETE FROM "+table+" WHERE time_ns < ?", cutoff); err != nil {
+		return err
+	}
+	if h.maxRows > 0 {
+		_, err := db.ExecContext(ctx, "DELETE FROM "+table+" WHERE rowid <= "+
+			"(SELECT rowid FROM "+table+" ORDER BY rowid DESC LIMIT 1 OFFSET ?)", h.maxRows)
+		return err
+	}
+	return nil
+}
+
+// LogQuery selects records for SQLiteHandler.Query.
+type LogQuery struct {
+	// MinLevel is the minimum level of the records.
+	MinLevel Level
+	// From and To, if set, limit the records' time to [From, To).
+	From time.Time
+	To   time.Time
+	// Logger, if set, selects the records of that logger and its descendants ("root" for
+	// the root logger only).
+	Logger string
+	// Limit is the maximum number of records returned (default 1000).
+	Limit int
+}
+
+// Query returns the stored records matching q, the most recent first. The records still
+// waiting for their batch (see SQLiteConfig.FlushInterval) are not returned.
+func (h *SQLiteHandler) Query(ctx context.Context, q LogQuery) ([]Record, error) {
+	var (
+		where []string
+		args  []interface{}
+	)
+	if q.MinLevel > NOTSET {
+		where = append(where, "level >= ?")
+		args = append(args, int64(q.MinLevel))
+	}
+	if !q.From.IsZero() {
+		where = append(where, "time_ns >= ?")
+		args = append(args, q.From.UnixNano())
+	}
+	if !q.To.IsZero() {
+		where = append(where, "time_ns < ?")
+		args = append(args, q.To.UnixNano())
+	}
+	if len(q.Logger) != 0 {
+		if q.Logger == "root" {
+			where = append(where, "logger = ?")
+			args = append(args, q.Logger)
+		} else {
+			escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(q.Logger)
+			where = append(where, `(logger = ? OR logger LIKE ? ESCAPE '\')`)
+			args = append(args, q.Logger, escaped+"/%")
+		}
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 1000
+	}
+
+	query := "SELECT time_ns, level, logger, message, fields, seq FROM " + h.config.Table
+	if len(where) != 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY time_ns DESC LIMIT " + strconv.Itoa(limit)
+
+	rows, err := h.config.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var (
+			timeNs, level   int64
+			name            string
+			message, fields sql.NullString
+			seq             sql.NullInt64
+		)
+		if err = rows.Scan(&timeNs, &level, &name, &message, &fields, &seq); err != nil {
+			return nil, err
+		}
+		rec := Record{
+			Time:    time.Unix(0, timeNs),
+			Name:    name,
+			Level:   Level(level),
+			Message: message.String,
+			Seq:     uint64(seq.Int64),
+		}
+		if rec.Name == "root" {
+			rec.Name = ""
+		}
+		if fields.Valid && len(fields.String) != 0 {
+			if err = json.Unmarshal([]byte(fields.String), &rec.Fields); err != nil {
+				return nil, errors.New("invalid fields: " + err.Error())
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
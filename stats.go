@@ -0,0 +1,200 @@
+package log4go
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a snapshot of the logging pipeline's counters, see ReadStats.
+type Stats struct {
+	// Loggers are the loggers that logged at least one record, sorted by name.
+	Loggers []LoggerStats
+	// Handlers are the (unique) handlers of all loggers keeping statistics.
+	Handlers []HandlerStats
+}
+
+// LoggerStats are the counters of a logger.
+type LoggerStats struct {
+	// Name is the logger's name ("root" for the root logger).
+	Name string
+	// Logged is the number of records logged (i.e. enabled), per level; custom levels are
+	// counted as NOTSET.
+	Logged map[Level]uint64
+	// RateLimited is the number of records dropped by the logger's rate limit.
+	RateLimited uint64
+}
+
+// HandlerStats are the counters of a handler.
+type HandlerStats struct {
+	// Name identifies the handler: its type name, suffixed with "#2", "#3"... when several
+	// handlers have the same type.
+	Name string
+	// Handled is the number of records written (or sent) successfully.
+	Handled uint64
+	// Dropped is the number of records given up on.
+	Dropped uint64
+	// Errors is the number of formatting, write and send errors.
+	Errors uint64
+	// LastError is the last error's message and LastErrorTime when it occurred.
+	LastError     string
+	LastErrorTime time.Time
+	// QueueDepth and QueueCapacity are the number of queued records and the queue's size.
+	QueueDepth    int
+	QueueCapacity int
+	// Writes is the number of writes (or batch sends) and WriteTime their total duration.
+	Writes    uint64
+	WriteTime time.Duration
+}
+
+// handlerCounters are the counters kept by the handlers, accessed atomically.
+type handlerCounters struct {
+	handled    uint64
+	dropped    uint64
+	errors     uint64
+	writes     uint64
+	writeNanos uint64
+
+	lastErr atomic.Value // lastError
+}
+
+type lastError struct {
+	message string
+	time    time.Time
+}
+
+func (c *handlerCounters) countHandled(n int) {
+	atomic.AddUint64(&c.handled, uint64(n))
+}
+
+func (c *handlerCounters) countDropped(n int) {
+	atomic.AddUint64(&c.dropped, uint64(n))
+}
+
+func (c *handlerCounters) countError(err error) {
+	atomic.AddUint64(&c.errors, 1)
+	c.lastErr.Store(lastError{message: err.Error(), time: now()})
+}
+
+func (c *handlerCounters) countWrite(d time.Duration) {
+	atomic.AddUint64(&c.writes, 1)
+	atomic.AddUint64(&c.writeNanos, uint64(d))
+}
+
+func (c *handlerCounters) read(queue int, capacity int) HandlerStats {
+	last, _ := c.lastErr.Load().(lastError)
+	return HandlerStats{
+		Handled:       atomic.LoadUint64(&c.handled),
+		Dropped:       atomic.LoadUint64(&c.dropped),
+		Errors:        atomic.LoadUint64(&c.errors),
+		LastError:     last.message,
+		LastErrorTime: last.time,
+		QueueDepth:    queue,
+		QueueCapacity: capacity,
+		Writes:        atomic.LoadUint64(&c.writes),
+		WriteTime:     time.Duration(atomic.LoadUint64(&c.writeNanos)),
+	}
+}
+
+// statsHandler is implemented by the handlers keeping statistics.
+type statsHandler interface {
+	handlerStats() HandlerStats
+}
+
+// nextSeq returns the sequence number of the next record, shared by the loggers derived with
+// With.
+func (l *Logger) nextSeq() uint64 {
+	if l.stats != nil {
+		l = l.stats
+	}
+	return atomic.AddUint64(&l.seq, 1)
+}
+
+// countLogged counts a record logged at lvl.
+func (l *Logger) countLogged(lvl Level) {
+	if l.stats != nil {
+		l = l.stats
+	}
+	if lvl < 0 || int(lvl) >= len(l.logged) {
+		lvl = 0 // custom levels are counted together
+	}
+	atomic.AddUint64(&l.logged[lvl], 1)
+}
+
+// ReadStats returns a snapshot of the loggers' and handlers' counters, e.g. to export them
+// as metrics (see the prometheus sub-package).
+func ReadStats() Stats {
+	root := GetLogger()
+
+	loggersLock.Lock()
+	all := registeredLoggers(root)
+	handlers := make([]Handler, 0, 10)
+	for _, logger := range all {
+		handlers = append(handlers, logger.loadHandlers()...)
+	}
+	loggersLock.Unlock()
+
+	var stats Stats
+	for _, logger := range all {
+		var logged map[Level]uint64
+		for lvl := range logger.logged {
+			if n := atomic.LoadUint64(&logger.logged[lvl]); n != 0 {
+				if logged == nil {
+					logged = make(map[Level]uint64)
+				}
+				logged[Level(lvl)] = n
+			}
+		}
+		limited := atomic.LoadUint64(&logger.limitedCount)
+		if logged != nil || limited != 0 {
+			name := logger.name
+			if len(name) == 0 {
+				name = "root"
+			}
+			stats.Loggers = append(stats.Loggers, LoggerStats{Name: name, Logged: logged, RateLimited: limited})
+		}
+	}
+
+	_, stats.Handlers = readHandlerStats(handlers)
+	return stats
+}
+
+// loadAllHandlers returns the handlers of all loggers, possibly several times.
+func loadAllHandlers() []Handler {
+	root := GetLogger()
+
+	loggersLock.Lock()
+	defer loggersLock.Unlock()
+	handlers := make([]Handler, 0, 10)
+	for _, logger := range registeredLoggers(root) {
+		handlers = append(handlers, logger.loadHandlers()...)
+	}
+	return handlers
+}
+
+// readHandlerStats returns the unique handlers keeping statistics among handlers, and their
+// statistics, named.
+func readHandlerStats(handlers []Handler) ([]Handler, []HandlerStats) {
+	var unique []Handler
+	var stats []HandlerStats
+	seen := make(map[string]bool, len(handlers))
+	types := make(map[string]int, len(handlers))
+	for _, h := range handlers {
+		sh, ok := h.(statsHandler)
+		hkey := fmt.Sprintf("%p", h)
+		if !ok || seen[hkey] {
+			continue
+		}
+		seen[hkey] = true
+
+		hs := sh.handlerStats()
+		hs.Name = handlerName(h)
+		types[hs.Name]++
+		if n := types[hs.Name]; n > 1 {
+			hs.Name += fmt.Sprintf("#%d", n)
+		}
+		unique = append(unique, h)
+		stats = append(stats, hs)
+	}
+	return unique, stats
+}
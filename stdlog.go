@@ -0,0 +1,63 @@
+package log4go
+
+import (
+	"log"
+	"strings"
+)
+
+// RedirectStdLog redirects the output of the standard library's log package to logger.
+// Lines starting with a level name in brackets or followed by a colon, e.g. "[ERROR] ..." or
+// "WARNING: ...", are logged with that level (the prefix is removed), others with defaultLevel.
+// The returned function restores the previous output, flags and prefix.
+func RedirectStdLog(logger *Logger, defaultLevel Level) func() {
+	oldOutput, oldFlags, oldPrefix := log.Writer(), log.Flags(), log.Prefix()
+
+	log.SetOutput(&loggerWriter{logger: logger, level: defaultLevel, parseLevel: true})
+	log.SetFlags(0) // log4go adds the time
+	log.SetPrefix("")
+
+	return func() {
+		log.SetOutput(oldOutput)
+		log.SetFlags(oldFlags)
+		log.SetPrefix(oldPrefix)
+	}
+}
+
+// stdLevelAliases are level names commonly used in log prefixes besides LevelName's.
+var stdLevelAliases = map[string]Level{
+	"WARN":  WARNING,
+	"ERR":   ERROR,
+	"CRIT":  FATAL,
+	"PANIC": FATAL,
+}
+
+// splitLevelPrefix splits a "[LEVEL] message" or "LEVEL: message" line into level and message.
+func splitLevelPrefix(line string) (Level, string, bool) {
+	var name, rest string
+	if strings.HasPrefix(line, "[") {
+		end := strings.IndexByte(line, ']')
+		if end < 0 {
+			return NOTSET, line, false
+		}
+		name, rest = line[1:end], line[end+1:]
+	} else {
+		end := strings.IndexByte(line, ':')
+		if end < 0 || strings.ContainsAny(line[:end], " \t") {
+			return NOTSET, line, false
+		}
+		name, rest = line[:end], line[end+1:]
+	}
+
+	upper := strings.ToUpper(strings.TrimSpace(name))
+	lvl, exists := stdLevelAliases[upper]
+	if !exists {
+		var err error
+		if lvl, err = ParseLevel(upper); err != nil || lvl == NOTSET {
+			return NOTSET, line, false
+		}
+		if _, named := levelToName[lvl]; !named { // e.g. "[42]"
+			return NOTSET, line, false
+		}
+	}
+	return lvl, strings.TrimLeft(rest, " \t"), true
+}
@@ -0,0 +1,35 @@
+package log4go
+
+// DurationField is the field holding the elapsed time (a time.Duration) of the records logged
+// by the functions returned by Logger.Duration and TimeIt, OperationField the operation.
+const (
+	DurationField  = "duration"
+	OperationField = "operation"
+)
+
+// Duration starts timing an operation, the returned function logs its elapsed time at level
+// lvl when called, e.g. when the operation returns:
+//
+//	defer log.Duration(log4go.INFO, "load config")()
+//
+// The record's message is "<operation> took <elapsed time>", with the operation and duration
+// fields (see DurationField); kv are more key/value pairs, or Fields, added to them.
+func (l *Logger) Duration(lvl Level, operation string, kv ...interface{}) func() {
+	start := now()
+	return func() {
+		if !l.IsEnabled(lvl) {
+			return
+		}
+		elapsed := now().Sub(start)
+		fields := bindFields(Fields{OperationField: operation, DurationField: elapsed}, "", kv)
+		l.log(lvl, false, "%s took %s", operation, elapsed, fields)
+	}
+}
+
+// TimeIt starts timing an operation, the returned function logs its elapsed time at DEBUG
+// level, see Duration:
+//
+//	defer log.TimeIt("load config")()
+func (l *Logger) TimeIt(operation string, kv ...interface{}) func() {
+	return l.Duration(DEBUG, operation, kv...)
+}
@@ -0,0 +1,160 @@
+package log4go
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+)
+
+// Tags label records for the TagHandlers subscribed to them, e.g. "audit" for the security
+// relevant events; passed as the last argument of a logging call (or before the Fields) they
+// are attached instead of being formatted into the message:
+//
+//	log.Warning("login failed for %s", user, log4go.Tags{"audit"})
+//
+// See also Logger.WithTags.
+type Tags []string
+
+// splitTags removes trailing Tags from args, returning them separately.
+func splitTags(args []interface{}) ([]interface{}, Tags) {
+	if len(args) == 0 {
+		return args, nil
+	}
+	if tags, ok := args[len(args)-1].(Tags); ok {
+		return args[:len(args)-1], tags
+	}
+	return args, nil
+}
+
+// splitArgs removes the trailing Tags and Fields, in any order, from args.
+func splitArgs(args []interface{}) ([]interface{}, Tags, Fields) {
+	args, tags := splitTags(args)
+	args, fields := splitFields(args)
+	if tags == nil {
+		args, tags = splitTags(args)
+	}
+	return args, tags, fields
+}
+
+// Has reports whether tag is one of the tags.
+func (t Tags) Has(tag string) bool {
+	for _, s := range t {
+		if s == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeTags returns the tags with those of more added, without modifying them.
+func mergeTags(tags, more Tags) Tags {
+	if len(more) == 0 {
+		return tags
+	}
+	if len(tags) == 0 {
+		return more
+	}
+	merged := make(Tags, len(tags), len(tags)+len(more))
+	copy(merged, tags)
+	for _, tag := range more {
+		if !merged.Has(tag) {
+			merged = append(merged, tag)
+		}
+	}
+	return merged
+}
+
+// WithTags returns a child logger (see With) tagging all its records with tags, in addition
+// to the logger's tags and those of the logging calls.
+func (l *Logger) WithTags(tags ...string) *Logger {
+	child := l.With("")
+	child.tags = mergeTags(l.tags, tags)
+	return child
+}
+
+// TagHandler passes the records carrying one of its tags to another handler, whatever their
+// level, e.g. to write the "audit" records to a separate sink as well; the records must still
+// be enabled by their logger's level.
+type TagHandler struct {
+	level   int32 // Level, accessed atomically
+	tags    Tags
+	handler Handler
+}
+
+// NewTagHandler returns a new TagHandler passing the records tagged with any of tags to
+// handler.
+func NewTagHandler(handler Handler, tags ...string) (*TagHandler, error) {
+	if handler == nil {
+		return nil, errors.New("log4go.TagHandler: no handler")
+	}
+	if len(tags) == 0 {
+		return nil, errors.New("log4go.TagHandler: no tags")
+	}
+	return &TagHandler{tags: tags, handler: handler}, nil
+}
+
+var _ Handler = &TagHandler{}
+
+// Handle passes the record to the handler if it has one of the tags.
+func (h *TagHandler) Handle(rec *Record) error {
+	if rec.Level < h.Level() {
+		return nil
+	}
+	for _, tag := range h.tags {
+		if rec.Tags.Has(tag) {
+			return h.handler.Handle(rec)
+		}
+	}
+	return nil
+}
+
+// Tags returns the tags the handler is subscribed to.
+func (h *TagHandler) Tags() Tags {
+	return h.tags
+}
+
+// Handler returns the wrapped handler.
+func (h *TagHandler) Handler() Handler {
+	return h.handler
+}
+
+// SetFormatter sets the wrapped handler's formatter.
+func (h *TagHandler) SetFormatter(formatter Formatter) {
+	h.handler.SetFormatter(formatter)
+}
+
+// Formatter returns the wrapped handler's formatter.
+func (h *TagHandler) Formatter() Formatter {
+	return h.handler.Formatter()
+}
+
+// SetLevel sets the handler's level.
+func (h *TagHandler) SetLevel(level Level) {
+	atomic.StoreInt32(&h.level, int32(level))
+}
+
+// Level returns the level previously set (or NOTSET if not set).
+func (h *TagHandler) Level() Level {
+	return Level(atomic.LoadInt32(&h.level))
+}
+
+// Shutdown shuts down the wrapped handler.
+func (h *TagHandler) Shutdown() {
+	_ = h.ShutdownContext(context.Background())
+}
+
+// ShutdownContext shuts down the wrapped handler, waiting until its queued records have been
+// written, or returns ctx.Err() if ctx is done before that.
+func (h *TagHandler) ShutdownContext(ctx context.Context) error {
+	shutdownHandlers(ctx, []Handler{h.handler})
+	return ctx.Err()
+}
+
+// Reopen reopens the wrapped handler's file, if it's a file handler.
+func (h *TagHandler) Reopen() error {
+	return reopenHandlers([]Handler{h.handler})
+}
+
+func (h *TagHandler) canReopen() bool {
+	return canReopenHandlers([]Handler{h.handler})
+}
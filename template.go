@@ -0,0 +1,102 @@
+package log4go
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MessageTemplateField is the field holding the template of the records logged with a
+// message template, e.g. to count the records per template rather than per message.
+//
+// A message is a template, rather than a printf format, when it has placeholders of the
+// record's fields (named) or of the call's arguments (positional), and no printf verbs, e.g.
+//
+//	log.Info("user {user} logged in from {ip}", log4go.Fields{"user": name, "ip": ip})
+//	log.Info("user {0} logged in from {1}", name, ip)
+//
+// The placeholders are replaced by the values (fmt.Sprint), those matching nothing are kept
+// as is, and "{{" and "}}" are a literal "{" and "}".
+const MessageTemplateField = "message_template"
+
+// expandTemplate returns the message expanded if it's a template, see MessageTemplateField.
+func expandTemplate(message string, args []interface{}, fields Fields) (string, bool) {
+	if strings.IndexByte(message, '{') < 0 || hasVerbs(message) {
+		return "", false
+	}
+
+	var b strings.Builder
+	expanded := false
+	for i := 0; i < len(message); i++ {
+		c := message[i]
+		if (c == '{' || c == '}') && i+1 < len(message) && message[i+1] == c {
+			b.WriteByte(c)
+			i++
+			continue
+		}
+		if c != '{' {
+			b.WriteByte(c)
+			continue
+		}
+		end := strings.IndexByte(message[i:], '}')
+		if end < 0 {
+			b.WriteString(message[i:])
+			break
+		}
+		name := message[i+1 : i+end]
+		if value, ok := templateValue(name, args, fields); ok {
+			b.WriteString(value)
+			expanded = true
+		} else {
+			b.WriteString(message[i : i+end+1])
+		}
+		i += end
+	}
+	if !expanded {
+		return "", false
+	}
+	return b.String(), true
+}
+
+// hasVerbs returns whether the message has printf verbs, e.g. "%d" or "%-8.2f" (not "%%").
+func hasVerbs(message string) bool {
+	for i := strings.IndexByte(message, '%'); i >= 0 && i+1 < len(message); {
+		j := i + 1
+		for j < len(message) && strings.IndexByte("+-#0123456789.*[]", message[j]) >= 0 {
+			j++
+		}
+		if j == len(message) {
+			return false
+		}
+		if c := message[j]; c != '%' && (c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return true
+		}
+		next := strings.IndexByte(message[j+1:], '%')
+		if next < 0 {
+			return false
+		}
+		i = j + 1 + next
+	}
+	return false
+}
+
+// templateValue returns the value of a placeholder: a field, or an argument.
+func templateValue(name string, args []interface{}, fields Fields) (string, bool) {
+	if value, ok := fields[name]; ok {
+		return fmt.Sprint(value), true
+	}
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 && n < len(args) {
+		return fmt.Sprint(args[n]), true
+	}
+	return "", false
+}
+
+// withTemplate returns the fields with the message template added, without modifying them.
+func withTemplate(fields Fields, template string) Fields {
+	f := make(Fields, len(fields)+1)
+	for key, value := range fields {
+		f[key] = value
+	}
+	f[MessageTemplateField] = template
+	return f
+}
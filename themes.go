@@ -0,0 +1,91 @@
+package log4go
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kaizer666/log4go/color"
+)
+
+// Theme is a named set of colors for level and pattern coloring.
+type Theme struct {
+	// Levels maps levels to line colors.
+	Levels map[Level]string
+	// Patterns maps pattern coloring names ("brackets", "punct", "quoted") to colors.
+	Patterns map[string]string
+}
+
+var themesLock sync.RWMutex
+var themes map[string]Theme
+
+func init() {
+	themes = map[string]Theme{
+		"dark": {
+			Levels:   defaultLevelColoring,
+			Patterns: defaultPatternColoring,
+		},
+		"light": {
+			Levels: map[Level]string{
+				FATAL:   color.RedBg + color.Bold,
+				ERROR:   color.Color256(160),
+				WARNING: color.Color256(130),
+				INFO:    color.Normal,
+				DEBUG:   color.Color256(244),
+				TRACE:   color.Color256(250),
+			},
+			Patterns: map[string]string{
+				"brackets": color.Color256(90),
+				"punct":    color.Color256(25),
+				"quoted":   color.Color256(28),
+			},
+		},
+		"solarized": {
+			Levels: map[Level]string{
+				FATAL:   color.BgRGB(0xdc, 0x32, 0x2f) + color.Bold,
+				ERROR:   color.Hex("#dc322f"),
+				WARNING: color.Hex("#b58900"),
+				INFO:    color.Hex("#839496"),
+				DEBUG:   color.Hex("#586e75"),
+				TRACE:   color.Hex("#586e75"),
+			},
+			Patterns: map[string]string{
+				"brackets": color.Hex("#6c71c4"),
+				"punct":    color.Hex("#268bd2"),
+				"quoted":   color.Hex("#2aa198"),
+			},
+		},
+	}
+}
+
+// RegisterTheme adds (or replaces) a named theme usable with SetTheme.
+func RegisterTheme(name string, theme Theme) {
+	themesLock.Lock()
+	defer themesLock.Unlock()
+
+	themes[name] = theme
+}
+
+// GetTheme returns the named theme.
+func GetTheme(name string) (Theme, bool) {
+	themesLock.RLock()
+	defer themesLock.RUnlock()
+
+	theme, exists := themes[name]
+	return theme, exists
+}
+
+// SetTheme sets the level colors of the named theme ("dark", "light", "solarized" or a
+// registered one), and its pattern colors if pattern coloring is enabled (see
+// EnablePatternColoring and SetPatternColoring).
+func (f *TemplateFormatter) SetTheme(name string) error {
+	theme, exists := GetTheme(name)
+	if !exists {
+		return fmt.Errorf("unknown theme: '%s'", name)
+	}
+
+	f.levelColoring = theme.Levels
+	if theme.Patterns != nil && f.patternColoringPatterns != nil {
+		f.SetPatternColoring(theme.Patterns, f.patternColoringPatterns)
+	}
+	return nil
+}
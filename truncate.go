@@ -0,0 +1,67 @@
+package log4go
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// DefaultTruncationMarker is appended to records cut by SetMaxLength when no marker is given.
+const DefaultTruncationMarker = "…[truncated]"
+
+// truncation is a handler's record size cap.
+type truncation struct {
+	maxLength int
+	marker    []byte
+}
+
+// SetMaxLength caps the size of the formatted records (in bytes, without the newline) written
+// by the handler; longer records are cut at a character boundary and end with marker
+// (DefaultTruncationMarker if empty). A maxLength of 0 disables the cap.
+func (h *StreamHandler) SetMaxLength(maxLength int, marker string) {
+	if len(marker) == 0 {
+		marker = DefaultTruncationMarker
+	}
+	h.truncation.Store(&truncation{maxLength: maxLength, marker: []byte(marker)})
+}
+
+// truncate cuts msg to the handler's maximum length, if set.
+func (h *StreamHandler) truncate(msg []byte) []byte {
+	t, _ := h.truncation.Load().(*truncation)
+	if t == nil || t.maxLength <= 0 || len(msg) <= t.maxLength {
+		return msg
+	}
+	return truncateRecord(msg, t.maxLength, t.marker)
+}
+
+// truncateRecord cuts msg so that, including the marker, it's at most maxLength bytes long;
+// UTF-8 characters and ANSI sequences are not split, and colors are reset (within maxLength) if
+// the part kept has any.
+func truncateRecord(msg []byte, maxLength int, marker []byte) []byte {
+	cut := truncationCut(msg, maxLength-len(marker))
+	if bytes.IndexByte(msg[:cut], '\x1b') >= 0 {
+		cut = truncationCut(msg, maxLength-len(marker)-len(colorReset))
+	}
+
+	out := append(msg[:cut:cut], marker...)
+	if bytes.IndexByte(msg[:cut], '\x1b') >= 0 {
+		out = append(out, colorReset...)
+	}
+	return out
+}
+
+// truncationCut returns where to cut msg to keep at most n bytes, without splitting UTF-8
+// characters and ANSI sequences.
+func truncationCut(msg []byte, n int) int {
+	cut := n
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && !utf8.RuneStart(msg[cut]) {
+		cut--
+	}
+	// don't leave a partial ANSI sequence
+	if esc := bytes.LastIndexByte(msg[:cut], '\x1b'); esc >= 0 && ansiSequenceLen(string(msg[esc:])) > cut-esc {
+		cut = esc
+	}
+	return cut
+}
@@ -0,0 +1,53 @@
+package log4go
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// sampledDebug is 1 when the DEBUG records of the Ctx methods depend on the context, see
+// SampledDebug; accessed atomically.
+var sampledDebug int32
+
+// SampledDebug enables (or disables) per-request verbose logging: the records of DebugCtx
+// (and of LogCtx at DEBUG level or below) are then emitted if and only if their context is
+// verbose (see IsVerbose), whatever the loggers' levels, so DEBUG logging can be on for some
+// requests in production without being on globally. Handlers filtering by level still apply.
+func SampledDebug(enable bool) {
+	var value int32
+	if enable {
+		value = 1
+	}
+	atomic.StoreInt32(&sampledDebug, value)
+}
+
+type verboseContextKey struct{}
+
+// ContextWithVerbose returns a copy of ctx flagged as verbose (or not), e.g. by a middleware
+// for the requests with a debug header, see SampledDebug.
+func ContextWithVerbose(ctx context.Context, verbose bool) context.Context {
+	return context.WithValue(ctx, verboseContextKey{}, verbose)
+}
+
+// IsVerbose reports whether ctx is verbose: flagged by ContextWithVerbose, else carrying a
+// sampled trace, that is a true "trace_sampled" field (see ContextWithFields and TraceFields).
+func IsVerbose(ctx context.Context) bool {
+	if verbose, ok := ctx.Value(verboseContextKey{}).(bool); ok {
+		return verbose
+	}
+	sampled, _ := FieldsFromContext(ctx)["trace_sampled"].(bool)
+	return sampled
+}
+
+// logSampled logs the record of a Ctx method at level lvl, DEBUG or below, when SampledDebug
+// is enabled; it reports whether it handled it.
+func (l *Logger) logSampled(ctx context.Context, lvl Level, message string, args []interface{}) bool {
+	if atomic.LoadInt32(&sampledDebug) == 0 || lvl > DEBUG || lvl == NOTSET {
+		return false
+	}
+	if IsVerbose(ctx) {
+		l.clearStaged()
+		l.emit(lvl, false, message, withContextFields(ctx, args)...)
+	}
+	return true
+}
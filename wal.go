@@ -0,0 +1,342 @@
+package log4go
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultWALAckInterval is the default interval at which a WALHandler acknowledges the records.
+const DefaultWALAckInterval = time.Second
+
+// WALOptions configures a WALHandler.
+type WALOptions struct {
+	// Dir is the directory of the log's segment files, created if missing.
+	Dir string
+	// SegmentSize is the size above which a new segment file is started (default
+	// DefaultSpoolSegmentSize).
+	SegmentSize int64
+	// Sync syncs the log to disk after each record, before handing it to the handler.
+	Sync bool
+	// AckInterval is the interval at which the records are acknowledged (default
+	// DefaultWALAckInterval).
+	AckInterval time.Duration
+}
+
+// WALHandler appends the records to a write-ahead log (a Spool of binary records) before
+// passing them to another, typically slow, handler; the records not acknowledged yet when the
+// process dies are passed again by the WALHandler of the next process, so none are lost (but
+// some may be handled twice).
+//
+// The records are acknowledged by the handler if it's a ReliableHandler, like the stream,
+// file and network handlers: the ones it fails to write are read from the log and passed to it
+// again every AckInterval. Else they're acknowledged once the handler flushed them without
+// error, if it has a Flush() error method, or once passed to it. The acknowledged records are
+// removed from the log every AckInterval. The records below the handler's level aren't logged.
+type WALHandler struct {
+	handler  Handler
+	reliable ReliableHandler // the handler, if reliable
+	wal      *Spool
+
+	mu       sync.Mutex // serializes the records, so the log is in the handling order
+	shutdown bool
+
+	ackMu     sync.Mutex // guards pending and discarded
+	pending   []walState // the states of the records of the log, oldest first
+	discarded uint64     // the number of records removed from the log
+
+	flushMu sync.Mutex // serializes the removals of the acknowledged records
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// walState is the state of a record of a WALHandler's log.
+type walState int8
+
+// WAL record states.
+const (
+	walPassed walState = iota // passed to the handler, not acknowledged yet
+	walFailed                 // the handler failed to write it, to be passed again
+	walAcked
+)
+
+// NewWALHandler returns a new WALHandler logging the records to the log in options.Dir before
+// passing them to handler; the records of the log not acknowledged yet are passed to handler
+// first.
+func NewWALHandler(handler Handler, options WALOptions) (*WALHandler, error) {
+	if handler == nil {
+		return nil, errors.New("log4go.WALHandler: no handler")
+	}
+	if options.AckInterval <= 0 {
+		options.AckInterval = DefaultWALAckInterval
+	}
+	wal, err := OpenSpool(SpoolOptions{Dir: options.Dir, SegmentSize: options.SegmentSize, Sync: options.Sync})
+	if err != nil {
+		return nil, err
+	}
+
+	h := &WALHandler{
+		handler: handler,
+		wal:     wal,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	h.reliable, _ = handler.(ReliableHandler)
+	err = wal.Scan(func(p []byte) error {
+		idx := h.track()
+		rec, err := DecodeBinaryRecord(p)
+		if err != nil {
+			reportError(h, err)
+			h.acknowledge(idx, nil) // so it's removed
+			return nil
+		}
+		if err := h.dispatch(rec, idx); err != nil {
+			reportError(h, err)
+		}
+		return nil
+	})
+	if err != nil {
+		_ = wal.Close()
+		return nil, err
+	}
+
+	go h.run(options.AckInterval)
+	return h, nil
+}
+
+var _ Handler = &WALHandler{}
+
+// run removes the acknowledged records every interval, until the handler is shut down.
+func (h *WALHandler) run(interval time.Duration) {
+	defer close(h.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := h.retry(); err != nil {
+				reportError(h, err)
+			}
+			if err := h.flush(); err != nil {
+				reportError(h, err)
+			}
+		case <-h.stop:
+			return
+		}
+	}
+}
+
+// track adds a record appended to the log to the pending ones, returning its index.
+func (h *WALHandler) track() uint64 {
+	h.ackMu.Lock()
+	defer h.ackMu.Unlock()
+	h.pending = append(h.pending, walPassed)
+	return h.discarded + uint64(len(h.pending)-1)
+}
+
+// dispatch passes the record of index idx to the handler.
+func (h *WALHandler) dispatch(rec *Record, idx uint64) error {
+	if h.reliable == nil {
+		return h.handler.Handle(rec)
+	}
+	return h.reliable.HandleAck(rec, func(err error) { h.acknowledge(idx, err) })
+}
+
+// acknowledge acknowledges the record of index idx, unless err isn't nil: it's then passed
+// again by the next retry.
+func (h *WALHandler) acknowledge(idx uint64, err error) {
+	state := walAcked
+	if err != nil {
+		reportError(h, fmt.Errorf("record not acknowledged: %w", err))
+		state = walFailed
+	}
+	h.ackMu.Lock()
+	defer h.ackMu.Unlock()
+	if idx >= h.discarded {
+		h.pending[idx-h.discarded] = state
+	}
+}
+
+// errRetried stops scanning the log once the failed records are passed again.
+var errRetried = errors.New("retried")
+
+// retry passes the records the handler failed to write to it again, reading them from the log.
+func (h *WALHandler) retry() error {
+	h.flushMu.Lock() // so the records aren't removed meanwhile
+	defer h.flushMu.Unlock()
+
+	h.ackMu.Lock()
+	var failed []bool
+	for idx, state := range h.pending {
+		if state == walFailed {
+			if failed == nil {
+				failed = make([]bool, len(h.pending))
+			}
+			failed[idx] = true
+			h.pending[idx] = walPassed
+		}
+	}
+	discarded := h.discarded
+	h.ackMu.Unlock()
+	if failed == nil {
+		return nil
+	}
+
+	idx := 0
+	err := h.wal.Scan(func(p []byte) error {
+		if idx == len(failed) {
+			return errRetried
+		}
+		if failed[idx] {
+			if rec, err := DecodeBinaryRecord(p); err != nil {
+				reportError(h, err)
+				h.acknowledge(discarded+uint64(idx), nil) // so it's removed
+			} else if err := h.dispatch(rec, discarded+uint64(idx)); err != nil {
+				reportError(h, err)
+			}
+		}
+		idx++
+		return nil
+	})
+	if err == errRetried {
+		err = nil
+	}
+	return err
+}
+
+// flush flushes the handler, then removes the acknowledged records at the start of the log.
+func (h *WALHandler) flush() error {
+	h.flushMu.Lock()
+	defer h.flushMu.Unlock()
+
+	h.ackMu.Lock()
+	n := len(h.pending)
+	h.ackMu.Unlock()
+
+	var err error
+	if f, ok := h.handler.(interface{ Flush() error }); ok {
+		err = f.Flush()
+	}
+
+	h.ackMu.Lock()
+	if h.reliable == nil && err == nil {
+		for idx := 0; idx < n; idx++ { // handled before the flush
+			h.pending[idx] = walAcked
+		}
+	}
+	acked := 0
+	for acked < len(h.pending) && h.pending[acked] == walAcked {
+		acked++
+	}
+	h.ackMu.Unlock()
+
+	if derr := h.wal.Discard(acked); derr != nil {
+		return derr
+	}
+	h.ackMu.Lock()
+	h.pending = h.pending[acked:]
+	h.discarded += uint64(acked)
+	h.ackMu.Unlock()
+	return err
+}
+
+// Handle appends the record to the log, then passes it to the handler, unless it's below the
+// handler's level.
+func (h *WALHandler) Handle(rec *Record) error {
+	if rec.Level < h.handler.Level() {
+		return nil
+	}
+	payload, err := encodeBinaryRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.shutdown {
+		return errors.New("log4go.WALHandler: shut down")
+	}
+	if err := h.wal.Append(payload); err != nil {
+		return err
+	}
+	return h.dispatch(rec, h.track())
+}
+
+// Flush flushes the handler and removes the acknowledged records from the log.
+func (h *WALHandler) Flush() error {
+	return h.flush()
+}
+
+// Pending returns the number of records not acknowledged yet.
+func (h *WALHandler) Pending() int {
+	h.ackMu.Lock()
+	defer h.ackMu.Unlock()
+	pending := 0
+	for _, state := range h.pending {
+		if state != walAcked {
+			pending++
+		}
+	}
+	return pending
+}
+
+// Handler returns the wrapped handler.
+func (h *WALHandler) Handler() Handler {
+	return h.handler
+}
+
+// SetFormatter sets the wrapped handler's formatter.
+func (h *WALHandler) SetFormatter(formatter Formatter) {
+	h.handler.SetFormatter(formatter)
+}
+
+// Formatter returns the wrapped handler's formatter.
+func (h *WALHandler) Formatter() Formatter {
+	return h.handler.Formatter()
+}
+
+// SetLevel sets the wrapped handler's level.
+func (h *WALHandler) SetLevel(level Level) {
+	h.handler.SetLevel(level)
+}
+
+// Level returns the wrapped handler's level.
+func (h *WALHandler) Level() Level {
+	return h.handler.Level()
+}
+
+// Shutdown shuts down the wrapped handler, and closes the log.
+func (h *WALHandler) Shutdown() {
+	_ = h.ShutdownContext(context.Background())
+}
+
+// ShutdownContext shuts down the wrapped handler, waiting until its queued records have been
+// written, acknowledges them and closes the log, or returns ctx.Err() if ctx is done before
+// that (the records not acknowledged are kept for the next process).
+func (h *WALHandler) ShutdownContext(ctx context.Context) error {
+	h.mu.Lock()
+	if h.shutdown {
+		h.mu.Unlock()
+		return nil
+	}
+	h.shutdown = true
+	h.mu.Unlock()
+
+	close(h.stop)
+	<-h.done
+
+	shutdownHandlers(ctx, []Handler{h.handler})
+	err := ctx.Err()
+	if err == nil {
+		err = h.flush()
+	}
+	if cerr := h.wal.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
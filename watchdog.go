@@ -0,0 +1,118 @@
+package log4go
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// StallOptions configures a handler's stall watchdog, see StreamHandler.SetStallWatchdog.
+type StallOptions struct {
+	// Timeout is the time after which a write still blocked (e.g. on a hung NFS mount or a
+	// full pipe) is a stall.
+	Timeout time.Duration
+	// Failover, if set, gets the records while the handler is stalled.
+	Failover Handler
+	// Drop drops the records while the handler is stalled (counting them, see Stats), rather
+	// than blocking the logging calls once its queue is full; ignored with a Failover.
+	Drop bool
+}
+
+// writeStarted records the start of a write, for the stall watchdog; called by the committer.
+func (h *StreamHandler) writeStarted(start time.Time) {
+	atomic.StoreInt64(&h.writeStart, start.UnixNano())
+}
+
+// writeDone records the end of a write, for the stall watchdog; called by the committer.
+func (h *StreamHandler) writeDone() {
+	atomic.StoreInt64(&h.writeStart, 0)
+}
+
+// SetStallWatchdog starts watching the handler's writes, until it's shut down: when one is
+// blocked for more than options.Timeout the stall is reported to stderr (so is the recovery),
+// and the records are passed to the failover handler, or dropped, if options say so, until
+// the write completes. A zero Timeout stops watching.
+func (h *StreamHandler) SetStallWatchdog(options StallOptions) {
+	if stop, _ := h.watchdog.Load().(chan struct{}); stop != nil {
+		close(stop)
+	}
+	atomic.StoreInt32(&h.stalled, 0)
+	if options.Timeout <= 0 {
+		h.watchdog.Store((chan struct{})(nil))
+		return
+	}
+	stop := make(chan struct{})
+	h.watchdog.Store(stop)
+	h.stallOptions.Store(&options)
+	go h.watch(options.Timeout, stop)
+}
+
+// watch checks the writes for stalls, until stop is closed or the handler is shut down.
+func (h *StreamHandler) watch(timeout time.Duration, stop chan struct{}) {
+	interval := timeout / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var stalledWrite int64 // start of the stalled write, if any
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-h.committerDone:
+			return
+		}
+
+		start := atomic.LoadInt64(&h.writeStart)
+		switch {
+		case stalledWrite == 0 && start != 0 && time.Since(time.Unix(0, start)) > timeout:
+			stalledWrite = start
+			atomic.StoreInt32(&h.stalled, 1)
+			h.reportStall(fmt.Sprintf("writer stalled for more than %s", timeout))
+		case stalledWrite != 0 && start != stalledWrite:
+			atomic.StoreInt32(&h.stalled, 0)
+			h.reportStall(fmt.Sprintf("writer recovered after %s", time.Since(time.Unix(0, stalledWrite)).Round(time.Millisecond)))
+			stalledWrite = 0
+		}
+	}
+}
+
+// reportStall prints a diagnostic of the stall watchdog to stderr: the diagnostics logger may
+// well write to the stalled handler.
+func (h *StreamHandler) reportStall(message string) {
+	var name string
+	if h.self != nil {
+		name = handlerName(h.self)
+	} else {
+		name = handlerName(h)
+	}
+	_, _ = fmt.Fprintf(os.Stderr, "log4go.%s: %s\n", name, message)
+}
+
+// handleStalled handles a record while the handler is stalled, see StallOptions; it returns
+// false if the record is to be queued anyway.
+func (h *StreamHandler) handleStalled(rec *Record, ack func(error)) bool {
+	options, _ := h.stallOptions.Load().(*StallOptions)
+	switch {
+	case options == nil:
+		return false
+	case options.Failover != nil:
+		if reliable, ok := options.Failover.(ReliableHandler); ok && ack != nil {
+			_ = reliable.HandleAck(rec, ack)
+			return true
+		}
+		err := options.Failover.Handle(rec)
+		if ack != nil {
+			ack(err)
+		}
+		return true
+	case options.Drop:
+		h.dropped(ack)
+		return true
+	}
+	return false
+}
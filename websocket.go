@@ -0,0 +1,272 @@
+package log4go
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/websocket"
+)
+
+// WebSocketClientBuffer is the number of records buffered per WebSocket client, records are
+// dropped (and the client told so) when a client doesn't keep up.
+const WebSocketClientBuffer = 256
+
+// WebSocketHandler broadcasts the formatted records to the connected WebSocket clients, e.g.
+// for a live tail in an admin UI; it's also the http.Handler accepting the clients.
+//
+// Clients can select the records with the "level" (minimum level name) and "logger" (logger
+// and descendants) query parameters, and change their level by sending {"level": "..."}.
+type WebSocketHandler struct {
+	// CheckOrigin, if set, decides whether a client's request (with an Origin header) is
+	// accepted; by default the origin's host must be the request's.
+	CheckOrigin func(r *http.Request) bool
+
+	counters  handlerCounters
+	level     int32 // Level, accessed atomically
+	formatter atomic.Value
+
+	mu       sync.RWMutex
+	clients  map[*wsClient]struct{}
+	shutdown bool
+}
+
+type wsClient struct {
+	conn     *websocket.Conn
+	level    int32 // Level, accessed atomically
+	logger   string
+	messages chan []byte
+	dropped  uint64 // accessed atomically
+	once     sync.Once
+	done     chan struct{} // closed when the client's connection is closed
+}
+
+// NewWebSocketHandler returns a new WebSocketHandler.
+func NewWebSocketHandler() (*WebSocketHandler, error) {
+	formatter, err := NewTemplateFormatter("{time} {level} {name}: {message}")
+	if err != nil {
+		return nil, err
+	}
+	h := &WebSocketHandler{clients: make(map[*wsClient]struct{})}
+	h.formatter.Store(formatterValue{formatter})
+	return h, nil
+}
+
+var _ Handler = &WebSocketHandler{}
+
+// Clients returns the number of connected clients.
+func (h *WebSocketHandler) Clients() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.clients)
+}
+
+// Handle sends the formatted record to the interested clients, without waiting for them.
+func (h *WebSocketHandler) Handle(rec *Record) error {
+	if rec.Level < h.Level() {
+		return nil
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	var msg []byte
+	for client := range h.clients {
+		if !client.wants(rec) {
+			continue
+		}
+		if msg == nil {
+			formatted, err := h.Formatter().Format(rec)
+			if err != nil {
+				if err == ErrorNotSet {
+					return nil
+				}
+				h.counters.countError(err)
+				return err
+			}
+			msg = append([]byte(nil), bytes.TrimSuffix(formatted, []byte{'\n'})...)
+		}
+		select {
+		case client.messages <- msg:
+			h.counters.countHandled(1)
+		default:
+			atomic.AddUint64(&client.dropped, 1)
+			h.counters.countDropped(1)
+		}
+	}
+	return nil
+}
+
+func (h *WebSocketHandler) handlerStats() HandlerStats {
+	return h.counters.read(0, 0)
+}
+
+func (c *wsClient) wants(rec *Record) bool {
+	if rec.Level < Level(atomic.LoadInt32(&c.level)) {
+		return false
+	}
+	if len(c.logger) == 0 {
+		return true
+	}
+	name := rec.Name
+	if len(name) == 0 {
+		name = "root"
+	}
+	return name == c.logger || strings.HasPrefix(name, c.logger+"/")
+}
+
+// ServeHTTP accepts a WebSocket client.
+func (h *WebSocketHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	server := websocket.Server{
+		Handshake: func(config *websocket.Config, r *http.Request) error {
+			if len(r.Header.Get("Origin")) == 0 {
+				return nil
+			}
+			if h.CheckOrigin != nil {
+				if !h.CheckOrigin(r) {
+					return fmt.Errorf("origin not allowed")
+				}
+				return nil
+			}
+			origin, err := url.Parse(r.Header.Get("Origin"))
+			if err != nil || origin.Host != r.Host {
+				return fmt.Errorf("origin not allowed")
+			}
+			return nil
+		},
+		Handler: h.serve,
+	}
+	server.ServeHTTP(w, r)
+}
+
+func (h *WebSocketHandler) serve(conn *websocket.Conn) {
+	query := conn.Request().URL.Query()
+	client := &wsClient{
+		conn:     conn,
+		logger:   query.Get("logger"),
+		messages: make(chan []byte, WebSocketClientBuffer),
+		done:     make(chan struct{}),
+	}
+	defer close(client.done)
+	if name := query.Get("level"); len(name) != 0 {
+		level, err := ParseLevel(name)
+		if err != nil {
+			_ = websocket.Message.Send(conn, err.Error())
+			return
+		}
+		client.level = int32(level)
+	}
+
+	h.mu.Lock()
+	if h.shutdown {
+		h.mu.Unlock()
+		return
+	}
+	h.clients[client] = struct{}{}
+	h.mu.Unlock()
+
+	defer conn.Close()
+	defer h.detach(client)
+	go h.receive(client)
+
+	// until detached, then the pending records are sent
+	for msg := range client.messages {
+		if dropped := atomic.SwapUint64(&client.dropped, 0); dropped > 0 {
+			note := fmt.Sprintf("log4go.WebSocketHandler: %d records dropped", dropped)
+			if websocket.Message.Send(conn, note) != nil {
+				return
+			}
+		}
+		if websocket.Message.Send(conn, string(msg)) != nil {
+			return
+		}
+	}
+}
+
+// receive handles the client's messages, changing its level.
+func (h *WebSocketHandler) receive(client *wsClient) {
+	defer h.detach(client)
+	for {
+		var request struct {
+			Level string `json:"level"`
+		}
+		if err := websocket.JSON.Receive(client.conn, &request); err != nil {
+			if _, ok := err.(*json.SyntaxError); ok {
+				continue
+			}
+			return
+		}
+		if level, err := ParseLevel(request.Level); err == nil {
+			atomic.StoreInt32(&client.level, int32(level))
+		}
+	}
+}
+
+// detach stops sending records to the client, its connection is closed once the pending
+// records have been sent.
+func (h *WebSocketHandler) detach(client *wsClient) {
+	client.once.Do(func() {
+		h.mu.Lock()
+		delete(h.clients, client)
+		close(client.messages)
+		h.mu.Unlock()
+	})
+}
+
+// SetFormatter sets the handler's Formatter.
+func (h *WebSocketHandler) SetFormatter(formatter Formatter) {
+	h.formatter.Store(formatterValue{formatter})
+}
+
+// Formatter returns the handler's Formatter.
+func (h *WebSocketHandler) Formatter() Formatter {
+	return h.formatter.Load().(formatterValue).Formatter
+}
+
+// SetLevel sets the level the handler will (at least) handle.
+func (h *WebSocketHandler) SetLevel(level Level) {
+	atomic.StoreInt32(&h.level, int32(level))
+}
+
+// Level returns the level previously set (or NOTSET if not set).
+func (h *WebSocketHandler) Level() Level {
+	return Level(atomic.LoadInt32(&h.level))
+}
+
+// Shutdown disconnects the clients, after they have been sent their pending records.
+func (h *WebSocketHandler) Shutdown() {
+	_ = h.ShutdownContext(context.Background())
+}
+
+// ShutdownContext disconnects the clients after they have been sent their pending records,
+// or when ctx is done.
+func (h *WebSocketHandler) ShutdownContext(ctx context.Context) error {
+	h.mu.Lock()
+	h.shutdown = true
+	clients := make([]*wsClient, 0, len(h.clients))
+	for client := range h.clients {
+		clients = append(clients, client)
+	}
+	h.mu.Unlock()
+
+	for _, client := range clients {
+		h.detach(client)
+	}
+	for _, client := range clients {
+		select {
+		case <-client.done:
+		case <-ctx.Done():
+			for _, client := range clients {
+				_ = client.conn.Close()
+			}
+			return ctx.Err()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package log4go
+
+import "fmt"
+
+// With returns a child logger named name (the logger's name if empty) attaching the bound
+// fields to all its records, e.g.
+//
+//	log := logger.With("http", "request_id", id, "user", user)
+//
+// where kv are key/value pairs, or Fields. The child inherits the logger's handlers, level
+// and bound fields (the fields of a logging call overriding them); unlike GetLogger it isn't
+// registered, it's cheap enough to make one per request, and its records are counted by the
+// logger (see ReadStats).
+func (l *Logger) With(name string, kv ...interface{}) *Logger {
+	childName := l.name
+	if len(name) > 0 {
+		if len(childName) > 0 {
+			childName += "/"
+		}
+		childName += name
+	}
+
+	stats := l
+	if l.stats != nil {
+		stats = l.stats
+	}
+	return &Logger{
+		name:       childName,
+		level:      int32(NOTSET),
+		parent:     l,
+		fields:     bindFields(l.fields, l.group, kv),
+		group:      l.group,
+		tags:       l.tags,
+		callerSkip: l.callerSkip,
+		mdc:        l.mdc,
+		stats:      stats,
+	}
+}
+
+// WithGroup returns a child logger (see With) nesting the fields bound or logged from now on
+// in the group name, as log/slog does: their keys are prefixed with "name.", e.g.
+//
+//	log := logger.WithGroup("http").With("", "method", r.Method)
+//	log.Info("handled", log4go.Fields{"status": 200}) // http.method, http.status
+//
+// The fields already bound aren't. The JSON formatters write such keys as ECS does (e.g.
+// "log.level"), which Elasticsearch and most log stores read as nested objects; the text
+// formatters write the prefixed keys. The logger itself is returned for an empty name.
+func (l *Logger) WithGroup(name string) *Logger {
+	if len(name) == 0 {
+		return l
+	}
+	child := l.With("")
+	child.group = l.group + name + "."
+	return child
+}
+
+// bindFields returns the fields with the key/value pairs (or Fields) of kv added, their keys
+// prefixed with group.
+func bindFields(fields Fields, group string, kv []interface{}) Fields {
+	if len(kv) == 0 {
+		return fields
+	}
+	bound := make(Fields, len(fields)+len(kv)/2)
+	for key, value := range fields {
+		bound[key] = value
+	}
+	for i := 0; i < len(kv); i++ {
+		if f, ok := kv[i].(Fields); ok {
+			for key, value := range f {
+				bound[group+key] = value
+			}
+			continue
+		}
+		key, ok := kv[i].(string)
+		if !ok {
+			key = fmt.Sprint(kv[i])
+		}
+		if i+1 < len(kv) {
+			i++
+			bound[group+key] = kv[i]
+		} else {
+			bound[group+key] = nil // no value
+		}
+	}
+	return bound
+}
+
+// recordFields returns the fields of a record logged with fields by l.
+func (l *Logger) recordFields(fields Fields) Fields {
+	if len(l.group) > 0 && len(fields) > 0 {
+		grouped := make(Fields, len(fields))
+		for key, value := range fields {
+			grouped[l.group+key] = value
+		}
+		fields = grouped
+	}
+	if len(l.fields) == 0 {
+		return fields
+	}
+	if len(fields) == 0 {
+		return l.fields // not modified by handlers
+	}
+	merged := make(Fields, len(l.fields)+len(fields))
+	for key, value := range l.fields {
+		merged[key] = value
+	}
+	for key, value := range fields {
+		merged[key] = value
+	}
+	return merged
+}
@@ -0,0 +1,57 @@
+package log4go
+
+import (
+	"bytes"
+	"io"
+	"sync"
+)
+
+// Writer returns an io.Writer logging each written line as a message with the given level,
+// e.g. for http.Server.ErrorLog: log.New(logger.Writer(log4go.ERROR), "", 0).
+// An incomplete last line is kept until its newline is written.
+func (l *Logger) Writer(lvl Level) io.Writer {
+	return &loggerWriter{logger: l, level: lvl}
+}
+
+type loggerWriter struct {
+	logger     *Logger
+	level      Level
+	parseLevel bool // detect level prefixes, see splitLevelPrefix
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func (w *loggerWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(p)
+	for len(p) > 0 {
+		idx := bytes.IndexByte(p, '\n')
+		if idx < 0 {
+			w.partial = append(w.partial, p...)
+			break
+		}
+
+		line := p[:idx]
+		if len(w.partial) > 0 {
+			line = append(w.partial, line...)
+			w.partial = w.partial[:0]
+		}
+		w.logLine(string(bytes.TrimSuffix(line, []byte{'\r'})))
+
+		p = p[idx+1:]
+	}
+	return n, nil
+}
+
+func (w *loggerWriter) logLine(line string) {
+	lvl := w.level
+	if w.parseLevel {
+		if prefixed, message, ok := splitLevelPrefix(line); ok {
+			lvl, line = prefixed, message
+		}
+	}
+	w.logger.log(lvl, false, "%s", line)
+}